@@ -0,0 +1,190 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Archiving a subject tree, for apps on core NATS that want what goes by
+// kept for auditing or replay without managing JetStream themselves. Archive
+// declares a stream capturing subject, plain publishes on it are stored as
+// they are, nothing about publishing or subscribing changes:
+//
+//	Archive(nc, "AUDIT", "orders.>", ArchiveMaxAge(30*24*time.Hour), ArchiveMaxBytes(10<<30))
+//
+// The stream doesn't ack what it stores, or requests on the subjects would
+// get its acks as replies. Where someone else owns the stream, e.g. it is
+// set up by ops, ArchiveVerify only checks it is there, captures subject and
+// has the limits asked for, and changes nothing. Read it back with
+// nc.Stream(subject, JetStreamStream(name)).Replay, see replay.go.
+
+type ArchiveOption func(*ArchiveOptions) error
+
+type ArchiveOptions struct {
+	// Limits, none if 0, oldest messages go first.
+	MaxAge   time.Duration
+	MaxBytes int64
+	MaxMsgs  int64
+	// Server default if 0.
+	Replicas int
+	Memory   bool
+	Verify   bool
+}
+
+func ArchiveMaxAge(d time.Duration) ArchiveOption {
+	return func(o *ArchiveOptions) error {
+		if d < 0 {
+			return errors.New("natsv2: negative archive max age")
+		}
+		o.MaxAge = d
+		return nil
+	}
+}
+
+func ArchiveMaxBytes(n int64) ArchiveOption {
+	return func(o *ArchiveOptions) error {
+		if n < 0 {
+			return errors.New("natsv2: negative archive max bytes")
+		}
+		o.MaxBytes = n
+		return nil
+	}
+}
+
+func ArchiveMaxMsgs(n int64) ArchiveOption {
+	return func(o *ArchiveOptions) error {
+		if n < 0 {
+			return errors.New("natsv2: negative archive max msgs")
+		}
+		o.MaxMsgs = n
+		return nil
+	}
+}
+
+func ArchiveReplicas(n int) ArchiveOption {
+	return func(o *ArchiveOptions) error {
+		if n < 0 || n > 5 {
+			return fmt.Errorf("natsv2: %d archive replicas", n)
+		}
+		o.Replicas = n
+		return nil
+	}
+}
+
+// ArchiveInMemory keeps the archive in memory, gone with the server.
+func ArchiveInMemory() ArchiveOption {
+	return func(o *ArchiveOptions) error {
+		o.Memory = true
+		return nil
+	}
+}
+
+func ArchiveVerify() ArchiveOption {
+	return func(o *ArchiveOptions) error {
+		o.Verify = true
+		return nil
+	}
+}
+
+func Archive(nc Connection, name, subject string, opts ...ArchiveOption) (*nats.StreamInfo, error) {
+	c := connFor(nc, subject)
+	if c == nil {
+		return nil, fmt.Errorf("natsv2: no archives on a %T", nc)
+	}
+	if name == "" {
+		return nil, errors.New("natsv2: empty stream name")
+	}
+	if err := checkSubject(subject, true); err != nil {
+		return nil, err
+	}
+	var o ArchiveOptions
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	subject = c.outSubject(subject)
+	if o.Verify {
+		return c.verifyArchive(name, subject, &o)
+	}
+	cfg := nats.StreamConfig{
+		Name:        name,
+		Description: "archive of " + subject,
+		Subjects:    []string{subject},
+		Retention:   nats.LimitsPolicy,
+		Discard:     nats.DiscardOld,
+		MaxAge:      o.MaxAge,
+		MaxBytes:    o.MaxBytes,
+		MaxMsgs:     o.MaxMsgs,
+		Replicas:    o.Replicas,
+		Storage:     nats.FileStorage,
+		NoAck:       true,
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = -1
+	}
+	if cfg.MaxMsgs == 0 {
+		cfg.MaxMsgs = -1
+	}
+	if o.Memory {
+		cfg.Storage = nats.MemoryStorage
+	}
+	return c.JetStream().DeclareStream(cfg)
+}
+
+func (c *conn) verifyArchive(name, subject string, o *ArchiveOptions) (*nats.StreamInfo, error) {
+	info, err := c.js.StreamInfo(name)
+	if err != nil {
+		return nil, fmt.Errorf("natsv2: archive %q: %w", name, err)
+	}
+	cfg := info.Config
+	covered := false
+	for _, s := range cfg.Subjects {
+		if subjectCovers(s, subject) {
+			covered = true
+			break
+		}
+	}
+	var problems []string
+	if !covered {
+		problems = append(problems, fmt.Sprintf("doesn't capture %q", subject))
+	}
+	if o.MaxAge > 0 && cfg.MaxAge != o.MaxAge {
+		problems = append(problems, fmt.Sprintf("max age is %v, want %v", cfg.MaxAge, o.MaxAge))
+	}
+	if o.MaxBytes > 0 && cfg.MaxBytes != o.MaxBytes {
+		problems = append(problems, fmt.Sprintf("max bytes is %d, want %d", cfg.MaxBytes, o.MaxBytes))
+	}
+	if o.MaxMsgs > 0 && cfg.MaxMsgs != o.MaxMsgs {
+		problems = append(problems, fmt.Sprintf("max msgs is %d, want %d", cfg.MaxMsgs, o.MaxMsgs))
+	}
+	if o.Replicas > 0 && cfg.Replicas != o.Replicas {
+		problems = append(problems, fmt.Sprintf("%d replicas, want %d", cfg.Replicas, o.Replicas))
+	}
+	if o.Memory && cfg.Storage != nats.MemoryStorage {
+		problems = append(problems, "not in memory")
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("natsv2: archive %q %s", name, strings.Join(problems, ", "))
+	}
+	return info, nil
+}
+
+// subjectCovers is whether everything subject matches, wildcards and all,
+// also matches pattern.
+func subjectCovers(pattern, subject string) bool {
+	pt, st := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return i < len(st)
+		}
+		if i >= len(st) || st[i] == ">" || (p != "*" && p != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
@@ -0,0 +1,119 @@
+package natsv2
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Async JetStream publishing on a Stream. MaxAsyncPending bounds how many
+// publishes wait for their ack, PublishAsync blocks once there are that
+// many. PublishAsyncComplete is closed once everything published so far is
+// acked or failed, OnAsyncError gets the failures, so a producer can fire
+// and forget and just check at the end.
+//
+//	orders := nc.Stream("orders.new", JetStreamStream("ORDERS"), MaxAsyncPending(256),
+//		OnAsyncError(func(m *nats.Msg, err error) { retry(m) }))
+//	for _, o := range batch {
+//		orders.PublishAsync(o)
+//	}
+//	<-orders.PublishAsyncComplete()
+//
+// Copies from WithEncoder share the window.
+
+func MaxAsyncPending(n int) StreamOption {
+	return func(o *StreamOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: max async pending must be at least 1")
+		}
+		o.MaxAsyncPending = n
+		return nil
+	}
+}
+
+func OnAsyncError(cb func(m *nats.Msg, err error)) StreamOption {
+	return func(o *StreamOptions) error {
+		o.OnAsyncError = cb
+		return nil
+	}
+}
+
+type asyncTracker struct {
+	slots   chan struct{}
+	onError func(*nats.Msg, error)
+
+	mu      sync.Mutex
+	pending int
+	done    chan struct{}
+}
+
+func newAsyncTracker(o *StreamOptions) *asyncTracker {
+	t := &asyncTracker{onError: o.OnAsyncError}
+	if o.MaxAsyncPending > 0 {
+		t.slots = make(chan struct{}, o.MaxAsyncPending)
+	}
+	return t
+}
+
+// acquire takes a slot in the window, waiting for one if need be.
+func (t *asyncTracker) acquire() {
+	if t.slots != nil {
+		t.slots <- struct{}{}
+	}
+	t.mu.Lock()
+	t.pending++
+	t.mu.Unlock()
+}
+
+func (t *asyncTracker) release() {
+	if t.slots != nil {
+		<-t.slots
+	}
+	t.mu.Lock()
+	t.pending--
+	if t.pending == 0 && t.done != nil {
+		close(t.done)
+		t.done = nil
+	}
+	t.mu.Unlock()
+}
+
+// watch releases the slot once f resolves.
+func (t *asyncTracker) watch(f nats.PubAckFuture) {
+	go func() {
+		defer t.release()
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			if t.onError != nil {
+				t.onError(f.Msg(), err)
+			}
+		}
+	}()
+}
+
+func (t *asyncTracker) complete() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending == 0 {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	if t.done == nil {
+		t.done = make(chan struct{})
+	}
+	return t.done
+}
+
+// PublishAsyncComplete is closed once the publishes so far have their acks,
+// right away for streams that aren't JetStream.
+func (s *stream) PublishAsyncComplete() <-chan struct{} {
+	if s.async == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return s.async.complete()
+}
@@ -0,0 +1,294 @@
+package natsv2
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Common TLS and auth setups without having to reach for nats.go options.
+// Files are checked when Connect runs so a bad path fails right away.
+//
+// A TokenProvider is for short lived user JWTs, from a vault or an auth
+// service. We ask it for one when connecting, and again once three quarters
+// of its lifetime, going by the JWT's exp claim, are gone, then reconnect so
+// the server sees the new one before the old one expires:
+//
+//	nc, _ := Connect(url, WithTokenProvider(TokenProviderFunc(func(ctx context.Context) (string, []byte, error) {
+//		return vault.NATSUser(ctx)
+//	})))
+
+// WithTLS enables TLS. The cert and key are for client auth and are optional
+// but must be given together, caFile is optional and adds to the roots.
+func WithTLS(certFile, keyFile, caFile string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if (certFile == "") != (keyFile == "") {
+			return errors.New("natsv2: tls needs both a cert and a key file")
+		}
+		if certFile != "" {
+			if err := checkFile("tls cert", certFile); err != nil {
+				return err
+			}
+			if err := checkFile("tls key", keyFile); err != nil {
+				return err
+			}
+			o.NATS = append(o.NATS, nats.ClientCert(certFile, keyFile))
+		}
+		if caFile != "" {
+			if err := checkFile("tls ca", caFile); err != nil {
+				return err
+			}
+			o.NATS = append(o.NATS, nats.RootCAs(caFile))
+		}
+		o.credFiles.cert, o.credFiles.key, o.credFiles.ca = certFile, keyFile, caFile
+		o.NATS = append(o.NATS, nats.Secure())
+		return nil
+	}
+}
+
+func WithCreds(path string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if err := checkFile("creds", path); err != nil {
+			return err
+		}
+		o.NATS = append(o.NATS, nats.UserCredentials(path))
+		o.credFiles.creds = path
+		return nil
+	}
+}
+
+func WithUserPass(user, pass string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if user == "" {
+			return errors.New("natsv2: user required")
+		}
+		o.NATS = append(o.NATS, nats.UserInfo(user, pass))
+		return nil
+	}
+}
+
+func WithToken(token string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if token == "" {
+			return errors.New("natsv2: token required")
+		}
+		o.NATS = append(o.NATS, nats.Token(token))
+		return nil
+	}
+}
+
+func WithTLSConfig(cfg *tls.Config) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if cfg == nil {
+			return errors.New("natsv2: tls config required")
+		}
+		o.NATS = append(o.NATS, nats.Secure(cfg))
+		return nil
+	}
+}
+
+// WithNkey authenticates with a user nkey seed, "SU...".
+func WithNkey(seed string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		kp, err := nkeys.FromSeed([]byte(seed))
+		if err != nil {
+			return fmt.Errorf("natsv2: nkey seed: %w", err)
+		}
+		pub, err := kp.PublicKey()
+		if err != nil {
+			return fmt.Errorf("natsv2: nkey seed: %w", err)
+		}
+		o.NATS = append(o.NATS, nats.Nkey(pub, kp.Sign))
+		return nil
+	}
+}
+
+// A TokenProvider returns a user JWT and the seed to sign the server's nonce
+// with, nil for bearer JWTs.
+type TokenProvider interface {
+	Token(ctx context.Context) (jwt string, seed []byte, err error)
+}
+
+type TokenProviderFunc func(ctx context.Context) (string, []byte, error)
+
+func (f TokenProviderFunc) Token(ctx context.Context) (string, []byte, error) {
+	return f(ctx)
+}
+
+// How long getting a token may take.
+const tokenTimeout = 10 * time.Second
+
+func WithTokenProvider(p TokenProvider) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if p == nil {
+			return errors.New("natsv2: token provider required")
+		}
+		o.tokens = &tokenSource{p: p, changed: make(chan struct{}, 1)}
+		o.NATS = append(o.NATS, nats.UserJWT(o.tokens.jwt, o.tokens.sign))
+		return nil
+	}
+}
+
+type tokenSource struct {
+	p       TokenProvider
+	changed chan struct{}
+
+	mu      sync.Mutex
+	token   string
+	seed    []byte
+	fetched time.Time
+	expires time.Time
+}
+
+// refreshAt is zero for tokens that don't expire.
+func (ts *tokenSource) refreshAt() time.Time {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.expires.IsZero() {
+		return time.Time{}
+	}
+	return ts.fetched.Add(ts.expires.Sub(ts.fetched) * 3 / 4)
+}
+
+func (ts *tokenSource) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, tokenTimeout)
+	defer cancel()
+	token, seed, err := ts.p.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("natsv2: token provider: %w", err)
+	}
+	ts.mu.Lock()
+	ts.token, ts.seed = token, seed
+	ts.fetched, ts.expires = time.Now(), jwtExpiry(token)
+	ts.mu.Unlock()
+	select {
+	case ts.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// jwt is nats.go's callback on every connect, a token that's due is
+// refreshed first.
+func (ts *tokenSource) jwt() (string, error) {
+	ts.mu.Lock()
+	due := ts.token == ""
+	ts.mu.Unlock()
+	if at := ts.refreshAt(); !at.IsZero() && time.Now().After(at) {
+		due = true
+	}
+	if due {
+		if err := ts.refresh(context.Background()); err != nil {
+			return "", err
+		}
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.token, nil
+}
+
+func (ts *tokenSource) sign(nonce []byte) ([]byte, error) {
+	ts.mu.Lock()
+	seed := ts.seed
+	ts.mu.Unlock()
+	if seed == nil {
+		return nil, nil
+	}
+	kp, err := nkeys.FromSeed(seed)
+	if err != nil {
+		return nil, fmt.Errorf("natsv2: token seed: %w", err)
+	}
+	defer kp.Wipe()
+	return kp.Sign(nonce)
+}
+
+// jwtExpiry is zero without an exp claim, the token isn't verified, that's
+// the server's job.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(data, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
+
+// refreshTokens gets a new token when the current one is due, reconnecting
+// so the server has it.
+func (c *conn) refreshTokens(ts *tokenSource, nc *nats.Conn) {
+	for {
+		// Nil for a token that doesn't expire, only a new one wakes us up.
+		var due <-chan time.Time
+		var t *time.Timer
+		if at := ts.refreshAt(); !at.IsZero() {
+			t = time.NewTimer(time.Until(at))
+			due = t.C
+		}
+		refresh := false
+		select {
+		case <-c.ctx.Done():
+		case <-ts.changed:
+		case <-due:
+			refresh = true
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+		if !refresh {
+			continue
+		}
+		if err := ts.refresh(c.ctx); err != nil {
+			c.log.Warn("token refresh failed", "error", err)
+			c.handleError(err)
+			select {
+			case <-time.After(time.Second):
+			case <-c.ctx.Done():
+				return
+			}
+			continue
+		}
+		// Drop the notice of our own refresh.
+		select {
+		case <-ts.changed:
+		default:
+		}
+		c.log.Info("token refreshed, reconnecting")
+		nc.ForceReconnect()
+	}
+}
+
+func checkFile(what, path string) error {
+	if path == "" {
+		return fmt.Errorf("natsv2: %s file required", what)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("natsv2: %s file: %w", what, err)
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("natsv2: %s file %q is a directory", what, path)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Subscriptions that end by themselves, after Max messages or once Until's
+// context or the Deadline is done, whichever comes first. OnComplete is
+// called once when the subscription ends, with nil after Max messages or an
+// Unsubscribe or Drain, or with why the context or deadline ended it.
+//
+//	nc.Subscribe("$SRV.PING", Handler(collect), Max(10), Deadline(time.Now().Add(time.Second)),
+//		OnComplete(func(err error) { report() }))
+
+func Max(n int) SubOption {
+	return func(o *SubOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: max must be at least 1")
+		}
+		o.Max = n
+		return nil
+	}
+}
+
+func Until(ctx context.Context) SubOption {
+	return func(o *SubOptions) error {
+		o.Until = ctx
+		return nil
+	}
+}
+
+func Deadline(t time.Time) SubOption {
+	return func(o *SubOptions) error {
+		o.Deadline = t
+		return nil
+	}
+}
+
+func OnComplete(cb func(error)) SubOption {
+	return func(o *SubOptions) error {
+		o.OnComplete = cb
+		return nil
+	}
+}
+
+type autoUnsub struct {
+	max        int
+	until      context.Context
+	deadline   time.Time
+	onComplete func(error)
+
+	n     int64
+	once  sync.Once
+	done  chan struct{}
+	mu    sync.Mutex
+	cause error
+}
+
+func (o *SubOptions) newAutoUnsub() error {
+	if o.Max == 0 && o.Until == nil && o.Deadline.IsZero() && o.OnComplete == nil {
+		return nil
+	}
+	if o.Max > 0 && o.Consumer != nil && o.Consumer.Pull {
+		return errors.New("natsv2: pull consumers fetch in batches, they can't take Max")
+	}
+	o.auto = &autoUnsub{max: o.Max, until: o.Until, deadline: o.Deadline, onComplete: o.OnComplete, done: make(chan struct{})}
+	return nil
+}
+
+// start has the server stop after max messages and watches for the end.
+func (a *autoUnsub) start(s Subscription) error {
+	if a.max > 0 {
+		// A SubscriptionSet is several, none of which the server can count
+		// for, so we stop it ourselves.
+		if sub := natsSubscription(s); sub != nil {
+			if err := sub.AutoUnsubscribe(a.max); err != nil {
+				return err
+			}
+		} else {
+			go func() {
+				<-a.done
+				s.Unsubscribe()
+			}()
+		}
+	}
+	if a.until == nil && a.deadline.IsZero() {
+		return nil
+	}
+	until := a.until
+	if until == nil {
+		until = context.Background()
+	}
+	go func() {
+		var deadline <-chan time.Time
+		if !a.deadline.IsZero() {
+			t := time.NewTimer(time.Until(a.deadline))
+			defer t.Stop()
+			deadline = t.C
+		}
+		select {
+		case <-until.Done():
+			a.stop(s, until.Err())
+		case <-deadline:
+			a.stop(s, context.DeadlineExceeded)
+		case <-a.done:
+		}
+	}()
+	return nil
+}
+
+// count finishes once handler has had the last of max messages.
+func (a *autoUnsub) count(handler nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		last, over := a.counted()
+		if over {
+			return
+		}
+		handler(m)
+		if last {
+			a.finish(nil)
+		}
+	}
+}
+
+// counted counts a message and reports whether it was the last one, or one
+// past it that came in before we stopped.
+func (a *autoUnsub) counted() (last, over bool) {
+	if a == nil || a.max == 0 {
+		return false, false
+	}
+	n := atomic.AddInt64(&a.n, 1)
+	return n == int64(a.max), n > int64(a.max)
+}
+
+// stop unsubscribes, with err for OnComplete.
+func (a *autoUnsub) stop(s Subscription, err error) {
+	a.mu.Lock()
+	a.cause = err
+	a.mu.Unlock()
+	s.Unsubscribe()
+}
+
+// finish runs for whichever end comes first, the rest are no-ops.
+func (a *autoUnsub) finish(err error) {
+	if a == nil {
+		return
+	}
+	a.once.Do(func() {
+		close(a.done)
+		a.mu.Lock()
+		if a.cause != nil {
+			err = a.cause
+		}
+		a.mu.Unlock()
+		if a.onComplete != nil {
+			a.onComplete(err)
+		}
+	})
+}
+
+func natsSubscription(s Subscription) *nats.Subscription {
+	switch s := s.(type) {
+	case *subscription:
+		return s.current()
+	case *pullSubscription:
+		return s.sub
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Batch publishing for bulk loads. Everything is handed to the client's
+// buffered writer and we flush once at the end instead of per message.
+
+type BatchMsg struct {
+	Subject string
+	Msg     interface{}
+}
+
+type BatchOption func(*BatchOptions) error
+
+type BatchOptions struct {
+	// Stop at the first failure instead of publishing the rest.
+	Strict bool
+	// Publish to this JetStream stream, see Batch.
+	JetStream string
+}
+
+func Strict() BatchOption {
+	return func(o *BatchOptions) error {
+		o.Strict = true
+		return nil
+	}
+}
+
+// BatchError reports which messages of a batch failed, keyed by index.
+type BatchError struct {
+	Errors map[int]error
+}
+
+func (e *BatchError) Indices() []int {
+	idx := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+func (e *BatchError) Error() string {
+	idx := e.Indices()
+	s := make([]string, len(idx))
+	for i, n := range idx {
+		s[i] = fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("natsv2: %d batch messages failed (%s): %v", len(idx), strings.Join(s, ", "), e.Errors[idx[0]])
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, i := range e.Indices() {
+		errs = append(errs, e.Errors[i])
+	}
+	return errs
+}
+
+func (c *conn) PublishBatch(subject string, msgs []interface{}, opts ...BatchOption) error {
+	bmsgs := make([]BatchMsg, len(msgs))
+	for i, msg := range msgs {
+		bmsgs[i] = BatchMsg{Subject: subject, Msg: msg}
+	}
+	return c.PublishBatchMsgs(bmsgs, opts...)
+}
+
+func (c *conn) PublishBatchMsgs(msgs []BatchMsg, opts ...BatchOption) error {
+	b := c.Batch(opts...)
+	for _, m := range msgs {
+		b.Add(m.Subject, m.Msg)
+	}
+	_, err := b.Send(context.Background())
+	return err
+}
+
+// A Batch collects messages to send together, for when they aren't all at
+// hand up front. Send publishes them and flushes once, with BatchJetStream
+// they are published to the stream without waiting on each ack, Send then
+// waits for all of them, so the round trips overlap.
+//
+//	b := nc.Batch(BatchJetStream("ORDERS"))
+//	for _, o := range orders {
+//		b.Add("orders.new", o, WithMsgID(o.ID))
+//	}
+//	acks, err := b.Send(ctx)
+//
+// Failures are a *BatchError by index, same as PublishBatch.
+type Batch interface {
+	Add(subject string, msg interface{}, opts ...PubOption) Batch
+	Len() int
+	// Send returns the acks by index for JetStream, nil for core NATS. The
+	// batch is empty again after.
+	Send(ctx context.Context) ([]*nats.PubAck, error)
+}
+
+func BatchJetStream(stream string) BatchOption {
+	return func(o *BatchOptions) error {
+		if stream == "" {
+			return errors.New("natsv2: empty stream name")
+		}
+		o.JetStream = stream
+		return nil
+	}
+}
+
+type batch struct {
+	c    *conn
+	opts BatchOptions
+	err  error
+	msgs []batchEntry
+}
+
+type batchEntry struct {
+	subject string
+	msg     interface{}
+	opts    []PubOption
+}
+
+func (c *conn) Batch(opts ...BatchOption) Batch {
+	b := &batch{c: c}
+	for _, opt := range opts {
+		if b.err = opt(&b.opts); b.err != nil {
+			break
+		}
+	}
+	return b
+}
+
+func (b *batch) Add(subject string, msg interface{}, opts ...PubOption) Batch {
+	b.msgs = append(b.msgs, batchEntry{subject, msg, opts})
+	return b
+}
+
+func (b *batch) Len() int {
+	return len(b.msgs)
+}
+
+func (b *batch) Send(ctx context.Context) ([]*nats.PubAck, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	msgs := b.msgs
+	b.msgs = nil
+	if b.opts.JetStream != "" {
+		return b.sendJetStream(ctx, msgs)
+	}
+	berr := &BatchError{Errors: make(map[int]error)}
+	for i, e := range msgs {
+		m, err := b.c.codecs.encode(e.subject, e.msg, b.c.codecs.out)
+		if err == nil {
+			err = b.c.publishMsg(ctx, m, e.opts...)
+		}
+		if err != nil {
+			berr.Errors[i] = err
+			if b.opts.Strict {
+				break
+			}
+		}
+	}
+	// Flush whatever made it into the buffer, even in strict mode.
+	if err := b.c.Flush(ctx); err != nil {
+		return nil, err
+	}
+	if len(berr.Errors) > 0 {
+		return nil, berr
+	}
+	return nil, nil
+}
+
+func (b *batch) sendJetStream(ctx context.Context, msgs []batchEntry) ([]*nats.PubAck, error) {
+	berr := &BatchError{Errors: make(map[int]error)}
+	futures := make([]nats.PubAckFuture, len(msgs))
+	for i, e := range msgs {
+		m, err := b.c.codecs.encode(e.subject, e.msg, b.c.codecs.out)
+		if err == nil {
+			m.Subject = b.c.outSubject(e.subject)
+			futures[i], err = b.c.publishJetStreamAsync(b.opts.JetStream, m, e.msg, e.opts)
+		}
+		if err != nil {
+			berr.Errors[i] = err
+			if b.opts.Strict {
+				break
+			}
+		}
+	}
+	acks := make([]*nats.PubAck, len(msgs))
+	for i, f := range futures {
+		if f == nil {
+			continue
+		}
+		select {
+		case ack := <-f.Ok():
+			acks[i] = ack
+		case err := <-f.Err():
+			berr.Errors[i] = err
+		case <-ctx.Done():
+			berr.Errors[i] = ctx.Err()
+		}
+	}
+	if len(berr.Errors) > 0 {
+		return acks, berr
+	}
+	return acks, nil
+}
@@ -0,0 +1,141 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Handling messages a batch at a time, for handlers that do better with many
+// at once, like bulk inserts. HandleBatch collects what arrives and calls h
+// with it once there are maxCount messages or maxWait went by since the
+// first of them, whichever comes first:
+//
+//	nc.Subscribe("metrics.>", HandleBatch(500, time.Second, func(msgs []*Msg) {
+//		db.InsertMany(rows(msgs))
+//	}))
+//
+// Batches are handed over one at a time and in order, the subscription
+// waits while a full one is handled. With AutoAck a JetStream batch is acked
+// once h returns, or nak'ed if it panics. Drain hands over what was
+// collected so far before it returns, Unsubscribe drops it; JetStream will
+// redeliver those, core messages are gone.
+
+func HandleBatch(maxCount int, maxWait time.Duration, h func([]*Msg)) SubOption {
+	return func(o *SubOptions) error {
+		if maxCount < 1 {
+			return errors.New("natsv2: batch needs a max count of at least 1")
+		}
+		if maxWait <= 0 {
+			return errors.New("natsv2: batch needs a max wait")
+		}
+		if h == nil {
+			return errors.New("natsv2: nil batch handler")
+		}
+		b := &batcher{max: maxCount, maxWait: maxWait, h: h}
+		o.Handler, o.batch, o.handlerName = b.add, b, funcName(h)
+		return nil
+	}
+}
+
+type batcher struct {
+	max     int
+	maxWait time.Duration
+	h       func([]*Msg)
+	// Set by wrapHandler for AutoAck.
+	ack bool
+
+	mu    sync.Mutex
+	buf   []*Msg
+	timer *time.Timer
+	// Held while h runs, so batches go one at a time.
+	run sync.Mutex
+}
+
+func (b *batcher) add(m *Msg) {
+	b.mu.Lock()
+	b.buf = append(b.buf, m)
+	if len(b.buf) == 1 {
+		b.timer = time.AfterFunc(b.maxWait, b.flush)
+	}
+	if len(b.buf) < b.max {
+		b.mu.Unlock()
+		return
+	}
+	b.deliver(b.take())
+}
+
+// flush hands over what there is.
+func (b *batcher) flush() {
+	b.mu.Lock()
+	b.deliver(b.take())
+}
+
+// take empties the buffer, called with mu held.
+func (b *batcher) take() []*Msg {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.buf
+	b.buf = nil
+	return batch
+}
+
+// deliver runs h on batch, called with mu held which it lets go of once
+// it's next in line.
+func (b *batcher) deliver(batch []*Msg) {
+	if len(batch) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.run.Lock()
+	b.mu.Unlock()
+	defer b.run.Unlock()
+	defer batch[0].c.recoverPanic(batch[0].Subject(), batch[0].sopts.panicSite())
+	done := false
+	if b.ack {
+		defer func() {
+			for _, m := range batch {
+				if done {
+					m.Ack()
+				} else {
+					m.Nak()
+				}
+			}
+		}()
+	}
+	b.h(batch)
+	done = true
+}
+
+// wait hands over the rest and waits for h to be done with it.
+func (b *batcher) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	idle := make(chan struct{})
+	go func() {
+		b.flush()
+		b.run.Lock()
+		b.run.Unlock()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop drops whatever is still collected.
+func (b *batcher) stop() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.take()
+	b.mu.Unlock()
+}
@@ -0,0 +1,166 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breakers, one per subject, shared by all requests on the
+// connection that ask for one. After threshold failures in a row, timeouts,
+// no responders or 5xx service errors, the breaker opens and requests fail
+// right away with ErrBreakerOpen. Once cooldown is up one request is let
+// through as a probe, if it works the breaker closes again, if not it stays
+// open for another cooldown.
+//
+//	nc, _ := natsv2.Connect(url, natsv2.OnBreakerChange(func(ev natsv2.BreakerEvent) { alert(ev) }))
+//	nc.Request("billing.charge", req, natsv2.Breaker(5, 30*time.Second))
+//
+// The first request on a subject sets its threshold and cooldown.
+
+var ErrBreakerOpen = errors.New("natsv2: circuit breaker open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+type BreakerEvent struct {
+	Subject  string
+	From, To BreakerState
+	// Failures in a row when it opened.
+	Failures int
+}
+
+type BreakerOptions struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+func Breaker(threshold int, cooldown time.Duration) ReqOption {
+	return func(o *ReqOptions) error {
+		if threshold < 1 {
+			return errors.New("natsv2: breaker threshold must be at least 1")
+		}
+		if cooldown <= 0 {
+			return errors.New("natsv2: breaker cooldown must be positive")
+		}
+		o.Breaker = &BreakerOptions{Threshold: threshold, Cooldown: cooldown}
+		return nil
+	}
+}
+
+func OnBreakerChange(cb func(BreakerEvent)) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.OnBreakerChange = append(o.OnBreakerChange, cb)
+		return nil
+	}
+}
+
+type breaker struct {
+	c       *conn
+	subject string
+	opts    BreakerOptions
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (c *conn) breaker(subject string, opts *BreakerOptions) *breaker {
+	b, _ := c.breakers.LoadOrStore(subject, &breaker{c: c, subject: subject, opts: *opts})
+	return b.(*breaker)
+}
+
+// allow reports whether a request can go ahead, false while open.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	var ev *BreakerEvent
+	defer func() {
+		b.mu.Unlock()
+		b.notify(ev)
+	}()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.opts.Cooldown {
+			return false
+		}
+		ev = b.set(BreakerHalfOpen)
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// One probe at a time.
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	var ev *BreakerEvent
+	defer func() {
+		b.mu.Unlock()
+		b.notify(ev)
+	}()
+	b.probing = false
+	if !breakerFailure(err) {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			ev = b.set(BreakerClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.opts.Threshold {
+		b.openedAt = time.Now()
+		if b.state != BreakerOpen {
+			ev = b.set(BreakerOpen)
+		}
+	}
+}
+
+func (b *breaker) set(to BreakerState) *BreakerEvent {
+	ev := &BreakerEvent{Subject: b.subject, From: b.state, To: to, Failures: b.failures}
+	b.state = to
+	return ev
+}
+
+func (b *breaker) notify(ev *BreakerEvent) {
+	if ev == nil {
+		return
+	}
+	b.c.log.Warn("circuit breaker", "subject", ev.Subject, "state", ev.To.String())
+	for _, cb := range b.c.opts.OnBreakerChange {
+		cb(*ev)
+	}
+}
+
+func breakerFailure(err error) bool {
+	var rerr *RequestError
+	if errors.As(err, &rerr) {
+		return strings.HasPrefix(rerr.Code, "5")
+	}
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrNoResponders)
+}
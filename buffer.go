@@ -0,0 +1,63 @@
+package natsv2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// PublishTo hands write a pooled buffer to encode straight into, instead of
+// building a []byte to hand to Publish, the client copies it out when
+// publishing so the buffer goes back to the pool right after. The payload
+// still gets the connection's pipeline encodings, headers and Content-Type
+// are up to the caller, e.g. with Headers.
+//
+//	nc.PublishTo("metrics", func(w io.Writer) error { return json.NewEncoder(w).Encode(sample) })
+//
+// Publish interceptors must not hang on to m.Data after they return, the
+// same goes for the encoders.
+
+var buffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// Buffers that grew past this are left for the GC, so one huge message
+// doesn't pin its memory in the pool.
+const maxPooledBuffer = 1 << 20
+
+func getBuffer() *bytes.Buffer {
+	return buffers.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	buffers.Put(buf)
+}
+
+func (c *conn) PublishTo(subject string, write func(w io.Writer) error, opts ...PubOption) error {
+	return c.PublishToCtx(context.Background(), subject, write, opts...)
+}
+
+func (c *conn) PublishToCtx(ctx context.Context, subject string, write func(w io.Writer) error, opts ...PubOption) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := write(buf); err != nil {
+		return err
+	}
+	m := &nats.Msg{Subject: subject, Data: buf.Bytes()}
+	if err := c.codecs.out.applyEncodings(m); err != nil {
+		return err
+	}
+	return c.publishMsg(ctx, m, opts...)
+}
+
+// pooledCopy is what's in buf, in a slice of its own.
+func pooledCopy(buf *bytes.Buffer) []byte {
+	out := make([]byte, buf.Len())
+	copy(out, buf.Bytes())
+	return out
+}
@@ -0,0 +1,42 @@
+package natsv2
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+type benchSample struct {
+	Host   string            `json:"host"`
+	Values []float64         `json:"values"`
+	Labels map[string]string `json:"labels"`
+}
+
+var sample = benchSample{
+	Host:   "web-1",
+	Values: []float64{0.25, 0.5, 0.75, 1, 2.5, 5, 10},
+	Labels: map[string]string{"region": "eu-west", "service": "checkout"},
+}
+
+func BenchmarkPublish(b *testing.B) {
+	nc := testConn(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := nc.Publish("metrics", sample); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPublishTo(b *testing.B) {
+	nc := testConn(b)
+	write := func(w io.Writer) error { return json.NewEncoder(w).Encode(sample) }
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := nc.PublishTo("metrics", write); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
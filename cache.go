@@ -0,0 +1,218 @@
+package natsv2
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Caching replies, for read heavy services like config or catalog lookups.
+// With Cached a reply is kept for ttl and the same request again, same
+// subject, payload, headers and Accept, gets it without going out. With
+// CacheStale as well a reply up to stale past its ttl is still returned,
+// and a request goes out in the background to refresh it:
+//
+//	r, err := nc.Request("catalog.item", ItemReq{SKU: "A1"}, Cached(time.Minute), CacheStale(10*time.Second))
+//
+// Only replies that aren't errors are kept. The cache is the connection's,
+// in memory and holding up to DefaultRequestCacheSize replies unless
+// WithRequestCache gives another, say one shared by several connections.
+// A cached request does no retries, breaker or metrics, those are for the
+// ones that go out. There is one refresh at a time per request, but misses
+// at the same time all go out.
+
+const DefaultRequestCacheSize = 1024
+
+// RequestCache keeps replies by key, Put's keep is how long one can be of
+// use, after which Get need not return it.
+type RequestCache interface {
+	Get(key string) (*CachedReply, bool)
+	Put(key string, r *CachedReply, keep time.Duration)
+}
+
+type CachedReply struct {
+	Subject string
+	Header  Header
+	Data    []byte
+	Stored  time.Time
+}
+
+func Cached(ttl time.Duration) ReqOption {
+	return func(o *ReqOptions) error {
+		if ttl <= 0 {
+			return errors.New("natsv2: cache ttl must be positive")
+		}
+		o.CacheTTL = ttl
+		return nil
+	}
+}
+
+func CacheStale(stale time.Duration) ReqOption {
+	return func(o *ReqOptions) error {
+		if stale < 0 {
+			return errors.New("natsv2: cache stale can't be negative")
+		}
+		o.CacheStale = stale
+		return nil
+	}
+}
+
+func WithRequestCache(cache RequestCache) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if cache == nil {
+			return errors.New("natsv2: nil request cache")
+		}
+		o.RequestCache = cache
+		return nil
+	}
+}
+
+func (c *conn) cachedRequest(subject string, m *nats.Msg, ropts *ReqOptions) (*Msg, error) {
+	if ropts.Streamed != nil {
+		return nil, errors.New("natsv2: streamed replies can't be cached")
+	}
+	key := cacheKey(m, ropts)
+	if r, ok := c.cache.Get(key); ok {
+		age := time.Since(r.Stored)
+		if age < ropts.CacheTTL {
+			c.log.Debug("cached reply", "subject", subject, "age", age)
+			return c.wrap(r.msg()), nil
+		}
+		if age < ropts.CacheTTL+ropts.CacheStale {
+			c.log.Debug("stale cached reply", "subject", subject, "age", age)
+			c.revalidate(key, subject, m, ropts)
+			return c.wrap(r.msg()), nil
+		}
+	}
+	r, err := c.sendRequest(subject, m, ropts)
+	if err == nil {
+		c.keep(key, r.m, ropts)
+	}
+	return r, err
+}
+
+// revalidate refreshes key's reply in the background, unless that is
+// already happening.
+func (c *conn) revalidate(key, subject string, m *nats.Msg, ropts *ReqOptions) {
+	if _, busy := c.revalidating.LoadOrStore(key, true); busy {
+		return
+	}
+	// The caller's context may be done with once it has its reply.
+	bg := *ropts
+	bg.Context = c.ctx
+	go func() {
+		defer c.revalidating.Delete(key)
+		r, err := c.sendRequest(subject, m, &bg)
+		if err != nil {
+			c.log.Debug("cache refresh failed", "subject", subject, "error", err)
+			return
+		}
+		c.keep(key, r.m, &bg)
+	}()
+}
+
+// keep puts a copy of m in the cache, the caller has m itself.
+func (c *conn) keep(key string, m *nats.Msg, ropts *ReqOptions) {
+	m = (&CachedReply{Subject: m.Subject, Header: Header(m.Header), Data: m.Data}).msg()
+	r := &CachedReply{Subject: m.Subject, Header: Header(m.Header), Data: m.Data, Stored: time.Now()}
+	c.cache.Put(key, r, ropts.CacheTTL+ropts.CacheStale)
+}
+
+// msg is a copy, so callers can't change what others get.
+func (r *CachedReply) msg() *nats.Msg {
+	m := &nats.Msg{Subject: r.Subject, Data: append([]byte(nil), r.Data...)}
+	if r.Header != nil {
+		m.Header = nats.Header{}
+		for k, v := range r.Header {
+			m.Header[k] = append([]string(nil), v...)
+		}
+	}
+	return m
+}
+
+// cacheKey is the subject and a hash of everything else that makes the
+// reply what it is.
+func cacheKey(m *nats.Msg, ropts *ReqOptions) string {
+	h := sha256.New()
+	write := func(hdr map[string][]string) {
+		keys := make([]string, 0, len(hdr))
+		for k := range hdr {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range hdr[k] {
+				h.Write([]byte(k + ":" + v + "\n"))
+			}
+		}
+	}
+	write(m.Header)
+	write(ropts.Headers)
+	write(map[string][]string{AcceptHeader: ropts.Accept, AcceptEncodingHeader: ropts.AcceptEncoding})
+	h.Write([]byte("\n"))
+	h.Write(m.Data)
+	return m.Subject + "#" + hex.EncodeToString(h.Sum(nil))
+}
+
+// NewMemoryCache is a RequestCache holding up to size replies, dropping
+// the least recently used.
+func NewMemoryCache(size int) RequestCache {
+	if size < 1 {
+		size = DefaultRequestCacheSize
+	}
+	return &memoryCache{size: size, lru: list.New(), items: map[string]*list.Element{}}
+}
+
+type memoryCache struct {
+	size int
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	r       *CachedReply
+	expires time.Time
+}
+
+func (mc *memoryCache) Get(key string) (*CachedReply, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	el, ok := mc.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memoryEntry)
+	if time.Now().After(e.expires) {
+		mc.lru.Remove(el)
+		delete(mc.items, key)
+		return nil, false
+	}
+	mc.lru.MoveToFront(el)
+	return e.r, true
+}
+
+func (mc *memoryCache) Put(key string, r *CachedReply, keep time.Duration) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	e := &memoryEntry{key: key, r: r, expires: time.Now().Add(keep)}
+	if el, ok := mc.items[key]; ok {
+		el.Value = e
+		mc.lru.MoveToFront(el)
+		return
+	}
+	mc.items[key] = mc.lru.PushFront(e)
+	for mc.lru.Len() > mc.size {
+		old := mc.lru.Back()
+		mc.lru.Remove(old)
+		delete(mc.items, old.Value.(*memoryEntry).key)
+	}
+}
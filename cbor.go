@@ -0,0 +1,32 @@
+package natsv2
+
+import (
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBOR, like MsgPack, for compact payloads without a schema, with a standard
+// behind it (RFC 8949) for the constrained devices that speak it. Both are
+// smaller than JSON, and faster for numbers and binary data, but can't be
+// read off the wire. Pick one for a connection with WithDefaultCodec, or for
+// a stream with WithEncoder.
+
+const CBORContentType = "application/cbor"
+
+// Times go as RFC 8949 tag 0 strings, to the nanosecond and with their
+// offset, where cbor.Marshal would cut them to whole seconds since the epoch.
+// Those still decode.
+var cborEnc, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano, TimeTag: cbor.EncTagRequired}.EncMode()
+
+func CBOR(v interface{}) ([]byte, error) {
+	return cborEnc.Marshal(v)
+}
+
+func DecodeCBOR(data []byte, v interface{}) error {
+	return cbor.Unmarshal(data, v)
+}
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string                     { return CBORContentType }
+func (cborCodec) Encode(v interface{}) ([]byte, error)    { return CBOR(v) }
+func (cborCodec) Decode(data []byte, v interface{}) error { return DecodeCBOR(data, v) }
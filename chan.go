@@ -0,0 +1,106 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Channel subscriptions, for select loops. What happens when the channel is
+// full is up to the Overflow policy, by default the subscription blocks until
+// there is room, and if that takes long enough the client's pending limits
+// make it a slow consumer.
+//
+//	orders := make(chan Order, 64)
+//	sub, err := natsv2.SubscribeChan(nc, "orders", orders, Overflow(OverflowDropOldest))
+
+type OverflowPolicy int
+
+const (
+	OverflowBlock OverflowPolicy = iota
+	// Make room by dropping the oldest message in the channel.
+	OverflowDropOldest
+	// Drop the new message.
+	OverflowDropNew
+)
+
+// ErrChannelFull is what the ErrorHandler gets for each dropped message, if
+// it was a request the requester gets a 503 service error.
+var ErrChannelFull = errors.New("natsv2: channel full, message dropped")
+
+// Overflow sets what Channel and SubscribeChan do when the channel is full.
+// Pull consumers always wait, they stop fetching instead.
+func Overflow(policy OverflowPolicy) SubOption {
+	return func(o *SubOptions) error {
+		if policy < OverflowBlock || policy > OverflowDropNew {
+			return fmt.Errorf("natsv2: unknown overflow policy %d", policy)
+		}
+		o.Overflow = policy
+		return nil
+	}
+}
+
+// SubscribeChan decodes each message into a T and delivers it on ch. Messages
+// that fail to decode go where DecodeErrors says, as with Subscribe.
+func SubscribeChan[T any](c Connection, subject string, ch chan T, opts ...SubOption) (Subscription, error) {
+	sopts := &SubOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, err
+		}
+	}
+	if sopts.Handler != nil || sopts.Channel != nil {
+		return nil, errors.New("natsv2: SubscribeChan takes no Handler or Channel")
+	}
+	return c.Subscribe(subject, append(opts, Handler(func(m *Msg) {
+		var v T
+		if err := c.Decode(m, &v); err != nil {
+			decodeFailedMsg(c, m, err)
+			return
+		}
+		deliver(ch, v, sopts.Overflow, func(T) {
+			// All we have of an older message is its value.
+			req := m.m
+			if sopts.Overflow == OverflowDropOldest {
+				req = nil
+			}
+			failed(c, req, "503", fmt.Errorf("%w: %q", ErrChannelFull, m.Subject()))
+		})
+	}))...)
+}
+
+// deliver puts v on ch according to policy, handing whatever it drops to
+// dropped.
+func deliver[T any](ch chan T, v T, policy OverflowPolicy, dropped func(T)) {
+	switch policy {
+	case OverflowDropNew:
+		select {
+		case ch <- v:
+		default:
+			dropped(v)
+		}
+		return
+	case OverflowDropOldest:
+		if cap(ch) == 0 {
+			// Nothing in there to drop.
+			select {
+			case ch <- v:
+			default:
+				dropped(v)
+			}
+			return
+		}
+		for {
+			select {
+			case ch <- v:
+				return
+			default:
+			}
+			select {
+			case old := <-ch:
+				dropped(old)
+			default:
+			}
+		}
+	}
+	ch <- v
+}
@@ -0,0 +1,195 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Chunked replies are for payloads bigger than what fits in one message.
+// The requester says it can take them with the Nats-Chunked header, carrying
+// the most it is willing to reassemble, and Respond splits anything bigger
+// than the chunk size into messages numbered from 1 in Nats-Chunk-Seq, the
+// last one also has Nats-Chunk-Last. Only the first chunk has the reply's
+// other headers. Responders that don't chunk still work, their single reply
+// is returned as is.
+
+const (
+	ChunkedHeader   = "Nats-Chunked"
+	ChunkSeqHeader  = "Nats-Chunk-Seq"
+	ChunkLastHeader = "Nats-Chunk-Last"
+
+	// Default for how much a chunked request will reassemble.
+	DefaultMaxReplySize = 64 * 1024 * 1024
+	// Default chunk size for responders, capped by the server's max payload.
+	DefaultChunkSize = 256 * 1024
+)
+
+var (
+	ErrReplyTooLarge = errors.New("natsv2: reply too large")
+	ErrChunkMissing  = errors.New("natsv2: chunk missing")
+)
+
+// Chunked lets the reply come back in chunks, reassembled before Request
+// returns, up to DefaultMaxReplySize unless MaxReplySize says otherwise.
+func Chunked() ReqOption {
+	return func(o *ReqOptions) error {
+		o.Chunked = true
+		return nil
+	}
+}
+
+func MaxReplySize(bytes int) ReqOption {
+	return func(o *ReqOptions) error {
+		if bytes < 1 {
+			return errors.New("natsv2: max reply size must be at least 1")
+		}
+		o.MaxReplySize = bytes
+		return nil
+	}
+}
+
+// WithChunkSize sets how big the chunks are that Respond sends to chunked
+// requests, and that ObjectStore puts.
+func WithChunkSize(bytes int) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if bytes < 1 {
+			return errors.New("natsv2: chunk size must be at least 1")
+		}
+		o.ChunkSize = bytes
+		return nil
+	}
+}
+
+func (c *conn) chunkSize() int {
+	if c.opts.ChunkSize > 0 {
+		return c.opts.ChunkSize
+	}
+	// Leave room for the headers.
+	if max := int(c.nc.MaxPayload()) - 1024; max > 0 && max < DefaultChunkSize {
+		return max
+	}
+	return DefaultChunkSize
+}
+
+func (c *conn) requestChunked(ctx context.Context, m *nats.Msg, max int) (*nats.Msg, error) {
+	var first *nats.Msg
+	var data []byte
+	sent := time.Now()
+	err := c.requestChunks(ctx, m, max, func(r *nats.Msg) error {
+		if first == nil {
+			first = r
+		}
+		data = append(data, r.Data...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	first.Header.Del(ChunkSeqHeader)
+	first.Header.Del(ChunkLastHeader)
+	first.Data = data
+	// To the last chunk, the handler time is to the first going out.
+	if m.Header.Get(LatencyHeader) != "" {
+		timeReply(first, sent)
+	}
+	return first, nil
+}
+
+// requestChunks sends a chunked request and hands each chunk to each as it
+// arrives, a single unchunked reply is handed over as the only one.
+func (c *conn) requestChunks(ctx context.Context, m *nats.Msg, max int, each func(*nats.Msg) error) error {
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
+		}
+	}
+	in, err := c.replyInbox()
+	if err != nil {
+		return err
+	}
+	defer in.close()
+	m.Reply = in.Reply
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	m.Header.Set(ChunkedHeader, strconv.Itoa(max))
+	if err := c.send(ctx, m, c.publish); err != nil {
+		return err
+	}
+
+	size := 0
+	for seq := 1; ; seq++ {
+		r, err := in.next(ctx)
+		if err != nil {
+			return wrapRequestError(m.Subject, err)
+		}
+		if seq == 1 && noResponders(r) {
+			return wrapRequestError(m.Subject, nats.ErrNoResponders)
+		}
+		if size += len(r.Data); size > max {
+			return fmt.Errorf("%w: more than %d bytes from %q", ErrReplyTooLarge, max, m.Subject)
+		}
+		s := r.Header.Get(ChunkSeqHeader)
+		if s == "" && seq == 1 {
+			return each(r)
+		}
+		if s != strconv.Itoa(seq) {
+			return fmt.Errorf("%w: got %q, want %d", ErrChunkMissing, s, seq)
+		}
+		if err := each(r); err != nil {
+			return err
+		}
+		if r.Header.Get(ChunkLastHeader) != "" {
+			return nil
+		}
+	}
+}
+
+// respondChunked sends reply in chunks if the request asked for that and it
+// does not fit in one.
+func (c *conn) respondChunked(req, reply *nats.Msg) error {
+	max, err := strconv.Atoi(req.Header.Get(ChunkedHeader))
+	size := c.chunkSize()
+	if err != nil || len(reply.Data) <= size {
+		return c.respondMsg(req, reply)
+	}
+	if reply.Header == nil {
+		reply.Header = nats.Header{}
+	}
+	if len(reply.Data) > max {
+		reply.Header.Set(ServiceErrorHeader, "reply too large")
+		reply.Header.Set(ServiceErrorCodeHeader, "413")
+		reply.Data = nil
+		return c.respondMsg(req, reply)
+	}
+	return c.sendReply(req, reply, size)
+}
+
+// chunks splits reply up, the first chunk has its headers.
+func chunks(reply *nats.Msg, size int) []*nats.Msg {
+	var out []*nats.Msg
+	data := reply.Data
+	for seq := 1; len(data) > 0; seq++ {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		chunk := nats.NewMsg(reply.Subject)
+		if seq == 1 {
+			chunk.Header = reply.Header
+		}
+		chunk.Header.Set(ChunkSeqHeader, strconv.Itoa(seq))
+		if n == len(data) {
+			chunk.Header.Set(ChunkLastHeader, "true")
+		}
+		chunk.Data = data[:n]
+		out = append(out, chunk)
+		data = data[n:]
+	}
+	return out
+}
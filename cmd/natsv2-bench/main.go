@@ -0,0 +1,128 @@
+// natsv2-bench runs natsbench over every combination of the modes, sizes,
+// client counts and codecs given, against a server or one in-process.
+//
+//	go run ./cmd/natsv2-bench -embedded -mode pub,pubsub,req -size 16,1024 -c 1,8 -codec raw,json,msgpack -format csv
+//
+// Results are a table unless -format says json or csv.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+	"github.com/derekcollison/natsv2.go/natsbench"
+)
+
+var codecs = map[string]string{
+	"raw":     "",
+	"json":    natsv2.JSONContentType,
+	"msgpack": natsv2.MsgPackContentType,
+	"cbor":    natsv2.CBORContentType,
+}
+
+func main() {
+	url := flag.String("s", nats.DefaultURL, "server URL")
+	embedded := flag.Bool("embedded", false, "run against an in-process server instead")
+	modes := flag.String("mode", "pub,pubsub,req", "pub, pubsub and req, comma separated")
+	msgs := flag.Int("n", natsbench.DefaultMsgs, "messages per run")
+	sizes := flag.String("size", strconv.Itoa(natsbench.DefaultSize), "payload sizes in bytes, comma separated")
+	clients := flag.String("c", "1", "publishers or requesters, comma separated")
+	subs := flag.Int("subs", 1, "subscribers for pubsub")
+	rate := flag.Int("rate", 0, "messages a second, 0 for as fast as possible")
+	codecList := flag.String("codec", "raw,json", "raw, json, msgpack and cbor, comma separated")
+	format := flag.String("format", "text", "text, json or csv")
+	flag.Parse()
+
+	write := map[string]func(io.Writer, []*natsbench.Result) error{
+		"text": natsbench.WriteText,
+		"json": natsbench.WriteJSON,
+		"csv":  natsbench.WriteCSV,
+	}[*format]
+	if write == nil {
+		log.Fatalf("unknown format %q", *format)
+	}
+	sizeList, err := ints(*sizes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientList, err := ints(*clients)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var contentTypes []string
+	for _, name := range split(*codecList) {
+		ct, ok := codecs[name]
+		if !ok {
+			log.Fatalf("unknown codec %q", name)
+		}
+		contentTypes = append(contentTypes, ct)
+	}
+
+	if *embedded {
+		s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Start()
+		defer s.Shutdown()
+		if !s.ReadyForConnections(10 * time.Second) {
+			log.Fatal("server not ready")
+		}
+		*url = s.ClientURL()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var results []*natsbench.Result
+	for _, mode := range split(*modes) {
+		for _, size := range sizeList {
+			for _, c := range clientList {
+				for _, ct := range contentTypes {
+					r, err := natsbench.Run(ctx, natsbench.Config{URL: *url, Mode: natsbench.Mode(mode), Msgs: *msgs,
+						Size: size, Clients: c, Subscribers: *subs, Codec: ct, Rate: *rate})
+					if err != nil {
+						log.Fatalf("%s size %d clients %d codec %q: %v", mode, size, c, ct, err)
+					}
+					results = append(results, r)
+				}
+			}
+		}
+	}
+	if err := write(os.Stdout, results); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func split(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func ints(s string) ([]int, error) {
+	var out []int
+	for _, f := range split(s) {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad number %q", f)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
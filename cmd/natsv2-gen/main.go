@@ -0,0 +1,304 @@
+// natsv2-gen turns service definitions into Go: an interface to implement,
+// a function that serves it as a natsv2 Service with an endpoint per method,
+// and a client with a method per endpoint, so both sides are checked by the
+// compiler.
+//
+//	//go:generate go run github.com/derekcollison/natsv2.go/cmd/natsv2-gen calc.natsv2.yaml
+//
+// A definition is YAML, with the request and response types naming Go types
+// of the package, or of one in imports:
+//
+//	package: calc
+//	services:
+//	  - name: calc
+//	    version: 1.0.0
+//	    description: Does sums
+//	    group: calc.v1
+//	    methods:
+//	      - name: Add
+//	        request: AddRequest
+//	        response: AddResponse
+//	      - name: Reset
+//	        request: ResetRequest
+//
+// A method serves the endpoint of its name in kebab case, "add", under the
+// group if there is one, or the subject given. Without a response it only
+// returns an error. The output is next to the input with .go added, or -o.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+type file struct {
+	Package  string    `yaml:"package"`
+	Imports  []string  `yaml:"imports"`
+	Services []service `yaml:"services"`
+
+	Source string `yaml:"-"`
+}
+
+type service struct {
+	Name        string   `yaml:"name"`
+	Version     string   `yaml:"version"`
+	Description string   `yaml:"description"`
+	Group       string   `yaml:"group"`
+	Methods     []method `yaml:"methods"`
+}
+
+type method struct {
+	Name     string `yaml:"name"`
+	Subject  string `yaml:"subject"`
+	Request  string `yaml:"request"`
+	Response string `yaml:"response"`
+}
+
+func main() {
+	out := flag.String("o", "", "output file, the input with .go added if empty")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: natsv2-gen [-o file.go] service.natsv2.yaml")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	in := flag.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(in, ".yaml") + ".go"
+	}
+	if err := run(in, *out); err != nil {
+		log.Fatalf("natsv2-gen: %v", err)
+	}
+}
+
+func run(in, out string) error {
+	data, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	f.Source = filepath.Base(in)
+	if err := f.check(); err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	src, err := f.generate()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, src, 0o644)
+}
+
+// As natsv2 has it for service and endpoint names.
+var nameRE = regexp.MustCompile(`^[A-Za-z0-9\-_]+$`)
+
+func (f *file) check() error {
+	if !token.IsIdentifier(f.Package) {
+		return fmt.Errorf("invalid package %q", f.Package)
+	}
+	if len(f.Services) == 0 {
+		return errors.New("no services")
+	}
+	seen := map[string]bool{}
+	for _, s := range f.Services {
+		if !nameRE.MatchString(s.Name) {
+			return fmt.Errorf("invalid service name %q", s.Name)
+		}
+		if s.Version == "" {
+			return fmt.Errorf("service %s has no version", s.Name)
+		}
+		if seen[s.GoName()] {
+			return fmt.Errorf("service %s twice", s.GoName())
+		}
+		seen[s.GoName()] = true
+		if len(s.Methods) == 0 {
+			return fmt.Errorf("service %s has no methods", s.Name)
+		}
+		methods := map[string]bool{}
+		for _, m := range s.Methods {
+			if !token.IsIdentifier(m.Name) || !token.IsExported(m.Name) {
+				return fmt.Errorf("service %s: method %q is not an exported Go name", s.Name, m.Name)
+			}
+			if methods[m.Name] {
+				return fmt.Errorf("service %s: method %s twice", s.Name, m.Name)
+			}
+			methods[m.Name] = true
+			if m.Request == "" {
+				return fmt.Errorf("service %s: method %s has no request type", s.Name, m.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// GoName is the service name as an exported Go name, "order-book" is
+// OrderBook.
+func (s service) GoName() string {
+	var b strings.Builder
+	up := true
+	for _, r := range s.Name {
+		if r == '-' || r == '_' {
+			up = true
+			continue
+		}
+		if up {
+			r = unicode.ToUpper(r)
+			up = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if !unicode.IsLetter(rune(name[0])) {
+		name = "S" + name
+	}
+	return name
+}
+
+// Endpoint is the method's name in kebab case, GetOrder is get-order.
+func (m method) Endpoint() string {
+	var b strings.Builder
+	rs := []rune(m.Name)
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			// A new word, but not inside an acronym like ID.
+			if i > 0 && (unicode.IsLower(rs[i-1]) || i+1 < len(rs) && unicode.IsLower(rs[i+1]) && unicode.IsUpper(rs[i-1])) {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// FullSubject is what a client sends to.
+func (s service) FullSubject(m method) string {
+	switch {
+	case m.Subject != "":
+		return m.Subject
+	case s.Group != "":
+		return s.Group + "." + m.Endpoint()
+	}
+	return m.Endpoint()
+}
+
+func (f *file) generate() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, f); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated code does not parse, check the type names: %w", err)
+	}
+	return src, nil
+}
+
+var tmpl = template.Must(template.New("").Parse(`// Code generated by natsv2-gen from {{.Source}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+{{- range .Imports}}
+	"{{.}}"
+{{- end}}
+)
+{{range $s := .Services}}
+// {{$s.GoName}}Server is the {{$s.Name}} service{{if $s.Description}}: {{$s.Description}}{{end}}.
+type {{$s.GoName}}Server interface {
+{{- range $s.Methods}}
+	{{.Name}}(ctx context.Context, req *{{.Request}}) {{if .Response}}(*{{.Response}}, error){{else}}error{{end}}
+{{- end}}
+}
+
+// Register{{$s.GoName}} serves srv as {{$s.Name}} {{$s.Version}}, opts are for the whole service.
+func Register{{$s.GoName}}(nc natsv2.Connection, srv {{$s.GoName}}Server, opts ...natsv2.ServiceOption) (natsv2.Service, error) {
+	{{- if $s.Description}}
+	opts = append([]natsv2.ServiceOption{natsv2.Description({{printf "%q" $s.Description}})}, opts...)
+	{{- end}}
+	svc, err := nc.Service({{printf "%q" $s.Name}}, {{printf "%q" $s.Version}}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	{{- if $s.Group}}
+	g := svc.AddGroup({{printf "%q" $s.Group}})
+	{{- else}}
+	var g natsv2.ServiceGroup = svc
+	{{- end}}
+	endpoints := []struct {
+		name string
+		opts []natsv2.ServiceOption
+	}{
+	{{- range $s.Methods}}
+		{ {{- printf "%q" .Endpoint}}, []natsv2.ServiceOption{
+			{{- if .Subject}}natsv2.ServiceSubject({{printf "%q" .Subject}}), {{end -}}
+			natsv2.ServiceHandleFunc(func(ctx context.Context, m *natsv2.Msg) (interface{}, error) {
+			req := new({{.Request}})
+			if err := m.Decode(req); err != nil {
+				return nil, &natsv2.RequestError{Code: "400", Description: err.Error()}
+			}
+			{{- if .Response}}
+			resp, err := srv.{{.Name}}(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+			{{- else}}
+			return nil, srv.{{.Name}}(ctx, req)
+			{{- end}}
+		})}},
+	{{- end}}
+	}
+	for _, e := range endpoints {
+		if err := g.AddEndpoint(e.name, e.opts...); err != nil {
+			svc.Shutdown()
+			return nil, err
+		}
+	}
+	return svc, nil
+}
+
+// {{$s.GoName}}Client calls the {{$s.Name}} service.
+type {{$s.GoName}}Client struct {
+	nc natsv2.Connection
+}
+
+func New{{$s.GoName}}Client(nc natsv2.Connection) *{{$s.GoName}}Client {
+	return &{{$s.GoName}}Client{nc: nc}
+}
+{{range $s.Methods}}
+func (c *{{$s.GoName}}Client) {{.Name}}(ctx context.Context, req *{{.Request}}, opts ...natsv2.ReqOption) {{if .Response}}(*{{.Response}}, error){{else}}error{{end}} {
+	{{- if .Response}}
+	resp := new({{.Response}})
+	if _, err := natsv2.RequestMsgInto(c.nc, {{printf "%q" ($s.FullSubject .)}}, req, resp, append([]natsv2.ReqOption{natsv2.Ctx(ctx)}, opts...)...); err != nil {
+		return nil, err
+	}
+	return resp, nil
+	{{- else}}
+	_, err := c.nc.Request({{printf "%q" ($s.FullSubject .)}}, req, append([]natsv2.ReqOption{natsv2.Ctx(ctx)}, opts...)...)
+	return err
+	{{- end}}
+}
+{{end}}
+{{- end}}`))
@@ -0,0 +1,153 @@
+// natsv2 talks to NATS from the terminal through the natsv2 API, so what it
+// sends and shows goes through the same codecs, encodings and headers an
+// application's would.
+//
+//	natsv2 pub -codec msgpack -encoding gzip orders.new '{"id": 22}'
+//	natsv2 sub -q audit 'orders.>'
+//	natsv2 req -H Tenant:acme calc.v1.add '{"A": 2, "B": 2}'
+//	natsv2 new -module example.com/orders orders
+//
+// Payloads for -codec are JSON, encoded with it on the way out. Received
+// ones are decoded with what their Content-Type and Content-Encoding say
+// and printed as JSON, or as they are if they aren't anything known. The
+// server is -s, or NATS_URL.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+var commands = []struct {
+	name, usage string
+	run         func(args []string) error
+}{
+	{"pub", "publish a message", pub},
+	{"sub", "print what arrives on a subject", sub},
+	{"req", "send a request and print the reply", req},
+	{"new", "scaffold a service project", scaffold},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: natsv2 <command> [flags] [args]")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-5s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "natsv2 <command> -h for its flags")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				if errors.Is(err, flag.ErrHelp) {
+					os.Exit(2)
+				}
+				fmt.Fprintf(os.Stderr, "natsv2 %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+// connFlags are the flags every command talking to a server has.
+type connFlags struct {
+	url      string
+	codec    string
+	encoding string
+	headers  headerFlag
+}
+
+func (cf *connFlags) register(fs *flag.FlagSet) {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	fs.StringVar(&cf.url, "s", url, "server URL")
+	fs.StringVar(&cf.codec, "codec", "", "json, msgpack, cbor or a content type, to encode JSON payloads with")
+	fs.StringVar(&cf.encoding, "encoding", "", "content encodings, outermost first, e.g. base64,gzip")
+	fs.Var(&cf.headers, "H", "header as Key:Value, repeatable")
+}
+
+var codecNames = map[string]string{
+	"json":    natsv2.JSONContentType,
+	"msgpack": natsv2.MsgPackContentType,
+	"cbor":    natsv2.CBORContentType,
+}
+
+func (cf *connFlags) contentType() string {
+	if ct, ok := codecNames[cf.codec]; ok {
+		return ct
+	}
+	return cf.codec
+}
+
+func (cf *connFlags) connect() (natsv2.Connection, error) {
+	var opts []natsv2.ConnectOption
+	ct := cf.contentType()
+	if ct != "" {
+		opts = append(opts, natsv2.WithDefaultCodec(ct))
+	}
+	if cf.encoding != "" {
+		stages := strings.Split(cf.encoding, ",")
+		if ct != "" {
+			stages = append(stages, ct)
+		}
+		opts = append(opts, natsv2.WithPipeline(stages...))
+	}
+	return natsv2.Connect(cf.url, opts...)
+}
+
+// payload is what to send, the argument if there is one, stdin otherwise,
+// parsed as JSON for a codec.
+func (cf *connFlags) payload(args []string) (interface{}, error) {
+	var data []byte
+	if len(args) > 0 && args[0] != "-" {
+		data = []byte(strings.Join(args, " "))
+	} else {
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			return nil, err
+		}
+	}
+	if cf.contentType() == "" {
+		return data, nil
+	}
+	v, err := parseJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("payload for -codec %s is not JSON: %w", cf.codec, err)
+	}
+	return v, nil
+}
+
+type headerFlag map[string][]string
+
+func (h *headerFlag) String() string { return fmt.Sprint(map[string][]string(*h)) }
+
+func (h *headerFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(k) == "" {
+		return fmt.Errorf("header %q is not Key:Value", s)
+	}
+	if *h == nil {
+		*h = headerFlag{}
+	}
+	k = strings.TrimSpace(k)
+	(*h)[k] = append((*h)[k], strings.TrimSpace(v))
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+func pub(args []string) error {
+	fs := flag.NewFlagSet("pub", flag.ContinueOnError)
+	var cf connFlags
+	cf.register(fs)
+	count := fs.Int("n", 1, "times to publish it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: natsv2 pub [flags] subject [payload | -]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	v, err := cf.payload(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	nc, err := cf.connect()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	for i := 0; i < *count; i++ {
+		if err := nc.Publish(fs.Arg(0), v, natsv2.Headers(cf.headers)); err != nil {
+			return err
+		}
+	}
+	return nc.Flush(context.Background())
+}
+
+func sub(args []string) error {
+	fs := flag.NewFlagSet("sub", flag.ContinueOnError)
+	var cf connFlags
+	cf.register(fs)
+	queue := fs.String("q", "", "queue group")
+	count := fs.Int("n", 0, "stop after this many, 0 for never")
+	raw := fs.Bool("raw", false, "print payloads as they are, without decoding")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: natsv2 sub [flags] subject")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	nc, err := cf.connect()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ch := make(chan *natsv2.Msg, 64)
+	opts := []natsv2.SubOption{natsv2.Channel(ch)}
+	if *queue != "" {
+		opts = append(opts, natsv2.Queue(*queue))
+	}
+	if !*raw {
+		opts = append(opts, natsv2.Decompress())
+	}
+	s, err := nc.Subscribe(fs.Arg(0), opts...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	fmt.Fprintf(os.Stderr, "listening on %q\n", fs.Arg(0))
+	for n := 1; *count == 0 || n <= *count; n++ {
+		var m *natsv2.Msg
+		select {
+		case m = <-ch:
+		case <-ctx.Done():
+			return nil
+		}
+		fmt.Printf("[#%d] %s", n, m.Subject())
+		if m.Reply() != "" {
+			fmt.Printf(" reply %s", m.Reply())
+		}
+		fmt.Println()
+		show(os.Stdout, m, *raw)
+	}
+	return nil
+}
+
+func req(args []string) error {
+	fs := flag.NewFlagSet("req", flag.ContinueOnError)
+	var cf connFlags
+	cf.register(fs)
+	timeout := fs.Duration("timeout", natsv2.DefaultRequestTimeout, "how long to wait for the reply")
+	raw := fs.Bool("raw", false, "print the reply as it is, without decoding")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: natsv2 req [flags] subject [payload | -]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	v, err := cf.payload(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	nc, err := cf.connect()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	start := time.Now()
+	reply, err := nc.Request(fs.Arg(0), v, natsv2.Timeout(*timeout), natsv2.RequestHeaders(cf.headers))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "reply in %v\n", time.Since(start).Round(time.Microsecond))
+	show(os.Stdout, reply, *raw)
+	return nil
+}
+
+// show prints m's headers and payload, decoded unless raw.
+func show(w io.Writer, m *natsv2.Msg, raw bool) {
+	h := m.Header()
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(w, "%s: %s\n", k, v)
+		}
+	}
+	if len(keys) > 0 {
+		fmt.Fprintln(w)
+	}
+	data := m.Data()
+	if !raw && (h.Get(natsv2.ContentTypeHeader) != "" || h.Get(natsv2.ContentEncodingHeader) != "" || json.Valid(data)) {
+		var v interface{}
+		if err := m.Decode(&v); err == nil {
+			if out, err := json.MarshalIndent(jsonable(v), "", "  "); err == nil {
+				fmt.Fprintf(w, "%s\n\n", out)
+				return
+			}
+		}
+	}
+	if utf8.Valid(data) {
+		fmt.Fprintf(w, "%s\n\n", data)
+	} else {
+		fmt.Fprintf(w, "%q\n\n", data)
+	}
+}
+
+// jsonable turns the map[interface{}]interface{} some codecs decode into
+// something encoding/json takes.
+func jsonable(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[fmt.Sprint(k)] = jsonable(e)
+		}
+		return out
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = jsonable(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonable(e)
+		}
+	}
+	return v
+}
+
+// parseJSON keeps whole numbers integers, for codecs that tell them apart.
+func parseJSON(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return numbers(v), nil
+}
+
+func numbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = numbers(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = numbers(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// scaffold writes a service project built around a natsv2-gen definition,
+// with an Echo method to start from.
+func scaffold(args []string) error {
+	fs := flag.NewFlagSet("new", flag.ContinueOnError)
+	module := fs.String("module", "", "module path, the name if empty")
+	dir := fs.String("dir", "", "where to put it, ./name if empty")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: natsv2 new [flags] name")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	p := project{Name: fs.Arg(0), Module: *module}
+	if !serviceNameRE.MatchString(p.Name) {
+		return fmt.Errorf("%q is not a service name, letters, digits, - and _ only", p.Name)
+	}
+	if p.Module == "" {
+		p.Module = p.Name
+	}
+	p.GoName = goName(p.Name)
+	if *dir == "" {
+		*dir = p.Name
+	}
+	if entries, err := os.ReadDir(*dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", *dir)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	for name, tmpl := range scaffoldFiles {
+		name = strings.ReplaceAll(name, "NAME", p.Name)
+		f, err := os.Create(filepath.Join(*dir, name))
+		if err != nil {
+			return err
+		}
+		err = template.Must(template.New(name).Parse(tmpl)).Execute(f, p)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("created %s, then:\n\n\tcd %s && go mod tidy && go generate && go run .\n\tnatsv2 req %s.v1.echo '{\"Text\": \"hi\"}'\n", *dir, *dir, p.Name)
+	return nil
+}
+
+type project struct {
+	Name, GoName, Module string
+}
+
+// As natsv2 has it for service names.
+var serviceNameRE = regexp.MustCompile(`^[A-Za-z0-9\-_]+$`)
+
+// goName is natsv2-gen's, "order-book" is OrderBook.
+func goName(name string) string {
+	var b strings.Builder
+	up := true
+	for _, r := range name {
+		if r == '-' || r == '_' {
+			up = true
+			continue
+		}
+		if up {
+			r = unicode.ToUpper(r)
+			up = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if !unicode.IsLetter(rune(s[0])) {
+		s = "S" + s
+	}
+	return s
+}
+
+var scaffoldFiles = map[string]string{
+	"go.mod": `module {{.Module}}
+
+go 1.21
+`,
+	"NAME.natsv2.yaml": `package: main
+services:
+  - name: {{.Name}}
+    version: 0.1.0
+    description: The {{.Name}} service
+    group: {{.Name}}.v1
+    methods:
+      - name: Echo
+        request: EchoRequest
+        response: EchoResponse
+`,
+	"main.go": `// The {{.Name}} service, its endpoints are in {{.Name}}.natsv2.yaml.
+//
+//	go generate && go run .
+package main
+
+//go:generate go run github.com/derekcollison/natsv2.go/cmd/natsv2-gen {{.Name}}.natsv2.yaml
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/nats-io/nats.go"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+type EchoRequest struct {
+	Text string
+}
+
+type EchoResponse struct {
+	Text string
+}
+
+type server struct{}
+
+func (s *server) Echo(ctx context.Context, req *EchoRequest) (*EchoResponse, error) {
+	return &EchoResponse{Text: req.Text}, nil
+}
+
+func main() {
+	url := os.Getenv("NATS_URL")
+	if url == "" {
+		url = nats.DefaultURL
+	}
+	nc, err := natsv2.Connect(url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer nc.Close()
+	svc, err := Register{{.GoName}}(nc, &server{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("{{.Name}} is up on %s", url)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	svc.Shutdown()
+}
+`,
+}
@@ -0,0 +1,320 @@
+package natsv2
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Codecs turn values into payloads and back and are selected by the
+// Content-Type header. Encodings like gzip and base64 are byte to byte
+// transforms on top of that, listed in Content-Encoding in the order they
+// were applied, so Base64(Gzip(JSON(v))) is "gzip, base64".
+
+const (
+	ContentTypeHeader     = "Content-Type"
+	ContentEncodingHeader = "Content-Encoding"
+
+	JSONContentType = "application/json"
+	TextContentType = "text/plain"
+)
+
+var (
+	ErrUnknownContentType = errors.New("natsv2: no codec for content type")
+	ErrUnknownEncoding    = errors.New("natsv2: unknown content encoding")
+)
+
+type Codec interface {
+	ContentType() string
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+type Encoding interface {
+	Name() string
+	Encode([]byte) ([]byte, error)
+	Decode([]byte) ([]byte, error)
+}
+
+// WithCodec registers a codec, replacing any existing one for its content type.
+func WithCodec(codec Codec) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.Codecs = append(o.Codecs, codec)
+		return nil
+	}
+}
+
+// WithEncoding registers a content encoding, replacing any existing one of the same name.
+func WithEncoding(enc Encoding) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.Encodings = append(o.Encodings, enc)
+		return nil
+	}
+}
+
+// WithDefaultCodec selects the codec used when a message has no Content-Type.
+// Defaults to JSON.
+func WithDefaultCodec(contentType string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.DefaultCodec = contentType
+		return nil
+	}
+}
+
+type codecs struct {
+	byType map[string]Codec
+	// byType's content types in the order registered, for negotiate.
+	types     []string
+	encodings map[string]Encoding
+	def       Codec
+	// What Publish and Request encode with, see pipeline.go.
+	out pipeline
+	// See compress.go.
+	maxDecoded  int
+	minCompress int
+}
+
+func newCodecs(o *ConnectOptions) (*codecs, error) {
+	cs := &codecs{
+		byType:      make(map[string]Codec),
+		encodings:   make(map[string]Encoding),
+		maxDecoded:  o.MaxDecompressedSize,
+		minCompress: o.CompressMinSize,
+	}
+	if cs.maxDecoded == 0 {
+		cs.maxDecoded = DefaultMaxDecompressedSize
+	}
+	for _, codec := range append([]Codec{jsonCodec{}, textCodec{}, msgpackCodec{}, cborCodec{}, protoCodec{}}, o.Codecs...) {
+		cs.add(codec)
+	}
+	for _, enc := range append([]Encoding{gzipEncoding{}, zstdEncoding{}, s2Encoding{}, base64Encoding{}}, o.Encodings...) {
+		cs.encodings[strings.ToLower(enc.Name())] = enc
+	}
+	if cs.def = cs.byType[o.DefaultCodec]; cs.def == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, o.DefaultCodec)
+	}
+	cs.out = pipeline{codec: cs.def, minSize: cs.minCompress}
+	if len(o.Pipeline) > 0 {
+		var err error
+		if cs.out, err = cs.pipeline(o.Pipeline); err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+// with returns a copy of the registry with extra codecs added.
+func (cs *codecs) with(extra []Codec) *codecs {
+	ncs := &codecs{
+		byType:    make(map[string]Codec, len(cs.byType)+len(extra)),
+		types:     append([]string(nil), cs.types...),
+		encodings: cs.encodings,
+		def:       cs.def,
+		out:       cs.out,
+
+		maxDecoded:  cs.maxDecoded,
+		minCompress: cs.minCompress,
+	}
+	for ct, codec := range cs.byType {
+		ncs.byType[ct] = codec
+	}
+	for _, codec := range extra {
+		ncs.add(codec)
+	}
+	return ncs
+}
+
+// add registers codec, in place of one with the same content type.
+func (cs *codecs) add(codec Codec) {
+	ct := codec.ContentType()
+	if cs.byType[ct] == nil {
+		cs.types = append(cs.types, ct)
+	}
+	cs.byType[ct] = codec
+}
+
+// encode builds the message for msg. By default we accept some things as
+// is, but in the end we need []byte. Everything else goes through the codec
+// and the message gets its Content-Type, then any encodings are applied.
+func (cs *codecs) encode(subject string, msg interface{}, p pipeline) (*nats.Msg, error) {
+	m := &nats.Msg{Subject: subject}
+	switch v := msg.(type) {
+	case nil:
+	case []byte:
+		m.Data = v
+	case string:
+		m.Data = []byte(v)
+	case Payload:
+		if v.err != nil {
+			return nil, v.err
+		}
+		m.Data, m.Header = v.data, v.header()
+	default:
+		data, err := p.codec.Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		ct := p.codec.ContentType()
+		if c, ok := p.codec.(contentTypeFor); ok {
+			ct = c.contentTypeFor(v)
+		}
+		m.Data = data
+		m.Header = nats.Header{ContentTypeHeader: []string{ct}}
+	}
+	if err := p.applyEncodings(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Encode builds the message publishing v would, with the default codecs and
+// the codec for contentType, the default if empty. For transports other
+// than a connection, like natsv2test.
+func Encode(subject string, v interface{}, contentType string) (*nats.Msg, error) {
+	p := defaultCodecs.out
+	if contentType != "" {
+		codec := defaultCodecs.byType[contentType]
+		if codec == nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, contentType)
+		}
+		p = p.withCodec(codec)
+	}
+	return defaultCodecs.encode(subject, v, p)
+}
+
+// Decode undoes any content encodings and then decodes into v with the codec
+// matching the message's Content-Type, or the default codec if there is none.
+func (c *conn) Decode(m *Msg, v interface{}) error {
+	return c.codecs.decode(m.m, v)
+}
+
+func (cs *codecs) decode(m *nats.Msg, v interface{}) error {
+	data, err := cs.plain(m)
+	if err != nil {
+		return err
+	}
+	codec := cs.def
+	var params map[string]string
+	if ct := m.Header.Get(ContentTypeHeader); ct != "" {
+		if mt, ps, err := mime.ParseMediaType(ct); err == nil {
+			ct, params = mt, ps
+		}
+		if codec = cs.byType[ct]; codec == nil {
+			return fmt.Errorf("%w: %q", ErrUnknownContentType, ct)
+		}
+	}
+	if c, ok := codec.(paramDecoder); ok {
+		return c.decodeParams(data, params, v)
+	}
+	return codec.Decode(data, v)
+}
+
+// plain is m's payload with its content encodings undone.
+func (cs *codecs) plain(m *nats.Msg) ([]byte, error) {
+	data := m.Data
+	ce := m.Header.Get(ContentEncodingHeader)
+	if ce == "" {
+		return data, nil
+	}
+	names := strings.Split(ce, ",")
+	for i := len(names) - 1; i >= 0; i-- {
+		name := strings.TrimSpace(names[i])
+		enc, ok := cs.encodings[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownEncoding, name)
+		}
+		var err error
+		if data, err = cs.decodeEncoding(enc, m, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Codecs whose Content-Type carries parameters, see protobuf.go.
+type contentTypeFor interface {
+	contentTypeFor(v interface{}) string
+}
+
+type paramDecoder interface {
+	decodeParams(data []byte, params map[string]string, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                     { return JSONContentType }
+func (jsonCodec) Encode(v interface{}) ([]byte, error)    { return json.Marshal(v) }
+func (jsonCodec) Decode(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// textCodec is what fmt.Printf would give you, WithDefaultCodec(TextContentType)
+// to publish values that way. Decodes into *string and *[]byte only.
+type textCodec struct{}
+
+func (textCodec) ContentType() string { return TextContentType }
+
+func (textCodec) Encode(v interface{}) ([]byte, error) {
+	// My hunch is this is just as fast if not faster then doing all the
+	// low level stuff directly since buf pooling.
+	return []byte(fmt.Sprintf("%+v", v)), nil
+}
+
+func (textCodec) Decode(data []byte, v interface{}) error {
+	switch p := v.(type) {
+	case *string:
+		*p = string(data)
+	case *[]byte:
+		*p = append((*p)[:0], data...)
+	default:
+		return fmt.Errorf("natsv2: can not decode text into %T", v)
+	}
+	return nil
+}
+
+type gzipEncoding struct{}
+
+func (gzipEncoding) Name() string { return "gzip" }
+
+func (gzipEncoding) Encode(in []byte) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	zw, _ := gzipWriters.Get().(*gzip.Writer)
+	if zw == nil {
+		zw = gzip.NewWriter(buf)
+	} else {
+		zw.Reset(buf)
+	}
+	defer gzipWriters.Put(zw)
+	if _, err := zw.Write(in); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return pooledCopy(buf), nil
+}
+
+func (e gzipEncoding) Decode(in []byte) ([]byte, error) {
+	return e.DecodeLimit(in, DefaultMaxDecompressedSize)
+}
+
+type base64Encoding struct{}
+
+func (base64Encoding) Name() string { return "base64" }
+
+func (base64Encoding) Encode(in []byte) ([]byte, error) {
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(in)))
+	base64.StdEncoding.Encode(out, in)
+	return out, nil
+}
+
+func (base64Encoding) Decode(in []byte) ([]byte, error) {
+	out := make([]byte, base64.StdEncoding.DecodedLen(len(in)))
+	n, err := base64.StdEncoding.Decode(out, in)
+	return out[:n], err
+}
@@ -0,0 +1,157 @@
+package natsv2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecOrder struct {
+	ID     string            `json:"id" msgpack:"id" cbor:"id"`
+	Items  []string          `json:"items" msgpack:"items" cbor:"items"`
+	Total  float64           `json:"total" msgpack:"total" cbor:"total"`
+	Paid   bool              `json:"paid" msgpack:"paid" cbor:"paid"`
+	Tags   map[string]string `json:"tags" msgpack:"tags" cbor:"tags"`
+	Placed time.Time         `json:"placed" msgpack:"placed" cbor:"placed"`
+}
+
+// A round trip encodes v and decodes it into what into returns, which has to
+// come back equal to want.
+type codecCase struct {
+	name string
+	v    interface{}
+	into func() interface{}
+	want interface{}
+}
+
+func structuredCases() []codecCase {
+	placed := time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC)
+	order := codecOrder{ID: "o-1", Items: []string{"a", "b"}, Total: 9.5, Paid: true, Tags: map[string]string{"eu": "1"}, Placed: placed}
+	return []codecCase{
+		{"struct", order, func() interface{} { return &codecOrder{} }, &order},
+		{"map", map[string]int{"a": 1, "b": -2}, func() interface{} { return &map[string]int{} }, &map[string]int{"a": 1, "b": -2}},
+		{"nil", nil, func() interface{} { return new(interface{}) }, new(interface{})},
+		{"bytes", []byte{0, 1, 0xff}, func() interface{} { return &[]byte{} }, &[]byte{0, 1, 0xff}},
+		{"time", placed, func() interface{} { return &time.Time{} }, &placed},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	cases := map[string][]codecCase{
+		JSONContentType:    structuredCases(),
+		MsgPackContentType: structuredCases(),
+		CBORContentType:    structuredCases(),
+		TextContentType: {
+			{"string", "hello", func() interface{} { return new(string) }, ptr("hello")},
+			{"bytes", "hello", func() interface{} { return &[]byte{} }, &[]byte{'h', 'e', 'l', 'l', 'o'}},
+			{"value", 42, func() interface{} { return new(string) }, ptr("42")},
+		},
+		ProtobufContentType: {
+			{"message", wrapperspb.String("hello"), func() interface{} { return &wrapperspb.StringValue{} }, wrapperspb.String("hello")},
+		},
+	}
+	cs, err := newCodecs(&ConnectOptions{DefaultCodec: JSONContentType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ct := range cs.types {
+		codec := cs.byType[ct]
+		if len(cases[ct]) == 0 {
+			t.Errorf("no round trips for %s", ct)
+		}
+		for _, tc := range cases[ct] {
+			t.Run(ct+"/"+tc.name, func(t *testing.T) {
+				data, err := codec.Encode(tc.v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := tc.into()
+				if err := codec.Decode(data, got); err != nil {
+					t.Fatal(err)
+				}
+				if !codecEqual(got, tc.want) {
+					t.Fatalf("got %#v, want %#v", got, tc.want)
+				}
+			})
+		}
+	}
+}
+
+func codecEqual(got, want interface{}) bool {
+	switch w := want.(type) {
+	case proto.Message:
+		g, ok := got.(proto.Message)
+		return ok && proto.Equal(g, w)
+	case *time.Time:
+		g, ok := got.(*time.Time)
+		return ok && g.Equal(*w)
+	case *codecOrder:
+		g, ok := got.(*codecOrder)
+		if !ok || !g.Placed.Equal(w.Placed) {
+			return false
+		}
+		gc, wc := *g, *w
+		gc.Placed, wc.Placed = time.Time{}, time.Time{}
+		return reflect.DeepEqual(gc, wc)
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func ptr[T any](v T) *T { return &v }
+
+var benchCodecs = []struct {
+	name  string
+	codec Codec
+}{
+	{"JSON", jsonCodec{}},
+	{"MsgPack", msgpackCodec{}},
+	{"CBOR", cborCodec{}},
+}
+
+func benchOrder() codecOrder {
+	return codecOrder{
+		ID:     "o-123456",
+		Items:  []string{"widget", "gadget", "doohickey", "thingamajig"},
+		Total:  1234.56,
+		Paid:   true,
+		Tags:   map[string]string{"region": "eu-west", "channel": "web", "tier": "gold"},
+		Placed: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	order := benchOrder()
+	for _, bc := range benchCodecs {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bc.codec.Encode(order); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	order := benchOrder()
+	for _, bc := range benchCodecs {
+		data, err := bc.codec.Encode(order)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				var out codecOrder
+				if err := bc.codec.Decode(data, &out); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
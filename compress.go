@@ -0,0 +1,336 @@
+package natsv2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nats-io/nats.go"
+)
+
+// Compression. gzip, zstd and s2 (stream format) are registered content
+// encodings, so Decode and Pipeline know them. Decompressed sizes are capped
+// so a small message can't blow up into gigabytes, DefaultMaxDecompressedSize
+// unless changed with WithMaxDecompressedSize.
+//
+// Subscribing with Decompress undoes the Content-Encoding before the handler
+// sees the message, m.Data() is the plain payload and the header only keeps
+// what could not be undone.
+//
+// Pipelines skip compressing payloads under CompressMinSize, where it costs
+// more than it saves. Requesters can also ask for compressed replies with
+// AcceptEncoding, Respond uses the first one listed it knows, HTTP style q
+// values work, the same minimum applies:
+//
+//	nc, _ := Connect(url, WithPipeline("zstd", JSONContentType), WithCompressMinSize(512))
+//	nc.Request("reports.get", id, AcceptEncoding("zstd", "gzip"))
+//
+// Encoders and decoders are pooled, compressing many small messages doesn't
+// allocate a new one each time.
+
+const (
+	DefaultMaxDecompressedSize = 64 * 1024 * 1024
+
+	AcceptEncodingHeader = "Accept-Encoding"
+)
+
+var ErrDecompressedTooLarge = errors.New("natsv2: decompressed payload too large")
+
+func WithMaxDecompressedSize(bytes int) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if bytes < 1 {
+			return errors.New("natsv2: max decompressed size must be at least 1")
+		}
+		o.MaxDecompressedSize = bytes
+		return nil
+	}
+}
+
+func WithCompressMinSize(bytes int) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if bytes < 0 {
+			return errors.New("natsv2: compress min size can't be negative")
+		}
+		o.CompressMinSize = bytes
+		return nil
+	}
+}
+
+// CompressMinSize is WithCompressMinSize for one stream.
+func CompressMinSize(bytes int) StreamOption {
+	return func(o *StreamOptions) error {
+		if bytes < 0 {
+			return errors.New("natsv2: compress min size can't be negative")
+		}
+		o.CompressMinSize = bytes
+		return nil
+	}
+}
+
+func AcceptEncoding(names ...string) ReqOption {
+	return func(o *ReqOptions) error {
+		o.AcceptEncoding = append(o.AcceptEncoding, names...)
+		return nil
+	}
+}
+
+// Decompress undoes content encodings before the handler is called. Messages
+// that fail to decode go where DecodeErrors says, see decodeerror.go.
+func Decompress() SubOption {
+	return func(o *SubOptions) error {
+		o.Decompress = true
+		return nil
+	}
+}
+
+// limitedDecoder is for encodings that can blow up in size.
+type limitedDecoder interface {
+	DecodeLimit(in []byte, max int) ([]byte, error)
+}
+
+// compresses is true for the compressing encodings, the ones that need a
+// limit on the way back.
+func compresses(enc Encoding) bool {
+	_, ok := enc.(limitedDecoder)
+	return ok
+}
+
+// compressReply compresses reply with what req accepts, unless it's encoded
+// already or too small.
+func (cs *codecs) compressReply(req, reply *nats.Msg) error {
+	accept := req.Header.Get(AcceptEncodingHeader)
+	if accept == "" || len(reply.Data) == 0 || len(reply.Data) < cs.minCompress ||
+		reply.Header.Get(ContentEncodingHeader) != "" || reply.Header.Get(ServiceErrorCodeHeader) != "" {
+		return nil
+	}
+	for _, name := range parseAcceptEncoding(accept) {
+		enc, ok := cs.encodings[name]
+		if !ok || !compresses(enc) {
+			continue
+		}
+		data, err := enc.Encode(reply.Data)
+		if err != nil {
+			return err
+		}
+		reply.Data = data
+		reply.Header.Set(ContentEncodingHeader, enc.Name())
+		return nil
+	}
+	return nil
+}
+
+// parseAcceptEncoding lists the names in order of preference, without the
+// ones at q=0.
+func parseAcceptEncoding(accept string) []string {
+	type choice struct {
+		name string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				continue
+			}
+		}
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" && q > 0 {
+			choices = append(choices, choice{name, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	names := make([]string, len(choices))
+	for i, c := range choices {
+		names[i] = c.name
+	}
+	return names
+}
+
+func readLimit(r io.Reader, max int) ([]byte, error) {
+	out, err := io.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > max {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrDecompressedTooLarge, max)
+	}
+	return out, nil
+}
+
+// msgEncoding is for encodings that need the message, to pick a key or
+// carry a nonce in the header, see encrypt.go.
+type msgEncoding interface {
+	encodeMsg(m *nats.Msg, in []byte) ([]byte, error)
+	decodeMsg(m *nats.Msg, in []byte) ([]byte, error)
+}
+
+func (cs *codecs) decodeEncoding(enc Encoding, m *nats.Msg, data []byte) ([]byte, error) {
+	if me, ok := enc.(msgEncoding); ok {
+		return me.decodeMsg(m, data)
+	}
+	if ld, ok := enc.(limitedDecoder); ok {
+		return ld.DecodeLimit(data, cs.maxDecoded)
+	}
+	return enc.Decode(data)
+}
+
+// undoEncodings decodes m in place, outermost encoding first.
+func (cs *codecs) undoEncodings(m *nats.Msg) error {
+	ce := m.Header.Get(ContentEncodingHeader)
+	if ce == "" {
+		return nil
+	}
+	names := strings.Split(ce, ",")
+	for len(names) > 0 {
+		name := strings.TrimSpace(names[len(names)-1])
+		enc, ok := cs.encodings[strings.ToLower(name)]
+		if !ok {
+			break
+		}
+		data, err := cs.decodeEncoding(enc, m, m.Data)
+		if err != nil {
+			return err
+		}
+		m.Data = data
+		names = names[:len(names)-1]
+	}
+	if len(names) == 0 {
+		m.Header.Del(ContentEncodingHeader)
+	} else {
+		m.Header.Set(ContentEncodingHeader, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func (c *conn) decompress(o *SubOptions, handler nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		err := c.codecs.undoEncodings(m)
+		if err == nil || c.decodeFailed(o, m, &DecodeError{Subject: m.Subject, Err: err}, true) {
+			handler(m)
+		}
+	}
+}
+
+var (
+	gzipWriters sync.Pool
+	gzipReaders sync.Pool
+)
+
+func (gzipEncoding) DecodeLimit(in []byte, max int) ([]byte, error) {
+	zr, _ := gzipReaders.Get().(*gzip.Reader)
+	var err error
+	if zr == nil {
+		zr, err = gzip.NewReader(bytes.NewReader(in))
+	} else {
+		err = zr.Reset(bytes.NewReader(in))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReaders.Put(zr)
+	return readLimit(zr, max)
+}
+
+type zstdEncoding struct{}
+
+func (zstdEncoding) Name() string { return "zstd" }
+
+// EncodeAll is safe to use concurrently, one encoder does for everyone.
+var zstdEncoder = struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	err  error
+}{}
+
+func (zstdEncoding) Encode(in []byte) ([]byte, error) {
+	zstdEncoder.once.Do(func() {
+		zstdEncoder.enc, zstdEncoder.err = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	})
+	if zstdEncoder.err != nil {
+		return nil, zstdEncoder.err
+	}
+	return zstdEncoder.enc.EncodeAll(in, nil), nil
+}
+
+func (e zstdEncoding) Decode(in []byte) ([]byte, error) {
+	return e.DecodeLimit(in, DefaultMaxDecompressedSize)
+}
+
+var zstdDecoders sync.Pool
+
+func (zstdEncoding) DecodeLimit(in []byte, max int) ([]byte, error) {
+	zr, _ := zstdDecoders.Get().(*zstd.Decoder)
+	var err error
+	if zr == nil {
+		zr, err = zstd.NewReader(bytes.NewReader(in), zstd.WithDecoderConcurrency(1))
+	} else {
+		err = zr.Reset(bytes.NewReader(in))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		zr.Reset(nil)
+		zstdDecoders.Put(zr)
+	}()
+	return readLimit(zr, max)
+}
+
+type s2Encoding struct{}
+
+func (s2Encoding) Name() string { return "s2" }
+
+var (
+	s2Writers sync.Pool
+	s2Readers sync.Pool
+)
+
+func (s2Encoding) Encode(in []byte) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	zw, _ := s2Writers.Get().(*s2.Writer)
+	if zw == nil {
+		zw = s2.NewWriter(buf, s2.WriterConcurrency(1))
+	} else {
+		zw.Reset(buf)
+	}
+	defer func() {
+		zw.Reset(nil)
+		s2Writers.Put(zw)
+	}()
+	if _, err := zw.Write(in); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return pooledCopy(buf), nil
+}
+
+func (e s2Encoding) Decode(in []byte) ([]byte, error) {
+	return e.DecodeLimit(in, DefaultMaxDecompressedSize)
+}
+
+func (s2Encoding) DecodeLimit(in []byte, max int) ([]byte, error) {
+	zr, _ := s2Readers.Get().(*s2.Reader)
+	if zr == nil {
+		zr = s2.NewReader(bytes.NewReader(in))
+	} else {
+		zr.Reset(bytes.NewReader(in))
+	}
+	defer func() {
+		zr.Reset(nil)
+		s2Readers.Put(zr)
+	}()
+	return readLimit(zr, max)
+}
@@ -0,0 +1,253 @@
+package natsv2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+)
+
+// Connecting from configuration, so the servers and credentials can differ
+// between environments without code changes. A Config can come from a JSON
+// or YAML file, from the environment, the same variables the nats CLI uses,
+// or be filled in by code, and any mix of those:
+//
+//	cfg, err := LoadConfig("nats.yaml")
+//	err = cfg.LoadEnv("NATS") // NATS_URL and friends win over the file
+//	cfg.Options = append(cfg.Options, WithLogger(log))
+//	nc, err := ConnectWithConfig(cfg)
+//
+//	urls: [nats://n1:4222, nats://n2:4222]
+//	creds: /etc/nats/app.creds
+//	tls: {cert: client.pem, key: client-key.pem, ca: ca.pem}
+//	reconnect: {max: -1, wait: 2s}
+//	default_codec: application/msgpack
+//
+// What can't be written down, interceptors, middleware, loggers and the
+// like, goes in Options and is applied last.
+
+type Config struct {
+	Name string   `json:"name,omitempty" yaml:"name,omitempty"`
+	URLs []string `json:"urls,omitempty" yaml:"urls,omitempty"`
+
+	// At most one way to authenticate.
+	Creds    string `json:"creds,omitempty" yaml:"creds,omitempty"`
+	Nkey     string `json:"nkey,omitempty" yaml:"nkey,omitempty"`
+	User     string `json:"user,omitempty" yaml:"user,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
+	Token    string `json:"token,omitempty" yaml:"token,omitempty"`
+
+	TLS       *TLSConfig       `json:"tls,omitempty" yaml:"tls,omitempty"`
+	Reconnect ReconnectConfig  `json:"reconnect,omitempty" yaml:"reconnect,omitempty"`
+	Websocket *WebsocketConfig `json:"websocket,omitempty" yaml:"websocket,omitempty"`
+	Proxy     string           `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+
+	DefaultCodec  string   `json:"default_codec,omitempty" yaml:"default_codec,omitempty"`
+	Pipeline      []string `json:"pipeline,omitempty" yaml:"pipeline,omitempty"`
+	InboxPrefix   string   `json:"inbox_prefix,omitempty" yaml:"inbox_prefix,omitempty"`
+	SubjectPrefix string   `json:"subject_prefix,omitempty" yaml:"subject_prefix,omitempty"`
+	RateLimit     int      `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	DrainTimeout  Duration `json:"drain_timeout,omitempty" yaml:"drain_timeout,omitempty"`
+
+	Options []ConnectOption `json:"-" yaml:"-"`
+}
+
+// Set to connect over websocket, see transport.go.
+type WebsocketConfig struct {
+	Path        string `json:"path,omitempty" yaml:"path,omitempty"`
+	Compression bool   `json:"compression,omitempty" yaml:"compression,omitempty"`
+}
+
+// The files for WithTLS.
+type TLSConfig struct {
+	Cert string `json:"cert,omitempty" yaml:"cert,omitempty"`
+	Key  string `json:"key,omitempty" yaml:"key,omitempty"`
+	CA   string `json:"ca,omitempty" yaml:"ca,omitempty"`
+}
+
+// Zero is the default, see reconnect.go.
+type ReconnectConfig struct {
+	// Negative retries forever.
+	Max     int      `json:"max,omitempty" yaml:"max,omitempty"`
+	Wait    Duration `json:"wait,omitempty" yaml:"wait,omitempty"`
+	BufSize int      `json:"buf_size,omitempty" yaml:"buf_size,omitempty"`
+}
+
+// Duration is a time.Duration written as "2s" in config files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads a .json, .yaml or .yml file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	default:
+		return cfg, fmt.Errorf("natsv2: config %s: unknown format %q", path, ext)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("natsv2: config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// LoadEnv sets what is in the environment under prefix, e.g. NATS_URL for
+// "NATS", leaving the rest as it is. URL is comma separated.
+func (cfg *Config) LoadEnv(prefix string) error {
+	env := func(name string) (string, bool) {
+		return os.LookupEnv(prefix + "_" + name)
+	}
+	str := func(name string, to *string) {
+		if v, ok := env(name); ok {
+			*to = v
+		}
+	}
+	var err error
+	num := func(name string, to *int) {
+		if v, ok := env(name); ok && err == nil {
+			if *to, err = strconv.Atoi(v); err != nil {
+				err = fmt.Errorf("natsv2: %s_%s: %w", prefix, name, err)
+			}
+		}
+	}
+	dur := func(name string, to *Duration) {
+		if v, ok := env(name); ok && err == nil {
+			if err = to.UnmarshalText([]byte(v)); err != nil {
+				err = fmt.Errorf("natsv2: %s_%s: %w", prefix, name, err)
+			}
+		}
+	}
+	if v, ok := env("URL"); ok {
+		cfg.URLs = strings.Split(v, ",")
+	}
+	str("NAME", &cfg.Name)
+	str("CREDS", &cfg.Creds)
+	str("NKEY", &cfg.Nkey)
+	str("USER", &cfg.User)
+	str("PASSWORD", &cfg.Password)
+	str("TOKEN", &cfg.Token)
+	for _, name := range []string{"CERT", "KEY", "CA"} {
+		if _, ok := env(name); ok && cfg.TLS == nil {
+			cfg.TLS = &TLSConfig{}
+		}
+	}
+	if cfg.TLS != nil {
+		str("CERT", &cfg.TLS.Cert)
+		str("KEY", &cfg.TLS.Key)
+		str("CA", &cfg.TLS.CA)
+	}
+	str("PROXY", &cfg.Proxy)
+	num("MAX_RECONNECTS", &cfg.Reconnect.Max)
+	dur("RECONNECT_WAIT", &cfg.Reconnect.Wait)
+	num("RECONNECT_BUF_SIZE", &cfg.Reconnect.BufSize)
+	str("DEFAULT_CODEC", &cfg.DefaultCodec)
+	str("INBOX_PREFIX", &cfg.InboxPrefix)
+	str("SUBJECT_PREFIX", &cfg.SubjectPrefix)
+	num("RATE_LIMIT", &cfg.RateLimit)
+	dur("DRAIN_TIMEOUT", &cfg.DrainTimeout)
+	return err
+}
+
+// ConnectOptions is the Config as options, Options last.
+func (cfg Config) ConnectOptions() ([]ConnectOption, error) {
+	var opts []ConnectOption
+	auth := 0
+	if cfg.Creds != "" {
+		auth++
+		opts = append(opts, WithCreds(cfg.Creds))
+	}
+	if cfg.Nkey != "" {
+		auth++
+		opts = append(opts, WithNkey(cfg.Nkey))
+	}
+	if cfg.User != "" {
+		auth++
+		opts = append(opts, WithUserPass(cfg.User, cfg.Password))
+	}
+	if cfg.Token != "" {
+		auth++
+		opts = append(opts, WithToken(cfg.Token))
+	}
+	if auth > 1 {
+		return nil, errors.New("natsv2: config has more than one of creds, nkey, user and token")
+	}
+	if cfg.Name != "" {
+		opts = append(opts, NATSOptions(nats.Name(cfg.Name)))
+	}
+	if t := cfg.TLS; t != nil {
+		opts = append(opts, WithTLS(t.Cert, t.Key, t.CA))
+	}
+	if ws := cfg.Websocket; ws != nil {
+		opts = append(opts, WithWebsocketOptions(WebsocketOptions{Path: ws.Path, Compression: ws.Compression}))
+	}
+	if cfg.Proxy != "" {
+		opts = append(opts, WithProxy(cfg.Proxy))
+	}
+	if r := cfg.Reconnect; r.Max != 0 {
+		opts = append(opts, WithMaxReconnects(r.Max))
+	}
+	if r := cfg.Reconnect; r.Wait != 0 {
+		opts = append(opts, WithReconnectWait(time.Duration(r.Wait)))
+	}
+	if r := cfg.Reconnect; r.BufSize != 0 {
+		opts = append(opts, WithReconnectBufSize(r.BufSize))
+	}
+	if cfg.DefaultCodec != "" {
+		opts = append(opts, WithDefaultCodec(cfg.DefaultCodec))
+	}
+	if len(cfg.Pipeline) > 0 {
+		opts = append(opts, WithPipeline(cfg.Pipeline...))
+	}
+	if cfg.InboxPrefix != "" {
+		opts = append(opts, WithInboxPrefix(cfg.InboxPrefix))
+	}
+	if cfg.SubjectPrefix != "" {
+		opts = append(opts, WithSubjectPrefix(cfg.SubjectPrefix))
+	}
+	if cfg.RateLimit > 0 {
+		opts = append(opts, WithRateLimit(cfg.RateLimit))
+	}
+	if cfg.DrainTimeout > 0 {
+		opts = append(opts, WithDrainTimeout(time.Duration(cfg.DrainTimeout)))
+	}
+	return append(opts, cfg.Options...), nil
+}
+
+// ConnectWithConfig connects to cfg's URLs, nats.DefaultURL if none.
+func ConnectWithConfig(cfg Config) (Connection, error) {
+	opts, err := cfg.ConnectOptions()
+	if err != nil {
+		return nil, err
+	}
+	url := nats.DefaultURL
+	if len(cfg.URLs) > 0 {
+		url = strings.Join(cfg.URLs, ",")
+	}
+	return Connect(url, opts...)
+}
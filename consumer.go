@@ -0,0 +1,280 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// JetStream consumers. Subscribing with JetStreamConsumer creates the consumer
+// on the server, durable if named, otherwise ephemeral and gone once we
+// unsubscribe. Push consumers get messages pushed like any subscription, pull
+// consumers are fetched from in batches by a goroutine of ours and handed to
+// the handler or Channel the same way, backing off when it is busy.
+//
+//	orders := nc.Stream("orders.>", JetStreamStream("ORDERS"))
+//	orders.Subscribe(JetStreamConsumer(ConsumerOptions{Durable: "billing", Pull: true}), AutoAck(), Handler(bill))
+//
+// Acks are up to the handler unless AutoAck is used, then a message is acked
+// once the handler returns and nak'ed if it panics. With AckNone there is
+// nothing to ack.
+
+type AckPolicy int
+
+const (
+	AckExplicit AckPolicy = iota
+	AckNone
+	AckAll
+)
+
+type ConsumerOptions struct {
+	// Durable name, ephemeral if empty.
+	Durable string
+	// Pull instead of push.
+	Pull      bool
+	AckPolicy AckPolicy
+	// Most unacked messages the server hands out, server default if 0.
+	MaxAckPending int
+	// Messages per fetch for pull consumers, as FetchBatch, see pull.go.
+	Batch int
+	// Idle heartbeats for push consumers, missed ones go to the
+	// ErrorHandler, or recreate an ordered consumer.
+	Heartbeat time.Duration
+	// See ordered.go.
+	Ordered bool
+	// Where delivery starts, from the beginning if both are zero, and at
+	// the pace messages were published instead of as fast as possible. See
+	// replay.go.
+	StartSeq       uint64
+	StartTime      time.Time
+	ReplayOriginal bool
+}
+
+const DefaultPullBatch = 64
+
+func JetStreamConsumer(opts ConsumerOptions) SubOption {
+	return func(o *SubOptions) error {
+		if opts.Durable != "" {
+			if err := checkQueue(opts.Durable); err != nil {
+				return err
+			}
+		}
+		if opts.MaxAckPending < 0 || opts.Batch < 0 || opts.Heartbeat < 0 {
+			return errors.New("natsv2: consumer limits can not be negative")
+		}
+		o.Consumer = &opts
+		return nil
+	}
+}
+
+func (co *ConsumerOptions) natsAckPolicy() nats.AckPolicy {
+	switch co.AckPolicy {
+	case AckNone:
+		return nats.AckNonePolicy
+	case AckAll:
+		return nats.AckAllPolicy
+	}
+	return nats.AckExplicitPolicy
+}
+
+// Set by stream.Subscribe for streams bound with JetStreamStream.
+func bindStream(name string) SubOption {
+	return func(o *SubOptions) error {
+		o.stream = name
+		return nil
+	}
+}
+
+func (c *conn) subscribeJetStream(subject string, sopts *SubOptions, handler nats.MsgHandler) (Subscription, error) {
+	co := sopts.Consumer
+	jopts := []nats.SubOpt{nats.ManualAck()}
+	switch co.AckPolicy {
+	case AckNone:
+		jopts = append(jopts, nats.AckNone())
+	case AckAll:
+		jopts = append(jopts, nats.AckAll())
+	default:
+		jopts = append(jopts, nats.AckExplicit())
+	}
+	if co.MaxAckPending > 0 {
+		jopts = append(jopts, nats.MaxAckPending(co.MaxAckPending))
+	}
+	switch {
+	case co.Ordered:
+		var err error
+		if jopts, err = c.orderedOpts(sopts); err != nil {
+			return nil, err
+		}
+	case sopts.bound:
+		// An existing consumer, see pull.go.
+		jopts = []nats.SubOpt{nats.ManualAck(), nats.Bind(sopts.stream, co.Durable)}
+	case sopts.stream != "":
+		jopts = append(jopts, nats.BindStream(sopts.stream))
+	}
+	if !sopts.bound {
+		switch {
+		case co.StartSeq > 0:
+			jopts = append(jopts, nats.StartSequence(co.StartSeq))
+		case !co.StartTime.IsZero():
+			jopts = append(jopts, nats.StartTime(co.StartTime))
+		}
+		if co.ReplayOriginal {
+			jopts = append(jopts, nats.ReplayOriginal())
+		}
+	}
+	if co.Heartbeat > 0 && !co.Pull {
+		jopts = append(jopts, nats.IdleHeartbeat(co.Heartbeat))
+	}
+
+	if co.Pull {
+		if sopts.Queue != "" {
+			return nil, errors.New("natsv2: pull consumers are shared by name, not queue")
+		}
+		if handler == nil && sopts.Channel == nil {
+			return nil, errors.New("natsv2: pull consumer needs a Handler or Channel")
+		}
+		if err := sopts.Fetch.check(); err != nil {
+			return nil, err
+		}
+		sub, err := c.js.PullSubscribe(subject, co.Durable, jopts...)
+		if err != nil {
+			return nil, err
+		}
+		batch := co.Batch
+		if batch == 0 {
+			batch = sopts.Fetch.batch()
+		}
+		ps := &pullSubscription{subscription: subscription{sub: sub, c: c, sopts: sopts}}
+		ps.start(c, batch, &sopts.Fetch, func(ctx context.Context, m *nats.Msg) bool {
+			if handler != nil {
+				handler(m)
+				return true
+			}
+			select {
+			case sopts.Channel <- c.wrap(c.unmap(m)):
+				if sopts.AutoAck && co.AckPolicy != AckNone {
+					m.Ack()
+				}
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+		return ps, nil
+	}
+
+	if co.Durable != "" {
+		jopts = append(jopts, nats.Durable(co.Durable))
+	}
+	var sub *nats.Subscription
+	var err error
+	switch {
+	case handler == nil && sopts.Queue != "":
+		sub, err = c.js.QueueSubscribeSync(subject, sopts.Queue, jopts...)
+	case handler == nil:
+		sub, err = c.js.SubscribeSync(subject, jopts...)
+	default:
+		sub, err = c.js.QueueSubscribe(subject, sopts.Queue, handler, jopts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription{sub: sub, c: c, sopts: sopts}, nil
+}
+
+// autoAck acks after handler returns and naks if it panics, the panic still
+// goes on to recoverHandler.
+func autoAck(handler nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		done := false
+		defer func() {
+			if done {
+				m.Ack()
+			} else {
+				m.Nak()
+			}
+		}()
+		handler(m)
+		done = true
+	}
+}
+
+// pullSubscription runs the fetch loop for a pull consumer.
+type pullSubscription struct {
+	subscription
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+func (ps *pullSubscription) start(c *conn, batch int, fo *FetchOptions, deliver func(context.Context, *nats.Msg) bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ps.cancel = cancel
+	ps.wg.Add(1)
+	go func() {
+		defer ps.wg.Done()
+		c.fetchLoop(ctx, ps.sub, batch, fo, ps.sopts.pause, deliver)
+	}()
+}
+
+// fetchLoop fetches until ctx is done, deliver returning false stops it.
+// While paused it doesn't fetch.
+func (c *conn) fetchLoop(ctx context.Context, sub *nats.Subscription, batch int, fo *FetchOptions, pause *pauseGate, deliver func(context.Context, *nats.Msg) bool) {
+	for ctx.Err() == nil {
+		if pause.wait(ctx) != nil {
+			return
+		}
+		_, cancel, popts := fo.pull(ctx)
+		msgs, err := sub.Fetch(batch, popts...)
+		cancel()
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, nats.ErrTimeout) {
+			c.log.Warn("pull fetch failed", "subject", sub.Subject, "error", err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		for _, m := range msgs {
+			if !deliver(ctx, m) {
+				return
+			}
+		}
+	}
+}
+
+func (ps *pullSubscription) stop() {
+	ps.once.Do(func() {
+		ps.cancel()
+		ps.wg.Wait()
+	})
+}
+
+func (ps *pullSubscription) Close() {
+	ps.Unsubscribe()
+}
+
+func (ps *pullSubscription) Unsubscribe() error {
+	ps.stop()
+	return ps.subscription.Unsubscribe()
+}
+
+// Drain stops fetching, lets the batch in hand be handled and then drains.
+func (ps *pullSubscription) Drain(ctx context.Context) error {
+	ps.sopts.cancel()
+	stopped := make(chan struct{})
+	go func() {
+		ps.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		ps.subscription.Unsubscribe()
+		return ctx.Err()
+	}
+	return ps.subscription.Drain(ctx)
+}
@@ -0,0 +1,69 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Contexts for publishing and handling. PublishCtx gives up once ctx is done,
+// including while waiting on a full reconnect buffer: rather than failing
+// with nats.ErrReconnectBufExceeded it waits for the reconnect to make room.
+// Publish can't be cancelled so it still fails right away. JetStream
+// publishes wait for their ack up to ctx as well.
+//
+// Handlers get a context with the message, Msg.Context, HandlerFuncs, typed
+// Subscribe handlers and JetStreamHandlers as their argument. It is done
+// once the subscription is unsubscribed or drained, the service shut down,
+// or the connection drained or closed, so a long handler can stop early when
+// we are shutting down:
+//
+//	nc.Subscribe("reports.build", Handler(func(m *Msg) {
+//		for _, part := range parts {
+//			if m.Context().Err() != nil {
+//				m.Nak()
+//				return
+//			}
+//			build(part)
+//		}
+//	}))
+//
+// Draining still waits for the handlers, the context only tells them to
+// hurry.
+
+// Context is done once whatever m came in on stops, see above, or a
+// request's deadline passes, see deadline.go. Messages not from a
+// subscription, like Request's reply, have the connection's. It carries m's
+// correlation, see correlate.go.
+func (m *Msg) Context() context.Context {
+	ctx := context.Background()
+	switch {
+	case m.hctx != nil:
+		ctx = m.hctx
+	case m.c != nil:
+		ctx = m.c.hctx
+	}
+	return m.withDeadline(correlatedBy(ctx, m.m))
+}
+
+// Polled, nats.go doesn't say when the buffer has room.
+const reconnectBufPoll = 10 * time.Millisecond
+
+// publishBuffered retries a publish that didn't fit in the reconnect buffer
+// until it does or ctx is done.
+func (c *conn) publishBuffered(ctx context.Context, m *nats.Msg) error {
+	t := time.NewTicker(reconnectBufPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := c.nc.PublishMsg(m); !errors.Is(err, nats.ErrReconnectBufExceeded) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package natsv2
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Correlation and causation IDs, to follow a flow across services without
+// tracing. With WithCorrelation every message we send gets the
+// X-Correlation-Id of the flow it is part of and an ID of its own, and one
+// sent while handling another has that one's ID in X-Causation-Id. The flow
+// is carried by the handler's context, so pass it on:
+//
+//	nc.Subscribe("orders.new", Handler(func(m *Msg) {
+//		nc.PublishCtx(m.Context(), "billing.charge", charge)
+//		nc.Request("stock.reserve", items, Ctx(m.Context()))
+//	}))
+//
+// Replies carry the request's flow on their own. A message sent without one
+// starts a new flow, or the one ContextWithCorrelationID says. A message's ID
+// is its Nats-Msg-Id if it has one, X-Message-Id otherwise.
+//
+// CorrelationLogHandler puts the IDs on slog records logged with the
+// handler's context:
+//
+//	log := slog.New(CorrelationLogHandler(slog.NewJSONHandler(os.Stderr, nil)))
+//	log.InfoContext(m.Context(), "order accepted")
+
+const (
+	CorrelationIDHeader = "X-Correlation-Id"
+	CausationIDHeader   = "X-Causation-Id"
+	MessageIDHeader     = "X-Message-Id"
+)
+
+func WithCorrelation() ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.Correlation = true
+		return nil
+	}
+}
+
+type correlationKey struct{}
+
+type correlation struct {
+	id    string
+	cause string
+}
+
+// ContextWithCorrelationID has messages sent with ctx join the flow id, e.g.
+// one from an incoming HTTP request.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, correlation{id: id})
+}
+
+// CorrelationID is the flow ctx is part of, "" for none.
+func CorrelationID(ctx context.Context) string {
+	cr, _ := ctx.Value(correlationKey{}).(correlation)
+	return cr.id
+}
+
+func (m *Msg) CorrelationID() string { return m.Header().Get(CorrelationIDHeader) }
+func (m *Msg) CausationID() string   { return m.Header().Get(CausationIDHeader) }
+func (m *Msg) MessageID() string     { return messageID(m.m) }
+
+func messageID(m *nats.Msg) string {
+	if id := m.Header.Get(MsgIDHeader); id != "" {
+		return id
+	}
+	return m.Header.Get(MessageIDHeader)
+}
+
+// correlatedBy is ctx in m's flow, with m as the cause. A message from
+// outside any flow starts one with its ID.
+func correlatedBy(ctx context.Context, m *nats.Msg) context.Context {
+	id := messageID(m)
+	cr := correlation{id: m.Header.Get(CorrelationIDHeader), cause: id}
+	if cr.id == "" {
+		cr.id = id
+	}
+	if cr.id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, correlationKey{}, cr)
+}
+
+// correlate stamps m with the flow in ctx, or a new one.
+func (c *conn) correlate(ctx context.Context, m *nats.Msg) {
+	if !c.opts.Correlation {
+		return
+	}
+	if m.Header.Get(CorrelationIDHeader) == "" {
+		cr, _ := ctx.Value(correlationKey{}).(correlation)
+		if cr.id == "" {
+			cr.id = nuid.Next()
+		}
+		m.Header.Set(CorrelationIDHeader, cr.id)
+		if cr.cause != "" && m.Header.Get(CausationIDHeader) == "" {
+			m.Header.Set(CausationIDHeader, cr.cause)
+		}
+	}
+	if messageID(m) == "" {
+		m.Header.Set(MessageIDHeader, nuid.Next())
+	}
+}
+
+// CorrelationLogHandler adds correlation_id and causation_id to records
+// logged with a context in a flow.
+func CorrelationLogHandler(h slog.Handler) slog.Handler {
+	return correlationLogHandler{h}
+}
+
+type correlationLogHandler struct {
+	slog.Handler
+}
+
+func (h correlationLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if cr, ok := ctx.Value(correlationKey{}).(correlation); ok {
+		r.AddAttrs(slog.String("correlation_id", cr.id))
+		if cr.cause != "" {
+			r.AddAttrs(slog.String("causation_id", cr.cause))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h correlationLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return correlationLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h correlationLogHandler) WithGroup(name string) slog.Handler {
+	return correlationLogHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,160 @@
+package natsv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Counters and rate limits shared by every instance of a service, on KV
+// alone. Both read the key and write it back only if its revision is still
+// the one read, trying again if someone got there first, so nothing is lost
+// however many update it at once:
+//
+//	n, err := kv.Increment("signups", 1)
+//
+//	quota, err := NewKVRateLimiter(kv, 100, 200) // 100/s per tenant, bursts of 200
+//	ok, err := quota.Allow("tenant." + tenantID)
+//
+// Counters are kept as decimal text, Get and Watch see "42". Each Allow is
+// two round trips to the server, with a local RateLimit in front where that
+// is too slow. The limiter's buckets are refilled by the clocks of whoever
+// takes from them, which need to agree to well within a second. A bucket
+// TTL of a few minutes clears out tenants that have gone quiet, they come
+// back full.
+
+// So a key updated faster than we can read it fails rather than spins.
+const casAttempts = 64
+
+var errContended = errors.New("natsv2: key changed on every attempt")
+
+func (b *kvBucket) Increment(key string, delta int64) (int64, error) {
+	for i := 0; i < casAttempts; i++ {
+		e, err := b.kv.Get(key)
+		if errors.Is(err, nats.ErrKeyNotFound) {
+			if _, err = b.kv.Create(key, []byte(strconv.FormatInt(delta, 10))); err == nil {
+				return delta, nil
+			}
+		}
+		if errors.Is(err, nats.ErrKeyExists) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseInt(string(e.Value()), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("natsv2: %s is not a counter: %w", key, err)
+		}
+		n += delta
+		_, err = b.kv.Update(key, []byte(strconv.FormatInt(n, 10)), e.Revision())
+		if err == nil {
+			return n, nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", errContended, key)
+}
+
+// A KVRateLimiter is a token bucket per key, e.g. per tenant.
+type KVRateLimiter struct {
+	kv    nats.KeyValue
+	rate  float64
+	burst float64
+}
+
+type tokenBucket struct {
+	Tokens float64 `json:"tokens"`
+	// Unix nanoseconds the tokens were counted at.
+	At int64 `json:"at"`
+}
+
+// NewKVRateLimiter lets through perSec for each key, up to burst at once.
+func NewKVRateLimiter(kv KV, perSec float64, burst int) (*KVRateLimiter, error) {
+	b, ok := kv.(*kvBucket)
+	if !ok {
+		return nil, fmt.Errorf("natsv2: no shared rate limits on a %T", kv)
+	}
+	if perSec <= 0 || burst < 1 {
+		return nil, errors.New("natsv2: rate limit must be positive with a burst of at least 1")
+	}
+	return &KVRateLimiter{kv: b.kv, rate: perSec, burst: float64(burst)}, nil
+}
+
+func (l *KVRateLimiter) Allow(key string) (bool, error) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN takes n tokens if there are that many, there is no taking part.
+func (l *KVRateLimiter) AllowN(key string, n int) (bool, error) {
+	ok, _, err := l.take(key, n)
+	return ok, err
+}
+
+// Wait takes a token, waiting for one until ctx is done.
+func (l *KVRateLimiter) Wait(ctx context.Context, key string) error {
+	for {
+		ok, wait, err := l.take(key, 1)
+		if err != nil || ok {
+			return err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// take takes n tokens, or says how long until there should be enough.
+func (l *KVRateLimiter) take(key string, n int) (bool, time.Duration, error) {
+	if float64(n) > l.burst {
+		return false, 0, fmt.Errorf("natsv2: %d tokens is more than the burst of %v", n, l.burst)
+	}
+	for i := 0; i < casAttempts; i++ {
+		now := time.Now()
+		tb := tokenBucket{Tokens: l.burst, At: now.UnixNano()}
+		var rev uint64
+		e, err := l.kv.Get(key)
+		switch {
+		case errors.Is(err, nats.ErrKeyNotFound):
+		case err != nil:
+			return false, 0, err
+		default:
+			if err := json.Unmarshal(e.Value(), &tb); err != nil {
+				return false, 0, fmt.Errorf("natsv2: %s is not a rate limit: %w", key, err)
+			}
+			rev = e.Revision()
+			elapsed := now.Sub(time.Unix(0, tb.At)).Seconds()
+			tb.Tokens = math.Min(l.burst, tb.Tokens+math.Max(0, elapsed)*l.rate)
+			tb.At = now.UnixNano()
+		}
+		if tb.Tokens < float64(n) {
+			return false, time.Duration((float64(n) - tb.Tokens) / l.rate * float64(time.Second)), nil
+		}
+		tb.Tokens -= float64(n)
+		data, _ := json.Marshal(tb)
+		if rev == 0 {
+			_, err = l.kv.Create(key, data)
+		} else {
+			_, err = l.kv.Update(key, data, rev)
+		}
+		if err == nil {
+			return true, 0, nil
+		}
+		if !errors.Is(err, nats.ErrKeyExists) {
+			return false, 0, err
+		}
+	}
+	return false, 0, fmt.Errorf("%w: %s", errContended, key)
+}
@@ -0,0 +1,178 @@
+package natsv2
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Picking up rotated credentials without a restart. The files given to
+// WithCreds and WithTLS are read again on every connect, so all a rotation
+// takes is a reconnect. ReloadCredentials checks the files and reconnects
+// if they are good, WatchCredentials does that whenever one of them
+// changes, checking every interval:
+//
+//	nc, _ := Connect(url, WithCreds("/run/secrets/app.creds"), WithTLS(cert, key, ca),
+//		WatchCredentials(30*time.Second),
+//		OnCredentialsReload(func(ev CredentialsReloadEvent) { log.Println(ev.Files, ev.Err) }))
+//
+// When the files don't parse, say one caught halfway through being written,
+// the connection keeps the ones it has and the callback gets the error, the
+// watch tries again once they change again. Failover groups' own Creds
+// aren't watched.
+
+type CredentialsReloadEvent struct {
+	// The ones that changed, all of them for ReloadCredentials.
+	Files []string
+	Err   error
+}
+
+// The credential files to check and watch, set by WithCreds and WithTLS.
+type credFiles struct {
+	creds, cert, key, ca string
+}
+
+func (f *credFiles) list() []string {
+	var files []string
+	for _, p := range []string{f.creds, f.cert, f.key, f.ca} {
+		if p != "" {
+			files = append(files, p)
+		}
+	}
+	return files
+}
+
+// check is whether the files would do for a connect.
+func (f *credFiles) check() error {
+	if f.creds != "" {
+		data, err := os.ReadFile(f.creds)
+		if err != nil {
+			return fmt.Errorf("natsv2: creds file: %w", err)
+		}
+		if _, err := nkeys.ParseDecoratedJWT(data); err != nil {
+			return fmt.Errorf("natsv2: creds file %q: %w", f.creds, err)
+		}
+		kp, err := nkeys.ParseDecoratedNKey(data)
+		if err != nil {
+			return fmt.Errorf("natsv2: creds file %q: %w", f.creds, err)
+		}
+		kp.Wipe()
+	}
+	if f.cert != "" {
+		if _, err := tls.LoadX509KeyPair(f.cert, f.key); err != nil {
+			return fmt.Errorf("natsv2: tls cert: %w", err)
+		}
+	}
+	if f.ca != "" {
+		data, err := os.ReadFile(f.ca)
+		if err != nil {
+			return fmt.Errorf("natsv2: tls ca file: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return fmt.Errorf("natsv2: tls ca file %q has no certificates", f.ca)
+		}
+	}
+	return nil
+}
+
+func WatchCredentials(interval time.Duration) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if interval <= 0 {
+			return errors.New("natsv2: credentials watch interval must be positive")
+		}
+		o.WatchCredentials = interval
+		return nil
+	}
+}
+
+func OnCredentialsReload(cb func(CredentialsReloadEvent)) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.OnCredentialsReload = append(o.OnCredentialsReload, cb)
+		return nil
+	}
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// credWatch keeps what the files looked like when last loaded.
+type credWatch struct {
+	mu     sync.Mutex
+	stamps map[string]fileStamp
+}
+
+func stampFiles(files []string) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil {
+			stamps[f] = fileStamp{fi.ModTime(), fi.Size()}
+		}
+	}
+	return stamps
+}
+
+func (c *conn) ReloadCredentials() error {
+	files := c.opts.credFiles.list()
+	if len(files) == 0 {
+		return errors.New("natsv2: no credential files to reload")
+	}
+	if c.closed.Load() {
+		return nats.ErrConnectionClosed
+	}
+	c.creds.mu.Lock()
+	defer c.creds.mu.Unlock()
+	return c.reloadCredentials(files, stampFiles(files))
+}
+
+// reloadCredentials reconnects with the files if they are good, called with
+// the watch's mu held.
+func (c *conn) reloadCredentials(changed []string, stamps map[string]fileStamp) error {
+	c.creds.stamps = stamps
+	err := c.opts.credFiles.check()
+	if err == nil {
+		c.log.Info("credentials changed, reconnecting", "files", changed)
+		err = c.nc.ForceReconnect()
+	}
+	if err != nil {
+		c.log.Warn("credentials reload failed", "files", changed, "error", err)
+		c.handleError(err)
+	}
+	for _, cb := range c.opts.OnCredentialsReload {
+		cb(CredentialsReloadEvent{Files: changed, Err: err})
+	}
+	return err
+}
+
+func (c *conn) watchCredentials(interval time.Duration) {
+	files := c.opts.credFiles.list()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-c.ctx.Done():
+			return
+		}
+		now := stampFiles(files)
+		c.creds.mu.Lock()
+		var changed []string
+		for _, f := range files {
+			if now[f] != c.creds.stamps[f] {
+				changed = append(changed, f)
+			}
+		}
+		if len(changed) > 0 {
+			c.reloadCredentials(changed, now)
+		}
+		c.creds.mu.Unlock()
+	}
+}
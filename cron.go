@@ -0,0 +1,137 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron specs for Schedule: minute, hour, day of month, month and day of
+// week, each *, a number, a range a-b, a step */n or a-b/n, or a comma
+// list of those. Days of the week are 0 to 6 from Sunday, 7 is Sunday too.
+// When both days are given either matching will do, as in cron. Also
+// @yearly, @monthly, @weekly, @daily, @hourly and @every 90s. Times are
+// in UTC.
+
+var ErrBadCron = errors.New("natsv2: invalid cron spec")
+
+type cronSpec struct {
+	minute, hour, dom, month, dow uint64
+	// Only one of either day restricted means both have to match.
+	domAny, dowAny bool
+	every          time.Duration
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(spec string) (*cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("%w: %q, @every needs a duration of a second or more", ErrBadCron, spec)
+		}
+		return &cronSpec{every: every}, nil
+	}
+	if m, ok := cronMacros[spec]; ok {
+		spec = m
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: %q, want 5 fields", ErrBadCron, spec)
+	}
+	cs := &cronSpec{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	for i, f := range []struct {
+		to       *uint64
+		min, max int
+	}{{&cs.minute, 0, 59}, {&cs.hour, 0, 23}, {&cs.dom, 1, 31}, {&cs.month, 1, 12}, {&cs.dow, 0, 7}} {
+		bits, err := cronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrBadCron, spec, err)
+		}
+		*f.to = bits
+	}
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1
+	}
+	return cs, nil
+}
+
+func cronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			rng = part[:i]
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			var err error
+			a, b, isRange := strings.Cut(rng, "-")
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (cs *cronSpec) dayMatches(t time.Time) bool {
+	dom, dow := cs.dom&(1<<t.Day()) != 0, cs.dow&(1<<t.Weekday()) != 0
+	if cs.domAny || cs.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next is the first time after t that matches, zero if none does within
+// five years, e.g. for February 30th.
+func (cs *cronSpec) next(t time.Time) time.Time {
+	if cs.every > 0 {
+		return t.Add(cs.every)
+	}
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case cs.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !cs.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case cs.hour&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case cs.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,208 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Dead letter routing for JetStream consumers. Once a message has been
+// delivered more than maxDeliveries times it is not handed to the handler
+// again, instead it is published to the dead letter subject with headers
+// describing where it came from and the original is acked so the server
+// stops redelivering it. The delivery count comes from the JetStream
+// metadata, so make sure the consumer's MaxDeliver is larger than
+// maxDeliveries or unlimited. Messages that are not from JetStream are
+// passed through untouched.
+//
+// With HandleJetStream the handler returns an error instead, a failed
+// message is nak'ed for redelivery until it is on its last delivery, then
+// dead lettered with the error in Nats-DLQ-Error. With maxDeliveries 0 the
+// first failure is the last.
+//
+//	orders.Subscribe(JetStreamConsumer(ConsumerOptions{Durable: "billing"}), DeadLetter("dlq.billing", 5),
+//		HandleJetStream(func(ctx context.Context, m *Msg) error { return bill(ctx, m) }))
+//
+// Dead letters are plain messages, subscribe to the subject, or put a stream
+// on it, to look at them. DeadLetterInfo reads the headers back and Redrive
+// publishes one to where it came from once whatever failed is fixed.
+
+const (
+	DeadLetterSubjectHeader    = "Nats-DLQ-Subject"
+	DeadLetterStreamHeader     = "Nats-DLQ-Stream"
+	DeadLetterConsumerHeader   = "Nats-DLQ-Consumer"
+	DeadLetterSequenceHeader   = "Nats-DLQ-Sequence"
+	DeadLetterDeliveriesHeader = "Nats-DLQ-Deliveries"
+	DeadLetterErrorHeader      = "Nats-DLQ-Error"
+)
+
+var ErrNotDeadLetter = errors.New("natsv2: not a dead letter")
+
+func DeadLetter(subject string, maxDeliveries int) SubOption {
+	return func(o *SubOptions) error {
+		if subject == "" {
+			return errors.New("natsv2: dead letter subject required")
+		}
+		if maxDeliveries < 0 {
+			return errors.New("natsv2: max deliveries can not be negative")
+		}
+		o.DeadLetter = subject
+		o.MaxDeliveries = maxDeliveries
+		return nil
+	}
+}
+
+// A JetStreamHandler's message is acked when it returns nil and nak'ed, or
+// dead lettered, when it returns an error or panics.
+type JetStreamHandler func(ctx context.Context, m *Msg) error
+
+func HandleJetStream(h JetStreamHandler) SubOption {
+	return func(o *SubOptions) error {
+		o.Handler, o.handlerName = func(m *Msg) { o.serveJetStream(m, h) }, funcName(h)
+		return nil
+	}
+}
+
+func (o *SubOptions) serveJetStream(m *Msg, h JetStreamHandler) {
+	attempts, err := o.retrying(m, h)
+	meta, merr := m.m.Metadata()
+	if err == nil {
+		if merr == nil {
+			m.m.Ack()
+		}
+		return
+	}
+	m.c.handleError(err)
+	last := merr != nil || meta.NumDelivered >= uint64(o.MaxDeliveries)
+	if o.Quarantine != nil && last {
+		if qerr := o.Quarantine.hold(m.m, meta, attempts, err); qerr != nil {
+			m.c.handleError(qerr)
+			if merr == nil {
+				m.m.Nak()
+			}
+		} else if merr == nil {
+			m.m.Ack()
+		}
+		return
+	}
+	if o.DeadLetter != "" && m.c != nil && last {
+		if m.c.sendDeadLetter(o, m.m, meta, err) && merr == nil {
+			m.m.Ack()
+		}
+		return
+	}
+	if merr == nil {
+		m.m.Nak()
+	}
+}
+
+func (c *conn) deadLetter(o *SubOptions, handler nats.MsgHandler) nats.MsgHandler {
+	if o.MaxDeliveries == 0 {
+		return handler
+	}
+	return func(m *nats.Msg) {
+		meta, err := m.Metadata()
+		if err != nil || meta.NumDelivered <= uint64(o.MaxDeliveries) {
+			handler(m)
+			return
+		}
+		if c.sendDeadLetter(o, m, meta, nil) {
+			m.Ack()
+		}
+	}
+}
+
+// sendDeadLetter reports whether the dead letter is out, meta is nil for
+// messages that aren't from JetStream.
+func (c *conn) sendDeadLetter(o *SubOptions, m *nats.Msg, meta *nats.MsgMetadata, cause error) bool {
+	dm := nats.NewMsg(c.outSubject(o.DeadLetter))
+	dm.Data = m.Data
+	for k, v := range m.Header {
+		dm.Header[k] = v
+	}
+	dm.Header.Set(DeadLetterSubjectHeader, m.Subject)
+	deliveries := uint64(1)
+	if meta != nil {
+		dm.Header.Set(DeadLetterStreamHeader, meta.Stream)
+		dm.Header.Set(DeadLetterConsumerHeader, meta.Consumer)
+		dm.Header.Set(DeadLetterSequenceHeader, strconv.FormatUint(meta.Sequence.Stream, 10))
+		deliveries = meta.NumDelivered
+	}
+	dm.Header.Set(DeadLetterDeliveriesHeader, strconv.FormatUint(deliveries, 10))
+	if cause != nil {
+		dm.Header.Set(DeadLetterErrorHeader, cause.Error())
+	}
+	// Only let go of the original once the dead letter is out.
+	if err := c.send(context.Background(), dm, c.publish); err != nil {
+		c.log.Warn("dead letter publish failed", "subject", o.DeadLetter, "error", err)
+		return false
+	}
+	if err := c.nc.Flush(); err != nil {
+		c.log.Warn("dead letter flush failed", "subject", o.DeadLetter, "error", err)
+		return false
+	}
+	c.log.Debug("dead lettered", "subject", m.Subject, "deliveries", deliveries)
+	return true
+}
+
+type DeadLetterInfo struct {
+	Subject    string
+	Stream     string
+	Consumer   string
+	Sequence   uint64
+	Deliveries uint64
+	Error      string
+}
+
+// DeadLetterInfo is where a dead letter came from, false if m isn't one.
+func (m *Msg) DeadLetterInfo() (DeadLetterInfo, bool) {
+	h := m.Header()
+	info := DeadLetterInfo{
+		Subject:  h.Get(DeadLetterSubjectHeader),
+		Stream:   h.Get(DeadLetterStreamHeader),
+		Consumer: h.Get(DeadLetterConsumerHeader),
+		Error:    h.Get(DeadLetterErrorHeader),
+	}
+	info.Sequence, _ = strconv.ParseUint(h.Get(DeadLetterSequenceHeader), 10, 64)
+	info.Deliveries, _ = strconv.ParseUint(h.Get(DeadLetterDeliveriesHeader), 10, 64)
+	return info, info.Subject != ""
+}
+
+// Redrive publishes a dead letter to its original subject, through
+// JetStream if it came from a stream, without the dead letter headers or
+// its message id so it isn't deduped. A dead letter that was itself
+// delivered by JetStream is acked after.
+func (m *Msg) Redrive() error {
+	info, ok := m.DeadLetterInfo()
+	if !ok {
+		return ErrNotDeadLetter
+	}
+	if m.c == nil {
+		return errors.New("natsv2: redrive needs a message from a connection")
+	}
+	rm := nats.NewMsg(info.Subject)
+	rm.Data = m.m.Data
+	for k, v := range m.m.Header {
+		rm.Header[k] = v
+	}
+	for _, k := range []string{DeadLetterSubjectHeader, DeadLetterStreamHeader, DeadLetterConsumerHeader,
+		DeadLetterSequenceHeader, DeadLetterDeliveriesHeader, DeadLetterErrorHeader, MsgIDHeader} {
+		rm.Header.Del(k)
+	}
+	var err error
+	if info.Stream != "" {
+		rm.Subject = m.c.outSubject(rm.Subject)
+		_, err = m.c.publishJetStream(context.Background(), info.Stream, rm, nil, nil)
+	} else {
+		err = m.c.publishMsg(context.Background(), rm)
+	}
+	if err != nil {
+		return err
+	}
+	if _, merr := m.m.Metadata(); merr == nil {
+		return m.m.Ack()
+	}
+	return nil
+}
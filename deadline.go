@@ -0,0 +1,89 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Requests carry their deadline, the one of their Timeout or Ctx, in
+// DeadlineHeader, and the handler's context, Msg.Context, is done once it
+// has passed, so a handler can stop working on what the requester has
+// already given up on:
+//
+//	nc.Subscribe("reports.build", Handler(func(m *Msg) {
+//		report, err := build(m.Context(), m.Data())
+//		if errors.Is(err, context.DeadlineExceeded) {
+//			return // nobody is waiting for it
+//		}
+//		m.Respond(report)
+//	}))
+//
+// Passing m.Context on to a Request made while handling hands the deadline
+// down to the next service too. The time is absolute, so the handler allows
+// for the clocks being apart by DefaultDeadlineSkew, or what WithDeadlineSkew
+// says, before giving up. Each Retry attempt has its own deadline.
+
+const DeadlineHeader = "Nats-Deadline"
+
+const DefaultDeadlineSkew = 250 * time.Millisecond
+
+// WithDeadlineSkew is how far the requesters' clocks may be behind ours,
+// a deadline counts as that much later.
+func WithDeadlineSkew(d time.Duration) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if d < 0 {
+			return errors.New("natsv2: deadline skew can't be negative")
+		}
+		o.DeadlineSkew = &d
+		return nil
+	}
+}
+
+// setDeadline stamps m with ctx's deadline, if it has one.
+func setDeadline(ctx context.Context, m *nats.Msg) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	m.Header.Set(DeadlineHeader, d.UTC().Format(time.RFC3339Nano))
+}
+
+// Deadline is when the requester gives up waiting for a reply, with the
+// skew allowed for, false if it didn't say.
+func (m *Msg) Deadline() (time.Time, bool) {
+	if m.m.Header == nil {
+		return time.Time{}, false
+	}
+	v := m.m.Header.Get(DeadlineHeader)
+	if v == "" {
+		return time.Time{}, false
+	}
+	d, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	skew := DefaultDeadlineSkew
+	if m.c != nil && m.c.opts.DeadlineSkew != nil {
+		skew = *m.c.opts.DeadlineSkew
+	}
+	return d.Add(skew), true
+}
+
+// withDeadline is ctx, done by m's deadline if it has one. It is made once,
+// the timer lets go of it when the deadline passes.
+func (m *Msg) withDeadline(ctx context.Context) context.Context {
+	d, ok := m.Deadline()
+	if !ok {
+		return ctx
+	}
+	if m.dctx == nil {
+		m.dctx, m.dcancel = context.WithDeadline(ctx, d)
+	}
+	return m.dctx
+}
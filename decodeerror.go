@@ -0,0 +1,136 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// What happens to a message that can't be decoded: a content encoding that
+// won't undo with Decompress, a payload a Validator turns down, or one a
+// typed Subscribe, SubscribeChan or SubscribeEvent can't decode into its T.
+// By default, DecodeErrorReport, the ErrorHandler gets the error and the
+// message goes to the subscription's DeadLetter subject if it has one, a
+// JetStream message is then acked, or terminated, since it won't decode any
+// better the next time, and a request gets a 400 back. DecodeErrors picks
+// another, OnDecodeError hands them to a func of the subscription's own:
+//
+//	nc.Subscribe("telemetry.>", Decompress(), DecodeErrors(DecodeErrorDrop), Handler(record))
+//	nc.Subscribe("orders.new", Decompress(), DeadLetter("dlq.orders", 0), Handler(place))
+//	natsv2.Subscribe(nc, "audit.*", handle, OnDecodeError(func(m *Msg, err error) {
+//		log.Printf("bad audit record on %s: %v", m.Subject(), err)
+//		m.Term()
+//	}))
+//
+// Whatever the policy, a Metrics that is also a DecodeMetrics counts them.
+// With DecodeErrorRaw the handler gets the message as it came, compressed
+// or invalid, with Msg.DecodeError saying what was wrong; a typed handler
+// has no T to give it, for those it is DecodeErrorReport.
+
+type DecodeErrorPolicy int
+
+const (
+	DecodeErrorReport DecodeErrorPolicy = iota
+	// Only counted, JetStream messages are terminated.
+	DecodeErrorDrop
+	DecodeErrorRaw
+)
+
+// DecodeErrorHeader is what was wrong with a message DecodeErrorRaw let
+// through.
+const DecodeErrorHeader = "Nats-Decode-Error"
+
+// DecodeMetrics is for a Metrics that counts messages that didn't decode.
+type DecodeMetrics interface {
+	DecodeFailed(subject string)
+}
+
+func DecodeErrors(p DecodeErrorPolicy) SubOption {
+	return func(o *SubOptions) error {
+		switch p {
+		case DecodeErrorReport, DecodeErrorDrop, DecodeErrorRaw:
+		default:
+			return fmt.Errorf("natsv2: unknown decode error policy %d", p)
+		}
+		o.DecodeErrors = p
+		return nil
+	}
+}
+
+// OnDecodeError gives messages that didn't decode to fn instead, acking or
+// responding is up to it.
+func OnDecodeError(fn func(m *Msg, err error)) SubOption {
+	return func(o *SubOptions) error {
+		if fn == nil {
+			return errors.New("natsv2: nil decode error func")
+		}
+		o.OnDecodeError = fn
+		return nil
+	}
+}
+
+// DecodeError is what was wrong with a message let through by
+// DecodeErrorRaw, nil if it decoded.
+func (m *Msg) DecodeError() error {
+	if m.m.Header == nil {
+		return nil
+	}
+	v := m.m.Header.Get(DecodeErrorHeader)
+	if v == "" {
+		return nil
+	}
+	return &DecodeError{Subject: m.m.Subject, Err: errors.New(v)}
+}
+
+// decodeFailed does what o says with m, which err says didn't decode, and
+// is whether m should go on to the handler anyway. raw is whether it can.
+func (c *conn) decodeFailed(o *SubOptions, m *nats.Msg, err error, raw bool) bool {
+	if dm, ok := c.metrics.(DecodeMetrics); ok {
+		dm.DecodeFailed(m.Subject)
+	}
+	policy := o.DecodeErrors
+	if policy == DecodeErrorRaw && !raw {
+		policy = DecodeErrorReport
+	}
+	meta, merr := m.Metadata()
+	switch {
+	case o.OnDecodeError != nil:
+		msg := c.wrap(m)
+		msg.hctx, msg.sopts = o.ctx, o
+		o.OnDecodeError(msg, err)
+	case policy == DecodeErrorRaw:
+		if m.Header == nil {
+			m.Header = nats.Header{}
+		}
+		m.Header.Set(DecodeErrorHeader, err.Error())
+		return true
+	case policy == DecodeErrorDrop:
+		c.log.Debug("undecodable message dropped", "subject", m.Subject, "error", err)
+		if merr == nil {
+			m.Term()
+		}
+	case o.DeadLetter != "":
+		c.handleError(err)
+		if c.sendDeadLetter(o, m, meta, err) && merr == nil {
+			m.Ack()
+		}
+	case merr == nil:
+		c.handleError(err)
+		m.Term()
+	default:
+		failed(c, m, "400", err)
+	}
+	return false
+}
+
+// decodeFailedMsg is decodeFailed for a typed handler's m, which may not be
+// from a connection.
+func decodeFailedMsg(c Connection, m *Msg, err error) {
+	derr := &DecodeError{Subject: m.Subject(), Err: err}
+	if m.c == nil || m.sopts == nil {
+		failedMsg(c, m, "400", derr)
+		return
+	}
+	m.c.decodeFailed(m.sopts, m.m, derr, false)
+}
@@ -0,0 +1,116 @@
+package natsv2
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Dedupe drops a message whose key was already seen within window, for
+// handlers of at-least-once deliveries that can't easily be made
+// idempotent. The key is the Nats-Msg-Id header unless key is given,
+// messages without one are always delivered.
+//
+//	orders.Subscribe(JetStreamConsumer(ConsumerOptions{Durable: "billing"}), AutoAck(),
+//		Dedupe(10*time.Minute, nil), Handler(bill))
+//	nc.Subscribe("events", Dedupe(time.Minute, func(msg *Msg) string {
+//		return msg.Header().Get("Event-Id")
+//	}), Handler(apply))
+//
+// Each duplicate starts the window over. Keys are remembered in an LRU of
+// DedupeSize, DefaultDedupeSize unless set, so a very busy subject can
+// forget a key before the window is up. Dropped JetStream messages are
+// acked, redeliveries of a message that wasn't acked are not dropped.
+
+const DefaultDedupeSize = 100000
+
+func Dedupe(window time.Duration, key func(*Msg) string) SubOption {
+	return func(o *SubOptions) error {
+		if window <= 0 {
+			return errors.New("natsv2: dedupe window must be positive")
+		}
+		o.DedupeWindow = window
+		o.DedupeKey = key
+		return nil
+	}
+}
+
+func DedupeSize(n int) SubOption {
+	return func(o *SubOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: dedupe size must be at least 1")
+		}
+		o.DedupeSize = n
+		return nil
+	}
+}
+
+type dedupeCache struct {
+	window time.Duration
+	size   int
+
+	mu    sync.Mutex
+	seen  map[string]*list.Element
+	order *list.List
+}
+
+type dedupeEntry struct {
+	key  string
+	seen time.Time
+}
+
+// duplicate records key and reports whether it was already there.
+func (d *dedupeCache) duplicate(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The oldest are at the back, drop what is past the window.
+	for e := d.order.Back(); e != nil && now.Sub(e.Value.(*dedupeEntry).seen) >= d.window; e = d.order.Back() {
+		delete(d.seen, e.Value.(*dedupeEntry).key)
+		d.order.Remove(e)
+	}
+	if e, ok := d.seen[key]; ok {
+		e.Value.(*dedupeEntry).seen = now
+		d.order.MoveToFront(e)
+		return true
+	}
+	d.seen[key] = d.order.PushFront(&dedupeEntry{key: key, seen: now})
+	if d.order.Len() > d.size {
+		e := d.order.Back()
+		delete(d.seen, e.Value.(*dedupeEntry).key)
+		d.order.Remove(e)
+	}
+	return false
+}
+
+func (c *conn) dedupe(sopts *SubOptions, handler nats.MsgHandler) nats.MsgHandler {
+	d := &dedupeCache{window: sopts.DedupeWindow, size: sopts.DedupeSize, seen: map[string]*list.Element{}, order: list.New()}
+	if d.size == 0 {
+		d.size = DefaultDedupeSize
+	}
+	jetstream := sopts.Consumer != nil && sopts.Consumer.AckPolicy != AckNone
+	return func(m *nats.Msg) {
+		if jetstream {
+			if meta, err := m.Metadata(); err == nil && meta.NumDelivered > 1 {
+				handler(m)
+				return
+			}
+		}
+		var key string
+		if sopts.DedupeKey != nil {
+			key = sopts.DedupeKey(&Msg{m: m, c: c})
+		} else {
+			key = m.Header.Get(MsgIDHeader)
+		}
+		if key == "" || !d.duplicate(key, time.Now()) {
+			handler(m)
+			return
+		}
+		c.log.Debug("dropped duplicate", "subject", m.Subject, "key", key)
+		if jetstream {
+			m.Ack()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package natsv2
+
+// Defaults for every request, stream and service on a connection, so the
+// timeout or retries most calls want are said once:
+//
+//	nc, err := Connect(url,
+//		WithRequestDefaults(Timeout(5*time.Second), Retry(3, 100*time.Millisecond)),
+//		WithStreamDefaults(Pipeline("zstd", JSONContentType)),
+//		WithServiceDefaults(ServiceMaxConcurrent(64)))
+//
+// Options apply in order and later ones win: the connection's defaults, then
+// a Client's, then the call's own, so any of them can be overridden where it
+// is used. A deadline on a request's Ctx still holds with a default Timeout,
+// whichever comes first.
+//
+// RequestOptions, StreamOptions and ServiceOptions are what a call with the
+// options given would go with, the defaults applied and what is left empty
+// filled in from the connection, DefaultRequestTimeout, the codec and so on:
+//
+//	ropts, err := nc.RequestOptions(Timeout(time.Second))
+//	fmt.Println(ropts.Timeout, ropts.Attempts) // 1s 3
+
+// WithRequestDefaults are applied before every request's own options. Given
+// more than once, they add up, as do the others.
+func WithRequestDefaults(opts ...ReqOption) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if err := applyOptions(&ReqOptions{}, opts); err != nil {
+			return err
+		}
+		o.RequestDefaults = append(o.RequestDefaults, opts...)
+		return nil
+	}
+}
+
+func WithStreamDefaults(opts ...StreamOption) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if err := applyOptions(&StreamOptions{}, opts); err != nil {
+			return err
+		}
+		o.StreamDefaults = append(o.StreamDefaults, opts...)
+		return nil
+	}
+}
+
+func WithServiceDefaults(opts ...ServiceOption) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if err := applyOptions(&ServiceOptions{}, opts); err != nil {
+			return err
+		}
+		o.ServiceDefaults = append(o.ServiceDefaults, opts...)
+		return nil
+	}
+}
+
+func applyOptions[O any, F ~func(*O) error](o *O, opts ...[]F) error {
+	for _, list := range opts {
+		for _, opt := range list {
+			if err := opt(o); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reqOptions is the connection's defaults and then opts.
+func (c *conn) reqOptions(opts []ReqOption) (*ReqOptions, error) {
+	ropts := &ReqOptions{}
+	if err := applyOptions(ropts, c.opts.RequestDefaults, opts); err != nil {
+		return nil, err
+	}
+	return ropts, nil
+}
+
+// serviceOptions are a service's options, defaults included, with what
+// Service fills in from its name.
+func (c *conn) serviceOptions(name, version string, opts []ServiceOption) (ServiceOptions, error) {
+	var svcopts ServiceOptions
+	if err := applyOptions(&svcopts, c.opts.ServiceDefaults, opts); err != nil {
+		return svcopts, err
+	}
+	if svcopts.Subject == "" {
+		svcopts.Subject = name
+	}
+	if svcopts.Queue == "" {
+		svcopts.Queue = DefaultServiceQueue(name, version)
+	}
+	if svcopts.LatencyBuckets == nil {
+		svcopts.LatencyBuckets = DefaultLatencyBuckets
+	}
+	return svcopts, nil
+}
+
+func (c *conn) RequestOptions(opts ...ReqOption) (*ReqOptions, error) {
+	ropts, err := c.reqOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if ropts.Timeout == 0 {
+		if ropts.Context == nil {
+			ropts.Timeout = DefaultRequestTimeout
+		} else if _, ok := ropts.Context.Deadline(); !ok {
+			ropts.Timeout = DefaultRequestTimeout
+		}
+	}
+	if ropts.Chunked && ropts.MaxReplySize == 0 {
+		ropts.MaxReplySize = DefaultMaxReplySize
+	}
+	return ropts, nil
+}
+
+func (c *conn) StreamOptions(opts ...StreamOption) (*StreamOptions, error) {
+	sopts := &StreamOptions{}
+	if err := applyOptions(sopts, c.opts.StreamDefaults, opts); err != nil {
+		return nil, err
+	}
+	if len(sopts.Pipeline) == 0 {
+		sopts.Pipeline = c.opts.Pipeline
+	}
+	if sopts.CompressMinSize == 0 {
+		sopts.CompressMinSize = c.opts.CompressMinSize
+	}
+	if sopts.ContentType == "" {
+		sopts.ContentType = c.codecs.out.codec.ContentType()
+	}
+	return sopts, nil
+}
+
+func (c *conn) ServiceOptions(name, version string, opts ...ServiceOption) (*ServiceOptions, error) {
+	svcopts, err := c.serviceOptions(name, version, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &svcopts, nil
+}
@@ -0,0 +1,290 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Leader election for singleton jobs in a fleet, on a KV lease. The name is
+// the bucket and the key, whoever holds the key leads and keeps renewing it,
+// every ttl/3, and once it stops the server drops the key after ttl and one
+// of the others takes over:
+//
+//	cp, err := Elect(nc, "leases.billing-run", 10*time.Second, OnElected(func(ctx context.Context) {
+//		runBilling(ctx) // until ctx is done, when leadership is lost
+//	}))
+//	defer cp.Close()
+//
+// The bucket is made with a TTL of ttl if it isn't there, all leases in a
+// bucket need the same ttl. A leader steps down as soon as a renewal fails,
+// before the lease runs out for the others, so two only lead at once if the
+// leader is stuck for longer than ttl without noticing, e.g. in a GC pause;
+// make the work idempotent or fence it with the revision where that
+// matters. Close, Drain and Shutdown give up the lease at once.
+
+type ElectOption func(*ElectOptions) error
+
+type ElectOptions struct {
+	// Who we are in the lease, a nuid if empty. Keep it across restarts and
+	// a restarted leader carries on with its lease.
+	ID string
+	// Run in a goroutine on becoming leader, ctx is done once we aren't.
+	OnElected func(ctx context.Context)
+	// With the leader's ID whenever another is seen, "" for none.
+	OnLeaderChange func(leader string)
+}
+
+func ElectID(id string) ElectOption {
+	return func(o *ElectOptions) error {
+		if id == "" {
+			return errors.New("natsv2: empty election id")
+		}
+		o.ID = id
+		return nil
+	}
+}
+
+func OnElected(fn func(ctx context.Context)) ElectOption {
+	return func(o *ElectOptions) error {
+		o.OnElected = fn
+		return nil
+	}
+}
+
+func OnLeaderChange(fn func(leader string)) ElectOption {
+	return func(o *ElectOptions) error {
+		o.OnLeaderChange = fn
+		return nil
+	}
+}
+
+type Campaign struct {
+	c    *conn
+	name string
+	kv   nats.KeyValue
+	key  string
+	ttl  time.Duration
+	opts ElectOptions
+	w    nats.KeyWatcher
+
+	mu sync.Mutex
+	// Of our lease, 0 unless leading.
+	rev     uint64
+	renewed time.Time
+	leader  string
+	cancel  context.CancelFunc
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func Elect(nc Connection, name string, ttl time.Duration, opts ...ElectOption) (*Campaign, error) {
+	c := connFor(nc, name)
+	if c == nil {
+		return nil, fmt.Errorf("natsv2: no elections on a %T", nc)
+	}
+	bucket, key, ok := strings.Cut(name, ".")
+	if !ok || bucket == "" || key == "" {
+		return nil, fmt.Errorf("natsv2: election %q is not bucket.key", name)
+	}
+	if ttl < time.Second {
+		return nil, errors.New("natsv2: lease ttl must be at least a second")
+	}
+	cp := &Campaign{c: c, name: name, key: key, ttl: ttl, stop: make(chan struct{}), done: make(chan struct{})}
+	for _, opt := range opts {
+		if err := opt(&cp.opts); err != nil {
+			return nil, err
+		}
+	}
+	if cp.opts.ID == "" {
+		cp.opts.ID = nuid.Next()
+	}
+	// Renewals that take longer than this are too late anyway.
+	js, err := c.nc.JetStream(nats.MaxWait(ttl / 3))
+	if err != nil {
+		return nil, err
+	}
+	if cp.kv, err = leaseBucket(js, bucket, ttl); err != nil {
+		return nil, err
+	}
+	if cp.w, err = cp.kv.Watch(key); err != nil {
+		return nil, err
+	}
+	go cp.run()
+	return cp, nil
+}
+
+func leaseBucket(js nats.JetStreamContext, bucket string, ttl time.Duration) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		return js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket, TTL: ttl, History: 1})
+	}
+	if err != nil {
+		return nil, err
+	}
+	status, err := kv.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status.TTL() != ttl {
+		return nil, fmt.Errorf("natsv2: bucket %q keeps values for %v, not the lease's %v", bucket, status.TTL(), ttl)
+	}
+	return kv, nil
+}
+
+func (cp *Campaign) ID() string {
+	return cp.opts.ID
+}
+
+// IsLeader is true while our lease is renewed.
+func (cp *Campaign) IsLeader() bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.rev != 0 && time.Since(cp.renewed) < cp.ttl
+}
+
+// Leader is the last leader seen, "" for none.
+func (cp *Campaign) Leader() string {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.leader
+}
+
+// Close stops campaigning and gives up the lease if we hold it.
+func (cp *Campaign) Close() {
+	cp.once.Do(func() { close(cp.stop) })
+	<-cp.done
+}
+
+func (cp *Campaign) run() {
+	defer close(cp.done)
+	defer cp.w.Stop()
+	t := time.NewTicker(cp.ttl / 3)
+	defer t.Stop()
+	updates := cp.w.Updates()
+	// The holder first, nil marks the end of the initial value.
+	for e := range updates {
+		if e == nil {
+			break
+		}
+		if e.Operation() == nats.KeyValuePut {
+			cp.follow(string(e.Value()))
+		}
+	}
+	cp.campaign()
+	for {
+		select {
+		case e, ok := <-updates:
+			if !ok {
+				updates = nil
+				continue
+			}
+			if e.Operation() != nats.KeyValuePut {
+				cp.follow("")
+				cp.campaign()
+				continue
+			}
+			cp.follow(string(e.Value()))
+		case <-t.C:
+			cp.campaign()
+		case <-cp.stop:
+			cp.resign()
+			return
+		case <-cp.c.hctx.Done():
+			cp.resign()
+			return
+		}
+	}
+}
+
+// campaign renews the lease if we hold it, otherwise tries to take it.
+func (cp *Campaign) campaign() {
+	cp.mu.Lock()
+	rev := cp.rev
+	cp.mu.Unlock()
+	if rev != 0 {
+		rev, err := cp.kv.Update(cp.key, []byte(cp.opts.ID), rev)
+		if err == nil {
+			cp.mu.Lock()
+			cp.rev, cp.renewed = rev, time.Now()
+			cp.mu.Unlock()
+			return
+		}
+		cp.c.log.Warn("lease not renewed, stepping down", "lease", cp.name, "error", err)
+		cp.lose()
+	}
+	rev, err := cp.kv.Create(cp.key, []byte(cp.opts.ID))
+	if errors.Is(err, nats.ErrKeyExists) && cp.Leader() == cp.opts.ID {
+		// Ours from before a restart.
+		var e nats.KeyValueEntry
+		if e, err = cp.kv.Get(cp.key); err == nil && string(e.Value()) == cp.opts.ID {
+			rev, err = cp.kv.Update(cp.key, []byte(cp.opts.ID), e.Revision())
+		} else if err == nil {
+			err = nats.ErrKeyExists
+		}
+	}
+	switch {
+	case err == nil:
+		cp.win(rev)
+	case !errors.Is(err, nats.ErrKeyExists):
+		cp.c.log.Warn("campaign failed", "lease", cp.name, "error", err)
+	}
+}
+
+func (cp *Campaign) win(rev uint64) {
+	cp.mu.Lock()
+	cp.rev, cp.renewed = rev, time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	cp.cancel = cancel
+	cp.mu.Unlock()
+	cp.c.log.Info("elected", "lease", cp.name, "id", cp.opts.ID)
+	cp.follow(cp.opts.ID)
+	if cp.opts.OnElected != nil {
+		go cp.opts.OnElected(ctx)
+	}
+}
+
+func (cp *Campaign) lose() {
+	cp.mu.Lock()
+	cp.rev = 0
+	cancel := cp.cancel
+	cp.cancel = nil
+	cp.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		cp.c.log.Info("stepped down", "lease", cp.name, "id", cp.opts.ID)
+	}
+}
+
+func (cp *Campaign) follow(leader string) {
+	cp.mu.Lock()
+	changed := leader != cp.leader
+	cp.leader = leader
+	cp.mu.Unlock()
+	if changed && cp.opts.OnLeaderChange != nil {
+		cp.opts.OnLeaderChange(leader)
+	}
+}
+
+// resign deletes the lease if it is still ours, so another needn't wait for
+// it to run out.
+func (cp *Campaign) resign() {
+	cp.mu.Lock()
+	rev := cp.rev
+	cp.mu.Unlock()
+	if rev == 0 {
+		return
+	}
+	cp.lose()
+	if err := cp.kv.Delete(cp.key, nats.LastRevision(rev)); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+		cp.c.log.Warn("lease not given up", "lease", cp.name, "error", err)
+	}
+}
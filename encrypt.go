@@ -0,0 +1,259 @@
+package natsv2
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Payload encryption, as pipeline stages like the compressions. With
+// WithEncryption the connection knows "aes-gcm" and "nacl-box", list one
+// outermost so the payload is compressed before it's encrypted:
+//
+//	nc, _ := Connect(url, WithEncryption(SubjectKeys(map[string][]byte{"tenant.a.>": keyA, "tenant.b.>": keyB})),
+//		WithPipeline("aes-gcm", "zstd", JSONContentType))
+//
+// Each message gets a random nonce, sent base64 in Nats-Encryption-Nonce
+// along with the key's id in Nats-Encryption-Key, so receivers with the
+// same KeyProvider can Decode, or Decompress, it. Keys come from the
+// provider every time, so they can live in Vault or a KMS and be rotated,
+// messages under an old key decode while the provider still has it.
+//
+// aes-gcm takes 16, 24 or 32 byte keys, nacl-box a 32 byte one, see
+// BoxSharedKey for a pair of box keys. Replies are whatever the handler
+// responds with, the pipeline isn't applied to them.
+
+const (
+	EncryptionKeyHeader   = "Nats-Encryption-Key"
+	EncryptionNonceHeader = "Nats-Encryption-Nonce"
+)
+
+var ErrDecrypt = errors.New("natsv2: can't decrypt payload")
+
+// A KeyProvider hands out keys by subject, and by id to decrypt, since a
+// message can arrive on a different subject, e.g. through an import.
+type KeyProvider interface {
+	EncryptionKey(subject string) (id string, key []byte, err error)
+	DecryptionKey(subject, id string) ([]byte, error)
+}
+
+func WithEncryption(keys KeyProvider) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if keys == nil {
+			return errors.New("natsv2: encryption needs a key provider")
+		}
+		o.Encodings = append(o.Encodings, aesGCMEncoding{keys}, naclBoxEncoding{keys})
+		return nil
+	}
+}
+
+// StaticKey is one key for everything.
+func StaticKey(id string, key []byte) KeyProvider {
+	return staticKey{id, key}
+}
+
+type staticKey struct {
+	id  string
+	key []byte
+}
+
+func (k staticKey) EncryptionKey(string) (string, []byte, error) { return k.id, k.key, nil }
+
+func (k staticKey) DecryptionKey(_, id string) ([]byte, error) {
+	if id != k.id {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrDecrypt, id)
+	}
+	return k.key, nil
+}
+
+// SubjectKeys picks the key by subject, wildcards work, the most specific
+// match wins. The patterns are the key ids.
+func SubjectKeys(keys map[string][]byte) KeyProvider {
+	ks := &subjectKeys{keys: keys}
+	for pattern := range keys {
+		rt, err := newRoute(pattern, nil)
+		if err != nil {
+			ks.err = err
+			break
+		}
+		ks.routes = append(ks.routes, rt)
+	}
+	return ks
+}
+
+type subjectKeys struct {
+	keys   map[string][]byte
+	routes []*route
+	err    error
+}
+
+func (ks *subjectKeys) EncryptionKey(subject string) (string, []byte, error) {
+	if ks.err != nil {
+		return "", nil, ks.err
+	}
+	var best *route
+	for _, rt := range ks.routes {
+		if rt.matches(subject) && (best == nil || rt.moreSpecific(best)) {
+			best = rt
+		}
+	}
+	if best == nil {
+		return "", nil, fmt.Errorf("natsv2: no encryption key for %q", subject)
+	}
+	return best.pattern, ks.keys[best.pattern], nil
+}
+
+func (ks *subjectKeys) DecryptionKey(_, id string) ([]byte, error) {
+	key, ok := ks.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrDecrypt, id)
+	}
+	return key, nil
+}
+
+// BoxSharedKey is the nacl-box key for a sender and receiver, the same from
+// either side given the other's public key.
+func BoxSharedKey(peersPublicKey, privateKey *[32]byte) []byte {
+	var shared [32]byte
+	box.Precompute(&shared, peersPublicKey, privateKey)
+	return shared[:]
+}
+
+// sealer is what the two encodings differ in.
+type sealer interface {
+	nonceSize() int
+	seal(key, nonce, in []byte) ([]byte, error)
+	open(key, nonce, in []byte) ([]byte, error)
+}
+
+func encryptMsg(s sealer, keys KeyProvider, m *nats.Msg, in []byte) ([]byte, error) {
+	id, key, err := keys.EncryptionKey(m.Subject)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, s.nonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out, err := s.seal(key, nonce, in)
+	if err != nil {
+		return nil, err
+	}
+	m.Header.Set(EncryptionKeyHeader, id)
+	m.Header.Set(EncryptionNonceHeader, base64.StdEncoding.EncodeToString(nonce))
+	return out, nil
+}
+
+func decryptMsg(s sealer, keys KeyProvider, m *nats.Msg, in []byte) ([]byte, error) {
+	nonce, err := base64.StdEncoding.DecodeString(m.Header.Get(EncryptionNonceHeader))
+	if err != nil || len(nonce) != s.nonceSize() {
+		return nil, fmt.Errorf("%w: bad nonce", ErrDecrypt)
+	}
+	key, err := keys.DecryptionKey(m.Subject, m.Header.Get(EncryptionKeyHeader))
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.open(key, nonce, in)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return out, nil
+}
+
+var errNeedsMsg = errors.New("natsv2: encryption needs the message, use it in a pipeline")
+
+type aesGCMEncoding struct{ keys KeyProvider }
+
+func (aesGCMEncoding) Name() string                  { return "aes-gcm" }
+func (aesGCMEncoding) Encode([]byte) ([]byte, error) { return nil, errNeedsMsg }
+func (aesGCMEncoding) Decode([]byte) ([]byte, error) { return nil, errNeedsMsg }
+
+func (e aesGCMEncoding) encodeMsg(m *nats.Msg, in []byte) ([]byte, error) {
+	return encryptMsg(e, e.keys, m, in)
+}
+
+func (e aesGCMEncoding) decodeMsg(m *nats.Msg, in []byte) ([]byte, error) {
+	return decryptMsg(e, e.keys, m, in)
+}
+
+func (aesGCMEncoding) nonceSize() int { return 12 }
+
+func (aesGCMEncoding) aead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e aesGCMEncoding) seal(key, nonce, in []byte) ([]byte, error) {
+	aead, err := e.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, in, nil), nil
+}
+
+func (e aesGCMEncoding) open(key, nonce, in []byte) ([]byte, error) {
+	aead, err := e.aead(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, in, nil)
+}
+
+type naclBoxEncoding struct{ keys KeyProvider }
+
+func (naclBoxEncoding) Name() string                  { return "nacl-box" }
+func (naclBoxEncoding) Encode([]byte) ([]byte, error) { return nil, errNeedsMsg }
+func (naclBoxEncoding) Decode([]byte) ([]byte, error) { return nil, errNeedsMsg }
+
+func (e naclBoxEncoding) encodeMsg(m *nats.Msg, in []byte) ([]byte, error) {
+	return encryptMsg(e, e.keys, m, in)
+}
+
+func (e naclBoxEncoding) decodeMsg(m *nats.Msg, in []byte) ([]byte, error) {
+	return decryptMsg(e, e.keys, m, in)
+}
+
+func (naclBoxEncoding) nonceSize() int { return 24 }
+
+func boxKey(key []byte) (*[32]byte, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("natsv2: nacl-box key must be 32 bytes, not %d", len(key))
+	}
+	var k [32]byte
+	copy(k[:], key)
+	return &k, nil
+}
+
+func (naclBoxEncoding) seal(key, nonce, in []byte) ([]byte, error) {
+	k, err := boxKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var n [24]byte
+	copy(n[:], nonce)
+	return secretbox.Seal(nil, in, &n, k), nil
+}
+
+func (naclBoxEncoding) open(key, nonce, in []byte) ([]byte, error) {
+	k, err := boxKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var n [24]byte
+	copy(n[:], nonce)
+	out, ok := secretbox.Open(nil, in, &n, k)
+	if !ok {
+		return nil, errors.New("authentication failed")
+	}
+	return out, nil
+}
@@ -0,0 +1,197 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A Service can have more endpoints than the one from ServiceHandler, each
+// on its own subject. They take the same options as the service for their
+// handler, ServiceSubject, ServiceQueue, Metadata and LatencyBuckets, and
+// default to the subject of their name, the service's queue group and
+// buckets. Groups put a subject prefix in front of their endpoints.
+//
+//	svc, err := nc.Service("calc", "1.0.0")
+//	svc.AddEndpoint("add", ServiceHandleFunc(add))     // on "add"
+//	v2 := svc.AddGroup("calc.v2")
+//	v2.AddEndpoint("add", ServiceHandleFunc(addV2))    // on "calc.v2.add"
+//
+// Each endpoint is listed in $SRV.INFO and has its own $SRV.STATS.
+type ServiceGroup interface {
+	AddEndpoint(name string, opts ...ServiceOption) error
+	AddGroup(name string) ServiceGroup
+}
+
+type endpoint struct {
+	s    *service
+	name string
+	opts ServiceOptions
+	// Under s.mu.
+	stats  ServiceEndpointStats
+	limits *handlerLimits
+	sub    *nats.Subscription
+}
+
+type serviceGroup struct {
+	s      *service
+	prefix string
+}
+
+func (s *service) AddEndpoint(name string, opts ...ServiceOption) error {
+	return s.addEndpoint("", name, opts)
+}
+
+func (s *service) AddGroup(name string) ServiceGroup {
+	return &serviceGroup{s: s, prefix: name}
+}
+
+func (g *serviceGroup) AddEndpoint(name string, opts ...ServiceOption) error {
+	return g.s.addEndpoint(g.prefix, name, opts)
+}
+
+func (g *serviceGroup) AddGroup(name string) ServiceGroup {
+	return &serviceGroup{s: g.s, prefix: g.prefix + "." + name}
+}
+
+func (s *service) addEndpoint(prefix, name string, opts []ServiceOption) error {
+	if !serviceNameRE.MatchString(name) {
+		return fmt.Errorf("%w: endpoint %q", ErrBadServiceName, name)
+	}
+	e := &endpoint{s: s, name: name}
+	for _, opt := range opts {
+		if err := opt(&e.opts); err != nil {
+			return err
+		}
+	}
+	if e.opts.Description != "" || e.opts.discover != nil {
+		return errors.New("natsv2: description and discover are for the whole service")
+	}
+	if e.opts.Subject == "" {
+		e.opts.Subject = name
+		if prefix != "" {
+			e.opts.Subject = prefix + "." + name
+		}
+	}
+	if err := checkSubject(e.opts.Subject, true); err != nil {
+		return err
+	}
+	return s.add(e)
+}
+
+// add fills in the service defaults and starts e.
+func (s *service) add(e *endpoint) error {
+	if e.opts.Handler == nil && e.opts.HandlerFunc == nil && e.opts.HTTPHandler == nil {
+		return fmt.Errorf("natsv2: endpoint %q needs a handler", e.name)
+	}
+	if e.opts.Queue == "" {
+		e.opts.Queue = s.opts.Queue
+	}
+	if e.opts.LatencyBuckets == nil {
+		e.opts.LatencyBuckets = s.opts.LatencyBuckets
+	}
+	if e.opts.Metadata == nil {
+		e.opts.Metadata = map[string]string{}
+	}
+	if e.opts.Panics == PanicDefault {
+		e.opts.Panics = s.opts.Panics
+	}
+	if e.opts.MaxConcurrent == 0 && e.opts.RateLimit == 0 {
+		e.opts.MaxConcurrent, e.opts.RateLimit, e.opts.Backpressure = s.opts.MaxConcurrent, s.opts.RateLimit, s.opts.Backpressure
+	}
+	e.limits = newHandlerLimits(s.c.ctx, e.opts.MaxConcurrent, e.opts.RateLimit, e.opts.Backpressure)
+	e.stats = ServiceEndpointStats{Name: e.name, Subject: e.opts.Subject, QueueGroup: e.opts.Queue,
+		Latency: newLatencyHistogram(e.opts.LatencyBuckets)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return errors.New("natsv2: service is shut down")
+	}
+	sub, err := e.subscribe()
+	if err != nil {
+		return err
+	}
+	e.sub = sub
+	s.subs = append(s.subs, sub)
+	s.endpoints = append(s.endpoints, e)
+	return nil
+}
+
+func (e *endpoint) subscribe() (*nats.Subscription, error) {
+	c := e.s.c
+	return c.nc.QueueSubscribe(c.outSubject(e.opts.Subject), e.opts.Queue, c.recoverHandler(e.panicSite(), c.interceptHandler(e.limits.wrap(c.unmapping(e.serve), e.overloaded))))
+}
+
+func (e *endpoint) serve(m *nats.Msg) {
+	start := time.Now()
+	var failure string
+	defer func() {
+		r := recover()
+		if perr, ok := r.(*PanicError); ok {
+			failure = fmt.Sprint(perr.Value)
+		} else if r != nil {
+			failure = fmt.Sprint(r)
+		}
+		e.record(time.Since(start), failure)
+		if r != nil {
+			e.s.c.panicked(m.Subject, r, debug.Stack(), e.panicSite())
+		}
+	}()
+	if e.opts.HTTPHandler != nil {
+		if status := serveHTTP(e.opts.HTTPHandler, m, nil); status >= http.StatusInternalServerError {
+			failure = fmt.Sprintf("%d %s", status, http.StatusText(status))
+		}
+		return
+	}
+	if e.opts.HandlerFunc != nil {
+		if err := e.opts.HandlerFunc.serve(e.msg(m), e.panicSite()); err != nil {
+			failure = err.Error()
+		}
+		return
+	}
+	msg := e.msg(m)
+	e.opts.Handler(msg)
+	if msg.err != nil {
+		failure = msg.err.Error()
+	}
+}
+
+func (e *endpoint) msg(m *nats.Msg) *Msg {
+	msg := e.s.c.wrap(m)
+	msg.hctx = e.s.ctx
+	return msg
+}
+
+// overloaded counts a rejected request as an error, though not in the
+// latencies.
+func (e *endpoint) overloaded(m *nats.Msg) {
+	e.s.mu.Lock()
+	e.stats.NumRequests++
+	e.stats.NumErrors++
+	e.stats.LastError = "429 " + ErrOverloaded.Error()
+	e.s.mu.Unlock()
+	e.s.c.metrics.ServiceError(e.s.name)
+	e.s.c.overloaded(m)
+}
+
+func (e *endpoint) record(d time.Duration, failure string) {
+	if failure != "" {
+		e.s.c.log.Warn("service request failed", "service", e.s.name, "endpoint", e.name, "error", failure)
+	}
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+	e.stats.NumRequests++
+	e.stats.ProcessingTime += d
+	e.stats.AverageProcessingTime = e.stats.ProcessingTime / time.Duration(e.stats.NumRequests)
+	e.stats.Latency.observe(d)
+	if failure != "" {
+		e.s.c.metrics.ServiceError(e.s.name)
+		e.stats.NumErrors++
+		e.stats.LastError = failure
+	}
+}
@@ -0,0 +1,155 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Lifecycle callbacks, so watching the connection doesn't need raw nats.go
+// options. Any number can be registered, they are called in order on the
+// client's callback goroutine, so keep them quick. Handlers set with
+// NATSOptions still run, after ours.
+//
+// OnError gets everything the ErrorHandler does, handler panics, decode
+// failures and the client's async errors like slow consumers as an
+// *AsyncError.
+//
+// nats.go doesn't tell us about failed reconnect attempts, Reconnects is how
+// many times reconnecting worked so far, this one included.
+
+type DisconnectEvent struct {
+	Server string
+	// Nil if we disconnected on purpose.
+	Err error
+}
+
+type ReconnectEvent struct {
+	Server     string
+	Reconnects uint64
+	// How long we were disconnected.
+	Downtime time.Duration
+}
+
+// AsyncError is an error the client ran into outside of any call.
+type AsyncError struct {
+	// Of the subscription it happened on, if any.
+	Subject string
+	Err     error
+}
+
+func (e *AsyncError) Error() string {
+	if e.Subject == "" {
+		return fmt.Sprintf("natsv2: async error: %v", e.Err)
+	}
+	return fmt.Sprintf("natsv2: async error on %q: %v", e.Subject, e.Err)
+}
+
+func (e *AsyncError) Unwrap() error { return e.Err }
+
+func OnDisconnect(cb func(DisconnectEvent)) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.OnDisconnect = append(o.OnDisconnect, cb)
+		return nil
+	}
+}
+
+func OnReconnect(cb func(ReconnectEvent)) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.OnReconnect = append(o.OnReconnect, cb)
+		return nil
+	}
+}
+
+// OnClosed is called once the connection is closed for good, with the last
+// error if that is why.
+func OnClosed(cb func(err error)) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.OnClosed = append(o.OnClosed, cb)
+		return nil
+	}
+}
+
+func OnError(cb func(error)) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.OnError = append(o.OnError, cb)
+		return nil
+	}
+}
+
+// watchLifecycle hooks our handlers into the client, keeping any already set.
+func (c *conn) watchLifecycle() {
+	opts := c.nc.Opts
+	var disconnectedAt time.Time
+
+	prevDisconnect := opts.DisconnectedErrCB
+	c.nc.SetDisconnectErrHandler(func(nc *nats.Conn, err error) {
+		disconnectedAt = time.Now()
+		if err != nil {
+			c.log.Warn("disconnected", "error", err)
+		}
+		ev := DisconnectEvent{Server: nc.ConnectedUrlRedacted(), Err: err}
+		for _, cb := range c.opts.OnDisconnect {
+			cb(ev)
+		}
+		if prevDisconnect != nil {
+			prevDisconnect(nc, err)
+		}
+	})
+
+	prevReconnect := opts.ReconnectedCB
+	c.nc.SetReconnectHandler(func(nc *nats.Conn) {
+		ev := ReconnectEvent{Server: nc.ConnectedUrlRedacted(), Reconnects: nc.Stats().Reconnects}
+		if !disconnectedAt.IsZero() {
+			ev.Downtime = time.Since(disconnectedAt)
+		}
+		c.log.Info("reconnected", "server", ev.Server, "downtime", ev.Downtime)
+		c.metrics.Reconnected()
+		if c.offline != nil {
+			c.replayOffline()
+		}
+		for _, cb := range c.opts.OnReconnect {
+			cb(ev)
+		}
+		if prevReconnect != nil {
+			prevReconnect(nc)
+		}
+	})
+
+	prevClosed := opts.ClosedCB
+	c.nc.SetClosedHandler(func(nc *nats.Conn) {
+		err := nc.LastError()
+		if errors.Is(err, nats.ErrConnectionClosed) {
+			err = nil
+		}
+		c.closeOffline()
+		if err != nil {
+			c.log.Warn("closed", "error", err)
+		} else {
+			c.log.Info("closed")
+		}
+		for _, cb := range c.opts.OnClosed {
+			cb(err)
+		}
+		if prevClosed != nil {
+			prevClosed(nc)
+		}
+	})
+
+	prevError := opts.AsyncErrorCB
+	c.nc.SetErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
+		aerr := &AsyncError{Err: err}
+		if sub != nil {
+			aerr.Subject = sub.Subject
+			if errors.Is(err, nats.ErrSlowConsumer) {
+				c.slowConsumer(sub)
+			}
+		}
+		c.handleError(aerr)
+		if prevError != nil {
+			prevError(nc, sub, err)
+		}
+	})
+}
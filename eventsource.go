@@ -0,0 +1,270 @@
+package natsv2
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// Event envelopes for event sourcing, the layer everyone writes by hand. The
+// payload goes through the codecs as Publish would, what the event is and
+// where it comes from goes in headers:
+//
+//	err := PublishEvent(nc, "orders.1234", Event[OrderPlaced]{Data: placed})
+//
+//	SubscribeEvent(nc, "orders.*", func(ctx context.Context, e Event[OrderPlaced]) error {
+//		return PublishEvent(nc, "shipments.new", Event[ShipmentRequested]{EventMeta: e.Next(), Data: req})
+//	})
+//
+// Type is the Go type's name unless the type has an EventType method, the ID
+// a nuid and also the Nats-Msg-Id, so a stream drops an event published
+// twice. Next is the meta for an event caused by this one, with the same
+// correlation ID and this one's ID as the causation ID.
+//
+// Schemas change, and old events in a stream don't. RegisterUpcaster adds
+// a step from one version of a type to the next, an event older than the
+// steps registered for its type is decoded as the oldest step's input and
+// taken through them in turn:
+//
+//	RegisterUpcaster("OrderPlaced", 1, func(v OrderPlacedV1) (OrderPlaced, error) {
+//		return OrderPlaced{ID: v.ID, Total: Money{Cents: v.Cents, Currency: "USD"}}, nil
+//	})
+//
+// Events are published at the version after the last step unless Version
+// says otherwise. SubscribeEvent skips events of another type, so several
+// types can share a subject; with an interface T it takes them all.
+
+const (
+	EventTypeHeader    = "Nats-Event-Type"
+	EventVersionHeader = "Nats-Event-Version"
+	EventTimeHeader    = "Nats-Event-Time"
+	EventSourceHeader  = "Nats-Event-Source"
+)
+
+type EventMeta struct {
+	ID      string
+	Type    string
+	Version int
+	// When it happened, not when it was published.
+	OccurredAt time.Time
+	// The connection's name if empty.
+	Source string
+	// The ID of the event that started the chain, and of the one that
+	// caused this one. CorrelationID is the ID if empty.
+	CorrelationID string
+	CausationID   string
+}
+
+// Next is the meta for an event caused by this one.
+func (m EventMeta) Next() EventMeta {
+	corr := m.CorrelationID
+	if corr == "" {
+		corr = m.ID
+	}
+	return EventMeta{CorrelationID: corr, CausationID: m.ID}
+}
+
+type Event[T any] struct {
+	EventMeta
+	Data T
+	// The message it came in, nil when publishing.
+	Msg *Msg
+}
+
+func PublishEvent[T any](c Connection, subject string, e Event[T], opts ...PubOption) error {
+	m := e.EventMeta
+	if m.ID == "" {
+		m.ID = nuid.Next()
+	}
+	if m.Type == "" {
+		m.Type = eventType[T]()
+	}
+	if m.Type == "" {
+		return fmt.Errorf("natsv2: event type of a %T needs to be set", e.Data)
+	}
+	if m.Version == 0 {
+		m.Version = upcasters.current(m.Type)
+	}
+	if m.OccurredAt.IsZero() {
+		m.OccurredAt = time.Now()
+	}
+	if m.Source == "" {
+		if cc := connFor(c, subject); cc != nil {
+			m.Source = cc.nc.Opts.Name
+		}
+	}
+	if m.CorrelationID == "" {
+		m.CorrelationID = m.ID
+	}
+	h := Header{}
+	h.Set(MsgIDHeader, m.ID)
+	h.Set(EventTypeHeader, m.Type)
+	h.Set(EventVersionHeader, strconv.Itoa(m.Version))
+	h.Set(EventTimeHeader, m.OccurredAt.UTC().Format(time.RFC3339Nano))
+	if m.Source != "" {
+		h.Set(EventSourceHeader, m.Source)
+	}
+	h.Set(CorrelationIDHeader, m.CorrelationID)
+	if m.CausationID != "" {
+		h.Set(CausationIDHeader, m.CausationID)
+	}
+	return c.Publish(subject, e.Data, append([]PubOption{Headers(h)}, opts...)...)
+}
+
+// SubscribeEvent decodes each event of T's type, upcast to the current
+// version, for handler. Failures go where Subscribe's do.
+func SubscribeEvent[T any](c Connection, subject string, handler func(ctx context.Context, e Event[T]) error, opts ...SubOption) (Subscription, error) {
+	want := eventType[T]()
+	return c.Subscribe(subject, append(opts, Handler(func(m *Msg) {
+		if t := m.Header().Get(EventTypeHeader); want != "" && t != "" && t != want {
+			return
+		}
+		e, err := decodeEvent[T](c, m)
+		if err != nil {
+			decodeFailedMsg(c, m, err)
+			return
+		}
+		if err := handler(m.Context(), e); err != nil {
+			failedMsg(c, m, "500", err)
+		}
+	}))...)
+}
+
+func decodeEvent[T any](c Connection, m *Msg) (Event[T], error) {
+	h := m.Header()
+	e := Event[T]{Msg: m, EventMeta: EventMeta{
+		ID:            h.Get(MsgIDHeader),
+		Type:          h.Get(EventTypeHeader),
+		Source:        h.Get(EventSourceHeader),
+		CorrelationID: h.Get(CorrelationIDHeader),
+		CausationID:   h.Get(CausationIDHeader),
+	}}
+	e.Version = upcasters.current(e.Type)
+	if v := h.Get(EventVersionHeader); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return e, fmt.Errorf("natsv2: bad event version %q", v)
+		}
+		e.Version = n
+	}
+	if t := h.Get(EventTimeHeader); t != "" {
+		at, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return e, fmt.Errorf("natsv2: bad event time %q", t)
+		}
+		e.OccurredAt = at
+	}
+	steps := upcasters.from(e.Type, e.Version)
+	if len(steps) == 0 {
+		return e, c.Decode(m, &e.Data)
+	}
+	v, err := steps[0].decode(func(v interface{}) error { return c.Decode(m, v) })
+	for _, up := range steps[1:] {
+		if err != nil {
+			break
+		}
+		v, err = up.apply(v)
+	}
+	if err != nil {
+		return e, fmt.Errorf("natsv2: upcasting %s v%d: %w", e.Type, e.Version, err)
+	}
+	data, ok := v.(T)
+	if !ok {
+		return e, fmt.Errorf("natsv2: %s v%d upcasts to a %T, not a %T", e.Type, e.Version, v, e.Data)
+	}
+	e.Data, e.Version = data, upcasters.current(e.Type)
+	return e, nil
+}
+
+// eventType is T's event type, "" for an interface.
+func eventType[T any]() string {
+	var v T
+	if t, ok := interface{}(v).(interface{ EventType() string }); ok {
+		return t.EventType()
+	}
+	rt := reflect.TypeOf(v)
+	if rt == nil {
+		return ""
+	}
+	for rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	return rt.Name()
+}
+
+// RegisterUpcaster adds the step from version from of eventType to from+1.
+// Steps for a type are registered before subscribing, usually in an init.
+func RegisterUpcaster[From, To any](eventType string, from int, fn func(From) (To, error)) {
+	upcasters.add(eventType, from, upcaster{
+		decode: func(decode func(interface{}) error) (interface{}, error) {
+			var f From
+			if err := decode(&f); err != nil {
+				return nil, err
+			}
+			return fn(f)
+		},
+		apply: func(v interface{}) (interface{}, error) {
+			f, ok := v.(From)
+			if !ok {
+				return nil, fmt.Errorf("natsv2: v%d upcaster of %s wants a %T, got a %T", from, eventType, f, v)
+			}
+			return fn(f)
+		},
+	})
+}
+
+type upcaster struct {
+	// decode decodes the message as the step's input and upcasts it, apply
+	// upcasts the previous step's output.
+	decode func(decode func(interface{}) error) (interface{}, error)
+	apply  func(v interface{}) (interface{}, error)
+}
+
+var upcasters = upcasterRegistry{steps: map[string]map[int]upcaster{}}
+
+type upcasterRegistry struct {
+	mu    sync.RWMutex
+	steps map[string]map[int]upcaster
+}
+
+func (r *upcasterRegistry) add(eventType string, from int, up upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.steps[eventType] == nil {
+		r.steps[eventType] = map[int]upcaster{}
+	}
+	r.steps[eventType][from] = up
+}
+
+// current is the version after the last step, 1 without any.
+func (r *upcasterRegistry) current(eventType string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v := 1
+	for from := range r.steps[eventType] {
+		if from+1 > v {
+			v = from + 1
+		}
+	}
+	return v
+}
+
+// from are the steps from version on, in order.
+func (r *upcasterRegistry) from(eventType string, version int) []upcaster {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var steps []upcaster
+	for {
+		up, ok := r.steps[eventType][version]
+		if !ok {
+			return steps
+		}
+		steps = append(steps, up)
+		version++
+	}
+}
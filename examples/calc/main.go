@@ -0,0 +1,98 @@
+// A service and its client from calc.natsv2.yaml, on an in-process server.
+//
+//	go generate ./examples/calc && go run ./examples/calc
+package main
+
+//go:generate go run ../../cmd/natsv2-gen calc.natsv2.yaml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+type AddRequest struct {
+	A, B int
+}
+
+type AddResponse struct {
+	Sum   int
+	Total int
+}
+
+type ResetRequest struct{}
+
+// calc keeps a running total of what it added up.
+type calc struct {
+	mu    sync.Mutex
+	total int
+}
+
+func (c *calc) Add(ctx context.Context, req *AddRequest) (*AddResponse, error) {
+	if req.A < 0 || req.B < 0 {
+		return nil, &natsv2.RequestError{Code: "400", Description: "only positive numbers"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total += req.A + req.B
+	return &AddResponse{Sum: req.A + req.B, Total: c.total}, nil
+}
+
+func (c *calc) Reset(ctx context.Context, req *ResetRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total = 0
+	return nil
+}
+
+func main() {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.Start()
+	defer s.Shutdown()
+	if !s.ReadyForConnections(10 * time.Second) {
+		log.Fatal("server not ready")
+	}
+	nc, err := natsv2.Connect(s.ClientURL())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer nc.Close()
+
+	svc, err := RegisterCalc(nc, &calc{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer svc.Shutdown()
+
+	ctx := context.Background()
+	client := NewCalcClient(nc)
+	for i := 1; i <= 3; i++ {
+		resp, err := client.Add(ctx, &AddRequest{A: i, B: i})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%d + %d = %d, total %d\n", i, i, resp.Sum, resp.Total)
+	}
+	var rerr *natsv2.RequestError
+	if _, err := client.Add(ctx, &AddRequest{A: -1}); errors.As(err, &rerr) {
+		fmt.Println("refused:", rerr.Code, rerr.Description)
+	}
+	if err := client.Reset(ctx, &ResetRequest{}); err != nil {
+		log.Fatal(err)
+	}
+	resp, err := client.Add(ctx, &AddRequest{A: 1, B: 1})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("after reset, total", resp.Total)
+}
@@ -0,0 +1,115 @@
+// Request throughput at increasing concurrency, natsv2's shared reply inbox
+// against a subscription per request, on an in-process server.
+//
+//	go run ./examples/reqbench -c 1,16,256,1024
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+func main() {
+	levels := flag.String("c", "1,16,256,1024", "concurrent requesters, comma separated")
+	flag.Parse()
+
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.Start()
+	defer s.Shutdown()
+	if !s.ReadyForConnections(10 * time.Second) {
+		log.Fatal("server not ready")
+	}
+
+	responder, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer responder.Close()
+	responder.Subscribe("bench.echo", func(m *nats.Msg) { m.Respond(m.Data) })
+	responder.Flush()
+
+	nc, err := natsv2.Connect(s.ClientURL())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer nc.Close()
+	raw, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer raw.Close()
+
+	payload := []byte("ping")
+	shared := func() error {
+		_, err := nc.Request("bench.echo", payload, natsv2.Timeout(5*time.Second))
+		return err
+	}
+	perRequest := func() error {
+		inbox := raw.NewInbox()
+		sub, err := raw.SubscribeSync(inbox)
+		if err != nil {
+			return err
+		}
+		defer sub.Unsubscribe()
+		if err := raw.PublishRequest("bench.echo", inbox, payload); err != nil {
+			return err
+		}
+		_, err = sub.NextMsg(5 * time.Second)
+		return err
+	}
+
+	fmt.Printf("%-12s %-14s %12s %12s\n", "concurrency", "inbox", "req/s", "ns/req")
+	for _, l := range strings.Split(*levels, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(l))
+		if err != nil || n < 1 {
+			log.Fatalf("bad concurrency %q", l)
+		}
+		for _, run := range []struct {
+			name string
+			req  func() error
+		}{{"shared", shared}, {"per-request", perRequest}} {
+			r := testing.Benchmark(func(b *testing.B) { bench(b, n, run.req) })
+			fmt.Printf("%-12d %-14s %12.0f %12d\n", n, run.name, float64(r.N)/r.T.Seconds(), r.NsPerOp())
+		}
+	}
+}
+
+// bench runs b.N requests spread over n goroutines.
+func bench(b *testing.B, n int, req func() error) {
+	work := make(chan struct{}, b.N)
+	for i := 0; i < b.N; i++ {
+		work <- struct{}{}
+	}
+	close(work)
+	errs := make(chan error, n)
+	b.ResetTimer()
+	for i := 0; i < n; i++ {
+		go func() {
+			for range work {
+				if err := req(); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
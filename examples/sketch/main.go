@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"go.etcd.io/bbolt"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+	"github.com/derekcollison/natsv2.go/natsbolt"
+)
+
+func foo() {
+	subj := "natsv2.x.foo"
+
+	// Close drains, so the publish is not lost.
+	nc, _ := natsv2.Connect("demo.nats.io")
+	defer nc.Close()
+	nc.Publish(subj, "Hello World!")
+
+	nc2, _ := nats.Connect("demo.nats.io")
+	defer nc2.Drain()
+	nc2.Publish(subj, []byte("Hello NATS World"))
+}
+
+func main() {
+	foo()
+
+	nc, err := natsv2.Connect("demo.nats.io")
+	if err != nil {
+		log.Fatalf("Could not connect: %v\n", err)
+	}
+
+	tsubj := "natsv2.foo"
+
+	// Do basic style publish.
+	nc.Publish(tsubj, "Hello World!")
+	nc.Publish(tsubj, 22)
+
+	type person struct {
+		Name    string
+		Address string
+		Age     int
+	}
+
+	me := &person{Name: "derek", Age: 22, Address: "Los Angeles, CA"}
+
+	nc.Publish(tsubj, me) // This will be JSON, the default codec.
+
+	nc.Publish(tsubj, natsv2.JSON(me))
+
+	nc.Publish(tsubj, natsv2.Base64(natsv2.Gzip(natsv2.JSON(me))))
+	// Or encode straight into a pooled buffer.
+	nc.PublishTo(tsubj, func(w io.Writer) error { return json.NewEncoder(w).Encode(me) })
+
+	if data, err := natsv2.MsgPack(me); err == nil {
+		nc.Publish(tsubj, data)
+	}
+
+	// Streams encode for you, JSON by default, and set Content-Type.
+	nc.Stream(tsubj).Publish(me)
+	nc.Stream(tsubj).WithEncoder(natsv2.MsgPackContentType).Publish(me)
+
+	nc.Subscribe("foo")
+	nc.Subscribe("foo", natsv2.Queue("bar"))
+	// Without a handler, take messages when you want them.
+	if sub, err := nc.Subscribe("foo"); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		sub.Next(ctx)
+		cancel()
+	}
+	nc.Subscribe("foo", natsv2.Handler(func(msg *natsv2.Msg) {}))
+	// Or decoded onto a channel, here dropping the oldest when it is full.
+	people := make(chan person, 64)
+	natsv2.SubscribeChan(nc, "people", people, natsv2.Overflow(natsv2.OverflowDropOldest))
+
+	nc.Request("service", "2+2")
+	nc.Request("service", "2+2", natsv2.Timeout(2*time.Second))
+
+	ctx, cancelCB := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelCB() // should always be called, not discarded, to prevent context leak
+
+	nc.Request("service", "2+2", natsv2.Ctx(ctx))
+
+	// Where the time went, network or handler.
+	if reply, err := nc.Request("service", "2+2", natsv2.TrackLatency()); err == nil {
+		fmt.Println(reply.Latency().Network(), reply.Latency().Handler)
+	}
+
+	// Chunked responses.
+	nc.Request("service", "video-22", natsv2.Chunked())
+
+	// Streamed responses.
+	nc.Request("service", "video-22", natsv2.Streamed(func(msg *natsv2.Msg) {}))
+
+	// Services.
+	// Joins a queue group for name and version by default.
+	svc, _ := nc.Service("my-service", "1.0.0", natsv2.ServiceHandler(func(msg *natsv2.Msg) {}))
+	// Will drain.
+	svc.Shutdown()
+
+	// Or return the reply, errors go back as service errors.
+	nc.Service("my-service", "1.0.0", natsv2.ServiceHandleFunc(func(ctx context.Context, req *natsv2.Msg) (interface{}, error) {
+		return me, nil
+	}))
+
+	// More endpoints, groups prefix their subjects.
+	svc, _ = nc.Service("calc", "1.0.0")
+	svc.AddEndpoint("add", natsv2.ServiceHandler(func(msg *natsv2.Msg) {}))
+	svc.AddGroup("calc.v2").AddEndpoint("add", natsv2.ServiceHandler(func(msg *natsv2.Msg) {}))
+
+	// Answers on $SRV.PING/INFO/STATS always, discover and health endpoints on top.
+	nc.Service("my-service", "1.0.0", natsv2.ServiceHandler(func(msg *natsv2.Msg) {}), natsv2.Discover("services.my-service", "description?"))
+	// Can be chained as well.
+	svc, _ = nc.Service("my-service", "1.0.0", natsv2.ServiceHandler(func(msg *natsv2.Msg) {}))
+	svc.Discover("services.my-service", "description?")
+	svc.Health("my-service.healthz")
+
+	// Also directly support HTTP handlers. Protecting current investments, tech, libraries.
+	nc.Service("my-service", "1.0.0", natsv2.HTTPHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("NATS-X", "yes")
+		w.WriteHeader(200)
+		io.WriteString(w, fmt.Sprintf("Hello from NATS for %q!\n", req.URL.Path))
+	}))
+
+	// For HTTP compatabilty. Also all middlewares etc.
+	nc.Handle("foo", func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, fmt.Sprintf("Hello from NATS for %q!\n", req.URL.Path))
+	})
+
+	// Wildcards work too, matched tokens are available via Params.
+	nc.Handle("api.users.{id}", func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, fmt.Sprintf("Hello user %s!\n", natsv2.Params(req)["id"]))
+	}, natsv2.Operations(natsv2.APIOperation{Summary: "Say hello", Response: ""}))
+
+	// And an OpenAPI document of them for the gateway, on GET /openapi through HTTPProxy(nc, "api").
+	nc.Handle("api.openapi", natsv2.OpenAPIHandler(nc, natsv2.OpenAPIInfo{Title: "sketch", Version: "1.0.0", Prefix: "api"}))
+
+	// Whole routers with the usual middleware.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/web/hello", func(w http.ResponseWriter, req *http.Request) { io.WriteString(w, "hello") })
+	nc.Mount("web", mux, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("NATS-X", "yes")
+			next.ServeHTTP(w, req)
+		})
+	})
+
+	// And the other way, a plain http.Client talking to them.
+	client := &http.Client{Transport: natsv2.HTTPTransport(nc)}
+	if resp, err := client.Get("nats:///api/users/22"); err == nil {
+		resp.Body.Close()
+	}
+
+	// Or put NATS services behind an HTTP server, GET /users/22 is a request on api.users.22.
+	go http.ListenAndServe(":8080", natsv2.HTTPProxy(nc, "api"))
+
+	// Kubernetes probes on /healthz/livez and /healthz/readyz.
+	go http.ListenAndServe(":8081", natsv2.HealthHandler(nc, natsv2.HealthJetStream()))
+
+	nc.Close()
+}
+
+func ex(nc natsv2.Connection) {
+	type sensor struct {
+		Name string
+		Temp int
+	}
+	curTemp := &sensor{Name: "sensor-22", Temp: 52}
+
+	stream := nc.Stream("foo.bar")
+	// Defaults to JSON
+	stream.Publish(curTemp)
+	// With middleware at publish.
+	stream.Publish(natsv2.Base64(natsv2.Gzip(natsv2.JSON(curTemp))))
+	// Or a different encoder for the whole stream.
+	stream.WithEncoder(natsv2.MsgPackContentType).Publish(curTemp)
+	stream.WithEncoder(natsv2.CBORContentType).Publish(curTemp)
+	// Protobuf messages carry their name in the Content-Type.
+	stream.Publish(natsv2.Base64(natsv2.Gzip(natsv2.Protobuf(wrapperspb.String("derek")))))
+	// As part of stream construction, with headers so receivers can Decode.
+	stream2 := nc.Stream("foo.bar", natsv2.Pipeline("base64", "gzip", natsv2.JSONContentType))
+	stream2.Publish(curTemp)
+
+	// Consumers
+	stream.Subscribe()
+	stream.Subscribe(natsv2.Queue("prod-v1"))
+	stream.Subscribe(natsv2.Handler(func(msg *natsv2.Msg) {}))
+
+	// Requests
+	nc.Request("service", "2+2")
+	nc.Request("service", "2+2", natsv2.Timeout(2*time.Second))
+
+	ctx, cancelCB := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelCB() // should always be called, not discarded, to prevent context leak
+
+	nc.Request("service", "2+2", natsv2.Ctx(ctx))
+
+	// JetStream
+	// Streams can be set up from the app.
+	nc.JetStream().DeclareStream(nats.StreamConfig{Name: "MY_ORDERS", Subjects: []string{"orders.>"}, Replicas: 3})
+	nc.JetStream().DeclareConsumer("MY_ORDERS", nats.ConsumerConfig{Durable: "billing", MaxDeliver: 5, FilterSubjects: []string{"orders.new"}})
+	if billing, err := nc.Consumer("MY_ORDERS", "billing"); err == nil {
+		billing.Consume(func(m *natsv2.Msg) { m.Ack() }, natsv2.Fetch(natsv2.FetchBatch(100), natsv2.FetchHeartbeat(time.Second)))
+	}
+	// Publishes wait for the ack and fail if not stored in MY_ORDERS.
+	orders := nc.Stream("orders.new", natsv2.JetStreamStream("MY_ORDERS"))
+	orders.Publish(curTemp, natsv2.WithMsgID("sensor-22-1"))
+	nc.Stream("orders.paid", natsv2.JetStreamStream("MY_ORDERS"), natsv2.Idempotent(nil)).Publish(curTemp)
+	if ack, err := orders.PublishAsync(curTemp); err == nil {
+		<-ack.Ok()
+	}
+	<-orders.PublishAsyncComplete()
+	// Bulk loads, published together and the acks waited on together.
+	nc.Batch(natsv2.BatchJetStream("MY_ORDERS")).Add("orders.new", curTemp).Add("orders.new", curTemp).Send(ctx)
+	// JetStream consumers, durable or not, push or pull.
+	orders.Subscribe(natsv2.JetStreamConsumer(natsv2.ConsumerOptions{Durable: "billing", Pull: true}), natsv2.AutoAck(), natsv2.Handler(func(msg *natsv2.Msg) {}))
+	// Failed messages are retried, then dead lettered.
+	orders.Subscribe(natsv2.JetStreamConsumer(natsv2.ConsumerOptions{Durable: "shipping"}), natsv2.DeadLetter("dlq.shipping", 5),
+		natsv2.HandleJetStream(func(ctx context.Context, msg *natsv2.Msg) error { return nil }))
+	// Repeats of a Nats-Msg-Id within ten minutes are dropped.
+	orders.Subscribe(natsv2.JetStreamConsumer(natsv2.ConsumerOptions{Durable: "invoices"}), natsv2.AutoAck(), natsv2.Dedupe(10*time.Minute, nil),
+		natsv2.Handler(func(msg *natsv2.Msg) {}))
+	// Publishes that go out later, kept in JetStream until then.
+	nc.Publish("reminders.send", curTemp, natsv2.Delay(5*time.Minute))
+	if sched, err := nc.Scheduler(); err == nil {
+		sched.Schedule("daily-report", "0 6 * * *", "reports.build", curTemp)
+		sched.Run()
+	}
+	// Written to a local store first, published once the server is there.
+	if db, err := bbolt.Open("outbox.db", 0o600, nil); err == nil {
+		if ob, err := natsv2.NewOutbox(nc, natsbolt.New(db), natsv2.OutboxJetStream("MY_ORDERS")); err == nil {
+			ob.Publish(context.Background(), "orders.placed", curTemp)
+		}
+	}
+	// A view of the whole stream, in order.
+	nc.Stream("orders.>", natsv2.JetStreamStream("MY_ORDERS")).Subscribe(natsv2.Ordered(), natsv2.Handler(func(msg *natsv2.Msg) {}))
+
+	// Handlers find out when we are shutting down.
+	nc.Subscribe("reports.build", natsv2.Handler(func(msg *natsv2.Msg) {
+		if msg.Context().Err() != nil {
+			return
+		}
+	}))
+
+	// Subjects from data, checked token by token.
+	if subj, err := natsv2.Subject("orders", "eu").Token("42").Build(); err == nil {
+		nc.Publish(subj, curTemp)
+	}
+
+	// Handlers by pattern behind one subscription.
+	r := natsv2.NewMsgRouter()
+	r.Handle("orders.{orderID}.created", func(msg *natsv2.Msg) { fmt.Println(msg.Param("orderID")) })
+	r.Subscribe(nc)
+
+	// Or everything from a file and the environment.
+	if cfg, err := natsv2.LoadConfig("nats.yaml"); err == nil && cfg.LoadEnv("NATS") == nil {
+		natsv2.ConnectWithConfig(cfg)
+	}
+	// Short lived credentials, refreshed before they expire.
+	natsv2.Connect("demo.nats.io", natsv2.WithTokenProvider(natsv2.TokenProviderFunc(func(ctx context.Context) (string, []byte, error) {
+		return "", nil, nil
+	})))
+	// One tenant's view, everything lives under acme.
+	if tenant, err := natsv2.Connect("demo.nats.io", natsv2.WithSubjectPrefix("acme"), natsv2.WithInboxPrefix("acme._INBOX")); err == nil {
+		tenant.Publish("orders.placed", curTemp)
+	}
+	// Control messages ahead of the bulk on the same subjects.
+	nc.Subscribe("devices.>", natsv2.Handler(func(msg *natsv2.Msg) {}), natsv2.Priority(
+		natsv2.PriorityLane{Name: "high", Weight: 4}, natsv2.PriorityLane{Name: "bulk", Weight: 1}))
+	nc.Publish("devices.7.reboot", curTemp, natsv2.Lane("high"))
+	// Each customer's orders in order, spread over the billing instances.
+	nc.Subscribe("orders.>", natsv2.Queue("billing"), natsv2.Handler(func(msg *natsv2.Msg) {}),
+		natsv2.Partitioned(16, func(msg *natsv2.Msg) string { return msg.Header().Get("Customer") }))
+	// One instance of the fleet runs the nightly job.
+	if cp, err := natsv2.Elect(nc, "leases.nightly", 10*time.Second, natsv2.OnElected(func(ctx context.Context) {})); err == nil {
+		defer cp.Close()
+	}
+	// One process at a time writes the monthly report.
+	if mu, err := nc.Lock("reports.monthly"); err == nil {
+		if lease, err := mu.Acquire(context.Background()); err == nil {
+			defer lease.Release()
+		}
+	}
+	// Global counters and per-tenant quotas, shared by every instance.
+	if kv, err := nc.KV("quotas"); err == nil {
+		kv.Increment("signups", 1)
+		if quota, err := natsv2.NewKVRateLimiter(kv, 100, 200); err == nil {
+			quota.Allow("tenant.acme")
+		}
+	}
+	// Ship a file as a byte stream, flow controlled by the reader.
+	if w, err := natsv2.NewWriter(nc, "backups.db1"); err == nil {
+		io.WriteString(w, "dump")
+		w.Close()
+	}
+	// Serve HTTP, or anything else on a net.Listener, through the mesh.
+	if l, err := natsv2.Listen(nc, "tunnel.admin"); err == nil {
+		go http.Serve(l, nil)
+	}
+	// Typed events with their type, version and causation in headers.
+	natsv2.PublishEvent(nc, "sensors.events", natsv2.Event[*sensor]{Data: curTemp})
+	// Carry the flow on from a handler, with WithCorrelation these share an X-Correlation-Id.
+	nc.Subscribe("sensors.events", natsv2.Handler(func(msg *natsv2.Msg) {
+		nc.PublishCtx(msg.Context(), "sensors.audit", msg.CorrelationID())
+	}))
+	// Nothing that breaks the contract goes out on, or reaches a handler for, sensors.>.
+	if tempSchema, err := natsv2.JSONSchema([]byte(`{"type":"object","required":["Temp"]}`)); err == nil {
+		natsv2.Connect("demo.nats.io", natsv2.WithValidator("sensors.>", tempSchema))
+	}
+	// Rebuild a view from the last day of a stream, done when it has caught up.
+	if r, err := nc.Stream("orders.>", natsv2.JetStreamStream("MY_ORDERS")).Replay(time.Now().Add(-24*time.Hour), func(msg *natsv2.Msg) {}); err == nil {
+		r.Wait(ctx)
+	}
+	// Keep 30 days of everything on sensors.> in a stream, publishers don't change.
+	natsv2.Archive(nc, "SENSORS_ARCHIVE", "sensors.>", natsv2.ArchiveMaxAge(30*24*time.Hour))
+	// See what goes by on sensors.> without taking it from anyone, the last 500 kept.
+	if tap, err := nc.Tap("sensors.>", nil, natsv2.TapBuffer(500)); err == nil {
+		defer tap.Dump(os.Stderr)
+	}
+	// Retry a failing handler in process, then hold the message in a KV bucket to look at and reinject.
+	if q, err := natsv2.OpenQuarantine(nc, "sensors-poison"); err == nil {
+		nc.Subscribe("sensors.>", natsv2.HandlerRetry(3, 100*time.Millisecond), natsv2.QuarantineTo(q),
+			natsv2.HandleJetStream(func(ctx context.Context, msg *natsv2.Msg) error { return nil }))
+	}
+	nc.Subscribe("sensors.>", natsv2.HandleBatch(100, time.Second, func(msgs []*natsv2.Msg) {
+		fmt.Println("batch of", len(msgs))
+	}))
+	nc.Request("sensors.config", nil, natsv2.Cached(time.Minute), natsv2.CacheStale(10*time.Second))
+	natsv2.Client(nc, "sensors.v1", natsv2.Timeout(time.Second), natsv2.Retry(3, 50*time.Millisecond)).Call(ctx, "latest", nil, curTemp)
+	if ok, err := nc.Can("sensors.temp", natsv2.PublishOp|natsv2.SubscribeOp); err == nil && !ok {
+		fmt.Println("not allowed to use sensors.temp")
+	}
+	if err := nc.Publish("sensors.dump", make([]byte, 8<<20)); errors.Is(err, natsv2.ErrPayloadTooLarge) {
+		fmt.Println("too big, connect with natsv2.ChunkOversize() to send it in chunks")
+	}
+	if err := nc.ReloadCredentials(); err != nil {
+		fmt.Println("no creds to reload:", err)
+	}
+	if set, err := nc.SubscribeMany([]string{"sensors.temp.>", "sensors.humidity.*"}, natsv2.Workers(4), natsv2.Handler(func(msg *natsv2.Msg) {})); err == nil {
+		defer set.Drain(ctx)
+	}
+	nc.Subscribe("sensors.report", natsv2.Handler(func(msg *natsv2.Msg) {
+		if d, ok := msg.Deadline(); ok && time.Until(d) < time.Second {
+			return
+		}
+	}))
+	go http.ListenAndServe(":8082", natsv2.RegistryHandler(nc))
+	nc.Subscribe("telemetry.>", natsv2.Decompress(), natsv2.DecodeErrors(natsv2.DecodeErrorDrop), natsv2.Handler(func(msg *natsv2.Msg) {}))
+	// Over JetStream, stored until a worker replies.
+	nc.Request("service", "2+2", natsv2.JetStreamRequest("NEW_ORDERS"))
+	natsv2.Connect("demo.nats.io", natsv2.LameDuckHandover(30*time.Second), natsv2.OnLameDuck(func(ev natsv2.LameDuckEvent) { fmt.Println(ev.Server, "going away") }))
+	var handlers natsv2.SubjectTrie[func(*natsv2.Msg)]
+	handlers.Insert("sensors.*.temp", func(msg *natsv2.Msg) {})
+	if h, _, ok := handlers.Lookup("sensors.kitchen.temp"); ok {
+		h(nil)
+	}
+	natsv2.Connect("demo.nats.io", natsv2.WithPanicReports("ops.crashes"))
+	nc.Subscribe("ledger.post", natsv2.Panics(natsv2.PanicRethrow), natsv2.Handler(func(msg *natsv2.Msg) {}))
+	natsv2.Republish(nc).From("sensors.{room}.raw", natsv2.Queue("cleaners")).Transform(func(ctx context.Context, msg *natsv2.Msg) (interface{}, error) {
+		return map[string]string{"reading": string(msg.Data())}, nil
+	}).To("sensors.{room}.clean")
+	if kv, err := nc.KV("settings"); err == nil {
+		if updates, err := kv.Updates(ctx, "theme.>"); err == nil {
+			for e := range updates {
+				fmt.Println(e.Key, e.Revision)
+			}
+		}
+	}
+	if nc, err := natsv2.Connect("demo.nats.io", natsv2.WithRequestDefaults(natsv2.Timeout(5*time.Second), natsv2.Retry(3, 100*time.Millisecond))); err == nil {
+		if ropts, err := nc.RequestOptions(natsv2.Timeout(time.Second)); err == nil {
+			fmt.Println(ropts.Timeout, ropts.Attempts)
+		}
+	}
+	// Hold deliveries through a migration, without unsubscribing.
+	if sub, err := nc.Subscribe("orders.>", natsv2.Handler(func(msg *natsv2.Msg) {})); err == nil {
+		sub.Pause()
+		sub.Resume()
+	}
+	// Presence that is worse stale than missing.
+	nc.Publish("presence.alice", curTemp, natsv2.TTL(5*time.Second))
+	// Publishes made while offline wait on disk.
+	natsv2.Connect("demo.nats.io", natsv2.WithOfflineBuffer("spool", 64<<20), natsv2.WithMaxReconnects(-1))
+	// Structured logs of connects, drops, failures and drains, through slog.Default().
+	natsv2.Connect("demo.nats.io", natsv2.WithSlog(nil))
+	// Over websocket, through the HTTP proxy.
+	natsv2.Connect("nats.example.com:443", natsv2.WithWebsocketOptions(natsv2.WebsocketOptions{Path: "/nats"}), natsv2.WithProxyFromEnvironment())
+	// The local cluster, then DR, and back once the local one is up again.
+	natsv2.ConnectGroups([]natsv2.ClusterGroup{
+		{Name: "east", URLs: []string{"nats://e1:4222", "nats://e2:4222"}},
+		{Name: "west", URLs: []string{"nats://w1:4222"}},
+	}, natsv2.WithFailback(30*time.Second))
+	// Several connections, sharded by subject.
+	if pool, err := natsv2.ConnectPool("demo.nats.io", 4); err == nil {
+		pool.Publish("telemetry.eu", curTemp)
+		defer pool.Close()
+	}
+	// Everything drained in order on the way out.
+	defer nc.Shutdown(ctx, natsv2.ShutdownTimeout(natsv2.ShutdownConsumers, time.Minute))
+}
@@ -0,0 +1,134 @@
+// Subject dispatch, a SubjectTrie lookup against matching each pattern in
+// turn with the subject split up, at increasing numbers of patterns. Then
+// random patterns and subjects, checked against the server's own matching.
+//
+//	go run ./examples/subjectbench -n 10,100,1000 -check 1000000
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats-server/v2/server"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+// Few tokens, so random patterns and subjects overlap.
+var alphabet = []string{"orders", "eu", "us", "created", "42", "a", "b"}
+
+func main() {
+	levels := flag.String("n", "10,100,1000", "patterns, comma separated")
+	check := flag.Int("check", 1000000, "random pattern and subject pairs to check against the server")
+	seed := flag.Int64("seed", 1, "random seed")
+	flag.Parse()
+	rnd := rand.New(rand.NewSource(*seed))
+
+	fmt.Printf("%-10s %-8s %12s %12s\n", "patterns", "match", "ns/op", "allocs/op")
+	for _, l := range strings.Split(*levels, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(l))
+		if err != nil || n < 1 {
+			log.Fatalf("bad pattern count %q", l)
+		}
+		var trie natsv2.SubjectTrie[int]
+		var patterns []string
+		for trie.Len() < n {
+			p := pattern(rnd, 1+rnd.Intn(6))
+			if trie.Insert(p, len(patterns)) == nil {
+				patterns = append(patterns, p)
+			}
+		}
+		subjects := make([]string, 1024)
+		for i := range subjects {
+			subjects[i] = subject(rnd, 1+rnd.Intn(6))
+		}
+		for _, run := range []struct {
+			name  string
+			match func(string) int
+		}{
+			{"trie", func(s string) int {
+				hits := 0
+				trie.Match(s, func(string, int) { hits++ })
+				return hits
+			}},
+			{"naive", func(s string) int { return naive(patterns, s) }},
+		} {
+			r := testing.Benchmark(func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					run.match(subjects[i%len(subjects)])
+				}
+			})
+			fmt.Printf("%-10d %-8s %12d %12d\n", n, run.name, r.NsPerOp(), r.AllocsPerOp())
+		}
+	}
+
+	// The server has a pattern and a literal subject collide when the
+	// subject is in the pattern.
+	var mismatches int
+	for i := 0; i < *check; i++ {
+		p, s := pattern(rnd, 1+rnd.Intn(5)), subject(rnd, 1+rnd.Intn(5))
+		want := server.SubjectsCollide(p, s)
+		var trie natsv2.SubjectTrie[struct{}]
+		trie.Insert(p, struct{}{})
+		_, _, found := trie.Lookup(s)
+		if got := natsv2.Matches(p, s); got != want || found != want {
+			mismatches++
+			if mismatches <= 10 {
+				fmt.Printf("mismatch: %q %q server %v, Matches %v, trie %v\n", p, s, want, got, found)
+			}
+		}
+	}
+	fmt.Printf("%d pairs checked against the server, %d mismatches\n", *check, mismatches)
+}
+
+// naive is the matching patterns counted one at a time, tokens split out.
+func naive(patterns []string, subject string) int {
+	st := strings.Split(subject, ".")
+	hits := 0
+	for _, p := range patterns {
+		pt := strings.Split(p, ".")
+		ok := len(pt) == len(st)
+		for i, tok := range pt {
+			if tok == ">" {
+				ok = len(st) > i
+				break
+			}
+			if i >= len(st) || tok != "*" && tok != st[i] {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			hits++
+		}
+	}
+	return hits
+}
+
+func subject(rnd *rand.Rand, n int) string {
+	tokens := make([]string, n)
+	for i := range tokens {
+		tokens[i] = alphabet[rnd.Intn(len(alphabet))]
+	}
+	return strings.Join(tokens, ".")
+}
+
+// pattern is a subject with some tokens made wildcards, maybe ending in '>'.
+func pattern(rnd *rand.Rand, n int) string {
+	tokens := strings.Split(subject(rnd, n), ".")
+	for i := range tokens {
+		if rnd.Intn(4) == 0 {
+			tokens[i] = "*"
+		}
+	}
+	if rnd.Intn(3) == 0 {
+		tokens[n-1] = ">"
+	}
+	return strings.Join(tokens, ".")
+}
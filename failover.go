@@ -0,0 +1,339 @@
+package natsv2
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Connecting across clusters. ConnectGroups takes the clusters in order of
+// preference, say the local region and then DR, each with its own servers
+// and, where they differ, its own creds and TLS. We connect to the first
+// group that is up and move down the list once all of a group's servers are
+// gone. WithFailback checks the better groups every interval while we are on
+// a worse one and moves back as soon as one can be reached, OnClusterSwitch
+// is told whenever the group changes.
+//
+//	nc, err := ConnectGroups([]ClusterGroup{
+//		{Name: "east", URLs: []string{"nats://e1:4222", "nats://e2:4222"}, Creds: "east.creds"},
+//		{Name: "west", URLs: []string{"nats://w1:4222"}, Creds: "west.creds", TLS: westTLS},
+//	}, WithFailback(30*time.Second), OnClusterSwitch(func(ev ClusterSwitchEvent) {
+//		log.Printf("moved from %s to %s", ev.From, ev.To)
+//	}))
+//
+// A user and password or a token that differ by group go in the URLs,
+// nats://user:pass@e1:4222. Group Creds and TLS are instead of WithCreds and
+// WithTLS, TLS is used when the group's servers ask for it. Servers the
+// cluster tells us about count as the group we are on.
+
+type ClusterGroup struct {
+	Name string
+	URLs []string
+	// Optional, for a group that needs other credentials or TLS.
+	Creds string
+	TLS   *tls.Config
+}
+
+type ClusterSwitchEvent struct {
+	From, To string
+	Server   string
+}
+
+// WithFailback moves back to a better group once it can be reached,
+// checking every interval.
+func WithFailback(interval time.Duration) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if interval <= 0 {
+			return errors.New("natsv2: failback interval must be positive")
+		}
+		o.Failback = interval
+		return nil
+	}
+}
+
+func OnClusterSwitch(cb func(ClusterSwitchEvent)) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.OnClusterSwitch = append(o.OnClusterSwitch, cb)
+		return nil
+	}
+}
+
+var errFailingBack = errors.New("natsv2: skipped while failing back")
+
+// How long checking a group for failback waits on each server.
+const failbackDialTimeout = 2 * time.Second
+
+func ConnectGroups(groups []ClusterGroup, opts ...ConnectOption) (Connection, error) {
+	gs, err := newClusterGroups(groups)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, g := range gs.groups {
+		urls = append(urls, g.URLs...)
+	}
+	nc, err := Connect(strings.Join(urls, ","), append(opts, gs.options)...)
+	if err != nil {
+		return nil, err
+	}
+	c := nc.(*conn)
+	gs.connected()
+	c.log.Info("connected to group", "group", gs.groups[gs.current].Name)
+	if c.opts.Failback > 0 {
+		go c.failback(gs, c.nc, c.opts.Failback)
+	}
+	return c, nil
+}
+
+type clusterGroups struct {
+	groups []ClusterGroup
+	dialer nats.CustomDialer
+
+	mu sync.Mutex
+	// Group by host:port, as nats.go dials them.
+	byAddr  map[string]int
+	dialing int
+	current int
+	// While failing back only groups before this one are dialed, for one
+	// pass over the servers, -1 otherwise.
+	better int
+	tries  int
+}
+
+func newClusterGroups(groups []ClusterGroup) (*clusterGroups, error) {
+	if len(groups) == 0 {
+		return nil, errors.New("natsv2: no cluster groups")
+	}
+	gs := &clusterGroups{dialer: &net.Dialer{Timeout: nats.DefaultTimeout}, byAddr: map[string]int{}, better: -1}
+	for i, g := range groups {
+		if g.Name == "" {
+			g.Name = fmt.Sprintf("group-%d", i)
+		}
+		if len(g.URLs) == 0 {
+			return nil, fmt.Errorf("natsv2: group %s has no server urls", g.Name)
+		}
+		if g.Creds != "" {
+			if err := checkFile("creds", g.Creds); err != nil {
+				return nil, err
+			}
+		}
+		for _, u := range g.URLs {
+			addr, err := hostPort(u)
+			if err != nil {
+				return nil, fmt.Errorf("natsv2: group %s: %w", g.Name, err)
+			}
+			if j, ok := gs.byAddr[addr]; ok && j != i {
+				return nil, fmt.Errorf("natsv2: %s is in groups %s and %s", addr, gs.groups[j].Name, g.Name)
+			}
+			gs.byAddr[addr] = i
+		}
+		gs.groups = append(gs.groups, g)
+	}
+	return gs, nil
+}
+
+// hostPort is the address nats.go dials for u.
+func hostPort(u string) (string, error) {
+	if !strings.Contains(u, "://") {
+		u = "nats://" + u
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	if pu.Port() == "" {
+		return net.JoinHostPort(pu.Hostname(), "4222"), nil
+	}
+	return pu.Host, nil
+}
+
+func (gs *clusterGroups) options(o *ConnectOptions) error {
+	o.NATS = append(o.NATS, nats.DontRandomize())
+	// We dial through WithDialer and WithProxy, not the other way round.
+	if d := o.dialer(); d != nil {
+		gs.dialer = d
+	}
+	o.Dialer, o.Proxy = gs, nil
+	var creds, secure bool
+	for _, g := range gs.groups {
+		creds = creds || g.Creds != ""
+		secure = secure || g.TLS != nil
+	}
+	if creds {
+		o.NATS = append(o.NATS, nats.UserJWT(gs.userJWT, gs.sign))
+	}
+	if secure {
+		// Not nats.Secure, that would make every group TLS.
+		o.NATS = append(o.NATS, func(no *nats.Options) error {
+			no.TLSConfig = gs.tlsConfig()
+			return nil
+		})
+	}
+	o.OnReconnect = append(o.OnReconnect, func(ev ReconnectEvent) {
+		from, to := gs.connected()
+		if from == to {
+			return
+		}
+		sev := ClusterSwitchEvent{From: gs.groups[from].Name, To: gs.groups[to].Name, Server: ev.Server}
+		for _, cb := range o.OnClusterSwitch {
+			cb(sev)
+		}
+	})
+	return nil
+}
+
+// Dial is nats.go's, everything we connect to goes through here so we know
+// which group it is.
+func (gs *clusterGroups) Dial(network, address string) (net.Conn, error) {
+	gs.mu.Lock()
+	g, ok := gs.byAddr[address]
+	if !ok {
+		g = gs.current
+		gs.byAddr[address] = g
+	}
+	if gs.better >= 0 {
+		gs.tries++
+		if gs.tries > len(gs.byAddr) {
+			gs.better = -1
+		} else if g >= gs.better {
+			gs.mu.Unlock()
+			return nil, errFailingBack
+		}
+	}
+	gs.dialing = g
+	gs.mu.Unlock()
+	return gs.dialer.Dial(network, address)
+}
+
+// connected makes the group last dialed the current one.
+func (gs *clusterGroups) connected() (from, to int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	from, gs.current = gs.current, gs.dialing
+	gs.better, gs.tries = -1, 0
+	return from, gs.current
+}
+
+func (gs *clusterGroups) dialingGroup() ClusterGroup {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.groups[gs.dialing]
+}
+
+func (gs *clusterGroups) userJWT() (string, error) {
+	g := gs.dialingGroup()
+	if g.Creds == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(g.Creds)
+	if err != nil {
+		return "", err
+	}
+	return nkeys.ParseDecoratedJWT(data)
+}
+
+func (gs *clusterGroups) sign(nonce []byte) ([]byte, error) {
+	data, err := os.ReadFile(gs.dialingGroup().Creds)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := nkeys.ParseDecoratedNKey(data)
+	if err != nil {
+		return nil, err
+	}
+	defer kp.Wipe()
+	return kp.Sign(nonce)
+}
+
+// tlsConfig verifies against the config of the group being dialed, nats.go
+// only takes the one.
+func (gs *clusterGroups) tlsConfig() *tls.Config {
+	return &tls.Config{
+		// Done in VerifyConnection instead.
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cfg := gs.dialingGroup().TLS
+			switch {
+			case cfg == nil:
+			case cfg.GetClientCertificate != nil:
+				return cfg.GetClientCertificate(cri)
+			case len(cfg.Certificates) > 0:
+				return &cfg.Certificates[0], nil
+			}
+			return &tls.Certificate{}, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			cfg := gs.dialingGroup().TLS
+			if cfg == nil {
+				cfg = &tls.Config{}
+			}
+			if cfg.InsecureSkipVerify {
+				return nil
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("natsv2: server sent no certificate")
+			}
+			vopts := x509.VerifyOptions{Roots: cfg.RootCAs, DNSName: cs.ServerName, Intermediates: x509.NewCertPool()}
+			if cfg.ServerName != "" {
+				vopts.DNSName = cfg.ServerName
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				vopts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(vopts)
+			return err
+		},
+	}
+}
+
+// failback checks the groups better than the current one every interval,
+// forcing a reconnect to the first that can be reached.
+func (c *conn) failback(gs *clusterGroups, nc *nats.Conn, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-c.ctx.Done():
+			return
+		}
+		gs.mu.Lock()
+		cur := gs.current
+		gs.mu.Unlock()
+		if cur == 0 || nc.Status() != nats.CONNECTED {
+			continue
+		}
+		for i := 0; i < cur; i++ {
+			if !gs.reachable(i) {
+				continue
+			}
+			c.log.Info("failing back", "from", gs.groups[cur].Name, "to", gs.groups[i].Name)
+			gs.mu.Lock()
+			gs.better, gs.tries = cur, 0
+			gs.mu.Unlock()
+			nc.ForceReconnect()
+			break
+		}
+	}
+}
+
+func (gs *clusterGroups) reachable(i int) bool {
+	for _, u := range gs.groups[i].URLs {
+		addr, _ := hostPort(u)
+		if conn, err := net.DialTimeout("tcp", addr, failbackDialTimeout); err == nil {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
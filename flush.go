@@ -0,0 +1,41 @@
+package natsv2
+
+import (
+	"context"
+	"time"
+)
+
+// Publishes are buffered and sent in the background, so Publish returning
+// nil only means the message was queued. Flush sends what is buffered and
+// waits for the server to answer a ping, after which everything published
+// before it has been processed by the server. It does not mean subscribers
+// got it, for that use Request or JetStream.
+
+// Used when Flush gets a context without a deadline.
+const DefaultFlushTimeout = 10 * time.Second
+
+func (c *conn) Flush(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultFlushTimeout)
+		defer cancel()
+	}
+	return c.nc.FlushWithContext(ctx)
+}
+
+func (c *conn) FlushTimeout(timeout time.Duration) error {
+	return c.nc.FlushTimeout(timeout)
+}
+
+// PublishSync publishes and flushes, and returns how long the flush took,
+// the round trip to the server.
+func (c *conn) PublishSync(ctx context.Context, subject string, msg interface{}, opts ...PubOption) (time.Duration, error) {
+	if err := c.PublishCtx(ctx, subject, msg, opts...); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if err := c.Flush(ctx); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
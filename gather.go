@@ -0,0 +1,120 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Scatter-gather. RequestAll sends one request and collects the replies from
+// every responder that answers, for discovery or quorum style queries, where
+// Request takes just the first. It stops after Gather's n replies or once the
+// window is up, whichever is first, and only fails if nothing replied.
+// Service error replies are collected like any other, they are the ones with
+// a ServiceErrorHeader.
+//
+//	replies, err := nc.RequestAll("config.version", nil, Gather(3, 500*time.Millisecond))
+//	nc.RequestAll("$SRV.PING", nil, Gather(0, time.Second), OnReply(func(m *Msg) { ... }))
+
+type GatherOptions struct {
+	// Stop after this many replies, 0 for no limit.
+	N int
+	// How long to collect for, the request timeout if 0.
+	Window time.Duration
+	// Called with each reply as it arrives.
+	OnReply func(*Msg)
+}
+
+func Gather(n int, window time.Duration) ReqOption {
+	return func(o *ReqOptions) error {
+		if n < 0 || window < 0 {
+			return errors.New("natsv2: gather needs n and window of at least 0")
+		}
+		o.gather().N, o.gather().Window = n, window
+		return nil
+	}
+}
+
+// OnReply streams gathered replies to cb as they come in, RequestAll still
+// returns them all at the end.
+func OnReply(cb func(*Msg)) ReqOption {
+	return func(o *ReqOptions) error {
+		o.gather().OnReply = cb
+		return nil
+	}
+}
+
+func (o *ReqOptions) gather() *GatherOptions {
+	if o.Gather == nil {
+		o.Gather = &GatherOptions{}
+	}
+	return o.Gather
+}
+
+func (c *conn) RequestAll(subject string, msg interface{}, opts ...ReqOption) ([]*Msg, error) {
+	ropts, err := c.reqOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if ropts.Chunked || ropts.Streamed != nil || ropts.JetStream != "" {
+		return nil, errors.New("natsv2: gathered replies can't be chunked, streamed or persisted")
+	}
+	c.log.Debug("request all", "subject", subject, "timeout", ropts.Timeout)
+
+	m, err := c.codecs.encode(subject, msg, c.codecs.out)
+	if err != nil {
+		return nil, err
+	}
+	m.Subject = c.outSubject(subject)
+	ropts.setAccept(m)
+	setHeaders(m, ropts.Headers)
+	start := time.Now()
+	replies, err := c.requestAll(ropts, m)
+	c.metrics.Requested(subject, time.Since(start), err)
+	return replies, err
+}
+
+func (c *conn) requestAll(ropts *ReqOptions, m *nats.Msg) ([]*Msg, error) {
+	g := ropts.gather()
+	if g.Window > 0 {
+		ropts.Timeout = g.Window
+	}
+	ctx, cancel := ropts.context()
+	defer cancel()
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	in, err := c.replyInbox()
+	if err != nil {
+		return nil, err
+	}
+	defer in.close()
+	m.Reply = in.Reply
+	if err := c.send(ctx, m, c.publish); err != nil {
+		return nil, err
+	}
+
+	var replies []*Msg
+	for g.N == 0 || len(replies) < g.N {
+		r, err := in.next(ctx)
+		if err != nil {
+			if len(replies) > 0 && errors.Is(err, context.DeadlineExceeded) {
+				break
+			}
+			return replies, wrapRequestError(m.Subject, err)
+		}
+		// No responders only comes when there are none at all.
+		if noResponders(r) {
+			return nil, wrapRequestError(m.Subject, nats.ErrNoResponders)
+		}
+		replies = append(replies, c.wrap(r))
+		if g.OnReply != nil {
+			g.OnReply(c.wrap(r))
+		}
+	}
+	return replies, nil
+}
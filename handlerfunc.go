@@ -0,0 +1,89 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+)
+
+// HandlerFunc returns its reply instead of sending it. The value is encoded
+// the same as Respond would, nil sends an empty reply. An error goes back as
+// a service error, 500 unless it is a *RequestError with its own code, and a
+// panic is recovered and sent as a 500. Both also go to the ErrorHandler.
+// Nothing is sent for messages without a reply subject.
+//
+// ctx is done once the connection is closed.
+//
+//	nc.Subscribe("calc.add", HandleFunc(func(ctx context.Context, req *Msg) (interface{}, error) {
+//		var in AddReq
+//		if err := req.Decode(&in); err != nil {
+//			return nil, &RequestError{Code: "400", Description: err.Error()}
+//		}
+//		return AddResp{Sum: in.A + in.B}, nil
+//	}))
+type HandlerFunc func(ctx context.Context, req *Msg) (interface{}, error)
+
+var errHandlerPanic = errors.New("handler panic")
+
+func HandleFunc(h HandlerFunc) SubOption {
+	return func(o *SubOptions) error {
+		o.Handler, o.handlerName = func(m *Msg) { h.serve(m, o.panicSite()) }, funcName(h)
+		return nil
+	}
+}
+
+// ServiceHandleFunc is ServiceHandler for a HandlerFunc, errors and panics
+// count towards the service's stats.
+func ServiceHandleFunc(h HandlerFunc) ServiceOption {
+	return func(o *ServiceOptions) error {
+		o.HandlerFunc = h
+		return nil
+	}
+}
+
+// serve runs h for m and sends the reply, it returns the error the
+// requester got, if any.
+func (h HandlerFunc) serve(m *Msg, site panicSite) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errHandlerPanic
+			m.fail("500", err)
+			m.c.panicked(m.Subject(), r, debug.Stack(), site)
+		}
+	}()
+	v, err := h(m.Context(), m)
+	if err != nil {
+		m.c.handleError(err)
+		var rerr *RequestError
+		if errors.As(err, &rerr) && rerr.Code != "" {
+			m.fail("", err)
+		} else {
+			m.fail("500", err)
+		}
+		return err
+	}
+	if replyTo(m.m) == "" {
+		return nil
+	}
+	if v == nil {
+		v = []byte(nil)
+	}
+	if err := m.Respond(v); err != nil {
+		m.c.handleError(err)
+		// A 406 has been sent already.
+		if !errors.Is(err, ErrNotAcceptable) {
+			m.fail("500", err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *Msg) fail(code string, err error) {
+	if replyTo(m.m) == "" {
+		return
+	}
+	if err := m.RespondError(code, err); err != nil {
+		m.c.handleError(err)
+	}
+}
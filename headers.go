@@ -0,0 +1,61 @@
+package natsv2
+
+import (
+	"github.com/nats-io/nats.go"
+)
+
+// Headers go out with Headers on a publish and RequestHeaders on a request,
+// added to whatever the codec or an interceptor sets. Keys are sent as given,
+// NATS headers are case sensitive.
+//
+//	nc.Publish("orders", order, Headers(map[string][]string{"Tenant": {"acme"}}))
+//
+// Handlers read them with Msg.Header.
+//
+//	nc.Subscribe("orders", Handler(func(m *Msg) {
+//		tenant := m.Header().Get("Tenant")
+//	}))
+
+type Header map[string][]string
+
+func (h Header) Get(key string) string      { return nats.Header(h).Get(key) }
+func (h Header) Values(key string) []string { return nats.Header(h).Values(key) }
+func (h Header) Set(key, value string)      { nats.Header(h).Set(key, value) }
+func (h Header) Add(key, value string)      { nats.Header(h).Add(key, value) }
+func (h Header) Del(key string)             { nats.Header(h).Del(key) }
+
+func Headers(h map[string][]string) PubOption {
+	return func(o *PubOptions) error {
+		o.Headers = addHeaders(o.Headers, h)
+		return nil
+	}
+}
+
+func RequestHeaders(h map[string][]string) ReqOption {
+	return func(o *ReqOptions) error {
+		o.Headers = addHeaders(o.Headers, h)
+		return nil
+	}
+}
+
+func addHeaders(dst Header, h map[string][]string) Header {
+	if dst == nil {
+		dst = Header{}
+	}
+	for k, vs := range h {
+		dst[k] = append(dst[k], vs...)
+	}
+	return dst
+}
+
+func setHeaders(m *nats.Msg, h Header) {
+	if len(h) == 0 {
+		return
+	}
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	for k, vs := range h {
+		m.Header[k] = append(m.Header[k], vs...)
+	}
+}
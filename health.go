@@ -0,0 +1,216 @@
+package natsv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Probes for Kubernetes and the like. Status is what the connection knows
+// without asking, Healthy goes to the server and back. HealthHandler serves
+// both kinds of probe:
+//
+//	http.Handle("/healthz/", HealthHandler(nc, HealthJetStream()))
+//
+//	livenessProbe:  {httpGet: {path: /healthz/livez, port: 8080}}
+//	readinessProbe: {httpGet: {path: /healthz/readyz, port: 8080}}
+//
+// Live fails only once the connection is closed, as it doesn't come back
+// from that, while disconnected the client is still reconnecting and a
+// restart wouldn't help. Ready needs a round trip to the server, every
+// subscription still valid, JetStream answering if asked for and the checks
+// added with HealthCheck. Either is 200 or 503 with the checks as JSON.
+
+// Used when the context has no deadline.
+const DefaultHealthTimeout = 2 * time.Second
+
+func (c *conn) Healthy(ctx context.Context) error {
+	if c.closed.Load() {
+		return errors.New("natsv2: connection is closed")
+	}
+	if s := c.nc.Status(); s != nats.CONNECTED {
+		return fmt.Errorf("natsv2: connection is %v", s)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultHealthTimeout)
+		defer cancel()
+	}
+	if err := c.nc.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("natsv2: ping: %w", err)
+	}
+	return nil
+}
+
+// invalidSubs names the subscriptions that ended while they weren't meant
+// to, e.g. on a permissions violation. Those with Max or a deadline end by
+// themselves.
+func (c *conn) invalidSubs() []string {
+	var names []string
+	c.subs.Range(func(k, v interface{}) bool {
+		if k.(*SubOptions).auto != nil {
+			return true
+		}
+		var sub *nats.Subscription
+		switch s := v.(type) {
+		case *subscription:
+			sub = s.current()
+		case *pullSubscription:
+			sub = s.sub
+		case *SubscriptionSet:
+			sub = s.invalid()
+		}
+		if sub != nil && !sub.IsValid() {
+			names = append(names, k.(*SubOptions).name())
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+type HealthOption func(*HealthOptions) error
+
+type HealthOptions struct {
+	// Ready needs JetStream to answer.
+	JetStream bool
+	Checks    map[string]func(context.Context) error
+	// For each probe, DefaultHealthTimeout if 0.
+	Timeout time.Duration
+}
+
+func HealthJetStream() HealthOption {
+	return func(o *HealthOptions) error {
+		o.JetStream = true
+		return nil
+	}
+}
+
+// HealthCheck adds check to ready, e.g. for the database next to NATS.
+func HealthCheck(name string, check func(context.Context) error) HealthOption {
+	return func(o *HealthOptions) error {
+		if name == "" || check == nil {
+			return errors.New("natsv2: health check needs a name and a func")
+		}
+		if o.Checks == nil {
+			o.Checks = map[string]func(context.Context) error{}
+		}
+		o.Checks[name] = check
+		return nil
+	}
+}
+
+func HealthTimeout(d time.Duration) HealthOption {
+	return func(o *HealthOptions) error {
+		o.Timeout = d
+		return nil
+	}
+}
+
+// HealthHandler serves the live probe on paths ending in /livez and the
+// ready one on /readyz, anything else is 404.
+func HealthHandler(c Connection, opts ...HealthOption) http.Handler {
+	hopts := &HealthOptions{Timeout: DefaultHealthTimeout}
+	for _, opt := range opts {
+		if err := opt(hopts); err != nil {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			})
+		}
+	}
+	if hopts.Timeout <= 0 {
+		hopts.Timeout = DefaultHealthTimeout
+	}
+	return &healthHandler{c: c, opts: hopts}
+}
+
+type healthHandler struct {
+	c    Connection
+	opts *HealthOptions
+}
+
+type healthReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var checks map[string]string
+	switch path.Base(req.URL.Path) {
+	case "livez":
+		checks = h.live()
+	case "readyz":
+		ctx, cancel := context.WithTimeout(req.Context(), h.opts.Timeout)
+		defer cancel()
+		checks = h.ready(ctx)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	report := healthReport{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, v := range checks {
+		if v != "ok" {
+			report.Status, status = "fail", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *healthHandler) live() map[string]string {
+	checks := map[string]string{"connection": "ok"}
+	if s := h.c.Status().State; s == nats.CLOSED {
+		checks["connection"] = s.String()
+	}
+	return checks
+}
+
+func (h *healthHandler) ready(ctx context.Context) map[string]string {
+	checks := map[string]string{"connection": healthResult(h.c.Healthy(ctx))}
+	var conns []*conn
+	switch c := h.c.(type) {
+	case *conn:
+		conns = []*conn{c}
+	case *Pool:
+		conns = c.conns
+	}
+	var invalid []string
+	for _, c := range conns {
+		invalid = append(invalid, c.invalidSubs()...)
+	}
+	checks["subscriptions"] = "ok"
+	if len(invalid) > 0 {
+		checks["subscriptions"] = "invalid: " + strings.Join(invalid, ", ")
+	}
+	if h.opts.JetStream {
+		var err error
+		if len(conns) > 0 {
+			_, err = conns[0].js.AccountInfo(nats.Context(ctx))
+		} else {
+			_, err = h.c.JetStream().StreamNames()
+		}
+		checks["jetstream"] = healthResult(err)
+	}
+	for name, check := range h.opts.Checks {
+		checks[name] = healthResult(check(ctx))
+	}
+	return checks
+}
+
+func healthResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
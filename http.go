@@ -0,0 +1,245 @@
+package natsv2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Handle serves an HTTP handler over NATS. The subject can be a pattern, see
+// router.go. When patterns overlap every subscription sees the message but
+// only the most specific route serves it. See openapi.go for the options.
+// Unsubscribing, or draining, takes the route away again, so the subject can
+// be handled by something else, and Drain and Shutdown drain it with the
+// other subscriptions.
+func (c *conn) Handle(subject string, handler HTTPHandlerFunc, opts ...HandleOption) (Subscription, error) {
+	rt, err := newRoute(subject, handler)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(&rt.opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.routes.add(rt); err != nil {
+		return nil, err
+	}
+	sopts := &SubOptions{subject: subject, pause: &pauseGate{}, route: rt}
+	sopts.ctx, sopts.cancel = context.WithCancel(c.hctx)
+	serve := c.interceptHandler(func(m *nats.Msg) {
+		_, params := c.routes.lookup(m.Subject)
+		serveHTTP(rt.handler, m, params)
+	})
+	s, err := c.subscribe(c.outSubject(rt.subject()), sopts, c.pausable(sopts, func(m *nats.Msg) {
+		c.unmap(m)
+		if best, _ := c.routes.lookup(m.Subject); best == rt {
+			defer c.recoverPanic(m.Subject, panicSite{})
+			serve(m)
+		}
+	}))
+	if err != nil {
+		sopts.cancel()
+		c.routes.remove(rt)
+		return nil, err
+	}
+	c.subs.Store(sopts, s)
+	return s, nil
+}
+
+// On the wire the request method and URL travel in headers next to the HTTP
+// headers, the body is the payload. The reply carries the status the same way.
+// Plain NATS requests without them still work, they show up as a GET, or a
+// POST when there is a payload, on the path for the subject.
+const (
+	HTTPMethodHeader = "Nats-HTTP-Method"
+	HTTPURLHeader    = "Nats-HTTP-URL"
+	HTTPStatusHeader = "Nats-HTTP-Status"
+)
+
+// serveHTTP returns the status sent back.
+func serveHTTP(handler HTTPHandlerFunc, m *nats.Msg, params map[string]string) int {
+	w := &responseWriter{header: make(http.Header)}
+	req, err := msgToRequest(m)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+	} else {
+		handler(w, withParams(req, params))
+	}
+	reply := w.msg()
+	if m.Reply != "" {
+		m.RespondMsg(reply)
+	}
+	return w.status
+}
+
+func msgToRequest(m *nats.Msg) (*http.Request, error) {
+	method := m.Header.Get(HTTPMethodHeader)
+	if method == "" {
+		method = http.MethodGet
+		if len(m.Data) > 0 {
+			method = http.MethodPost
+		}
+	}
+	target := m.Header.Get(HTTPURLHeader)
+	if target == "" {
+		target = (&url.URL{Path: subjectToPath(m.Subject)}).String()
+	}
+	req, err := http.NewRequestWithContext(correlatedBy(context.Background(), m), method, target, bytes.NewReader(m.Data))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range m.Header {
+		if k != HTTPMethodHeader && k != HTTPURLHeader {
+			for _, vv := range v {
+				req.Header.Add(k, vv)
+			}
+		}
+	}
+	req.Host = req.Header.Get("Host")
+	req.RequestURI = target
+	return req, nil
+}
+
+// RoundTrip sends req to the handler on subject and returns its response.
+// The request's context bounds the call, and a default Timeout, see
+// defaults.go, or DefaultRequestTimeout if it has no deadline.
+func (c *conn) RoundTrip(subject string, req *http.Request) (*http.Response, error) {
+	if err := checkSubject(subject, false); err != nil {
+		return nil, err
+	}
+	m, err := requestToMsg(c.outSubject(subject), req, req.URL.RequestURI())
+	if err != nil {
+		return nil, err
+	}
+	ropts, err := c.reqOptions([]ReqOption{Ctx(req.Context())})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := ropts.context()
+	defer cancel()
+	reply, err := c.requestMsg(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	return msgToResponse(reply, req)
+}
+
+func requestToMsg(subject string, req *http.Request, uri string) (*nats.Msg, error) {
+	m := &nats.Msg{Subject: subject, Header: nats.Header{}}
+	for k, v := range req.Header {
+		m.Header[k] = v
+	}
+	m.Header.Set(HTTPMethodHeader, req.Method)
+	m.Header.Set(HTTPURLHeader, uri)
+	if req.Host != "" {
+		m.Header.Set("Host", req.Host)
+	}
+	if req.Body != nil {
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		m.Data = data
+	}
+	return m, nil
+}
+
+func msgToResponse(m *nats.Msg, req *http.Request) (*http.Response, error) {
+	status := http.StatusOK
+	if s := m.Header.Get(HTTPStatusHeader); s != "" {
+		var err error
+		if status, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("natsv2: bad http status %q", s)
+		}
+	}
+	header := make(http.Header, len(m.Header))
+	for k, v := range m.Header {
+		if k != HTTPStatusHeader {
+			for _, vv := range v {
+				header.Add(k, vv)
+			}
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(m.Data)),
+		ContentLength: int64(len(m.Data)),
+		Request:       req,
+	}, nil
+}
+
+// HTTPTransport lets an http.Client talk to handlers served with Handle, the
+// URL path is turned back into the subject, "/api/users/22" is "api.users.22".
+//
+//	client := &http.Client{Transport: HTTPTransport(nc)}
+//	resp, err := client.Get("nats:///api/users/22")
+func HTTPTransport(c Connection) http.RoundTripper {
+	return transport{c}
+}
+
+type transport struct {
+	c Connection
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.c.RoundTrip(pathToSubject(req.URL.Path), req)
+}
+
+// The reconstructed request path, "api.users.22" becomes "/api/users/22".
+func subjectToPath(subject string) string {
+	return "/" + strings.ReplaceAll(subject, ".", "/")
+}
+
+func pathToSubject(path string) string {
+	return strings.ReplaceAll(strings.Trim(path, "/"), "/", ".")
+}
+
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) msg() *nats.Msg {
+	m := &nats.Msg{Header: nats.Header{}, Data: w.body.Bytes()}
+	for k, v := range w.header {
+		m.Header[k] = v
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	m.Header.Set(HTTPStatusHeader, strconv.Itoa(w.status))
+	return m
+}
@@ -0,0 +1,165 @@
+package natsv2
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// HTTPProxy is the other way around from Handle, an http.Handler that turns
+// HTTP requests into NATS requests, so NATS services can be reached from
+// plain HTTP clients. The path goes after subjectPrefix, GET /users/7 is a
+// request on "api.users.7" for the prefix "api", and the handler sees the
+// path of that subject, as with HTTPTransport.
+//
+//	http.ListenAndServe(":8080", natsv2.HTTPProxy(nc, "api", natsv2.Timeout(5*time.Second)))
+//
+// Replies are asked for in chunks and written out as they arrive. The status
+// is the one the handler set, or 200 for plain NATS replies. Service errors
+// become their code if it is an HTTP status and 500 otherwise, timeouts 504,
+// no responders 503 and anything else 502. opts apply to every request, the
+// HTTP request's context also bounds it.
+func HTTPProxy(c Connection, subjectPrefix string, opts ...ReqOption) http.Handler {
+	return &httpProxy{c: c, prefix: subjectPrefix, opts: opts}
+}
+
+type httpProxy struct {
+	c      Connection
+	prefix string
+	opts   []ReqOption
+}
+
+// Headers that are about the HTTP connection, not the request.
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	opts := make([]ReqOption, 0, len(p.opts)+1)
+	ropts, err := p.c.RequestOptions(append(append(opts, p.opts...), Ctx(req.Context()))...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	subject := p.prefix
+	if path := pathToSubject(req.URL.Path); path != "" {
+		subject += "." + path
+	}
+	if err := checkSubject(subject, false); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	out := req.Clone(req.Context())
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := out.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		out.Header.Set("X-Forwarded-For", ip)
+	}
+	u := *req.URL
+	u.Path, u.RawPath = subjectToPath(subject), ""
+	out.URL = &u
+
+	c, ok := p.c.(*conn)
+	if !ok {
+		p.roundTrip(w, subject, out)
+		return
+	}
+	m, err := requestToMsg(subject, out, out.URL.RequestURI())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	setHeaders(m, ropts.Headers)
+	max := ropts.MaxReplySize
+	if max == 0 {
+		max = DefaultMaxReplySize
+	}
+	ctx, cancel := ropts.context()
+	defer cancel()
+
+	wrote := false
+	err = c.requestChunks(ctx, m, max, func(r *nats.Msg) error {
+		if !wrote {
+			writeReplyHeader(w, subject, r)
+			wrote = true
+		}
+		if _, err := w.Write(r.Data); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		return
+	}
+	c.log.Debug("http proxy", "subject", subject, "error", err)
+	if wrote {
+		// Too late for a status, cut the response short.
+		panic(http.ErrAbortHandler)
+	}
+	http.Error(w, err.Error(), proxyStatus(err))
+}
+
+// roundTrip is for Connections other than ours, without streaming.
+func (p *httpProxy) roundTrip(w http.ResponseWriter, subject string, req *http.Request) {
+	resp, err := p.c.RoundTrip(subject, req)
+	if err != nil {
+		http.Error(w, err.Error(), proxyStatus(err))
+		return
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func writeReplyHeader(w http.ResponseWriter, subject string, r *nats.Msg) {
+	status := http.StatusOK
+	if s, err := strconv.Atoi(r.Header.Get(HTTPStatusHeader)); err == nil {
+		status = s
+	}
+	var rerr *RequestError
+	if errors.As(requestError(subject, r), &rerr) {
+		status = http.StatusInternalServerError
+		if code, err := strconv.Atoi(rerr.Code); err == nil && code >= 400 && code < 600 {
+			status = code
+		}
+		if len(r.Data) == 0 {
+			r.Data = []byte(rerr.Description + "\n")
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+	}
+	for k, v := range r.Header {
+		if k == HTTPStatusHeader || k == ChunkSeqHeader || k == ChunkLastHeader {
+			continue
+		}
+		w.Header()[k] = v
+	}
+	w.WriteHeader(status)
+}
+
+func proxyStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrNoResponders):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, nats.ErrMaxPayload):
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,22 @@
+package natsv2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// WithInboxPrefix puts all reply inboxes under prefix instead of _INBOX, so
+// tightly permissioned users only need to be allowed to subscribe to
+// "<prefix>.>". The shared reply inbox, see inboxmux.go, is made with the
+// client's NewInbox which honors the prefix.
+func WithInboxPrefix(prefix string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if err := checkSubject(prefix, false); err != nil || strings.HasSuffix(prefix, ".") {
+			return fmt.Errorf("natsv2: invalid inbox prefix %q", prefix)
+		}
+		o.NATS = append(o.NATS, nats.CustomInboxPrefix(prefix))
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package natsv2
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Requests share one wildcard inbox subscription, <inbox>.<id>.*, made on
+// the first request. Each request gets its own last token and the replies
+// to it are looked up by that, so a thousand requests in flight are still
+// one subscription and no interest changes on the server. Plain, chunked,
+// streamed and gathered requests all go through here.
+//
+// See examples/reqbench for the numbers against a subscription per request.
+
+type replyMux struct {
+	mu      sync.Mutex
+	prefix  string
+	sub     *nats.Subscription
+	waiting map[string]*replyInbox
+	ids     *nuid.NUID
+}
+
+// A replyInbox is one request's share of the mux, replies queue up on it
+// until read so a slow reader doesn't hold up the others.
+type replyInbox struct {
+	mux   *replyMux
+	token string
+	Reply string
+
+	mu     sync.Mutex
+	queue  []*nats.Msg
+	notify chan struct{}
+	// The connection's, no replies come after it is closed.
+	closed <-chan struct{}
+}
+
+func (c *conn) replyInbox() (*replyInbox, error) {
+	if c.closed.Load() {
+		return nil, nats.ErrConnectionClosed
+	}
+	r := &c.replies
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sub == nil || !r.sub.IsValid() {
+		r.prefix = c.nc.NewInbox() + "."
+		sub, err := c.nc.Subscribe(r.prefix+"*", r.deliver)
+		if err != nil {
+			return nil, err
+		}
+		sub.SetPendingLimits(-1, -1)
+		r.sub, r.waiting, r.ids = sub, map[string]*replyInbox{}, nuid.New()
+	}
+	in := &replyInbox{mux: r, token: r.ids.Next(), notify: make(chan struct{}, 1), closed: c.ctx.Done()}
+	in.Reply = r.prefix + in.token
+	r.waiting[in.token] = in
+	return in, nil
+}
+
+func (r *replyMux) deliver(m *nats.Msg) {
+	token := m.Subject[strings.LastIndexByte(m.Subject, '.')+1:]
+	r.mu.Lock()
+	in := r.waiting[token]
+	r.mu.Unlock()
+	if in == nil {
+		// Late, the request gave up on it.
+		return
+	}
+	in.mu.Lock()
+	in.queue = append(in.queue, m)
+	in.mu.Unlock()
+	select {
+	case in.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (in *replyInbox) next(ctx context.Context) (*nats.Msg, error) {
+	for {
+		in.mu.Lock()
+		if len(in.queue) > 0 {
+			m := in.queue[0]
+			in.queue[0] = nil
+			in.queue = in.queue[1:]
+			in.mu.Unlock()
+			return m, nil
+		}
+		in.mu.Unlock()
+		select {
+		case <-in.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-in.closed:
+			return nil, nats.ErrConnectionClosed
+		}
+	}
+}
+
+// The server's answer when nobody is subscribed.
+func noResponders(m *nats.Msg) bool {
+	return len(m.Data) == 0 && m.Header.Get("Status") == "503"
+}
+
+func (in *replyInbox) close() {
+	in.mux.mu.Lock()
+	delete(in.mux.waiting, in.token)
+	in.mux.mu.Unlock()
+}
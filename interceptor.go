@@ -0,0 +1,105 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Interceptors see every message going out and coming in, for things like
+// logging, metrics, tracing headers or injecting auth tokens, without
+// wrapping each call and handler. They run in the order registered, the first
+// one outermost, and call next to carry on, or not to stop the message.
+//
+// Publish interceptors get publishes, JetStream publishes, replies and the
+// request message of any Request, err is whatever sending (and for a request, getting
+// the reply) returned. Subscribe interceptors get messages for Subscribe,
+// Handle and Service handlers. They sit right on top of the low level client
+// and see its *nats.Msg, before it is wrapped in a Msg.
+//
+//	nc, _ := Connect(url, WithPublishInterceptor(func(ctx context.Context, m *nats.Msg, next PublishFunc) error {
+//		m.Header.Set("Authorization", token)
+//		return next(ctx, m)
+//	}))
+
+type PublishFunc func(ctx context.Context, m *nats.Msg) error
+
+type PublishInterceptor func(ctx context.Context, m *nats.Msg, next PublishFunc) error
+
+type SubscribeInterceptor func(m *nats.Msg, next nats.MsgHandler)
+
+func WithPublishInterceptor(pi PublishInterceptor) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.PublishInterceptors = append(o.PublishInterceptors, pi)
+		return nil
+	}
+}
+
+func WithSubscribeInterceptor(si SubscribeInterceptor) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.SubscribeInterceptors = append(o.SubscribeInterceptors, si)
+		return nil
+	}
+}
+
+// send runs m through the publish interceptors and then send. The header is
+// always there so interceptors can just set things on it.
+func (c *conn) send(ctx context.Context, m *nats.Msg, send PublishFunc) error {
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	c.correlate(ctx, m)
+	if err := c.validateOut(m); err != nil {
+		return err
+	}
+	if len(c.opts.PublishInterceptors) == 0 {
+		// Nothing to chain, save the closures.
+		if err := c.sign(send)(ctx, m); err != nil {
+			return err
+		}
+		c.metrics.Published(m.Subject, len(m.Data))
+		return nil
+	}
+	out := c.sign(send)
+	send = func(ctx context.Context, m *nats.Msg) error {
+		if err := out(ctx, m); err != nil {
+			return err
+		}
+		c.metrics.Published(m.Subject, len(m.Data))
+		return nil
+	}
+	for i := len(c.opts.PublishInterceptors) - 1; i >= 0; i-- {
+		pi, next := c.opts.PublishInterceptors[i], send
+		send = func(ctx context.Context, m *nats.Msg) error {
+			return pi(ctx, m, next)
+		}
+	}
+	return send(ctx, m)
+}
+
+func (c *conn) interceptHandler(handler nats.MsgHandler) nats.MsgHandler {
+	for i := len(c.opts.SubscribeInterceptors) - 1; i >= 0; i-- {
+		si, next := c.opts.SubscribeInterceptors[i], handler
+		handler = func(m *nats.Msg) {
+			si(m, next)
+		}
+	}
+	return c.countReceived(c.verify(handler))
+}
+
+// publish waits for room in the reconnect buffer if ctx can be cancelled,
+// see context.go.
+func (c *conn) publish(ctx context.Context, m *nats.Msg) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ok, err := c.spill(m); ok {
+		return err
+	}
+	err := c.nc.PublishMsg(m)
+	if errors.Is(err, nats.ErrReconnectBufExceeded) && ctx.Done() != nil {
+		return c.publishBuffered(ctx, m)
+	}
+	return c.tooLarge(m, err)
+}
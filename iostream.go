@@ -0,0 +1,427 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Byte streams over a subject, for code that speaks io.Reader and io.Writer,
+// file transfers, log shipping, piping a tar through. The Writer cuts what
+// is written into chunks numbered in Nats-Chunk-Seq and ends with a
+// Nats-Stream-End message, the Reader reads them from a subscription
+// without Handler or Channel:
+//
+//	sub, err := nc.Subscribe("backups.db1")
+//	r := NewReader(sub)
+//	io.Copy(file, r)
+//
+//	w, err := NewWriter(nc, "backups.db1")
+//	io.Copy(w, dump)
+//	err = w.Close()
+//
+// Every chunk carries the writer's inbox as its reply, some ask for an ack
+// in Nats-Chunk-Ack and the writer doesn't get more than Window ahead of
+// the unacked ones, so a slow reader slows the writer rather than dropping
+// chunks. The first chunk is sent again until a reader acks it, up to the
+// Timeout, and Close waits for the reader to have seen the end, so a nil
+// error from Close means everything arrived. Reader.Close tells the writer,
+// whose next Write fails with io.ErrClosedPipe.
+//
+// One writer to a subject at a time, chunks from two get mixed up and the
+// reader fails with ErrChunkMissing. Pick a subject per transfer.
+
+const (
+	ChunkAckHeader    = "Nats-Chunk-Ack"
+	StreamErrorHeader = "Nats-Stream-Error"
+
+	DefaultIOWindow  = 16
+	DefaultIOTimeout = 30 * time.Second
+)
+
+type IOOption func(*IOOptions) error
+
+type IOOptions struct {
+	// The connection's chunk size, see chunked.go, if 0.
+	ChunkSize int
+	// Chunks in flight, DefaultIOWindow if 0.
+	Window int
+	// How long to wait for an ack or the next chunk, DefaultIOTimeout if 0.
+	Timeout time.Duration
+}
+
+func IOChunkSize(bytes int) IOOption {
+	return func(o *IOOptions) error {
+		if bytes < 1 {
+			return errors.New("natsv2: chunk size must be at least 1")
+		}
+		o.ChunkSize = bytes
+		return nil
+	}
+}
+
+func IOWindow(chunks int) IOOption {
+	return func(o *IOOptions) error {
+		if chunks < 1 {
+			return errors.New("natsv2: window must be at least 1")
+		}
+		o.Window = chunks
+		return nil
+	}
+}
+
+func IOTimeout(d time.Duration) IOOption {
+	return func(o *IOOptions) error {
+		if d <= 0 {
+			return errors.New("natsv2: io timeout must be positive")
+		}
+		o.Timeout = d
+		return nil
+	}
+}
+
+func ioOptions(opts []IOOption) (*IOOptions, error) {
+	o := &IOOptions{Window: DefaultIOWindow, Timeout: DefaultIOTimeout}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+type Writer struct {
+	c       *conn
+	subject string
+	opts    *IOOptions
+	inbox   string
+	acks    chan *nats.Msg
+	sub     *nats.Subscription
+	buf     []byte
+	seq     uint64
+	acked   uint64
+	err     error
+	// Unix nanoseconds, 0 for none, see tunnel.go.
+	deadline atomic.Int64
+}
+
+func NewWriter(nc Connection, subject string, opts ...IOOption) (*Writer, error) {
+	if err := checkSubject(subject, false); err != nil {
+		return nil, err
+	}
+	c := connFor(nc, subject)
+	if c == nil {
+		return nil, fmt.Errorf("natsv2: no stream writer on a %T", nc)
+	}
+	o, err := ioOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return c.newWriter(c.outSubject(subject), o)
+}
+
+func (c *conn) newWriter(subject string, o *IOOptions) (*Writer, error) {
+	if o.ChunkSize == 0 {
+		o.ChunkSize = c.chunkSize()
+	}
+	w := &Writer{c: c, subject: subject, opts: o, inbox: c.nc.NewInbox(), acks: make(chan *nats.Msg, 64)}
+	var err error
+	if w.sub, err = c.nc.ChanSubscribe(w.inbox, w.acks); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		k := w.opts.ChunkSize - len(w.buf)
+		if k > len(p) {
+			k = len(p)
+		}
+		w.buf = append(w.buf, p[:k]...)
+		p, n = p[k:], n+k
+		if len(w.buf) == w.opts.ChunkSize {
+			if err := w.Flush(); err != nil {
+				return n - k, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush sends what is buffered, short of a chunk.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	m := nats.NewMsg(w.subject)
+	m.Data = w.buf
+	w.buf = nil
+	return w.send(m)
+}
+
+func (w *Writer) Close() error {
+	return w.CloseWithError(nil)
+}
+
+// CloseWithError ends the stream, the reader gets err from Read instead of
+// io.EOF if it isn't nil.
+func (w *Writer) CloseWithError(err error) error {
+	defer w.sub.Unsubscribe()
+	if err == nil {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	} else {
+		w.buf = nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+	end := nats.NewMsg(w.subject)
+	end.Header.Set(StreamEndHeader, "true")
+	if err != nil {
+		end.Header.Set(StreamErrorHeader, err.Error())
+	}
+	if serr := w.send(end); serr != nil {
+		return serr
+	}
+	if serr := w.wait(w.seq); serr != nil {
+		return serr
+	}
+	w.err = io.ErrClosedPipe
+	return nil
+}
+
+func (w *Writer) send(m *nats.Msg) error {
+	w.seq++
+	m.Reply = w.inbox
+	m.Header.Set(ChunkSeqHeader, strconv.FormatUint(w.seq, 10))
+	every := uint64(w.opts.Window / 2)
+	if every == 0 {
+		every = 1
+	}
+	ack := w.seq == 1 || w.seq%every == 0 || m.Header.Get(StreamEndHeader) != ""
+	if ack {
+		m.Header.Set(ChunkAckHeader, "true")
+	}
+	if err := w.c.nc.PublishMsg(m); err != nil {
+		return err
+	}
+	if w.seq == 1 {
+		return w.first(m)
+	}
+	if w.seq-w.acked >= uint64(w.opts.Window) {
+		return w.wait(w.seq - uint64(w.opts.Window) + 1)
+	}
+	return w.take()
+}
+
+// first sends the first chunk again until a reader acks it, it may not have
+// subscribed yet.
+func (w *Writer) first(m *nats.Msg) error {
+	deadline, set := w.expiry()
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for w.acked == 0 {
+		select {
+		case a := <-w.acks:
+			if err := w.ack(a); err != nil {
+				return err
+			}
+		case <-t.C:
+			if time.Now().After(deadline) {
+				if set {
+					return os.ErrDeadlineExceeded
+				}
+				w.err = fmt.Errorf("natsv2: no reader on %q: %w", w.subject, nats.ErrTimeout)
+				return w.err
+			}
+			if err := w.c.nc.PublishMsg(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wait is for seq to be acked.
+func (w *Writer) wait(seq uint64) error {
+	deadline, set := w.expiry()
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+	for w.acked < seq {
+		select {
+		case a := <-w.acks:
+			if err := w.ack(a); err != nil {
+				return err
+			}
+		case <-t.C:
+			if set {
+				return os.ErrDeadlineExceeded
+			}
+			w.err = fmt.Errorf("natsv2: reader on %q stopped acking: %w", w.subject, nats.ErrTimeout)
+			return w.err
+		}
+	}
+	return nil
+}
+
+// expiry is when waiting for acks gives up, at the timeout or the write
+// deadline if that is sooner. A missed deadline isn't the end of the stream.
+func (w *Writer) expiry() (time.Time, bool) {
+	at := time.Now().Add(w.opts.Timeout)
+	if d := w.deadline.Load(); d != 0 && d < at.UnixNano() {
+		return time.Unix(0, d), true
+	}
+	return at, false
+}
+
+// take handles the acks that are in without waiting.
+func (w *Writer) take() error {
+	for {
+		select {
+		case a := <-w.acks:
+			if err := w.ack(a); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (w *Writer) ack(a *nats.Msg) error {
+	if a.Header.Get(StreamEndHeader) != "" {
+		w.err = io.ErrClosedPipe
+		return w.err
+	}
+	if noResponders(a) {
+		if w.acked == 0 {
+			// The first chunk before anyone subscribed.
+			return nil
+		}
+		// The reader went away without a word.
+		w.err = io.ErrClosedPipe
+		return w.err
+	}
+	if seq, err := strconv.ParseUint(a.Header.Get(ChunkAckHeader), 10, 64); err == nil && seq > w.acked {
+		w.acked = seq
+	}
+	return nil
+}
+
+type Reader struct {
+	sub   Subscription
+	opts  *IOOptions
+	buf   []byte
+	seq   uint64
+	reply *Msg
+	err   error
+}
+
+// NewReader reads the stream from sub, which it unsubscribes on Close.
+func NewReader(sub Subscription, opts ...IOOption) *Reader {
+	o, err := ioOptions(opts)
+	return &Reader{sub: sub, opts: o, err: err}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.opts.Timeout)
+	defer cancel()
+	n, err := r.read(ctx, p)
+	if errors.Is(err, context.DeadlineExceeded) {
+		r.err = fmt.Errorf("natsv2: nothing from the writer in %v: %w", r.opts.Timeout, nats.ErrTimeout)
+		err = r.err
+	}
+	return n, err
+}
+
+// read waits for a chunk until ctx is done, which isn't the end of the
+// stream.
+func (r *Reader) read(ctx context.Context, p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if err := r.next(ctx); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) next(ctx context.Context) error {
+	m, err := r.sub.Next(ctx)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	seq, _ := strconv.ParseUint(m.Header().Get(ChunkSeqHeader), 10, 64)
+	switch {
+	case seq != 0 && seq <= r.seq:
+		// The first chunk again, our ack crossed it.
+		r.ack(m, false)
+		return nil
+	case seq != r.seq+1:
+		r.err = fmt.Errorf("%w: got %d, want %d", ErrChunkMissing, seq, r.seq+1)
+		r.ack(m, true)
+		return nil
+	}
+	r.seq, r.reply = seq, m
+	if m.Header().Get(StreamEndHeader) != "" {
+		r.err = io.EOF
+		if e := m.Header().Get(StreamErrorHeader); e != "" {
+			r.err = fmt.Errorf("natsv2: writer failed: %s", e)
+		}
+	}
+	r.buf = m.Data()
+	r.ack(m, false)
+	return nil
+}
+
+// ack tells the writer how far we are, when m asked for that or when
+// we stop reading.
+func (r *Reader) ack(m *Msg, stop bool) {
+	if m == nil || m.Reply() == "" || (!stop && m.Header().Get(ChunkAckHeader) == "") {
+		return
+	}
+	a := nats.NewMsg(m.Reply())
+	a.Header.Set(ChunkAckHeader, strconv.FormatUint(r.seq, 10))
+	if stop {
+		a.Header.Set(StreamEndHeader, "true")
+	}
+	if m.c != nil {
+		m.c.nc.PublishMsg(a)
+		return
+	}
+	m.respondNATS(a)
+}
+
+// Close stops reading, a writer still writing is told so.
+func (r *Reader) Close() error {
+	if r.err == nil {
+		r.ack(r.reply, true)
+		r.err = io.ErrClosedPipe
+	}
+	return r.sub.Unsubscribe()
+}
@@ -0,0 +1,88 @@
+//go:build go1.23
+
+package natsv2
+
+import (
+	"context"
+	"iter"
+)
+
+// The watches, History and listings as iterators, to range over:
+//
+//	for e, err := range KVWatch(ctx, kv, "settings.>") {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Breaking out of the loop stops the watcher, as ctx being done does. An
+// error only ever comes first, from setting up, and ends the loop. These
+// need Go 1.23, the channels they range over are in watchers.go.
+
+func KVWatch(ctx context.Context, kv KV, keys string) iter.Seq2[*KVEntry, error] {
+	return func(yield func(*KVEntry, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		updates, err := kv.Updates(ctx, keys)
+		ranged(updates, err, yield)
+	}
+}
+
+func KVKeys(ctx context.Context, kv KV) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		keys, err := kv.ListKeys(ctx)
+		ranged(keys, err, yield)
+	}
+}
+
+func KVHistory(kv KV, key string) iter.Seq2[*KVEntry, error] {
+	return func(yield func(*KVEntry, error) bool) {
+		entries, err := kv.History(key)
+		listed(entries, err, yield)
+	}
+}
+
+func ObjectWatch(ctx context.Context, store ObjectStore) iter.Seq2[*ObjectInfo, error] {
+	return func(yield func(*ObjectInfo, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		updates, err := store.Updates(ctx)
+		ranged(updates, err, yield)
+	}
+}
+
+func Objects(store ObjectStore) iter.Seq2[*ObjectInfo, error] {
+	return func(yield func(*ObjectInfo, error) bool) {
+		infos, err := store.List()
+		listed(infos, err, yield)
+	}
+}
+
+func ranged[T any](ch <-chan T, err error, yield func(T, error) bool) {
+	if err != nil {
+		var zero T
+		yield(zero, err)
+		return
+	}
+	for v := range ch {
+		if !yield(v, nil) {
+			return
+		}
+	}
+}
+
+func listed[T any](vs []T, err error, yield func(T, error) bool) {
+	if err != nil {
+		var zero T
+		yield(zero, err)
+		return
+	}
+	for _, v := range vs {
+		if !yield(v, nil) {
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package natsv2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A Stream bound to a JetStream stream with JetStreamStream publishes through
+// JetStream. Publish waits for the server's ack, PublishAsync hands back the
+// ack future. Every publish expects the named stream, so a subject that ends
+// up stored somewhere else fails instead of quietly going there, and Stream
+// already checks this up front.
+//
+//	orders := nc.Stream("orders.new", JetStreamStream("MY_ORDERS"))
+//	orders.Publish(order, WithMsgID(order.ID))
+//
+// JetStream specific publish options. The server drops a message whose
+// Nats-Msg-Id it has already seen within the stream's duplicate window, so
+// retried publishes are stored once. There is nothing to dedupe against on
+// core NATS, so using these there is an error rather than silently ignored.
+//
+// For effectively once, publish with Idempotent so every message has an id,
+// and have consumers AckSync once done, see msg.go:
+//
+//	orders := nc.Stream("orders.new", JetStreamStream("MY_ORDERS"), Idempotent(func(v interface{}) string { return v.(*Order).ID }))
+//
+// With a nil func the id is a hash of the subject and payload, so only a
+// payload that is the same every retry dedupes.
+
+const MsgIDHeader = nats.MsgIdHdr
+
+var (
+	ErrJetStreamRequired = errors.New("natsv2: option only valid for JetStream publishes")
+	ErrStreamMismatch    = errors.New("natsv2: subject is not stored in the expected stream")
+)
+
+func JetStreamStream(name string) StreamOption {
+	return func(o *StreamOptions) error {
+		if name == "" {
+			return errors.New("natsv2: empty stream name")
+		}
+		o.JetStream = name
+		return nil
+	}
+}
+
+// The last of WithMsgID, WithMsgIDFunc and WithContentMsgID wins.
+func WithMsgID(id string) PubOption {
+	return func(o *PubOptions) error {
+		if id == "" {
+			return errors.New("natsv2: empty message id")
+		}
+		o.MsgID, o.MsgIDFunc, o.ContentMsgID = id, nil, false
+		return nil
+	}
+}
+
+// WithMsgIDFunc derives the message id from the value being published.
+func WithMsgIDFunc(fn func(v interface{}) string) PubOption {
+	return func(o *PubOptions) error {
+		o.MsgID, o.MsgIDFunc, o.ContentMsgID = "", fn, false
+		return nil
+	}
+}
+
+// WithContentMsgID uses a hash of the subject and payload as the id.
+func WithContentMsgID() PubOption {
+	return func(o *PubOptions) error {
+		o.MsgID, o.MsgIDFunc, o.ContentMsgID = "", nil, true
+		return nil
+	}
+}
+
+// Idempotent gives every JetStream publish on the stream a message id from
+// fn, or from the content if fn is nil. Publish options still win.
+func Idempotent(fn func(v interface{}) string) StreamOption {
+	return func(o *StreamOptions) error {
+		if fn == nil {
+			o.MsgID = WithContentMsgID()
+		} else {
+			o.MsgID = WithMsgIDFunc(fn)
+		}
+		return nil
+	}
+}
+
+func (o *PubOptions) jetStreamOnly() bool {
+	return o.MsgID != "" || o.MsgIDFunc != nil || o.ContentMsgID
+}
+
+func (o *PubOptions) msgID(m *nats.Msg, v interface{}) string {
+	switch {
+	case o.MsgIDFunc != nil:
+		return o.MsgIDFunc(v)
+	case o.ContentMsgID:
+		h := sha256.New()
+		h.Write([]byte(m.Subject))
+		h.Write([]byte{0})
+		h.Write(m.Data)
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	return o.MsgID
+}
+
+func (c *conn) checkJetStream(name, subject string) error {
+	got, err := c.js.StreamNameBySubject(subject)
+	if errors.Is(err, nats.ErrNoMatchingStream) {
+		return fmt.Errorf("%w: no stream for %q, want %q", ErrStreamMismatch, subject, name)
+	}
+	if err != nil {
+		return err
+	}
+	if got != name {
+		return fmt.Errorf("%w: %q is in %q, want %q", ErrStreamMismatch, subject, got, name)
+	}
+	return nil
+}
+
+func (c *conn) jsPubOpts(name string, m *nats.Msg, v interface{}, opts []PubOption) ([]nats.PubOpt, error) {
+	popts := &PubOptions{}
+	for _, opt := range opts {
+		if err := opt(popts); err != nil {
+			return nil, err
+		}
+	}
+	if popts.Delay > 0 {
+		return nil, errors.New("natsv2: Delay is for core NATS publishes, the scheduler's go to the stream when due")
+	}
+	setHeaders(m, popts.Headers)
+	popts.setExpires(m, false)
+	jopts := []nats.PubOpt{nats.ExpectStream(name)}
+	if id := popts.msgID(m, v); id != "" {
+		jopts = append(jopts, nats.MsgId(id))
+	}
+	return jopts, nil
+}
+
+// nats.go's default wait for a JetStream ack.
+const jetStreamAckWait = 5 * time.Second
+
+// publishJetStream publishes and waits for the ack. A duplicate is not an
+// error, the ack says so.
+func (c *conn) publishJetStream(ctx context.Context, name string, m *nats.Msg, v interface{}, opts []PubOption) (*nats.PubAck, error) {
+	jopts, err := c.jsPubOpts(name, m, v, opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var ack *nats.PubAck
+	err = c.send(ctx, m, func(ctx context.Context, m *nats.Msg) error {
+		// Without a deadline the JetStream default wait applies, still
+		// cut short if ctx is cancelled.
+		if _, ok := ctx.Deadline(); !ok && ctx.Done() != nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, jetStreamAckWait)
+			defer cancel()
+		}
+		if _, ok := ctx.Deadline(); ok {
+			jopts = append(jopts, nats.Context(ctx))
+		}
+		var err error
+		ack, err = c.js.PublishMsg(m, jopts...)
+		return err
+	})
+	return ack, err
+}
+
+func (c *conn) publishJetStreamAsync(name string, m *nats.Msg, v interface{}, opts []PubOption) (nats.PubAckFuture, error) {
+	jopts, err := c.jsPubOpts(name, m, v, opts)
+	if err != nil {
+		return nil, err
+	}
+	if c.limiter != nil {
+		if err := c.limiter.wait(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	var future nats.PubAckFuture
+	err = c.send(context.Background(), m, func(ctx context.Context, m *nats.Msg) error {
+		var err error
+		future, err = c.js.PublishMsgAsync(m, jopts...)
+		return err
+	})
+	return future, err
+}
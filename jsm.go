@@ -0,0 +1,263 @@
+package natsv2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Managing JetStream streams and consumers, so an application can set up
+// what it needs instead of relying on someone having run the CLI first.
+// Configs are the client's, retention, replicas, storage and the rest are as
+// documented there.
+//
+//	jsm := nc.JetStream()
+//	jsm.DeclareStream(nats.StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}, Replicas: 3, Storage: nats.FileStorage})
+//	jsm.PurgeStream("ORDERS", PurgeSubject("orders.test.>"), PurgeKeep(10))
+//	jsm.DeclareConsumer("ORDERS", nats.ConsumerConfig{Durable: "billing", AckWait: time.Minute, MaxDeliver: 5,
+//		BackOff: []time.Duration{time.Second, 10 * time.Second}, FilterSubjects: []string{"orders.new", "orders.paid"}})
+//
+// ConsumerInfo has NumPending, NumAckPending and NumRedelivered for tooling.
+//
+// DeclareStream as a StreamOption does the same and binds the Stream to it.
+type JetStreamManager interface {
+	// DeclareStream creates the stream, or updates it if it is there.
+	DeclareStream(cfg nats.StreamConfig) (*nats.StreamInfo, error)
+	AddStream(cfg nats.StreamConfig) (*nats.StreamInfo, error)
+	UpdateStream(cfg nats.StreamConfig) (*nats.StreamInfo, error)
+	DeleteStream(name string) error
+	PurgeStream(name string, opts ...PurgeOption) error
+	StreamInfo(name string) (*nats.StreamInfo, error)
+	StreamNames() ([]string, error)
+
+	// DeclareConsumer creates the consumer, or updates it if it is there.
+	DeclareConsumer(stream string, cfg nats.ConsumerConfig) (*nats.ConsumerInfo, error)
+	DeleteConsumer(stream, name string) error
+	ConsumerInfo(stream, name string) (*nats.ConsumerInfo, error)
+	ConsumerNames(stream string) ([]string, error)
+	// PauseConsumer stops deliveries until the time given, needs
+	// nats-server 2.11 or later.
+	PauseConsumer(stream, name string, until time.Time) (*ConsumerPause, error)
+	ResumeConsumer(stream, name string) (*ConsumerPause, error)
+}
+
+var (
+	ErrStreamNotFound   = nats.ErrStreamNotFound
+	ErrConsumerNotFound = nats.ErrConsumerNotFound
+)
+
+type ConsumerPause struct {
+	Paused         bool          `json:"paused"`
+	PauseUntil     time.Time     `json:"pause_until"`
+	PauseRemaining time.Duration `json:"pause_remaining"`
+}
+
+type PurgeOption func(*nats.StreamPurgeRequest) error
+
+// PurgeSubject only purges messages on subject, wildcards work.
+func PurgeSubject(subject string) PurgeOption {
+	return func(r *nats.StreamPurgeRequest) error {
+		if err := checkSubject(subject, true); err != nil {
+			return err
+		}
+		r.Subject = subject
+		return nil
+	}
+}
+
+// PurgeUpTo purges the messages before seq.
+func PurgeUpTo(seq uint64) PurgeOption {
+	return func(r *nats.StreamPurgeRequest) error {
+		if r.Keep > 0 {
+			return errors.New("natsv2: purge takes a sequence or a number to keep, not both")
+		}
+		r.Sequence = seq
+		return nil
+	}
+}
+
+// PurgeKeep keeps the last n messages.
+func PurgeKeep(n uint64) PurgeOption {
+	return func(r *nats.StreamPurgeRequest) error {
+		if r.Sequence > 0 {
+			return errors.New("natsv2: purge takes a sequence or a number to keep, not both")
+		}
+		r.Keep = n
+		return nil
+	}
+}
+
+// DeclareStream declares the stream when the Stream is made and publishes
+// to it, as JetStreamStream would.
+func DeclareStream(cfg nats.StreamConfig) StreamOption {
+	return func(o *StreamOptions) error {
+		if cfg.Name == "" {
+			return errors.New("natsv2: empty stream name")
+		}
+		o.JetStream = cfg.Name
+		o.Declare = &cfg
+		return nil
+	}
+}
+
+type jetStreamManager struct {
+	c *conn
+}
+
+func (c *conn) JetStream() JetStreamManager {
+	return jetStreamManager{c}
+}
+
+func (m jetStreamManager) DeclareStream(cfg nats.StreamConfig) (*nats.StreamInfo, error) {
+	info, err := m.AddStream(cfg)
+	if errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		if info, err = m.UpdateStream(cfg); err == nil {
+			m.c.streams.Store(cfg.Name, RegisteredStream{cfg, time.Now().UTC()})
+		}
+	}
+	return info, err
+}
+
+func (m jetStreamManager) AddStream(cfg nats.StreamConfig) (*nats.StreamInfo, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("natsv2: empty stream name")
+	}
+	info, err := m.c.js.AddStream(&cfg)
+	if err == nil {
+		m.c.log.Info("stream added", "stream", cfg.Name)
+		m.c.streams.Store(cfg.Name, RegisteredStream{cfg, time.Now().UTC()})
+	}
+	return info, err
+}
+
+func (m jetStreamManager) UpdateStream(cfg nats.StreamConfig) (*nats.StreamInfo, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("natsv2: empty stream name")
+	}
+	return m.c.js.UpdateStream(&cfg)
+}
+
+func (m jetStreamManager) DeleteStream(name string) error {
+	if err := m.c.js.DeleteStream(name); err != nil {
+		return err
+	}
+	m.c.log.Info("stream deleted", "stream", name)
+	m.c.streams.Delete(name)
+	m.c.consumers.Range(func(k, _ interface{}) bool {
+		if k.(consumerKey).stream == name {
+			m.c.consumers.Delete(k)
+		}
+		return true
+	})
+	return nil
+}
+
+func (m jetStreamManager) PurgeStream(name string, opts ...PurgeOption) error {
+	req := &nats.StreamPurgeRequest{}
+	for _, opt := range opts {
+		if err := opt(req); err != nil {
+			return err
+		}
+	}
+	return m.c.js.PurgeStream(name, req)
+}
+
+func (m jetStreamManager) StreamInfo(name string) (*nats.StreamInfo, error) {
+	return m.c.js.StreamInfo(name)
+}
+
+func (m jetStreamManager) StreamNames() ([]string, error) {
+	var names []string
+	for name := range m.c.js.StreamNames() {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (m jetStreamManager) DeclareConsumer(stream string, cfg nats.ConsumerConfig) (*nats.ConsumerInfo, error) {
+	name := cfg.Durable
+	if name == "" {
+		name = cfg.Name
+	}
+	if name == "" {
+		return nil, errors.New("natsv2: declared consumers need a durable name")
+	}
+	if cfg.Durable == "" {
+		cfg.Durable = name
+	}
+	info, err := m.c.js.AddConsumer(stream, &cfg)
+	if errors.Is(err, nats.ErrConsumerNameAlreadyInUse) {
+		info, err = m.c.js.UpdateConsumer(stream, &cfg)
+	}
+	if err == nil {
+		m.c.consumers.Store(consumerKey{stream, name}, RegisteredConsumer{stream, cfg, time.Now().UTC()})
+	}
+	return info, err
+}
+
+func (m jetStreamManager) DeleteConsumer(stream, name string) error {
+	if err := m.c.js.DeleteConsumer(stream, name); err != nil {
+		return err
+	}
+	m.c.consumers.Delete(consumerKey{stream, name})
+	return nil
+}
+
+func (m jetStreamManager) ConsumerInfo(stream, name string) (*nats.ConsumerInfo, error) {
+	return m.c.js.ConsumerInfo(stream, name)
+}
+
+func (m jetStreamManager) ConsumerNames(stream string) ([]string, error) {
+	var names []string
+	for name := range m.c.js.ConsumerNames(stream) {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (m jetStreamManager) PauseConsumer(stream, name string, until time.Time) (*ConsumerPause, error) {
+	return m.pause(stream, name, until)
+}
+
+// ResumeConsumer is a pause until the zero time.
+func (m jetStreamManager) ResumeConsumer(stream, name string) (*ConsumerPause, error) {
+	return m.pause(stream, name, time.Time{})
+}
+
+// pause is the raw API call, the client we're on predates it.
+func (m jetStreamManager) pause(stream, name string, until time.Time) (*ConsumerPause, error) {
+	var req struct {
+		PauseUntil *time.Time `json:"pause_until,omitempty"`
+	}
+	if !until.IsZero() {
+		req.PauseUntil = &until
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := m.c.nc.Request("$JS.API.CONSUMER.PAUSE."+stream+"."+name, data, DefaultRequestTimeout)
+	if errors.Is(err, nats.ErrTimeout) || errors.Is(err, nats.ErrNoResponders) {
+		return nil, fmt.Errorf("natsv2: pausing consumers needs nats-server 2.11 or later: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		ConsumerPause
+		Error *nats.APIError `json:"error"`
+	}
+	if err := json.Unmarshal(reply.Data, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		if resp.Error.ErrorCode == nats.JSErrCodeConsumerNotFound {
+			return nil, ErrConsumerNotFound
+		}
+		return nil, resp.Error
+	}
+	return &resp.ConsumerPause, nil
+}
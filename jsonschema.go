@@ -0,0 +1,485 @@
+package natsv2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONSchema is a Validator for JSON payloads, without pulling in a schema
+// library. It knows the keywords contracts are mostly written in: type,
+// enum, const, properties, required, additionalProperties, items, minItems,
+// maxItems, uniqueItems, minLength, maxLength, pattern, minimum, maximum,
+// exclusiveMinimum, exclusiveMaximum, multipleOf, allOf, anyOf, oneOf, not
+// and $ref to the schema's own definitions or $defs. Other keywords, format
+// among them, are ignored. Errors say where in the payload, /items/2/sku:
+// missing.
+
+// JSONSchema compiles schema, errors in it come back now rather than on the
+// first message.
+func JSONSchema(schema []byte) (Validator, error) {
+	var doc interface{}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		return nil, fmt.Errorf("natsv2: json schema: %w", err)
+	}
+	sc := &schemaCompiler{doc: doc, refs: map[string]*jsonSchema{}}
+	root, err := sc.compile(doc)
+	if err != nil {
+		return nil, fmt.Errorf("natsv2: json schema: %w", err)
+	}
+	return func(subject string, data []byte) error {
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return fmt.Errorf("not JSON: %w", err)
+		}
+		return root.validate(v, "")
+	}, nil
+}
+
+type jsonSchema struct {
+	// For true and false as schemas.
+	always *bool
+
+	types    []string
+	enum     []interface{}
+	cnst     interface{}
+	hasConst bool // cnst can be null
+
+	properties map[string]*jsonSchema
+	required   []string
+	additional *jsonSchema
+
+	items       *jsonSchema
+	minItems    int
+	maxItems    int
+	uniqueItems bool
+	minLength   int
+	maxLength   int
+	pattern     *regexp.Regexp
+
+	minimum, maximum           *float64
+	exclusiveMin, exclusiveMax *float64
+	multipleOf                 float64
+
+	allOf, anyOf, oneOf []*jsonSchema
+	not                 *jsonSchema
+	ref                 *jsonSchema
+}
+
+type schemaCompiler struct {
+	doc  interface{}
+	refs map[string]*jsonSchema
+}
+
+func (sc *schemaCompiler) compile(v interface{}) (*jsonSchema, error) {
+	s := &jsonSchema{minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+	if b, ok := v.(bool); ok {
+		s.always = &b
+		return s, nil
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema is a %T, not an object", v)
+	}
+	var err error
+	for k, kv := range obj {
+		switch k {
+		case "type":
+			switch t := kv.(type) {
+			case string:
+				s.types = []string{t}
+			case []interface{}:
+				for _, tt := range t {
+					name, ok := tt.(string)
+					if !ok {
+						return nil, fmt.Errorf("type %v", tt)
+					}
+					s.types = append(s.types, name)
+				}
+			default:
+				return nil, fmt.Errorf("type %v", kv)
+			}
+		case "enum":
+			if s.enum, ok = kv.([]interface{}); !ok {
+				return nil, fmt.Errorf("enum is a %T, not an array", kv)
+			}
+		case "const":
+			s.cnst, s.hasConst = kv, true
+		case "properties":
+			props, ok := kv.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("properties is a %T, not an object", kv)
+			}
+			s.properties = map[string]*jsonSchema{}
+			for name, ps := range props {
+				if s.properties[name], err = sc.compile(ps); err != nil {
+					return nil, fmt.Errorf("properties/%s: %w", name, err)
+				}
+			}
+		case "required":
+			req, ok := kv.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("required is a %T, not an array", kv)
+			}
+			for _, r := range req {
+				name, ok := r.(string)
+				if !ok {
+					return nil, fmt.Errorf("required %v", r)
+				}
+				s.required = append(s.required, name)
+			}
+		case "additionalProperties":
+			if s.additional, err = sc.compile(kv); err != nil {
+				return nil, fmt.Errorf("additionalProperties: %w", err)
+			}
+		case "items":
+			if s.items, err = sc.compile(kv); err != nil {
+				return nil, fmt.Errorf("items: %w", err)
+			}
+		case "minItems", "maxItems", "minLength", "maxLength":
+			n, ok := kv.(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("%s %v", k, kv)
+			}
+			switch k {
+			case "minItems":
+				s.minItems = int(n)
+			case "maxItems":
+				s.maxItems = int(n)
+			case "minLength":
+				s.minLength = int(n)
+			default:
+				s.maxLength = int(n)
+			}
+		case "uniqueItems":
+			s.uniqueItems, _ = kv.(bool)
+		case "pattern":
+			p, ok := kv.(string)
+			if !ok {
+				return nil, fmt.Errorf("pattern %v", kv)
+			}
+			if s.pattern, err = regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("pattern: %w", err)
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			n, ok := kv.(float64)
+			if !ok {
+				// Draft 4's boolean exclusiveMinimum isn't supported.
+				return nil, fmt.Errorf("%s %v", k, kv)
+			}
+			switch k {
+			case "minimum":
+				s.minimum = &n
+			case "maximum":
+				s.maximum = &n
+			case "exclusiveMinimum":
+				s.exclusiveMin = &n
+			default:
+				s.exclusiveMax = &n
+			}
+		case "multipleOf":
+			n, ok := kv.(float64)
+			if !ok || n <= 0 {
+				return nil, fmt.Errorf("multipleOf %v", kv)
+			}
+			s.multipleOf = n
+		case "allOf", "anyOf", "oneOf":
+			list, ok := kv.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("%s needs schemas", k)
+			}
+			var subs []*jsonSchema
+			for i, ss := range list {
+				sub, err := sc.compile(ss)
+				if err != nil {
+					return nil, fmt.Errorf("%s/%d: %w", k, i, err)
+				}
+				subs = append(subs, sub)
+			}
+			switch k {
+			case "allOf":
+				s.allOf = subs
+			case "anyOf":
+				s.anyOf = subs
+			default:
+				s.oneOf = subs
+			}
+		case "not":
+			if s.not, err = sc.compile(kv); err != nil {
+				return nil, fmt.Errorf("not: %w", err)
+			}
+		case "$ref":
+			name, ok := kv.(string)
+			if !ok {
+				return nil, fmt.Errorf("$ref %v", kv)
+			}
+			if s.ref, err = sc.resolve(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, nil
+}
+
+// resolve compiles a local $ref once, recursive schemas refer back to the one
+// being compiled.
+func (sc *schemaCompiler) resolve(ref string) (*jsonSchema, error) {
+	if s, ok := sc.refs[ref]; ok {
+		return s, nil
+	}
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("$ref %q: only refs into the schema itself are supported", ref)
+	}
+	v := sc.doc
+	for _, tok := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if tok == "" {
+			continue
+		}
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref %q not found", ref)
+		}
+		if v, ok = obj[tok]; !ok {
+			return nil, fmt.Errorf("$ref %q not found", ref)
+		}
+	}
+	s := &jsonSchema{}
+	sc.refs[ref] = s
+	compiled, err := sc.compile(v)
+	if err != nil {
+		return nil, fmt.Errorf("$ref %q: %w", ref, err)
+	}
+	*s = *compiled
+	return s, nil
+}
+
+func (s *jsonSchema) validate(v interface{}, path string) error {
+	where := path
+	if where == "" {
+		where = "/"
+	}
+	if s.always != nil {
+		if !*s.always {
+			return fmt.Errorf("%s: not allowed", where)
+		}
+		return nil
+	}
+	if s.ref != nil {
+		if err := s.ref.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if len(s.types) > 0 {
+		ok := false
+		for _, t := range s.types {
+			if isJSONType(v, t) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: is %s, want %s", where, jsonTypeOf(v), strings.Join(s.types, " or "))
+		}
+	}
+	if s.enum != nil {
+		ok := false
+		for _, e := range s.enum {
+			if jsonEqual(v, e) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%s: %s is not one of the allowed values", where, jsonText(v))
+		}
+	}
+	if s.hasConst && !jsonEqual(v, s.cnst) {
+		return fmt.Errorf("%s: must be %s", where, jsonText(s.cnst))
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s/%s: missing", path, name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		// So the error is the same one each time.
+		sort.Strings(names)
+		for _, name := range names {
+			ps, ok := s.properties[name]
+			if !ok {
+				ps = s.additional
+			}
+			if ps != nil {
+				if err := ps.validate(v[name], path+"/"+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if s.minItems >= 0 && len(v) < s.minItems {
+			return fmt.Errorf("%s: %d items, want at least %d", where, len(v), s.minItems)
+		}
+		if s.maxItems >= 0 && len(v) > s.maxItems {
+			return fmt.Errorf("%s: %d items, want at most %d", where, len(v), s.maxItems)
+		}
+		if s.uniqueItems {
+			for i := range v {
+				for j := i + 1; j < len(v); j++ {
+					if jsonEqual(v[i], v[j]) {
+						return fmt.Errorf("%s: items %d and %d are the same", where, i, j)
+					}
+				}
+			}
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(item, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength >= 0 && n < s.minLength {
+			return fmt.Errorf("%s: %d characters, want at least %d", where, n, s.minLength)
+		}
+		if s.maxLength >= 0 && n > s.maxLength {
+			return fmt.Errorf("%s: %d characters, want at most %d", where, n, s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s: %q doesn't match %s", where, v, s.pattern)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		switch {
+		case s.minimum != nil && f < *s.minimum:
+			return fmt.Errorf("%s: %v is less than %v", where, v, *s.minimum)
+		case s.maximum != nil && f > *s.maximum:
+			return fmt.Errorf("%s: %v is more than %v", where, v, *s.maximum)
+		case s.exclusiveMin != nil && f <= *s.exclusiveMin:
+			return fmt.Errorf("%s: %v is not more than %v", where, v, *s.exclusiveMin)
+		case s.exclusiveMax != nil && f >= *s.exclusiveMax:
+			return fmt.Errorf("%s: %v is not less than %v", where, v, *s.exclusiveMax)
+		case s.multipleOf > 0 && !isMultiple(f, s.multipleOf):
+			return fmt.Errorf("%s: %v is not a multiple of %v", where, v, s.multipleOf)
+		}
+	}
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 {
+		var first error
+		for _, sub := range s.anyOf {
+			if first = sub.validate(v, path); first == nil {
+				break
+			}
+		}
+		if first != nil {
+			return fmt.Errorf("%s: matches none of anyOf, e.g. %v", where, first)
+		}
+	}
+	if len(s.oneOf) > 0 {
+		n := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("%s: matches %d of oneOf, want 1", where, n)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fmt.Errorf("%s: matches what it must not", where)
+	}
+	return nil
+}
+
+func isJSONType(v interface{}, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		if _, err := n.Int64(); err == nil {
+			return true
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	}
+	return jsonTypeOf(v) == t
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// jsonEqual compares a payload's values, with json.Number, to the schema's,
+// with float64.
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(jsonNormal(a), jsonNormal(b))
+}
+
+func jsonNormal(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = jsonNormal(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			out[k] = jsonNormal(e)
+		}
+		return out
+	}
+	return v
+}
+
+func jsonText(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func isMultiple(f, of float64) bool {
+	q := f / of
+	return math.Abs(q-math.Round(q)) < 1e-9
+}
@@ -0,0 +1,117 @@
+package natsv2
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrMalformedJSONStream = errors.New("natsv2: malformed json stream")
+
+// RequestStreamJSON sends a request and decodes the reply as a stream of
+// JSON values, sending each one to out as soon as it is parsed. The reply can
+// be a single JSON array, one element per value, or newline delimited JSON.
+// Anything starting with '[' is taken to be an array. out is always closed
+// when this returns, and the error covers both the request and bad data.
+//
+// The request is Streamed, so a responder using ReplyStream can send the data
+// in as many messages as it likes and we decode as they arrive. Timeouts are
+// as for Streamed.
+func RequestStreamJSON[T any](c Connection, subject string, msg interface{}, out chan<- T, opts ...ReqOption) error {
+	defer close(out)
+
+	ropts := &ReqOptions{}
+	for _, opt := range opts {
+		if err := opt(ropts); err != nil {
+			return err
+		}
+	}
+	ctx := ropts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	pr, pw := io.Pipe()
+	decoded := make(chan error, 1)
+	go func() {
+		err := decodeJSONStream(ctx, pr, out)
+		// Unblocks the writer if we stopped early.
+		pr.CloseWithError(err)
+		decoded <- err
+	}()
+	_, err := c.Request(subject, msg, append(opts, Streamed(func(m *Msg) {
+		pw.Write(m.Data())
+	}))...)
+	pw.CloseWithError(err)
+	if derr := <-decoded; err == nil {
+		err = derr
+	}
+	return err
+}
+
+func decodeJSONStream[T any](ctx context.Context, r io.Reader, out chan<- T) error {
+	br := bufio.NewReader(r)
+	array := false
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n' {
+			br.ReadByte()
+			continue
+		}
+		array = b[0] == '['
+		break
+	}
+
+	send := func(v T) error {
+		select {
+		case out <- v:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	dec := json.NewDecoder(br)
+	if !array {
+		for {
+			var v T
+			if err := dec.Decode(&v); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("%w: %v", ErrMalformedJSONStream, err)
+			}
+			if err := send(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedJSONStream, err)
+	}
+	for dec.More() {
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			return fmt.Errorf("%w: %v", ErrMalformedJSONStream, err)
+		}
+		if err := send(v); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedJSONStream, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("%w: trailing data after array", ErrMalformedJSONStream)
+	}
+	return nil
+}
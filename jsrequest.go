@@ -0,0 +1,210 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Requests that are stored before anyone handles them. With
+// JetStreamRequest the request is published to a stream, which must store
+// its subject, instead of to whoever is listening, so it waits there for a
+// worker that isn't up yet, and a worker that dies before replying gets it
+// again:
+//
+//	reply, err := nc.Request("orders.new", order, JetStreamRequest("NEW_ORDERS"), Timeout(time.Minute))
+//
+// Workers are ordinary JetStream consumers on the stream; Respond on the
+// message goes to the requester, not to JetStream, so ack once the reply is
+// out, AutoAck does:
+//
+//	nc.Stream("orders.new", JetStreamStream("NEW_ORDERS")).Subscribe(
+//		JetStreamConsumer(ConsumerOptions{Durable: "orders"}), AutoAck(),
+//		Handler(func(m *Msg) { m.Respond(place(m)) }))
+//
+// The reply goes to an inbox of the requester's, unless StoreReplies says to
+// store it too, on the stream's subject prefix.<request id>. Then a
+// requester that restarted while it was waiting gets it with ResumeRequest,
+// as long as it kept the id, so give one with RequestID:
+//
+//	opts := []ReqOption{JetStreamRequest("NEW_ORDERS"), StoreReplies("ORDER_REPLIES", "orders.replies"), RequestID(order.ID)}
+//	reply, err := nc.Request("orders.new", order, opts...)
+//	// later, after a restart
+//	reply, err = ResumeRequest(nc, order.ID, opts...)
+//
+// The id is the request's Nats-Msg-Id too, so sending it again within the
+// stream's duplicate window doesn't store it twice, retries with Retry
+// don't, and the reply's, so a redelivered request replied to again doesn't
+// store two replies. Workers see it in RequestIDHeader. Timeout and Ctx are
+// for the wait as usual, and say when the worker may give up, see
+// deadline.go. Persisted requests can't be chunked, streamed or gathered.
+
+const (
+	RequestIDHeader   = "Nats-Request-Id"
+	ReplyToHeader     = "Nats-Reply-To"
+	ReplyStreamHeader = "Nats-Reply-Stream"
+)
+
+func JetStreamRequest(stream string) ReqOption {
+	return func(o *ReqOptions) error {
+		if stream == "" {
+			return errors.New("natsv2: empty stream name")
+		}
+		o.JetStream = stream
+		return nil
+	}
+}
+
+// StoreReplies stores replies in stream, on prefix.<request id>, which the
+// stream must store.
+func StoreReplies(stream, prefix string) ReqOption {
+	return func(o *ReqOptions) error {
+		if stream == "" {
+			return errors.New("natsv2: empty reply stream name")
+		}
+		if err := checkSubject(prefix, false); err != nil {
+			return err
+		}
+		o.ReplyStream, o.ReplyPrefix = stream, prefix
+		return nil
+	}
+}
+
+// RequestID is the persisted request's id, a random one if not given. It
+// goes in a subject, so it is a single token.
+func RequestID(id string) ReqOption {
+	return func(o *ReqOptions) error {
+		if id == "" || strings.ContainsAny(id, ". *>\t\r\n") {
+			return fmt.Errorf("natsv2: request id %q is not a single subject token", id)
+		}
+		o.RequestID = id
+		return nil
+	}
+}
+
+// requestJetStream is request for JetStreamRequest.
+func (c *conn) requestJetStream(ctx context.Context, ropts *ReqOptions, m *nats.Msg) (*nats.Msg, error) {
+	if ropts.Chunked {
+		return nil, errors.New("natsv2: persisted requests can't be chunked")
+	}
+	if ropts.RequestID == "" {
+		// Kept for the retries.
+		ropts.RequestID = nuid.Next()
+	}
+	id := ropts.RequestID
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	m.Header.Set(RequestIDHeader, id)
+	var wait func(context.Context) (*nats.Msg, error)
+	if ropts.ReplyStream != "" {
+		subject := c.outSubject(ropts.ReplyPrefix + "." + id)
+		m.Header.Set(ReplyToHeader, subject)
+		m.Header.Set(ReplyStreamHeader, ropts.ReplyStream)
+		wait = func(ctx context.Context) (*nats.Msg, error) {
+			return c.storedReply(ctx, ropts.ReplyStream, subject)
+		}
+	} else {
+		in, err := c.replyInbox()
+		if err != nil {
+			return nil, err
+		}
+		defer in.close()
+		m.Header.Set(ReplyToHeader, in.Reply)
+		wait = in.next
+	}
+	c.log.Debug("persisted request", "subject", m.Subject, "stream", ropts.JetStream, "id", id)
+	if _, err := c.publishJetStream(ctx, ropts.JetStream, m, nil, []PubOption{WithMsgID(id)}); err != nil {
+		return nil, wrapRequestError(m.Subject, err)
+	}
+	reply, err := wait(ctx)
+	if err != nil {
+		return nil, wrapRequestError(m.Subject, err)
+	}
+	if ok, err := c.verified(reply); !ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// storedReply is the reply on subject in stream, once it is there. An
+// ordered consumer gets it whether it was stored before or after, a look
+// at the stream and then a core subscription could miss one being stored
+// in between.
+func (c *conn) storedReply(ctx context.Context, stream, subject string) (*nats.Msg, error) {
+	sub, err := c.js.SubscribeSync(subject, nats.BindStream(stream), nats.OrderedConsumer(), nats.DeliverLastPerSubject())
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+	m, err := sub.NextMsgWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &nats.Msg{Subject: m.Subject, Header: m.Header, Data: m.Data}, nil
+}
+
+// ResumeRequest waits for the reply to the persisted request id, sent with
+// opts, which need its StoreReplies. One already stored comes back at once.
+func ResumeRequest(nc Connection, id string, opts ...ReqOption) (*Msg, error) {
+	ropts := &ReqOptions{}
+	for _, opt := range append(opts, RequestID(id)) {
+		if err := opt(ropts); err != nil {
+			return nil, err
+		}
+	}
+	if ropts.ReplyStream == "" {
+		return nil, errors.New("natsv2: resuming a request needs its StoreReplies")
+	}
+	c := connFor(nc, ropts.ReplyPrefix)
+	if c == nil {
+		return nil, fmt.Errorf("natsv2: no persisted requests on a %T", nc)
+	}
+	// Again with the connection's defaults under opts.
+	ropts, err := c.reqOptions(append(opts, RequestID(id)))
+	if err != nil {
+		return nil, err
+	}
+	subject := c.outSubject(ropts.ReplyPrefix + "." + id)
+	ctx, cancel := ropts.context()
+	defer cancel()
+	reply, err := c.storedReply(ctx, ropts.ReplyStream, subject)
+	if err != nil {
+		return nil, wrapRequestError(subject, err)
+	}
+	if ok, err := c.verified(reply); !ok {
+		return nil, err
+	}
+	return c.wrap(reply), requestError(subject, reply)
+}
+
+// replyTo is where the reply to m goes. A JetStream message's Reply is for
+// acking it, a persisted request's requester is in ReplyToHeader.
+func replyTo(m *nats.Msg) string {
+	if strings.HasPrefix(m.Reply, "$JS.ACK.") {
+		if to := m.Header.Get(ReplyToHeader); to != "" {
+			return to
+		}
+	}
+	return m.Reply
+}
+
+// respondStored stores the reply to a persisted request in the StoreReplies
+// stream, false if it has none.
+func (c *conn) respondStored(ctx context.Context, req, reply *nats.Msg) (bool, error) {
+	stream := req.Header.Get(ReplyStreamHeader)
+	if stream == "" || !strings.HasPrefix(req.Reply, "$JS.ACK.") {
+		return false, nil
+	}
+	if reply.Header == nil {
+		reply.Header = nats.Header{}
+	}
+	id := req.Header.Get(RequestIDHeader)
+	reply.Header.Set(RequestIDHeader, id)
+	_, err := c.publishJetStream(ctx, stream, reply, nil, []PubOption{WithMsgID(id)})
+	return true, err
+}
@@ -0,0 +1,317 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// KV is a JetStream key-value bucket. Values go through a codec like
+// everything else, but KV has nowhere to keep a Content-Type, so the bucket
+// has one codec, the connection default unless set with KVCodec, and that is
+// what Decode uses. []byte and string are stored as is.
+//
+//	kv, err := nc.KV("settings")
+//	kv.Put("theme", Theme{Dark: true})
+//	e, err := kv.Get("theme")
+//	e.Decode(&theme)
+//
+// Watch takes Handler or Channel, the same as Subscribe. It gets the current
+// values first and then changes, as messages with the key as the subject
+// and, for deletes and purges, a KV-Operation header of DEL or PURGE. The
+// revision is in Nats-Sequence and Content-Type is the bucket's, so Decode
+// works on them. For typed values use KVOf.
+type KV interface {
+	Bucket() string
+	Get(key string) (*KVEntry, error)
+	Put(key string, v interface{}) (uint64, error)
+	Delete(key string) error
+	Watch(keys string, opts ...SubOption) (Subscription, error)
+	History(key string) ([]*KVEntry, error)
+	Keys() ([]string, error)
+	Decode(m *Msg, v interface{}) error
+	// See counter.go.
+	Increment(key string, delta int64) (int64, error)
+	// See watchers.go.
+	Updates(ctx context.Context, keys string) (<-chan *KVEntry, error)
+	ListKeys(ctx context.Context) (<-chan string, error)
+}
+
+const (
+	KVOperationHeader = "KV-Operation"
+	KVRevisionHeader  = nats.JSSequence
+)
+
+var (
+	ErrKeyNotFound    = nats.ErrKeyNotFound
+	ErrBucketNotFound = nats.ErrBucketNotFound
+)
+
+type KVOption func(*KVOptions) error
+
+type KVOptions struct {
+	// Codec for values, the connection default if empty.
+	ContentType string
+	// Create the bucket with this if it does not exist.
+	Create *nats.KeyValueConfig
+}
+
+func KVCodec(contentType string) KVOption {
+	return func(o *KVOptions) error {
+		o.ContentType = contentType
+		return nil
+	}
+}
+
+// CreateBucket makes the bucket with cfg if it is not there yet, the bucket
+// name is filled in.
+func CreateBucket(cfg nats.KeyValueConfig) KVOption {
+	return func(o *KVOptions) error {
+		o.Create = &cfg
+		return nil
+	}
+}
+
+type KVEntry struct {
+	Key      string
+	Value    []byte
+	Revision uint64
+	Created  time.Time
+	Op       nats.KeyValueOp
+
+	codec Codec
+}
+
+func (e *KVEntry) Decode(v interface{}) error {
+	return e.codec.Decode(e.Value, v)
+}
+
+func (e *KVEntry) msg() *nats.Msg {
+	m := nats.NewMsg(e.Key)
+	m.Data = e.Value
+	m.Header.Set(ContentTypeHeader, e.codec.ContentType())
+	m.Header.Set(KVRevisionHeader, strconv.FormatUint(e.Revision, 10))
+	switch e.Op {
+	case nats.KeyValueDelete:
+		m.Header.Set(KVOperationHeader, "DEL")
+	case nats.KeyValuePurge:
+		m.Header.Set(KVOperationHeader, "PURGE")
+	}
+	return m
+}
+
+func (c *conn) KV(bucket string, opts ...KVOption) (KV, error) {
+	kopts := &KVOptions{}
+	for _, opt := range opts {
+		if err := opt(kopts); err != nil {
+			return nil, err
+		}
+	}
+	codec := c.codecs.def
+	if kopts.ContentType != "" {
+		if codec = c.codecs.byType[kopts.ContentType]; codec == nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, kopts.ContentType)
+		}
+	}
+	kv, err := c.js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) && kopts.Create != nil {
+		cfg := *kopts.Create
+		cfg.Bucket = bucket
+		kv, err = c.js.CreateKeyValue(&cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kvBucket{c: c, kv: kv, codec: codec}, nil
+}
+
+type kvBucket struct {
+	c     *conn
+	kv    nats.KeyValue
+	codec Codec
+}
+
+func (b *kvBucket) Bucket() string {
+	return b.kv.Bucket()
+}
+
+func (b *kvBucket) entry(e nats.KeyValueEntry) *KVEntry {
+	return &KVEntry{
+		Key:      e.Key(),
+		Value:    e.Value(),
+		Revision: e.Revision(),
+		Created:  e.Created(),
+		Op:       e.Operation(),
+		codec:    b.codec,
+	}
+}
+
+func (b *kvBucket) Get(key string) (*KVEntry, error) {
+	e, err := b.kv.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return b.entry(e), nil
+}
+
+func (b *kvBucket) Put(key string, v interface{}) (uint64, error) {
+	m, err := b.c.codecs.encode(key, v, pipeline{codec: b.codec})
+	if err != nil {
+		return 0, err
+	}
+	return b.kv.Put(key, m.Data)
+}
+
+// Decode is for watch messages, Decode on the entry for Get and History.
+func (b *kvBucket) Decode(m *Msg, v interface{}) error {
+	return b.codec.Decode(m.Data(), v)
+}
+
+func (b *kvBucket) Delete(key string) error {
+	return b.kv.Delete(key)
+}
+
+func (b *kvBucket) History(key string) ([]*KVEntry, error) {
+	hist, err := b.kv.History(key)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*KVEntry, len(hist))
+	for i, e := range hist {
+		entries[i] = b.entry(e)
+	}
+	return entries, nil
+}
+
+// Keys is empty, not an error, for an empty bucket.
+func (b *kvBucket) Keys() ([]string, error) {
+	keys, err := b.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+func (b *kvBucket) Watch(keys string, opts ...SubOption) (Subscription, error) {
+	sopts := &SubOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, err
+		}
+	}
+	if sopts.Queue != "" || sopts.Consumer != nil || sopts.DeadLetter != "" {
+		return nil, errors.New("natsv2: watch only takes Handler or Channel")
+	}
+	ctx, cancel := context.WithCancel(b.c.hctx)
+	var deliver nats.MsgHandler
+	switch {
+	case sopts.Handler != nil:
+		deliver = b.c.recoverHandler(sopts.panicSite(), b.c.handler(ctx, sopts))
+	case sopts.Channel != nil:
+		deliver = b.c.channelHandler(sopts.Channel, sopts.Overflow)
+	default:
+		cancel()
+		return nil, errors.New("natsv2: watch needs a Handler or Channel")
+	}
+	w, err := b.kv.Watch(keys)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	kw := &kvWatch{w: w, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(kw.done)
+		for e := range w.Updates() {
+			// nil marks the end of the initial values.
+			if e != nil {
+				kw.pause.wait(ctx)
+				deliver(b.entry(e).msg())
+			}
+		}
+	}()
+	return kw, nil
+}
+
+type kvWatch struct {
+	w nats.KeyWatcher
+	// Ends the handler's context.
+	cancel context.CancelFunc
+	done   chan struct{}
+	// See pause.go.
+	pause pauseGate
+}
+
+func (kw *kvWatch) Close() {
+	kw.Unsubscribe()
+}
+
+func (kw *kvWatch) Unsubscribe() error {
+	kw.cancel()
+	return kw.w.Stop()
+}
+
+// Drain lets updates already received be delivered.
+func (kw *kvWatch) Drain(ctx context.Context) error {
+	kw.cancel()
+	if err := kw.w.Stop(); err != nil {
+		return err
+	}
+	select {
+	case <-kw.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TypedKV is a KV of T values, so Get and Put don't need Decode.
+//
+//	settings := KVOf[Theme](kv)
+//	theme, rev, err := settings.Get("theme")
+type TypedKV[T any] struct {
+	KV
+}
+
+func KVOf[T any](kv KV) TypedKV[T] {
+	return TypedKV[T]{kv}
+}
+
+func (t TypedKV[T]) Get(key string) (T, uint64, error) {
+	var v T
+	e, err := t.KV.Get(key)
+	if err != nil {
+		return v, 0, err
+	}
+	if err := e.Decode(&v); err != nil {
+		return v, 0, err
+	}
+	return v, e.Revision, nil
+}
+
+func (t TypedKV[T]) Put(key string, v T) (uint64, error) {
+	return t.KV.Put(key, v)
+}
+
+// Watch calls handler with each value, deleted is true for deletes and
+// purges and v is then the zero value. Values that don't decode go to the
+// ErrorHandler.
+func (t TypedKV[T]) Watch(keys string, handler func(key string, v T, deleted bool)) (Subscription, error) {
+	return t.KV.Watch(keys, Handler(func(m *Msg) {
+		var v T
+		if m.Header().Get(KVOperationHeader) != "" {
+			handler(m.Subject(), v, true)
+			return
+		}
+		if err := t.KV.Decode(m, &v); err != nil {
+			if b, ok := t.KV.(*kvBucket); ok {
+				b.c.handleError(&DecodeError{Subject: m.Subject(), Err: err})
+			}
+			return
+		}
+		handler(m.Subject(), v, false)
+	}))
+}
@@ -0,0 +1,286 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A server going away for a deploy or maintenance says so first, it goes
+// into lame duck mode and closes its clients over a while. OnLameDuck hears
+// about it, LameDuckHandover also moves off the server before it is closed
+// on, without dropping requests:
+//
+//	nc, err := natsv2.Connect(urls, LameDuckHandover(30*time.Second), OnLameDuck(func(ev LameDuckEvent) {
+//		log.Println("server going away:", ev.Server)
+//	}))
+//
+// The handover leaves the queue groups, core queue subscriptions and
+// service endpoints, so new requests go to the group's other members,
+// waits up to the timeout for the handlers already going, reconnects to
+// another server of the cluster and joins the groups again. Subscriptions
+// that aren't in a queue group and JetStream ones don't miss anything by
+// going over with the reconnect, they aren't left first. Being the only
+// server known there is nowhere to go, the groups are joined again where
+// they are. Requests the connection itself has waiting fail with the
+// reconnect, as they do when it drops.
+
+type LameDuckEvent struct {
+	Server   string
+	ServerID string
+}
+
+// OnLameDuck is called when the server we are connected to goes into lame
+// duck mode.
+func OnLameDuck(cb func(LameDuckEvent)) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.OnLameDuck = append(o.OnLameDuck, cb)
+		return nil
+	}
+}
+
+// LameDuckHandover moves off a server going into lame duck mode, taking up
+// to timeout for the handlers to finish and the reconnect.
+func LameDuckHandover(timeout time.Duration) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if timeout <= 0 {
+			return errors.New("natsv2: lame duck handover needs a timeout")
+		}
+		o.LameDuckHandover = timeout
+		return nil
+	}
+}
+
+// watchLameDuck is the client's LameDuckModeHandler, for the conn in c once
+// Connect has it. nats.go has no setter for it like the other handlers, so
+// it goes in with the options, in front of any set with NATSOptions.
+func watchLameDuck(c *atomic.Pointer[conn]) nats.Option {
+	return func(o *nats.Options) error {
+		prev := o.LameDuckModeHandler
+		o.LameDuckModeHandler = func(nc *nats.Conn) {
+			if c := c.Load(); c != nil {
+				c.lameDuck(nc)
+			}
+			if prev != nil {
+				prev(nc)
+			}
+		}
+		return nil
+	}
+}
+
+func (c *conn) lameDuck(nc *nats.Conn) {
+	ev := LameDuckEvent{Server: nc.ConnectedUrlRedacted(), ServerID: nc.ConnectedServerId()}
+	c.log.Warn("server in lame duck mode", "server", ev.Server)
+	for _, cb := range c.opts.OnLameDuck {
+		cb(ev)
+	}
+	if c.opts.LameDuckHandover > 0 && c.handingOver.CompareAndSwap(false, true) {
+		go func() {
+			defer c.handingOver.Store(false)
+			c.handover(ev.ServerID, c.opts.LameDuckHandover)
+		}()
+	}
+}
+
+// handover leaves the queue groups, waits for their handlers, reconnects
+// away from the server with id and joins them again.
+func (c *conn) handover(id string, timeout time.Duration) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+
+	var subs []*subscription
+	var sets []*SubscriptionSet
+	c.subs.Range(func(k, v interface{}) bool {
+		sopts := k.(*SubOptions)
+		if sopts.Queue == "" || sopts.auto != nil {
+			return true
+		}
+		switch s := v.(type) {
+		case *subscription:
+			if s.handler != nil && sopts.Consumer == nil {
+				subs = append(subs, s)
+			}
+		case *SubscriptionSet:
+			sets = append(sets, s)
+		}
+		return true
+	})
+	var endpoints []*endpoint
+	c.services.Range(func(k, _ interface{}) bool {
+		s := k.(*service)
+		s.mu.Lock()
+		if !s.done {
+			endpoints = append(endpoints, s.endpoints...)
+		}
+		s.mu.Unlock()
+		return true
+	})
+	moving := map[*subscription]bool{}
+	for _, s := range subs {
+		moving[s] = true
+	}
+	for _, ss := range sets {
+		ss.mu.Lock()
+		for _, m := range ss.members {
+			moving[m.s] = true
+		}
+		ss.mu.Unlock()
+	}
+	c.log.Info("handing over", "subscriptions", len(moving), "endpoints", len(endpoints))
+
+	// Left all at once, then waited for.
+	var wg sync.WaitGroup
+	wait := func(f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				c.handleError(err)
+			}
+		}()
+	}
+	for _, s := range subs {
+		s := s
+		wait(func() error { return c.drainSubs(ctx, s.sopts, s.current()) })
+	}
+	for _, ss := range sets {
+		ss := ss
+		var members []*nats.Subscription
+		ss.mu.Lock()
+		for _, m := range ss.members {
+			members = append(members, m.s.current())
+		}
+		ss.mu.Unlock()
+		wait(func() error { return c.drainSubs(ctx, ss.sopts, members...) })
+	}
+	for _, e := range endpoints {
+		e := e
+		e.s.mu.Lock()
+		sub := e.sub
+		e.s.mu.Unlock()
+		wait(func() error { return e.drain(ctx, sub) })
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		c.log.Warn("handlers still going at the end of the handover", "timeout", timeout)
+	}
+
+	if len(c.nc.Servers()) > 1 {
+		if err := c.nc.ForceReconnect(); err != nil {
+			c.handleError(err)
+		}
+		t := time.NewTicker(10 * time.Millisecond)
+	reconnect:
+		for !c.nc.IsConnected() || c.nc.ConnectedServerId() == id {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				c.log.Warn("no other server within the handover", "timeout", timeout)
+				break reconnect
+			}
+		}
+		t.Stop()
+	} else {
+		c.log.Warn("no other server to hand over to", "server", c.nc.ConnectedUrlRedacted())
+	}
+	if c.ctx.Err() != nil {
+		return
+	}
+
+	var errs []error
+	for _, s := range subs {
+		errs = append(errs, s.rejoin())
+	}
+	for _, ss := range sets {
+		ss.mu.Lock()
+		for _, m := range ss.members {
+			if moving[m.s] {
+				errs = append(errs, m.s.rejoin())
+			}
+		}
+		ss.mu.Unlock()
+	}
+	for _, e := range endpoints {
+		errs = append(errs, e.rejoin())
+	}
+	for _, err := range errs {
+		if err != nil {
+			c.handleError(err)
+		}
+	}
+	c.log.Info("handed over", "server", c.nc.ConnectedUrlRedacted(), "took", time.Since(start))
+}
+
+// current is the client subscription s is on now, a new one once a
+// handover has joined the queue group again.
+func (s *subscription) current() *nats.Subscription {
+	if sub := s.moved.Load(); sub != nil {
+		return sub
+	}
+	return s.sub
+}
+
+// rejoin subscribes s again after a handover, unless it was stopped
+// meanwhile.
+func (s *subscription) rejoin() error {
+	if s.sopts.ctx.Err() != nil {
+		return nil
+	}
+	old := s.current()
+	sub, err := s.c.nc.QueueSubscribe(old.Subject, s.sopts.Queue, s.handler)
+	if err != nil {
+		return err
+	}
+	s.moved.Store(sub)
+	s.c.slow.Delete(old)
+	if s.sopts.ctx.Err() != nil {
+		// Stopped while we were at it, the stop had the old one.
+		s.c.slow.Delete(sub)
+		return sub.Unsubscribe()
+	}
+	return s.c.setPending(s)
+}
+
+// drain is service.drain for e's sub alone, the service goes on.
+func (e *endpoint) drain(ctx context.Context, sub *nats.Subscription) error {
+	if err := sub.Drain(); err != nil {
+		return err
+	}
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for sub.IsValid() {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			sub.Unsubscribe()
+			return ctx.Err()
+		}
+	}
+	return e.limits.wait(ctx)
+}
+
+func (e *endpoint) rejoin() error {
+	s := e.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil
+	}
+	sub, err := e.subscribe()
+	if err != nil {
+		return err
+	}
+	for i, old := range s.subs {
+		if old == e.sub {
+			s.subs[i] = sub
+		}
+	}
+	e.sub = sub
+	return nil
+}
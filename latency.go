@@ -0,0 +1,151 @@
+package natsv2
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Where the time of a request goes. TrackLatency times the round trip and
+// asks the responder to say how long it took to answer, so the reply can
+// tell the handler apart from the network in between:
+//
+//	reply, err := nc.Request("orders.get", id, TrackLatency())
+//	l := reply.Latency() // l.RTT, l.Handler, l.Network()
+//
+// Responders here answer with HandlerTimeHeader by themselves, others can
+// set it too, as a Go duration. Metrics that also implement LatencyMetrics
+// get every tracked request.
+//
+// For services imported from another account the server can measure the
+// same thing, see latency tracking in the server docs. With sampling set to
+// "headers" it only does for requests carrying a trace header, SampleLatency
+// adds one, and the results arrive as ServiceLatency on the subject the
+// export names:
+//
+//	nc.Request("orders.get", id, SampleLatency())
+//	nc.Subscribe("latency.orders", Handler(func(msg *Msg) {
+//		var l ServiceLatency
+//		msg.Decode(&l)
+//	}))
+
+const (
+	// On a request, asks for HandlerTimeHeader. The value only means
+	// something locally, see Latency.
+	LatencyHeader     = "Nats-Latency"
+	HandlerTimeHeader = "Nats-Handler-Time"
+)
+
+func TrackLatency() ReqOption {
+	return func(o *ReqOptions) error {
+		o.TrackLatency = true
+		return nil
+	}
+}
+
+// SampleLatency marks the request to be sampled by server latency tracking,
+// unless it already carries a trace header.
+func SampleLatency() ReqOption {
+	return func(o *ReqOptions) error {
+		for _, h := range []string{"Traceparent", "Uber-Trace-Id", "B3", "X-B3-Sampled", "X-B3-Traceid"} {
+			if len(o.Headers[h]) > 0 {
+				return nil
+			}
+		}
+		o.Headers = addHeaders(o.Headers, map[string][]string{"X-B3-Sampled": {"1"}})
+		return nil
+	}
+}
+
+type Latency struct {
+	// From sending the request to the reply arriving.
+	RTT time.Duration
+	// From the request arriving at the responder to the reply going out,
+	// zero if the responder didn't say.
+	Handler time.Duration
+}
+
+// Network is the part of RTT not spent in the handler.
+func (l Latency) Network() time.Duration {
+	return l.RTT - l.Handler
+}
+
+// Latency is set on replies to requests made with TrackLatency.
+func (m *Msg) Latency() Latency {
+	var l Latency
+	l.RTT, _ = time.ParseDuration(m.m.Header.Get(LatencyHeader))
+	l.Handler, _ = time.ParseDuration(m.m.Header.Get(HandlerTimeHeader))
+	return l
+}
+
+// LatencyMetrics is for a Metrics that wants the split of tracked requests.
+type LatencyMetrics interface {
+	RequestLatency(subject string, l Latency)
+}
+
+// The requesting side: the reply carries the RTT in LatencyHeader.
+func timeReply(reply *nats.Msg, sent time.Time) {
+	if reply.Header == nil {
+		reply.Header = nats.Header{}
+	}
+	reply.Header.Set(LatencyHeader, time.Since(sent).String())
+}
+
+// The responding side: on arrival LatencyHeader becomes the time the request
+// came in, which respondMsg turns into HandlerTimeHeader.
+func stampRequest(m *nats.Msg) {
+	if m.Reply != "" && m.Header.Get(LatencyHeader) != "" {
+		m.Header.Set(LatencyHeader, strconv.FormatInt(time.Now().UnixNano(), 10))
+	}
+}
+
+func timeHandler(req, reply *nats.Msg) {
+	arrived, err := strconv.ParseInt(req.Header.Get(LatencyHeader), 10, 64)
+	if err != nil {
+		return
+	}
+	if reply.Header == nil {
+		reply.Header = nats.Header{}
+	}
+	reply.Header.Set(HandlerTimeHeader, time.Since(time.Unix(0, arrived)).String())
+}
+
+// ServiceLatency is what the server publishes for a sampled request, see
+// SampleLatency. Durations are in nanoseconds, as the server sends them.
+type ServiceLatency struct {
+	Type      string              `json:"type"`
+	ID        string              `json:"id"`
+	Time      time.Time           `json:"timestamp"`
+	Status    int                 `json:"status"`
+	Error     string              `json:"description,omitempty"`
+	Requestor *LatencyClient      `json:"requestor,omitempty"`
+	Responder *LatencyClient      `json:"responder,omitempty"`
+	Header    map[string][]string `json:"header,omitempty"`
+	Start     time.Time           `json:"start"`
+	// In the service, between the request reaching it and the reply leaving.
+	Service time.Duration `json:"service"`
+	// Between servers.
+	System time.Duration `json:"system"`
+	Total  time.Duration `json:"total"`
+}
+
+type LatencyClient struct {
+	Account string        `json:"acc,omitempty"`
+	Name    string        `json:"name,omitempty"`
+	Server  string        `json:"server,omitempty"`
+	RTT     time.Duration `json:"rtt,omitempty"`
+}
+
+// Network is the time NATS itself took, both clients' RTT to their server
+// and the time between servers.
+func (l *ServiceLatency) Network() time.Duration {
+	d := l.System
+	if l.Requestor != nil {
+		d += l.Requestor.RTT
+	}
+	if l.Responder != nil {
+		d += l.Responder.RTT
+	}
+	return d
+}
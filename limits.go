@@ -0,0 +1,172 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Load shedding for handlers. MaxConcurrent runs each message's handler on
+// its own goroutine, at most n at once, RateLimit lets through up to rps
+// messages a second. What's over either is rejected, requests get a "429"
+// service error back, unless Backpressure is given, then the subscription
+// waits for room and the pending limits take it from there.
+//
+//	nc.Subscribe("images.resize", Handler(resize), MaxConcurrent(16), RateLimit(200))
+//	nc.Service("resize", "1.0.0", ServiceHandler(resize), ServiceMaxConcurrent(16))
+//
+// Endpoints take the service's limits unless given their own, each has its
+// own count and rate.
+
+var ErrOverloaded = errors.New("natsv2: handler overloaded")
+
+func MaxConcurrent(n int) SubOption {
+	return func(o *SubOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: max concurrent must be at least 1")
+		}
+		o.MaxConcurrent = n
+		return nil
+	}
+}
+
+func RateLimit(rps int) SubOption {
+	return func(o *SubOptions) error {
+		if rps < 1 {
+			return errors.New("natsv2: rate limit must be positive")
+		}
+		o.RateLimit = rps
+		return nil
+	}
+}
+
+func Backpressure() SubOption {
+	return func(o *SubOptions) error {
+		o.Backpressure = true
+		return nil
+	}
+}
+
+func ServiceMaxConcurrent(n int) ServiceOption {
+	return func(o *ServiceOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: max concurrent must be at least 1")
+		}
+		o.MaxConcurrent = n
+		return nil
+	}
+}
+
+func ServiceRateLimit(rps int) ServiceOption {
+	return func(o *ServiceOptions) error {
+		if rps < 1 {
+			return errors.New("natsv2: rate limit must be positive")
+		}
+		o.RateLimit = rps
+		return nil
+	}
+}
+
+func ServiceBackpressure() ServiceOption {
+	return func(o *ServiceOptions) error {
+		o.Backpressure = true
+		return nil
+	}
+}
+
+type handlerLimits struct {
+	ctx     context.Context
+	slots   chan struct{}
+	rate    *rateLimiter
+	block   bool
+	running sync.WaitGroup
+}
+
+// newHandlerLimits is nil without limits, ctx ends any waiting.
+func newHandlerLimits(ctx context.Context, maxConcurrent, rps int, block bool) *handlerLimits {
+	if maxConcurrent == 0 && rps == 0 {
+		return nil
+	}
+	l := &handlerLimits{ctx: ctx, block: block}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	if rps > 0 {
+		l.rate = newRateLimiter(rps, !block)
+	}
+	return l
+}
+
+// wrap calls reject for what's over the limits.
+func (l *handlerLimits) wrap(handler nats.MsgHandler, reject nats.MsgHandler) nats.MsgHandler {
+	if l == nil {
+		return handler
+	}
+	return func(m *nats.Msg) {
+		if l.rate != nil {
+			if err := l.rate.wait(l.ctx); err != nil {
+				if errors.Is(err, ErrRateLimited) {
+					reject(m)
+				}
+				return
+			}
+		}
+		if l.slots == nil {
+			handler(m)
+			return
+		}
+		if l.block {
+			select {
+			case l.slots <- struct{}{}:
+			case <-l.ctx.Done():
+				return
+			}
+		} else {
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				reject(m)
+				return
+			}
+		}
+		l.running.Add(1)
+		go func() {
+			defer func() {
+				<-l.slots
+				l.running.Done()
+			}()
+			handler(m)
+		}()
+	}
+}
+
+// wait is for the running handlers, for Drain.
+func (l *handlerLimits) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	idle := make(chan struct{})
+	go func() {
+		l.running.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// overloaded replies with a 429, a rejected message without a reply subject
+// is just dropped.
+func (c *conn) overloaded(m *nats.Msg) {
+	c.log.Debug("handler overloaded", "subject", m.Subject)
+	if m.Reply == "" {
+		return
+	}
+	c.respondMsg(m, errorReply("429", fmt.Errorf("%w: %s", ErrOverloaded, m.Subject)))
+}
@@ -0,0 +1,225 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Locks and semaphores between processes, on a KV bucket. A semaphore of n
+// has n slots, the keys name.0 to name.n-1, a slot is taken by creating
+// its key and held by renewing it every TTL/3. A Lock is a semaphore of 1:
+//
+//	mu, err := nc.Lock("reports.monthly")
+//	lease, err := mu.Acquire(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer lease.Release()
+//	store.Write(lease.Token, report)
+//
+//	conns, err := nc.Semaphore("db.connections", 10)
+//
+// Acquire waits for a slot until ctx is done, TryAcquire doesn't wait. A
+// holder that dies or loses its connection stops renewing and the server
+// drops the key after the TTL. The holder gives up sooner, at the first
+// renewal that fails, and closes Lost.
+//
+// Token is the slot's revision, which only grows, so what the holder writes
+// to can turn away tokens older than the newest it has seen. That is the
+// fence against a holder paused for longer than the TTL that hasn't noticed
+// it lost the lease.
+//
+// Locks are in DefaultLockBucket with a TTL of DefaultLockTTL unless
+// LockBucket says otherwise, all locks in a bucket have its TTL.
+
+const (
+	DefaultLockBucket = "natsv2_locks"
+	DefaultLockTTL    = 30 * time.Second
+)
+
+var (
+	ErrLockHeld  = errors.New("natsv2: lock held")
+	ErrLeaseLost = errors.New("natsv2: lease lost")
+)
+
+type LockOption func(*LockOptions) error
+
+type LockOptions struct {
+	Bucket string
+	TTL    time.Duration
+}
+
+func LockBucket(bucket string, ttl time.Duration) LockOption {
+	return func(o *LockOptions) error {
+		if bucket == "" || ttl < time.Second {
+			return errors.New("natsv2: lock bucket needs a name and a ttl of at least a second")
+		}
+		o.Bucket, o.TTL = bucket, ttl
+		return nil
+	}
+}
+
+type Semaphore struct {
+	c    *conn
+	kv   nats.KeyValue
+	name string
+	n    int
+	ttl  time.Duration
+}
+
+func (c *conn) Lock(name string, opts ...LockOption) (*Semaphore, error) {
+	return c.Semaphore(name, 1, opts...)
+}
+
+func (c *conn) Semaphore(name string, n int, opts ...LockOption) (*Semaphore, error) {
+	if err := checkSubject(name, false); err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, errors.New("natsv2: semaphore needs at least one slot")
+	}
+	lopts := &LockOptions{Bucket: DefaultLockBucket, TTL: DefaultLockTTL}
+	for _, opt := range opts {
+		if err := opt(lopts); err != nil {
+			return nil, err
+		}
+	}
+	// Renewals that take longer than this are too late anyway.
+	js, err := c.nc.JetStream(nats.MaxWait(lopts.TTL / 3))
+	if err != nil {
+		return nil, err
+	}
+	kv, err := leaseBucket(js, lopts.Bucket, lopts.TTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Semaphore{c: c, kv: kv, name: name, n: n, ttl: lopts.TTL}, nil
+}
+
+// TryAcquire takes a free slot or returns ErrLockHeld.
+func (s *Semaphore) TryAcquire() (*Lease, error) {
+	id := nuid.Next()
+	// From a random slot, so waiters don't all go for the same one.
+	first := rand.Intn(s.n)
+	for i := 0; i < s.n; i++ {
+		key := s.name + "." + strconv.Itoa((first+i)%s.n)
+		rev, err := s.kv.Create(key, []byte(id))
+		if errors.Is(err, nats.ErrKeyExists) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		l := &Lease{Token: rev, s: s, key: key, id: id, rev: rev, lost: make(chan struct{}), stop: make(chan struct{}), done: make(chan struct{})}
+		go l.renew()
+		return l, nil
+	}
+	return nil, ErrLockHeld
+}
+
+// Acquire waits for a slot until ctx is done.
+func (s *Semaphore) Acquire(ctx context.Context) (*Lease, error) {
+	w, err := s.kv.Watch(s.name+".*", nats.UpdatesOnly(), nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Stop()
+	// Slots that run out aren't watched for, so look again now and then.
+	t := time.NewTicker(s.ttl / 3)
+	defer t.Stop()
+	for {
+		l, err := s.TryAcquire()
+		if !errors.Is(err, ErrLockHeld) {
+			return l, err
+		}
+	wait:
+		for {
+			select {
+			case e := <-w.Updates():
+				if e != nil && e.Operation() != nats.KeyValuePut {
+					break wait
+				}
+			case <-t.C:
+				break wait
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-s.c.hctx.Done():
+				return nil, fmt.Errorf("natsv2: waiting for %s: connection closing", s.name)
+			}
+		}
+	}
+}
+
+// A Lease is a slot held, until Release or until it is lost.
+type Lease struct {
+	// Fencing token, see lock.go.
+	Token uint64
+
+	s   *Semaphore
+	key string
+	id  string
+
+	rev  uint64
+	lost chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Lost is closed once the lease is lost, the work it guards should stop.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Lease) renew() {
+	defer close(l.done)
+	t := time.NewTicker(l.s.ttl / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-l.stop:
+			return
+		case <-l.s.c.hctx.Done():
+			// Given up as the connection drains or closes.
+			l.release()
+			close(l.lost)
+			return
+		}
+		rev, err := l.s.kv.Update(l.key, []byte(l.id), l.rev)
+		if err != nil {
+			l.s.c.log.Warn("lease lost", "lock", l.s.name, "key", l.key, "error", err)
+			close(l.lost)
+			return
+		}
+		l.rev = rev
+	}
+}
+
+// Release gives the slot back, ErrLeaseLost if it was lost meanwhile.
+func (l *Lease) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		err = l.release()
+	})
+	return err
+}
+
+func (l *Lease) release() error {
+	select {
+	case <-l.lost:
+		return ErrLeaseLost
+	default:
+	}
+	return l.s.kv.Delete(l.key, nats.LastRevision(l.rev))
+}
@@ -0,0 +1,46 @@
+package natsv2
+
+import "log/slog"
+
+// Logger takes a message and alternating keys and values, the same shape as
+// log/slog so a *slog.Logger can be used directly. Nothing is logged unless
+// one is set with WithLogger or WithSlog.
+//
+// Info is the connection's life: connects, reconnects, closes, services
+// starting and stopping and the phases of a Shutdown. Warn is for what
+// loses or delays messages, disconnects, slow consumers and drops, failed
+// service requests and drains. Error is for async errors and handler
+// panics, unless an ErrorHandler takes those. Debug has every request and
+// subscribe, leave it off outside of development.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+func WithLogger(l Logger) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.Logger = l
+		return nil
+	}
+}
+
+// WithSlog logs to l, or slog.Default() if nil, with logger=nats on every
+// record to tell ours apart.
+func WithSlog(l *slog.Logger) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if l == nil {
+			l = slog.Default()
+		}
+		o.Logger = l.With(slog.String("logger", "nats"))
+		return nil
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
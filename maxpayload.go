@@ -0,0 +1,147 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Publishes bigger than the server's max_payload. On their own they fail
+// with ErrPayloadTooLarge, saying how big the message and the limit are.
+// With ChunkOversize, Publish sends them in chunks of the WithChunkSize
+// size instead, as chunked replies are, see chunked.go, with a
+// Nats-Chunk-Id to tell them apart:
+//
+//	nc, _ := Connect(url, ChunkOversize())
+//	nc.Publish("reports.daily", bigReport)
+//
+// natsv2 subscriptions with a Handler or Channel put the chunks back
+// together before anything else sees them, up to DefaultMaxReplySize, so
+// the message handled is the one published. Others would get the chunks,
+// so it is for when all the subscribers are natsv2 ones. Queue group members
+// each get only some of the chunks and drop them, JetStream consumers get
+// them as they are. Requests and replies are never chunked this way.
+
+const ChunkIDHeader = "Nats-Chunk-Id"
+
+// How long chunks of a message wait for the rest.
+const chunkTimeout = time.Minute
+
+var ErrPayloadTooLarge = fmt.Errorf("natsv2: payload too large: %w", nats.ErrMaxPayload)
+
+func ChunkOversize() ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.ChunkOversize = true
+		return nil
+	}
+}
+
+// tooLarge is err, with the sizes if it is about m not fitting.
+func (c *conn) tooLarge(m *nats.Msg, err error) error {
+	if !errors.Is(err, nats.ErrMaxPayload) {
+		return err
+	}
+	return fmt.Errorf("%w, %d bytes to %q and the server's max_payload is %d", ErrPayloadTooLarge, len(m.Data), m.Subject, c.nc.MaxPayload())
+}
+
+// publishOrChunk is publish, sending m in chunks if it is too large and
+// ChunkOversize says to.
+func (c *conn) publishOrChunk(ctx context.Context, m *nats.Msg) error {
+	err := c.publish(ctx, m)
+	if !c.opts.ChunkOversize || !errors.Is(err, ErrPayloadTooLarge) {
+		return err
+	}
+	id, size, data := nuid.Next(), c.chunkSize(), m.Data
+	c.log.Debug("chunking publish", "subject", m.Subject, "size", len(data), "chunk", size)
+	for seq := 1; len(data) > 0; seq++ {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		chunk := nats.NewMsg(m.Subject)
+		chunk.Reply = m.Reply
+		if seq == 1 {
+			for k, v := range m.Header {
+				chunk.Header[k] = v
+			}
+		}
+		chunk.Header.Set(ChunkIDHeader, id)
+		chunk.Header.Set(ChunkSeqHeader, strconv.Itoa(seq))
+		if n == len(data) {
+			chunk.Header.Set(ChunkLastHeader, "true")
+		}
+		chunk.Data = data[:n]
+		if err := c.publish(ctx, chunk); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+type chunkedMsg struct {
+	first   *nats.Msg
+	data    []byte
+	next    int
+	started time.Time
+}
+
+// reassembling puts chunked publishes back together for handler, anything
+// else goes straight through.
+func (c *conn) reassembling(handler nats.MsgHandler) nats.MsgHandler {
+	var mu sync.Mutex
+	partial := map[string]*chunkedMsg{}
+	return func(m *nats.Msg) {
+		id := m.Header.Get(ChunkIDHeader)
+		if id == "" {
+			handler(m)
+			return
+		}
+		seq, _ := strconv.Atoi(m.Header.Get(ChunkSeqHeader))
+		mu.Lock()
+		p := partial[id]
+		if p == nil {
+			now := time.Now()
+			for k, old := range partial {
+				if now.Sub(old.started) > chunkTimeout {
+					c.log.Warn("chunks timed out", "subject", old.first.Subject, "id", k)
+					delete(partial, k)
+				}
+			}
+			p = &chunkedMsg{first: m, next: 1, started: now}
+			partial[id] = p
+		}
+		if seq != p.next {
+			delete(partial, id)
+			mu.Unlock()
+			c.log.Warn("chunk missing", "subject", m.Subject, "id", id, "got", seq, "want", p.next)
+			return
+		}
+		p.data = append(p.data, m.Data...)
+		p.next++
+		if len(p.data) > DefaultMaxReplySize {
+			delete(partial, id)
+			mu.Unlock()
+			c.log.Warn("chunked message too large", "subject", m.Subject, "id", id)
+			return
+		}
+		if m.Header.Get(ChunkLastHeader) == "" {
+			mu.Unlock()
+			return
+		}
+		delete(partial, id)
+		mu.Unlock()
+		whole := p.first
+		whole.Data = p.data
+		whole.Header.Del(ChunkIDHeader)
+		whole.Header.Del(ChunkSeqHeader)
+		whole.Header.Del(ChunkLastHeader)
+		handler(whole)
+	}
+}
@@ -0,0 +1,56 @@
+package natsv2
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Metrics gets told about traffic on the connection, set one with
+// WithMetrics. natsprom has one for Prometheus, anything else can implement
+// this. Calls come from publishing and handler goroutines, so they must be
+// safe for concurrent use and cheap.
+//
+// Published counts every message that went out, requests included, Received
+// every message given to a handler. Pending is the subscription's backlog
+// seen as each message is received, keyed by the subscription subject.
+// Requested is called once per Request with how long it took and its error.
+// ServiceError is a Service handler panicking or an HTTP handler answering
+// 5xx. A Metrics can also be a LatencyMetrics, see latency.go, a
+// DecodeMetrics, see decodeerror.go, or a PanicMetrics, see panics.go.
+type Metrics interface {
+	Published(subject string, bytes int)
+	Received(subject string, bytes int)
+	Pending(subject string, msgs, bytes int)
+	Requested(subject string, latency time.Duration, err error)
+	Reconnected()
+	ServiceError(service string)
+}
+
+func WithMetrics(m Metrics) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.Metrics = m
+		return nil
+	}
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Published(string, int)                  {}
+func (nopMetrics) Received(string, int)                   {}
+func (nopMetrics) Pending(string, int, int)               {}
+func (nopMetrics) Requested(string, time.Duration, error) {}
+func (nopMetrics) Reconnected()                           {}
+func (nopMetrics) ServiceError(string)                    {}
+
+func (c *conn) countReceived(handler nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		c.metrics.Received(m.Subject, len(m.Data))
+		if m.Sub != nil {
+			if msgs, bytes, err := m.Sub.Pending(); err == nil {
+				c.metrics.Pending(m.Sub.Subject, msgs, bytes)
+			}
+		}
+		handler(m)
+	}
+}
@@ -0,0 +1,35 @@
+package natsv2
+
+import (
+	"net/http"
+)
+
+// Standard net/http middleware works on handlers served over NATS, as does
+// any http.Handler, e.g. a router from chi or gorilla/mux. The request path
+// is the subject with dots as slashes, or the URL the requester sent with
+// RoundTrip and HTTPTransport, so routers match the same paths either way.
+//
+//	nc.Mount("api", router, logging, auth)     // "api.users.7" is GET /api/users/7
+//	nc.Handle("health", Chain(healthHandler, logging))
+//	nc.Service("api", "1.0.0", ServiceSubject("api.>"), HTTPHandler(Chain(router, logging)))
+
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in mw, the first one is the outermost, for Handle or a
+// Service's HTTPHandler.
+func Chain(h http.Handler, mw ...Middleware) HTTPHandlerFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h.ServeHTTP
+}
+
+// Mount serves h for every subject below subject, wrapped in mw. The paths
+// keep the mount point, use http.StripPrefix for a handler that expects
+// them relative to it.
+func (c *conn) Mount(subject string, h http.Handler, mw ...Middleware) (Subscription, error) {
+	if err := checkSubject(subject, false); err != nil {
+		return nil, err
+	}
+	return c.Handle(subject+".>", Chain(h, mw...))
+}
@@ -0,0 +1,227 @@
+package natsv2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Msg is a message as handlers, Request and the rest of the API see it. It
+// decodes and responds through the connection it came in on. FromNATS and
+// NATS convert to and from the low level client's message, and NewMsg makes
+// one for testing handlers without a server.
+//
+// Interceptors and NATSOptions are the exceptions, they are hooks into the
+// low level client and get its messages.
+type Msg struct {
+	m *nats.Msg
+	c *conn
+	// The error sent with RespondError, for the Service stats.
+	err error
+	// Where replies go without a connection, see FromNATSWith.
+	respond func(*nats.Msg) error
+	// See context.go.
+	hctx context.Context
+	// See msgrouter.go.
+	params map[string]string
+	// See deadline.go.
+	dctx    context.Context
+	dcancel context.CancelFunc
+	// See decodeerror.go.
+	sopts *SubOptions
+}
+
+func NewMsg(subject string, data []byte) *Msg {
+	return &Msg{m: &nats.Msg{Subject: subject, Data: data, Header: nats.Header{}}}
+}
+
+// FromNATS wraps m, Decode uses the default codecs and Respond replies on m's
+// subscription.
+func FromNATS(m *nats.Msg) *Msg {
+	return &Msg{m: m}
+}
+
+// FromNATSWith is FromNATS with replies going to respond instead of m's
+// subscription, for in-memory transports like natsv2test.
+func FromNATSWith(m *nats.Msg, respond func(reply *nats.Msg) error) *Msg {
+	return &Msg{m: m, respond: respond}
+}
+
+// respondNATS sends reply without a connection.
+func (m *Msg) respondNATS(reply *nats.Msg) error {
+	if m.respond == nil {
+		return m.m.RespondMsg(reply)
+	}
+	if m.m.Reply == "" {
+		return nats.ErrMsgNoReply
+	}
+	reply.Subject = m.m.Reply
+	return m.respond(reply)
+}
+
+func (m *Msg) NATS() *nats.Msg {
+	return m.m
+}
+
+func (c *conn) wrap(m *nats.Msg) *Msg {
+	if m == nil {
+		return nil
+	}
+	stampRequest(m)
+	return &Msg{m: m, c: c}
+}
+
+func (m *Msg) Subject() string { return m.m.Subject }
+func (m *Msg) Reply() string   { return m.m.Reply }
+func (m *Msg) Data() []byte    { return m.m.Data }
+
+// Header is never nil, so Get on it is always fine.
+func (m *Msg) Header() Header {
+	if m.m.Header == nil {
+		m.m.Header = nats.Header{}
+	}
+	return Header(m.m.Header)
+}
+
+func (m *Msg) Decode(v interface{}) error {
+	if m.c == nil {
+		return defaultCodecs.decode(m.m, v)
+	}
+	return m.c.codecs.decode(m.m, v)
+}
+
+func (m *Msg) Respond(v interface{}) error {
+	if m.c == nil {
+		reply, err := defaultCodecs.encode(m.m.Reply, v, defaultCodecs.out)
+		if err != nil {
+			return err
+		}
+		return m.respondNATS(reply)
+	}
+	return m.c.Respond(m, v)
+}
+
+// Ack acks a JetStream message, see AutoAck to have it done for you.
+func (m *Msg) Ack() error {
+	return m.m.Ack()
+}
+
+// AckSync acks and waits for the server to confirm it, so once it returns
+// the message won't be redelivered. Without a deadline on ctx,
+// DefaultRequestTimeout applies.
+func (m *Msg) AckSync(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+	return m.m.AckSync(nats.Context(ctx))
+}
+
+// Nak asks for a JetStream message to be redelivered, after delay if given.
+func (m *Msg) Nak(delay ...time.Duration) error {
+	if len(delay) > 0 && delay[0] > 0 {
+		return m.m.NakWithDelay(delay[0])
+	}
+	return m.m.Nak()
+}
+
+// InProgress resets the ack wait, for handlers that take a while.
+func (m *Msg) InProgress() error {
+	return m.m.InProgress()
+}
+
+// Term stops redelivery for good, reason shows up in the server's
+// advisory.
+func (m *Msg) Term(reason string) error {
+	if reason == "" {
+		return m.m.Term()
+	}
+	return m.m.Respond([]byte("+TERM " + reason))
+}
+
+var defaultCodecs = mustCodecs(&ConnectOptions{DefaultCodec: JSONContentType})
+
+func mustCodecs(o *ConnectOptions) *codecs {
+	cs, err := newCodecs(o)
+	if err != nil {
+		panic(err)
+	}
+	return cs
+}
+
+// handler adapts a user handler for the low level client.
+// handler hands o's Handler messages with ctx, see context.go.
+func (c *conn) handler(ctx context.Context, o *SubOptions) nats.MsgHandler {
+	h := o.Handler
+	if h == nil {
+		return nil
+	}
+	return func(m *nats.Msg) {
+		msg := c.wrap(m)
+		msg.hctx, msg.sopts = ctx, o
+		h(msg)
+	}
+}
+
+// channelHandler delivers on ch, see chan.go for when it is full.
+func (c *conn) channelHandler(ch chan *Msg, policy OverflowPolicy) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		deliver(ch, c.wrap(m), policy, func(d *Msg) {
+			err := fmt.Errorf("%w: %q", ErrChannelFull, d.Subject())
+			c.handleError(err)
+			d.fail("503", err)
+		})
+	}
+}
+
+// RespondError sends err as a service error with code, which the requester
+// gets back as a *RequestError. A *RequestError keeps its own code and
+// description if code is empty.
+func (m *Msg) RespondError(code string, err error) error {
+	m.err = err
+	reply := errorReply(code, err)
+	if m.c == nil {
+		return m.respondNATS(reply)
+	}
+	return m.c.respondMsg(m.m, reply)
+}
+
+// RespondJSON replies with v as JSON, whatever the request Accepts.
+func (m *Msg) RespondJSON(v interface{}) error {
+	if m.c == nil {
+		return m.Respond(JSON(v))
+	}
+	return m.c.Respond(m, JSON(v))
+}
+
+// respondMsg sends reply to req through the publish interceptors.
+func (c *conn) respondMsg(req, reply *nats.Msg) error {
+	return c.sendReply(req, reply, 0)
+}
+
+// sendReply is the one way out for replies, chunked ones included, in
+// chunks of size if that is more than 0, see chunked.go.
+func (c *conn) sendReply(req, reply *nats.Msg, size int) error {
+	to := replyTo(req)
+	if to == "" {
+		return nats.ErrMsgNoReply
+	}
+	reply.Subject = to
+	timeHandler(req, reply)
+	ctx := correlatedBy(context.Background(), req)
+	if ok, err := c.respondStored(ctx, req, reply); ok {
+		return err
+	}
+	if size <= 0 {
+		return c.send(ctx, reply, c.publish)
+	}
+	for _, chunk := range chunks(reply, size) {
+		if err := c.send(ctx, chunk, c.publish); err != nil {
+			return err
+		}
+	}
+	return nil
+}
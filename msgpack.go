@@ -0,0 +1,24 @@
+package natsv2
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgPack for services that want something compact without a schema.
+// Returns the error directly, unlike the Payload helpers.
+
+const MsgPackContentType = "application/msgpack"
+
+func MsgPack(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func DecodeMsgPack(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string                     { return MsgPackContentType }
+func (msgpackCodec) Encode(v interface{}) ([]byte, error)    { return MsgPack(v) }
+func (msgpackCodec) Decode(data []byte, v interface{}) error { return DecodeMsgPack(data, v) }
@@ -0,0 +1,135 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A MsgRouter is Handle for plain messages: handlers by subject pattern, with
+// the wildcard tokens on the Msg by name, all behind one subscription. The
+// patterns are the same as for Handle, see router.go.
+//
+//	r := NewMsgRouter()
+//	r.Handle("orders.{orderID}.created", func(msg *Msg) {
+//		id := msg.Param("orderID")
+//	})
+//	r.Handle("orders.*.cancelled", cancelled) // msg.Param("1")
+//	r.Handle("orders.>", audit)
+//	sub, err := r.Subscribe(nc, Queue("orders"))
+//
+// Each message goes to the one handler with the most specific pattern,
+// literal tokens beat '*' beat '>' from the left. Messages no pattern
+// matches go to NotFound, or are dropped. Subscribe listens on the narrowest
+// subject that covers the patterns there are at the time, patterns added
+// later are only seen if it covers them too.
+
+type MsgRouter struct {
+	mu       sync.RWMutex
+	trie     SubjectTrie[*msgRoute]
+	patterns []*route
+	notFound func(*Msg)
+}
+
+type msgRoute struct {
+	rt      *route
+	handler func(*Msg)
+}
+
+func NewMsgRouter() *MsgRouter {
+	return &MsgRouter{}
+}
+
+func (r *MsgRouter) Handle(pattern string, handler func(*Msg)) error {
+	if handler == nil {
+		return errors.New("natsv2: nil handler")
+	}
+	rt, err := newRoute(pattern, nil)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if mr, ok := r.trie.Get(rt.subject()); ok {
+		return fmt.Errorf("natsv2: pattern %q overlaps existing route %q", pattern, mr.rt.pattern)
+	}
+	if err := r.trie.Insert(rt.subject(), &msgRoute{rt: rt, handler: handler}); err != nil {
+		return err
+	}
+	r.patterns = append(r.patterns, rt)
+	return nil
+}
+
+// NotFound handles the messages no pattern matches.
+func (r *MsgRouter) NotFound(handler func(*Msg)) {
+	r.mu.Lock()
+	r.notFound = handler
+	r.mu.Unlock()
+}
+
+// ServeMsg is the router as a Handler.
+func (r *MsgRouter) ServeMsg(m *Msg) {
+	r.mu.RLock()
+	mr, _, ok := r.trie.Lookup(m.Subject())
+	notFound := r.notFound
+	r.mu.RUnlock()
+	if !ok {
+		if notFound != nil {
+			notFound(m)
+		}
+		return
+	}
+	m.params, _ = mr.rt.match(m.Subject())
+	mr.handler(m)
+}
+
+// Subject is the narrowest subject all the patterns are in.
+func (r *MsgRouter) Subject() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.patterns) == 0 {
+		return ""
+	}
+	shortest, same := len(r.patterns[0].tokens), true
+	for _, rt := range r.patterns[1:] {
+		if len(rt.tokens) != shortest {
+			same = false
+		}
+		if len(rt.tokens) < shortest {
+			shortest = len(rt.tokens)
+		}
+	}
+	var tokens []string
+	for i := 0; i < shortest; i++ {
+		tok, fwc := r.patterns[0].tokens[i], false
+		for _, rt := range r.patterns {
+			if rt.tokens[i] != tok {
+				tok = "*"
+			}
+			fwc = fwc || rt.kinds[i] == fwcToken
+		}
+		// A shorter pattern ends here, only '>' takes both it and longer ones.
+		if fwc || !same && i == shortest-1 {
+			tokens = append(tokens, ">")
+			break
+		}
+		tokens = append(tokens, tok)
+	}
+	return strings.Join(tokens, ".")
+}
+
+// Subscribe subscribes nc on Subject with the router as the handler.
+func (r *MsgRouter) Subscribe(nc Connection, opts ...SubOption) (Subscription, error) {
+	subject := r.Subject()
+	if subject == "" {
+		return nil, errors.New("natsv2: router has no patterns")
+	}
+	return nc.Subscribe(subject, append(opts, Handler(r.ServeMsg))...)
+}
+
+// Param is a wildcard token of a message from a MsgRouter, by the name in
+// the pattern or its number for a plain '*'.
+func (m *Msg) Param(name string) string {
+	return m.params[name]
+}
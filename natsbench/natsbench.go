@@ -0,0 +1,421 @@
+// Package natsbench measures what natsv2 costs, throughput and latency
+// percentiles for publishing, publish to subscribe and requests, with the
+// payloads going through a codec or not, so claims about the encoding path
+// can be checked against numbers.
+//
+//	r, err := natsbench.Run(ctx, natsbench.Config{URL: url, Mode: natsbench.Request, Msgs: 100000, Size: 1024, Clients: 8, Codec: natsv2.MsgPackContentType})
+//	natsbench.WriteJSON(os.Stdout, []*natsbench.Result{r})
+//
+// Every client, subscriber and responder has a connection of its own. With
+// a codec, values carrying Size bytes are encoded on the way out and decoded
+// on the way in, without one the payload is Size raw bytes. Latency is per
+// call for Pub and Request, and from publish to delivery for PubSub, where
+// it is measured with the send time the payload carries.
+//
+// cmd/natsv2-bench runs these from the command line.
+package natsbench
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nuid"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+type Mode string
+
+const (
+	Pub     Mode = "pub"
+	PubSub  Mode = "pubsub"
+	Request Mode = "req"
+)
+
+const (
+	DefaultMsgs = 100000
+	DefaultSize = 128
+	// A PubSub run is over once nothing has arrived for this long, with the
+	// rest counted as lost.
+	DefaultIdle = 5 * time.Second
+)
+
+type Config struct {
+	URL            string
+	ConnectOptions []natsv2.ConnectOption
+	Mode           Mode
+	// A fresh one under "natsbench." if empty.
+	Subject string
+	// In total, split over the clients.
+	Msgs int
+	// Payload bytes, at least 8 raw.
+	Size int
+	// Publishers or requesters, 1 if 0.
+	Clients int
+	// For PubSub, each gets every message, 1 if 0.
+	Subscribers int
+	// Content type of the codec, raw bytes if empty.
+	Codec string
+	// Messages a second over all clients, as fast as they go if 0. Latency
+	// flat out is mostly queueing, PubSub's in particular.
+	Rate int
+	Idle time.Duration
+}
+
+type Result struct {
+	Mode        Mode          `json:"mode"`
+	Codec       string        `json:"codec"`
+	Size        int           `json:"size"`
+	Clients     int           `json:"clients"`
+	Subscribers int           `json:"subscribers,omitempty"`
+	Rate        int           `json:"rate,omitempty"`
+	Msgs        int           `json:"msgs"`
+	Errors      int           `json:"errors"`
+	Lost        int           `json:"lost"`
+	Elapsed     time.Duration `json:"elapsed_ns"`
+	MsgsPerSec  float64       `json:"msgs_per_sec"`
+	BytesPerSec float64       `json:"bytes_per_sec"`
+	Latency     Latency       `json:"latency_ns"`
+}
+
+type Latency struct {
+	Min  time.Duration `json:"min"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+// payload is what goes through a codec.
+type payload struct {
+	Sent int64  `json:"sent" msgpack:"sent" cbor:"sent"`
+	Body string `json:"body" msgpack:"body" cbor:"body"`
+}
+
+func (cfg *Config) defaults() error {
+	switch cfg.Mode {
+	case Pub, PubSub, Request:
+	default:
+		return fmt.Errorf("natsbench: unknown mode %q", cfg.Mode)
+	}
+	if cfg.Msgs == 0 {
+		cfg.Msgs = DefaultMsgs
+	}
+	if cfg.Size == 0 {
+		cfg.Size = DefaultSize
+	}
+	if cfg.Clients == 0 {
+		cfg.Clients = 1
+	}
+	if cfg.Subscribers == 0 {
+		cfg.Subscribers = 1
+	}
+	if cfg.Idle == 0 {
+		cfg.Idle = DefaultIdle
+	}
+	if cfg.Msgs < 0 || cfg.Size < 0 || cfg.Clients < 0 || cfg.Subscribers < 0 || cfg.Rate < 0 {
+		return errors.New("natsbench: negative config")
+	}
+	if cfg.Codec == "" && cfg.Size < 8 {
+		cfg.Size = 8
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = "natsbench." + nuid.Next()
+	}
+	return nil
+}
+
+type bench struct {
+	cfg  Config
+	body string
+	raw  []byte
+}
+
+// Run runs cfg to completion or ctx being done.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if err := cfg.defaults(); err != nil {
+		return nil, err
+	}
+	b := &bench{cfg: cfg}
+	if cfg.Codec != "" {
+		b.body = strings.Repeat("x", cfg.Size)
+	} else {
+		b.raw = make([]byte, cfg.Size)
+	}
+	opts := cfg.ConnectOptions
+	if cfg.Codec != "" {
+		opts = append(append([]natsv2.ConnectOption{}, opts...), natsv2.WithDefaultCodec(cfg.Codec))
+	}
+	var conns []natsv2.Connection
+	defer func() {
+		for _, nc := range conns {
+			nc.Close()
+		}
+	}()
+	connect := func() (natsv2.Connection, error) {
+		nc, err := natsv2.Connect(cfg.URL, opts...)
+		if err == nil {
+			conns = append(conns, nc)
+		}
+		return nc, err
+	}
+	clients := make([]natsv2.Connection, cfg.Clients)
+	for i := range clients {
+		nc, err := connect()
+		if err != nil {
+			return nil, err
+		}
+		clients[i] = nc
+	}
+
+	r := &Result{Mode: cfg.Mode, Codec: cfg.Codec, Size: cfg.Size, Clients: cfg.Clients, Rate: cfg.Rate, Msgs: cfg.Msgs}
+	var samples []time.Duration
+	var err error
+	switch cfg.Mode {
+	case Pub:
+		samples, err = b.pub(ctx, r, clients)
+	case PubSub:
+		r.Subscribers = cfg.Subscribers
+		subs := make([]natsv2.Connection, cfg.Subscribers)
+		for i := range subs {
+			if subs[i], err = connect(); err != nil {
+				return nil, err
+			}
+		}
+		samples, err = b.pubSub(ctx, r, clients, subs)
+	case Request:
+		var responder natsv2.Connection
+		if responder, err = connect(); err != nil {
+			return nil, err
+		}
+		samples, err = b.request(ctx, r, clients, responder)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n := len(samples)
+	if r.Elapsed > 0 {
+		r.MsgsPerSec = float64(n) / r.Elapsed.Seconds()
+		r.BytesPerSec = r.MsgsPerSec * float64(cfg.Size)
+	}
+	r.Latency = percentiles(samples)
+	return r, nil
+}
+
+// share is client i's part of the messages.
+func (b *bench) share(i int) int {
+	n := b.cfg.Msgs / b.cfg.Clients
+	if i < b.cfg.Msgs%b.cfg.Clients {
+		n++
+	}
+	return n
+}
+
+func (b *bench) value(sent time.Time) interface{} {
+	if b.cfg.Codec != "" {
+		return &payload{Sent: sent.UnixNano(), Body: b.body}
+	}
+	data := make([]byte, len(b.raw))
+	binary.BigEndian.PutUint64(data, uint64(sent.UnixNano()))
+	return data
+}
+
+func (b *bench) sent(m *natsv2.Msg) (time.Time, error) {
+	if b.cfg.Codec != "" {
+		var p payload
+		if err := m.Decode(&p); err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, p.Sent), nil
+	}
+	if len(m.Data()) < 8 {
+		return time.Time{}, errors.New("natsbench: short payload")
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(m.Data()))), nil
+}
+
+// clients runs fn for each client's share of the messages, the samples
+// it returns merged.
+func (b *bench) clients(ctx context.Context, r *Result, clients []natsv2.Connection, fn func(nc natsv2.Connection) (time.Duration, error)) []time.Duration {
+	var mu sync.Mutex
+	var all []time.Duration
+	var wg sync.WaitGroup
+	for i, nc := range clients {
+		wg.Add(1)
+		go func(nc natsv2.Connection, n int) {
+			defer wg.Done()
+			samples := make([]time.Duration, 0, n)
+			errs := 0
+			var every time.Duration
+			if b.cfg.Rate > 0 {
+				every = time.Duration(len(clients)) * time.Second / time.Duration(b.cfg.Rate)
+			}
+			start := time.Now()
+			for j := 0; j < n && ctx.Err() == nil; j++ {
+				if every > 0 {
+					if d := time.Until(start.Add(time.Duration(j) * every)); d > 0 {
+						time.Sleep(d)
+					}
+				}
+				d, err := fn(nc)
+				if err != nil {
+					errs++
+					continue
+				}
+				if d >= 0 {
+					samples = append(samples, d)
+				}
+			}
+			mu.Lock()
+			all = append(all, samples...)
+			r.Errors += errs
+			mu.Unlock()
+		}(nc, b.share(i))
+	}
+	wg.Wait()
+	return all
+}
+
+func (b *bench) pub(ctx context.Context, r *Result, clients []natsv2.Connection) ([]time.Duration, error) {
+	start := time.Now()
+	samples := b.clients(ctx, r, clients, func(nc natsv2.Connection) (time.Duration, error) {
+		t := time.Now()
+		err := nc.Publish(b.cfg.Subject, b.value(t))
+		return time.Since(t), err
+	})
+	for _, nc := range clients {
+		if err := nc.Flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+	r.Elapsed = time.Since(start)
+	return samples, ctx.Err()
+}
+
+func (b *bench) pubSub(ctx context.Context, r *Result, clients, subs []natsv2.Connection) ([]time.Duration, error) {
+	var mu sync.Mutex
+	var samples []time.Duration
+	var received, errs atomic.Int64
+	want := int64(b.cfg.Msgs * len(subs))
+	done := make(chan struct{})
+	var last atomic.Int64
+	for _, nc := range subs {
+		sub, err := nc.Subscribe(b.cfg.Subject, natsv2.Handler(func(m *natsv2.Msg) {
+			now := time.Now()
+			last.Store(now.UnixNano())
+			if sent, err := b.sent(m); err != nil {
+				errs.Add(1)
+			} else {
+				mu.Lock()
+				samples = append(samples, now.Sub(sent))
+				mu.Unlock()
+			}
+			if received.Add(1) == want {
+				close(done)
+			}
+		}))
+		if err != nil {
+			return nil, err
+		}
+		defer sub.Close()
+		if err := nc.Flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	b.clients(ctx, r, clients, func(nc natsv2.Connection) (time.Duration, error) {
+		return -1, nc.Publish(b.cfg.Subject, b.value(time.Now()))
+	})
+	for _, nc := range clients {
+		nc.Flush(ctx)
+	}
+	last.Store(time.Now().UnixNano())
+	idle := time.NewTicker(b.cfg.Idle / 10)
+	defer idle.Stop()
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case <-ctx.Done():
+			break wait
+		case <-idle.C:
+			if time.Since(time.Unix(0, last.Load())) > b.cfg.Idle {
+				break wait
+			}
+		}
+	}
+	r.Elapsed = time.Unix(0, last.Load()).Sub(start)
+	mu.Lock()
+	defer mu.Unlock()
+	r.Errors += int(errs.Load())
+	r.Lost = int(want - received.Load())
+	return append([]time.Duration(nil), samples...), ctx.Err()
+}
+
+func (b *bench) request(ctx context.Context, r *Result, clients []natsv2.Connection, responder natsv2.Connection) ([]time.Duration, error) {
+	sub, err := responder.Subscribe(b.cfg.Subject, natsv2.Handler(func(m *natsv2.Msg) {
+		if b.cfg.Codec == "" {
+			m.Respond(m.Data())
+			return
+		}
+		var p payload
+		if err := m.Decode(&p); err != nil {
+			m.RespondError("400", err)
+			return
+		}
+		m.Respond(&p)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+	if err := responder.Flush(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	samples := b.clients(ctx, r, clients, func(nc natsv2.Connection) (time.Duration, error) {
+		t := time.Now()
+		reply, err := nc.Request(b.cfg.Subject, b.value(t), natsv2.Ctx(ctx))
+		if err != nil {
+			return 0, err
+		}
+		if _, err := b.sent(reply); err != nil {
+			return 0, err
+		}
+		return time.Since(t), nil
+	})
+	r.Elapsed = time.Since(start)
+	return samples, ctx.Err()
+}
+
+func percentiles(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	at := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(samples)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return samples[i]
+	}
+	return Latency{
+		Min:  samples[0],
+		P50:  at(0.5),
+		P90:  at(0.9),
+		P99:  at(0.99),
+		P999: at(0.999),
+		Max:  samples[len(samples)-1],
+	}
+}
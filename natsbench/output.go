@@ -0,0 +1,54 @@
+package natsbench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// WriteJSON writes results as a JSON array, durations in nanoseconds.
+func WriteJSON(w io.Writer, results []*Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+var csvHeader = []string{"mode", "codec", "size", "clients", "subscribers", "rate", "msgs", "errors", "lost",
+	"elapsed_ns", "msgs_per_sec", "bytes_per_sec", "min_ns", "p50_ns", "p90_ns", "p99_ns", "p999_ns", "max_ns"}
+
+// WriteCSV writes results with a header row, durations in nanoseconds.
+func WriteCSV(w io.Writer, results []*Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, r := range results {
+		l := r.Latency
+		cw.Write([]string{string(r.Mode), r.Codec, strconv.Itoa(r.Size), strconv.Itoa(r.Clients), strconv.Itoa(r.Subscribers), strconv.Itoa(r.Rate),
+			strconv.Itoa(r.Msgs), strconv.Itoa(r.Errors), strconv.Itoa(r.Lost), ns(r.Elapsed),
+			strconv.FormatFloat(r.MsgsPerSec, 'f', 0, 64), strconv.FormatFloat(r.BytesPerSec, 'f', 0, 64),
+			ns(l.Min), ns(l.P50), ns(l.P90), ns(l.P99), ns(l.P999), ns(l.Max)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteText writes results as a table for people.
+func WriteText(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "mode\tcodec\tsize\tclients\tmsgs/s\tMB/s\tp50\tp99\tp99.9\tmax\terrors\tlost\t")
+	for _, r := range results {
+		codec := r.Codec
+		if codec == "" {
+			codec = "raw"
+		}
+		l := r.Latency
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.0f\t%.1f\t%v\t%v\t%v\t%v\t%d\t%d\t\n", r.Mode, codec, r.Size, r.Clients,
+			r.MsgsPerSec, r.BytesPerSec/1e6, l.P50, l.P99, l.P999, l.Max, r.Errors, r.Lost)
+	}
+	return tw.Flush()
+}
+
+func ns(d time.Duration) string { return strconv.FormatInt(int64(d), 10) }
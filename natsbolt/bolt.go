@@ -0,0 +1,100 @@
+// Package natsbolt is a natsv2.OutboxStore in a bbolt database. It is its
+// own package so bbolt is only linked in by those who use it.
+//
+//	db, err := bbolt.Open("outbox.db", 0o600, nil)
+//	ob, err := natsv2.NewOutbox(nc, natsbolt.New(db))
+//
+// AddTx adds messages in a bbolt transaction of yours, so they are only
+// there if the rest of it is.
+package natsbolt
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.etcd.io/bbolt"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+const DefaultBucket = "natsv2-outbox"
+
+type Store struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+var _ natsv2.OutboxStore = (*Store)(nil)
+
+func New(db *bbolt.DB) *Store {
+	return NewBucket(db, DefaultBucket)
+}
+
+func NewBucket(db *bbolt.DB, bucket string) *Store {
+	return &Store{db: db, bucket: []byte(bucket)}
+}
+
+// Messages are kept by id, which sorts by time, as JSON.
+type record struct {
+	Subject string              `json:"subject"`
+	Header  map[string][]string `json:"header,omitempty"`
+	Data    []byte              `json:"data,omitempty"`
+}
+
+func (s *Store) Add(ctx context.Context, msgs ...natsv2.OutboxMsg) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return s.AddTx(tx, msgs...)
+	})
+}
+
+func (s *Store) AddTx(tx *bbolt.Tx, msgs ...natsv2.OutboxMsg) error {
+	b, err := tx.CreateBucketIfNotExists(s.bucket)
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		data, err := json.Marshal(record{Subject: m.Subject, Header: m.Header, Data: m.Data})
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(m.ID), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Pending(ctx context.Context, max int) ([]natsv2.OutboxMsg, error) {
+	var msgs []natsv2.OutboxMsg
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && len(msgs) < max; k, v = c.Next() {
+			var r record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			msgs = append(msgs, natsv2.OutboxMsg{ID: string(k), Subject: r.Subject, Header: r.Header, Data: r.Data})
+		}
+		return nil
+	})
+	return msgs, err
+}
+
+func (s *Store) Delete(ctx context.Context, ids ...string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		if b == nil {
+			return nil
+		}
+		for _, id := range ids {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
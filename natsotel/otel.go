@@ -0,0 +1,138 @@
+// Package natsotel adds OpenTelemetry tracing to a natsv2 connection. It is
+// its own package so the OpenTelemetry modules are only linked in by those
+// who use it.
+//
+//	nc, err := natsv2.Connect(url, natsotel.WithTracing())
+//
+// Publishes and requests get a producer span named "<subject> publish" and
+// carry its context in the message headers. Handlers run inside a consumer
+// span "<subject> process" that continues the sender's trace.
+package natsotel
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+const tracerName = "github.com/derekcollison/natsv2.go/natsotel"
+
+type Option func(*options)
+
+type options struct {
+	tp         trace.TracerProvider
+	propagator propagation.TextMapPropagator
+}
+
+// WithTracerProvider defaults to the global one.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tp = tp
+	}
+}
+
+// WithPropagator defaults to the global one.
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(o *options) {
+		o.propagator = p
+	}
+}
+
+// WithTracing registers the tracing interceptors on the connection.
+func WithTracing(opts ...Option) natsv2.ConnectOption {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return func(co *natsv2.ConnectOptions) error {
+		if o.tp == nil {
+			o.tp = otel.GetTracerProvider()
+		}
+		if o.propagator == nil {
+			o.propagator = otel.GetTextMapPropagator()
+		}
+		t := &tracing{tracer: o.tp.Tracer(tracerName), propagator: o.propagator}
+		co.PublishInterceptors = append(co.PublishInterceptors, t.publish)
+		co.SubscribeInterceptors = append(co.SubscribeInterceptors, t.process)
+		return nil
+	}
+}
+
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func (t *tracing) publish(ctx context.Context, m *nats.Msg, next natsv2.PublishFunc) error {
+	ctx, span := t.tracer.Start(ctx, m.Subject+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attributes(m)...))
+	defer span.End()
+	t.propagator.Inject(ctx, HeaderCarrier(m.Header))
+	err := next(ctx, m)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+func (t *tracing) process(m *nats.Msg, next nats.MsgHandler) {
+	ctx := t.propagator.Extract(context.Background(), HeaderCarrier(m.Header))
+	attrs := attributes(m)
+	if m.Sub != nil && m.Sub.Queue != "" {
+		attrs = append(attrs, attribute.String("messaging.consumer.group.name", m.Sub.Queue))
+	}
+	ctx, span := t.tracer.Start(ctx, m.Subject+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+	// Handlers can't be handed ctx, so the process span goes in the headers
+	// for Extract to find.
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	t.propagator.Inject(ctx, HeaderCarrier(m.Header))
+	next(m)
+}
+
+func attributes(m *nats.Msg) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "nats"),
+		attribute.String("messaging.destination.name", m.Subject),
+		attribute.Int("messaging.message.body.size", len(m.Data)),
+	}
+}
+
+// Extract returns ctx carrying the span from m, inside a traced handler that's
+// the process span, so spans started from it nest under the delivery. It uses
+// the global propagator.
+func Extract(ctx context.Context, m *natsv2.Msg) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, HeaderCarrier(m.Header()))
+}
+
+// HeaderCarrier lets a propagator read and write NATS headers.
+type HeaderCarrier nats.Header
+
+func (h HeaderCarrier) Get(key string) string {
+	return nats.Header(h).Get(key)
+}
+
+func (h HeaderCarrier) Set(key, value string) {
+	nats.Header(h).Set(key, value)
+}
+
+func (h HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
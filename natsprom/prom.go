@@ -0,0 +1,219 @@
+// Package natsprom exposes natsv2 connection metrics to Prometheus. It is its
+// own package so client_golang is only linked in by those who use it.
+//
+//	m := natsprom.New()
+//	prometheus.MustRegister(m)
+//	nc, err := natsv2.Connect(url, natsv2.WithMetrics(m))
+//
+// Subjects are cut down to their first few tokens for labels, two unless set
+// with SubjectTokens, so "orders.new.eu" counts as "orders.new" and label
+// cardinality stays bounded. Reply inboxes all count as "_INBOX".
+package natsprom
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+const DefaultSubjectTokens = 2
+
+type Option func(*Collector)
+
+// Namespace prefixes the metric names, "natsv2" by default.
+func Namespace(ns string) Option {
+	return func(c *Collector) {
+		c.namespace = ns
+	}
+}
+
+func SubjectTokens(n int) Option {
+	return func(c *Collector) {
+		c.tokens = n
+	}
+}
+
+// Buckets for the request latency histogram, in seconds.
+func Buckets(buckets []float64) Option {
+	return func(c *Collector) {
+		c.buckets = buckets
+	}
+}
+
+// ConstLabels are added to every metric, e.g. to tell connections apart.
+func ConstLabels(labels prometheus.Labels) Option {
+	return func(c *Collector) {
+		c.labels = labels
+	}
+}
+
+// Collector is both a prometheus.Collector and a natsv2.Metrics.
+type Collector struct {
+	namespace string
+	tokens    int
+	buckets   []float64
+	labels    prometheus.Labels
+
+	published      *prometheus.CounterVec
+	publishedBytes *prometheus.CounterVec
+	received       *prometheus.CounterVec
+	receivedBytes  *prometheus.CounterVec
+	pendingMsgs    *prometheus.GaugeVec
+	pendingBytes   *prometheus.GaugeVec
+	requests       *prometheus.HistogramVec
+	network        *prometheus.HistogramVec
+	handler        *prometheus.HistogramVec
+	reconnects     prometheus.Counter
+	serviceErrors  *prometheus.CounterVec
+	decodeErrors   *prometheus.CounterVec
+	panics         *prometheus.CounterVec
+}
+
+var (
+	_ natsv2.Metrics        = (*Collector)(nil)
+	_ natsv2.LatencyMetrics = (*Collector)(nil)
+	_ natsv2.DecodeMetrics  = (*Collector)(nil)
+	_ natsv2.PanicMetrics   = (*Collector)(nil)
+)
+
+func New(opts ...Option) *Collector {
+	c := &Collector{namespace: "natsv2", tokens: DefaultSubjectTokens, buckets: prometheus.DefBuckets}
+	for _, opt := range opts {
+		opt(c)
+	}
+	counter := func(name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.namespace, Name: name, Help: help, ConstLabels: c.labels,
+		}, labels)
+	}
+	gauge := func(name, help string, labels ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: c.namespace, Name: name, Help: help, ConstLabels: c.labels,
+		}, labels)
+	}
+	c.published = counter("messages_published_total", "Messages published, by subject prefix.", "subject")
+	c.publishedBytes = counter("published_bytes_total", "Payload bytes published, by subject prefix.", "subject")
+	c.received = counter("messages_received_total", "Messages handled, by subject prefix.", "subject")
+	c.receivedBytes = counter("received_bytes_total", "Payload bytes handled, by subject prefix.", "subject")
+	c.pendingMsgs = gauge("pending_messages", "Messages waiting in the subscription, by subject prefix.", "subject")
+	c.pendingBytes = gauge("pending_bytes", "Bytes waiting in the subscription, by subject prefix.", "subject")
+	histogram := func(name, help string, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: c.namespace, Name: name, Help: help, ConstLabels: c.labels, Buckets: c.buckets,
+		}, labels)
+	}
+	c.requests = histogram("request_duration_seconds", "Request latency, by subject prefix and result.", "subject", "result")
+	c.network = histogram("request_network_seconds", "Request time outside the handler, for TrackLatency, by subject prefix.", "subject")
+	c.handler = histogram("request_handler_seconds", "Request time in the handler, for TrackLatency, by subject prefix.", "subject")
+	c.reconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: c.namespace, Name: "reconnects_total", Help: "Successful reconnects.", ConstLabels: c.labels,
+	})
+	c.serviceErrors = counter("service_errors_total", "Service handler failures, by service.", "service")
+	c.decodeErrors = counter("decode_errors_total", "Messages received that didn't decode, by subject prefix.", "subject")
+	c.panics = counter("handler_panics_total", "Handler panics, by subject prefix.", "subject")
+	return c
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.published, c.publishedBytes, c.received, c.receivedBytes,
+		c.pendingMsgs, c.pendingBytes, c.requests, c.network, c.handler, c.reconnects, c.serviceErrors,
+		c.decodeErrors, c.panics,
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.collectors() {
+		m.Describe(ch)
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.collectors() {
+		m.Collect(ch)
+	}
+}
+
+func (c *Collector) prefix(subject string) string {
+	if strings.HasPrefix(subject, "_INBOX.") {
+		return "_INBOX"
+	}
+	if c.tokens < 1 {
+		return subject
+	}
+	i := 0
+	for n := 0; n < c.tokens; n++ {
+		j := strings.IndexByte(subject[i:], '.')
+		if j < 0 {
+			return subject
+		}
+		i += j + 1
+	}
+	return subject[:i-1]
+}
+
+func (c *Collector) Published(subject string, bytes int) {
+	p := c.prefix(subject)
+	c.published.WithLabelValues(p).Inc()
+	c.publishedBytes.WithLabelValues(p).Add(float64(bytes))
+}
+
+func (c *Collector) Received(subject string, bytes int) {
+	p := c.prefix(subject)
+	c.received.WithLabelValues(p).Inc()
+	c.receivedBytes.WithLabelValues(p).Add(float64(bytes))
+}
+
+func (c *Collector) Pending(subject string, msgs, bytes int) {
+	p := c.prefix(subject)
+	c.pendingMsgs.WithLabelValues(p).Set(float64(msgs))
+	c.pendingBytes.WithLabelValues(p).Set(float64(bytes))
+}
+
+func (c *Collector) Requested(subject string, latency time.Duration, err error) {
+	result := "ok"
+	var rerr *natsv2.RequestError
+	switch {
+	case err == nil:
+	case errors.As(err, &rerr):
+		result = "service_error"
+	case errors.Is(err, natsv2.ErrTimeout):
+		result = "timeout"
+	case errors.Is(err, natsv2.ErrNoResponders):
+		result = "no_responders"
+	case errors.Is(err, natsv2.ErrBreakerOpen):
+		result = "breaker_open"
+	default:
+		result = "error"
+	}
+	c.requests.WithLabelValues(c.prefix(subject), result).Observe(latency.Seconds())
+}
+
+// RequestLatency only counts the handler if the responder said.
+func (c *Collector) RequestLatency(subject string, l natsv2.Latency) {
+	p := c.prefix(subject)
+	c.network.WithLabelValues(p).Observe(l.Network().Seconds())
+	if l.Handler > 0 {
+		c.handler.WithLabelValues(p).Observe(l.Handler.Seconds())
+	}
+}
+
+func (c *Collector) Reconnected() {
+	c.reconnects.Inc()
+}
+
+func (c *Collector) ServiceError(service string) {
+	c.serviceErrors.WithLabelValues(service).Inc()
+}
+
+func (c *Collector) DecodeFailed(subject string) {
+	c.decodeErrors.WithLabelValues(c.prefix(subject)).Inc()
+}
+
+func (c *Collector) Panicked(subject string) {
+	c.panics.WithLabelValues(c.prefix(subject)).Inc()
+}
@@ -0,0 +1,125 @@
+// Package natssql is a natsv2.OutboxStore in a database/sql table, written
+// for SQLite. Bring your own driver, this package doesn't import one.
+//
+//	db, err := sql.Open("sqlite", "app.db")
+//	store := natssql.New(db, "outbox")
+//	err = store.CreateTable(ctx)
+//	ob, err := natsv2.NewOutbox(nc, store)
+//
+// AddTx adds messages in a transaction of yours, so they commit or roll
+// back with the rows they are about:
+//
+//	tx, err := db.BeginTx(ctx, nil)
+//	_, err = tx.ExecContext(ctx, "INSERT INTO orders ...")
+//	msg, err := ob.Message("orders.placed", order)
+//	err = store.AddTx(ctx, tx, msg)
+//	err = tx.Commit()
+//	ob.Notify()
+//
+// The statements use ? placeholders, so MySQL works as well.
+package natssql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+type Store struct {
+	db    *sql.DB
+	table string
+}
+
+var _ natsv2.OutboxStore = (*Store)(nil)
+
+// New uses table, which isn't quoted so has to be a plain name.
+func New(db *sql.DB, table string) *Store {
+	return &Store{db: db, table: table}
+}
+
+func (s *Store) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id VARCHAR(64) PRIMARY KEY,
+	subject TEXT NOT NULL,
+	header TEXT,
+	data BLOB
+)`, s.table))
+	return err
+}
+
+// Execer is a *sql.Tx, or a *sql.DB or *sql.Conn.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (s *Store) Add(ctx context.Context, msgs ...natsv2.OutboxMsg) error {
+	if len(msgs) == 1 {
+		return s.AddTx(ctx, s.db, msgs...)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.AddTx(ctx, tx, msgs...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) AddTx(ctx context.Context, tx Execer, msgs ...natsv2.OutboxMsg) error {
+	query := fmt.Sprintf("INSERT INTO %s (id, subject, header, data) VALUES (?, ?, ?, ?)", s.table)
+	for _, m := range msgs {
+		var header []byte
+		if len(m.Header) > 0 {
+			var err error
+			if header, err = json.Marshal(m.Header); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, query, m.ID, m.Subject, string(header), m.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Pending(ctx context.Context, max int) ([]natsv2.OutboxMsg, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT id, subject, header, data FROM %s ORDER BY id LIMIT ?", s.table), max)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var msgs []natsv2.OutboxMsg
+	for rows.Next() {
+		var m natsv2.OutboxMsg
+		var header sql.NullString
+		if err := rows.Scan(&m.ID, &m.Subject, &header, &m.Data); err != nil {
+			return nil, err
+		}
+		if header.String != "" {
+			if err := json.Unmarshal([]byte(header.String), &m.Header); err != nil {
+				return nil, fmt.Errorf("natssql: header of %s: %w", m.ID, err)
+			}
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *Store) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE id IN (?%s)", s.table, strings.Repeat(", ?", len(ids)-1))
+	_, err := s.db.ExecContext(ctx, query, args...)
+	return err
+}
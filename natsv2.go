@@ -0,0 +1,1038 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+type Connection interface {
+	Publish(string, interface{}, ...PubOption) error
+	PublishCtx(context.Context, string, interface{}, ...PubOption) error
+	// See buffer.go.
+	PublishTo(string, func(io.Writer) error, ...PubOption) error
+	PublishToCtx(context.Context, string, func(io.Writer) error, ...PubOption) error
+	PublishSync(context.Context, string, interface{}, ...PubOption) (time.Duration, error)
+	PublishBatch(string, []interface{}, ...BatchOption) error
+	PublishBatchMsgs([]BatchMsg, ...BatchOption) error
+	Batch(...BatchOption) Batch
+	Subscribe(string, ...SubOption) (Subscription, error)
+	SubscribeMulti([]string, ...SubOption) (Subscription, error)
+	// See subscribemany.go.
+	SubscribeMany([]string, ...SubOption) (*SubscriptionSet, error)
+	PullChannel(stream, consumer string, batch int, opts ...SubOption) (<-chan *Msg, func(), error)
+	Request(string, interface{}, ...ReqOption) (*Msg, error)
+	RequestAll(string, interface{}, ...ReqOption) ([]*Msg, error)
+	Stream(string, ...StreamOption) Stream
+	Service(name, version string, opts ...ServiceOption) (Service, error)
+	Handle(string, HTTPHandlerFunc, ...HandleOption) (Subscription, error)
+	Mount(string, http.Handler, ...Middleware) (Subscription, error)
+	RoundTrip(string, *http.Request) (*http.Response, error)
+	Decode(*Msg, interface{}) error
+	Respond(*Msg, interface{}) error
+	ReplyStream(*Msg) ReplyStream
+	KV(bucket string, opts ...KVOption) (KV, error)
+	JetStream() JetStreamManager
+	// See pull.go.
+	Consumer(stream, name string) (Consumer, error)
+	ObjectStore(bucket string, opts ...ObjectStoreOption) (ObjectStore, error)
+	// See schedule.go.
+	Scheduler() (Scheduler, error)
+	// See lock.go.
+	Lock(name string, opts ...LockOption) (*Semaphore, error)
+	Semaphore(name string, n int, opts ...LockOption) (*Semaphore, error)
+	Status() Status
+	// See tap.go.
+	Tap(pattern string, sink TapSink, opts ...TapOption) (*Tap, error)
+	// See credreload.go.
+	ReloadCredentials() error
+	// See permissions.go.
+	Can(subject string, op PermissionOp) (bool, error)
+	Permissions() (*Permissions, error)
+	// See registry.go.
+	Registry() *Registry
+	// See defaults.go.
+	RequestOptions(...ReqOption) (*ReqOptions, error)
+	StreamOptions(...StreamOption) (*StreamOptions, error)
+	ServiceOptions(name, version string, opts ...ServiceOption) (*ServiceOptions, error)
+	// See health.go.
+	Healthy(context.Context) error
+	Flush(context.Context) error
+	FlushTimeout(time.Duration) error
+	Drain(context.Context) error
+	// See shutdown.go.
+	Shutdown(context.Context, ...ShutdownOption) error
+	Close()
+}
+
+type HTTPHandlerFunc func(http.ResponseWriter, *http.Request)
+
+// Close is Unsubscribe without the error. Drain stops new messages but lets
+// the ones already received be handled, up to ctx being done, after which
+// we unsubscribe and return ctx.Err().
+type Subscription interface {
+	Close()
+	Unsubscribe() error
+	Drain(ctx context.Context) error
+	// See next.go.
+	Next(ctx context.Context) (*Msg, error)
+	// See pause.go.
+	Pause()
+	Resume()
+}
+
+type SubOption func(*SubOptions) error
+
+type SubOptions struct {
+	Queue   string
+	Handler func(*Msg)
+	Channel chan *Msg
+	// See chan.go.
+	Overflow OverflowPolicy
+
+	DeadLetter    string
+	MaxDeliveries int
+	// See quarantine.go.
+	Attempts     int
+	RetryBackoff time.Duration
+	Quarantine   *Quarantine
+	// See batchhandler.go.
+	batch *batcher
+
+	AutoAck bool
+	// See consumer.go.
+	Consumer *ConsumerOptions
+	stream   string
+	// See pull.go.
+	Fetch FetchOptions
+	bound bool
+
+	Decompress bool
+	// See decodeerror.go.
+	DecodeErrors  DecodeErrorPolicy
+	OnDecodeError func(*Msg, error)
+	// See panics.go.
+	Panics PanicPolicy
+
+	// See autounsub.go.
+	Max        int
+	Until      context.Context
+	Deadline   time.Time
+	OnComplete func(error)
+	auto       *autoUnsub
+
+	// See workers.go.
+	Workers        int
+	MaxInFlight    int
+	OrderBySubject bool
+	pool           *workerPool
+
+	// See slowconsumer.go.
+	PendingMsgs  int
+	PendingBytes int
+	SlowConsumer SlowConsumerPolicy
+	OnDrop       func(DropStats)
+	queue        *pendingQueue
+
+	// See priority.go.
+	Lanes    []PriorityLane
+	LaneFunc func(*Msg) string
+	lanes    *priorityQueue
+
+	// See limits.go.
+	MaxConcurrent int
+	RateLimit     int
+	Backpressure  bool
+	limits        *handlerLimits
+
+	// See dedupe.go.
+	DedupeWindow time.Duration
+	DedupeKey    func(*Msg) string
+	DedupeSize   int
+
+	// See ttl.go.
+	OnExpired func(*Msg)
+
+	// See partition.go.
+	Partitions     int
+	PartitionKey   func(*Msg) string
+	PartitionToken int
+	OnRebalance    func([]int)
+	member         *partitionMember
+
+	// See context.go.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// See shutdown.go.
+	subject string
+	// See pause.go.
+	pause *pauseGate
+	// See registry.go.
+	handlerName string
+	// See http.go.
+	route *route
+}
+
+func Queue(name string) SubOption {
+	return func(o *SubOptions) error {
+		if err := checkQueue(name); err != nil {
+			return err
+		}
+		o.Queue = name
+		return nil
+	}
+}
+
+func Handler(mcb func(*Msg)) SubOption {
+	return func(o *SubOptions) error {
+		o.Handler, o.handlerName = mcb, funcName(mcb)
+		return nil
+	}
+}
+
+// Channel delivers messages on ch instead of to a handler. When full it
+// blocks unless told otherwise with Overflow.
+func Channel(ch chan *Msg) SubOption {
+	return func(o *SubOptions) error {
+		o.Channel = ch
+		return nil
+	}
+}
+
+type PubOption func(*PubOptions) error
+
+type PubOptions struct {
+	Headers Header
+	// JetStream only, see jetstream.go.
+	MsgID        string
+	MsgIDFunc    func(interface{}) string
+	ContentMsgID bool
+	// See schedule.go.
+	Delay time.Duration
+	// See ttl.go.
+	TTL time.Duration
+}
+
+type ReqOption func(*ReqOptions) error
+
+type ReqOptions struct {
+	Headers Header
+	Timeout time.Duration
+	Context context.Context
+	Accept  []string
+	// See compress.go.
+	AcceptEncoding []string
+
+	// See chunked.go.
+	Chunked      bool
+	MaxReplySize int
+	// See streamed.go.
+	Streamed func(*Msg)
+	// See gather.go.
+	Gather *GatherOptions
+	// See retry.go.
+	Attempts int
+	Backoff  time.Duration
+	// See breaker.go.
+	Breaker *BreakerOptions
+	// See latency.go.
+	TrackLatency bool
+	// See cache.go.
+	CacheTTL   time.Duration
+	CacheStale time.Duration
+	// See jsrequest.go.
+	JetStream   string
+	ReplyStream string
+	ReplyPrefix string
+	RequestID   string
+}
+
+func Timeout(timeout time.Duration) ReqOption {
+	return func(o *ReqOptions) error {
+		o.Timeout = timeout
+		return nil
+	}
+}
+
+func Ctx(ctx context.Context) ReqOption {
+	return func(o *ReqOptions) error {
+		o.Context = ctx
+		return nil
+	}
+}
+
+// Request returns the first reply. A service error reply is returned along
+// with its *RequestError, see svcerr.go.
+func (c *conn) Request(subject string, msg interface{}, opts ...ReqOption) (*Msg, error) {
+	ropts, err := c.reqOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	c.log.Debug("request", "subject", subject, "timeout", ropts.Timeout)
+
+	m, err := c.codecs.encode(subject, msg, c.codecs.out)
+	if err != nil {
+		return nil, err
+	}
+	m.Subject = c.outSubject(subject)
+	if ropts.CacheTTL > 0 {
+		return c.cachedRequest(subject, m, ropts)
+	}
+	return c.sendRequest(subject, m, ropts)
+}
+
+func (c *conn) sendRequest(subject string, m *nats.Msg, ropts *ReqOptions) (*Msg, error) {
+	var b *breaker
+	if ropts.Breaker != nil {
+		b = c.breaker(subject, ropts.Breaker)
+		if !b.allow() {
+			err := fmt.Errorf("%w: %s", ErrBreakerOpen, subject)
+			c.metrics.Requested(subject, 0, err)
+			return nil, err
+		}
+	}
+	start := time.Now()
+	reply, err := c.requestRetry(ropts, m)
+	if err == nil {
+		err = requestError(subject, reply)
+	}
+	if b != nil {
+		b.record(err)
+	}
+	c.metrics.Requested(subject, time.Since(start), err)
+	r := c.wrap(reply)
+	if lm, ok := c.metrics.(LatencyMetrics); ok && ropts.TrackLatency && err == nil {
+		lm.RequestLatency(subject, r.Latency())
+	}
+	return r, err
+}
+
+func (o *ReqOptions) setAccept(m *nats.Msg) {
+	if len(o.Accept) == 0 && len(o.AcceptEncoding) == 0 {
+		return
+	}
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	if len(o.Accept) > 0 {
+		m.Header.Set(AcceptHeader, strings.Join(o.Accept, ", "))
+	}
+	if len(o.AcceptEncoding) > 0 {
+		m.Header.Set(AcceptEncodingHeader, strings.Join(o.AcceptEncoding, ", "))
+	}
+}
+
+func (c *conn) request(ropts *ReqOptions, m *nats.Msg) (*nats.Msg, error) {
+	if ropts.Gather != nil {
+		return nil, errors.New("natsv2: gathered replies need RequestAll")
+	}
+	ropts.setAccept(m)
+	setHeaders(m, ropts.Headers)
+	if ropts.TrackLatency {
+		if m.Header == nil {
+			m.Header = nats.Header{}
+		}
+		m.Header.Set(LatencyHeader, "1")
+	}
+	if ropts.Streamed != nil {
+		if ropts.Chunked {
+			return nil, errors.New("natsv2: streamed and chunked replies don't mix")
+		}
+		if ropts.JetStream != "" {
+			return nil, errors.New("natsv2: persisted requests can't be streamed")
+		}
+		return c.requestStreamed(ropts, m)
+	}
+
+	ctx, cancel := ropts.context()
+	defer cancel()
+	setDeadline(ctx, m)
+	if ropts.JetStream != "" {
+		return c.requestJetStream(ctx, ropts, m)
+	}
+	if ropts.Chunked {
+		max := ropts.MaxReplySize
+		if max == 0 {
+			max = DefaultMaxReplySize
+		}
+		return c.requestChunked(ctx, m, max)
+	}
+	return c.requestMsg(ctx, m)
+}
+
+func (c *conn) requestMsg(ctx context.Context, m *nats.Msg) (*nats.Msg, error) {
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	in, err := c.replyInbox()
+	if err != nil {
+		return nil, err
+	}
+	defer in.close()
+	m.Reply = in.Reply
+	sent := time.Now()
+	if err := c.send(ctx, m, c.publish); err != nil {
+		return nil, wrapRequestError(m.Subject, err)
+	}
+	reply, err := in.next(ctx)
+	if err != nil {
+		return nil, wrapRequestError(m.Subject, err)
+	}
+	if noResponders(reply) {
+		return nil, wrapRequestError(m.Subject, nats.ErrNoResponders)
+	}
+	if m.Header.Get(LatencyHeader) != "" {
+		timeReply(reply, sent)
+	}
+	if ok, err := c.verified(reply); !ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Requests that get no reply fail with one of these, errors.Is also still
+// matches the nats.go or context error underneath.
+var (
+	ErrNoResponders = errors.New("natsv2: no responders")
+	ErrTimeout      = errors.New("natsv2: request timed out")
+)
+
+type requestFailure struct {
+	subject string
+	kind    error
+	err     error
+}
+
+func (e *requestFailure) Error() string {
+	return fmt.Sprintf("%v on %q: %v", e.kind, e.subject, e.err)
+}
+
+func (e *requestFailure) Is(target error) bool { return target == e.kind }
+func (e *requestFailure) Unwrap() error        { return e.err }
+
+func wrapRequestError(subject string, err error) error {
+	switch {
+	case errors.Is(err, nats.ErrNoResponders):
+		return &requestFailure{subject, ErrNoResponders, err}
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, nats.ErrTimeout):
+		return &requestFailure{subject, ErrTimeout, err}
+	}
+	return err
+}
+
+// Used when neither Timeout nor a context with a deadline is given.
+const DefaultRequestTimeout = 2 * time.Second
+
+func (o *ReqOptions) context() (context.Context, context.CancelFunc) {
+	ctx := o.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if o.Timeout > 0 {
+		return context.WithTimeout(ctx, o.Timeout)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return context.WithTimeout(ctx, DefaultRequestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (c *conn) Subscribe(subject string, opts ...SubOption) (Subscription, error) {
+	if err := checkSubject(subject, true); err != nil {
+		return nil, err
+	}
+	sopts := &SubOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, err
+		}
+	}
+	if sopts.Partitions > 0 {
+		return c.subscribePartitioned(subject, sopts)
+	}
+	return c.subscribeWith(c.outSubject(subject), sopts)
+}
+
+func (c *conn) subscribeWith(subject string, sopts *SubOptions) (Subscription, error) {
+	c.log.Debug("subscribe", "subject", subject, "queue", sopts.Queue)
+
+	sopts.ctx, sopts.cancel = context.WithCancel(c.hctx)
+	sopts.subject = subject
+	sopts.pause = &pauseGate{}
+	s, err := c.subscribeHandler(subject, sopts)
+	if err != nil {
+		sopts.cancel()
+		return nil, err
+	}
+	c.subs.Store(sopts, s)
+	return s, nil
+}
+
+func (c *conn) subscribeHandler(subject string, sopts *SubOptions) (Subscription, error) {
+	handler, err := c.buildHandler(sopts)
+	if err != nil {
+		return nil, err
+	}
+	var s Subscription
+	if sopts.Consumer != nil {
+		s, err = c.subscribeJetStream(subject, sopts, handler)
+	} else {
+		s, err = c.subscribe(subject, sopts, handler)
+	}
+	if err != nil {
+		sopts.lanes.stop()
+		sopts.queue.stop()
+		sopts.pool.stop()
+		return nil, err
+	}
+	if err := c.setPending(s); err != nil {
+		s.Unsubscribe()
+		return nil, err
+	}
+	if sopts.auto == nil {
+		return s, nil
+	}
+	if err := sopts.auto.start(s); err != nil {
+		s.Unsubscribe()
+		return nil, err
+	}
+	return s, nil
+}
+
+// buildHandler is what a subscription's messages go through, nil for
+// synchronous ones.
+func (c *conn) buildHandler(sopts *SubOptions) (nats.MsgHandler, error) {
+	handler := c.handler(sopts.ctx, sopts)
+	// Pull consumers feed channels themselves, so stopping doesn't block on
+	// a full one.
+	if handler == nil && sopts.Channel != nil && (sopts.Consumer == nil || !sopts.Consumer.Pull) {
+		handler = c.channelHandler(sopts.Channel, sopts.Overflow)
+	}
+	if handler != nil {
+		handler = c.wrapHandler(sopts, handler)
+		sopts.limits = newHandlerLimits(c.ctx, sopts.MaxConcurrent, sopts.RateLimit, sopts.Backpressure)
+		handler = sopts.limits.wrap(handler, c.overloaded)
+	} else if sopts.MaxConcurrent > 0 || sopts.RateLimit > 0 {
+		return nil, errors.New("natsv2: limits need a Handler or Channel")
+	}
+	if err := sopts.newAutoUnsub(); err != nil {
+		return nil, err
+	}
+	if handler != nil && sopts.auto != nil {
+		handler = sopts.auto.count(handler)
+	}
+	handler, err := sopts.newWorkerPool(handler)
+	if err != nil {
+		return nil, err
+	}
+	if handler, err = sopts.newPendingQueue(handler, c.log); err != nil {
+		sopts.pool.stop()
+		return nil, err
+	}
+	if handler, err = sopts.newPriorityQueue(handler, c); err != nil {
+		sopts.queue.stop()
+		sopts.pool.stop()
+		return nil, err
+	}
+	if sopts.member != nil {
+		handler = sopts.member.filter(handler)
+	}
+	if handler != nil && sopts.Consumer == nil {
+		handler = c.reassembling(handler)
+	}
+	return handler, nil
+}
+
+func (c *conn) subscribe(subject string, sopts *SubOptions, handler nats.MsgHandler) (Subscription, error) {
+	// nats.go's sync subscribe doesn't check for a closed connection first.
+	if c.closed.Load() {
+		return nil, nats.ErrConnectionClosed
+	}
+	var sub *nats.Subscription
+	var err error
+	switch {
+	case handler == nil:
+		sub, err = c.nc.QueueSubscribeSync(subject, sopts.Queue)
+	default:
+		sub, err = c.nc.QueueSubscribe(subject, sopts.Queue, handler)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription{sub: sub, c: c, sopts: sopts, handler: handler}, nil
+}
+
+// wrapHandler puts everything a message goes through on its way in around
+// handler.
+func (c *conn) wrapHandler(sopts *SubOptions, handler nats.MsgHandler) nats.MsgHandler {
+	if sopts.Decompress {
+		handler = c.decompress(sopts, handler)
+	}
+	if sopts.AutoAck && sopts.Consumer != nil && sopts.Consumer.AckPolicy != AckNone {
+		if sopts.batch != nil {
+			sopts.batch.ack = true
+		} else {
+			handler = autoAck(handler)
+		}
+	}
+	if sopts.DeadLetter != "" {
+		handler = c.deadLetter(sopts, handler)
+	}
+	if sopts.DedupeWindow > 0 {
+		handler = c.dedupe(sopts, handler)
+	}
+	handler = c.validating(sopts, handler)
+	handler = c.dropExpired(sopts, handler)
+	return c.recoverHandler(sopts.panicSite(), c.pausable(sopts, c.interceptHandler(c.unmapping(handler))))
+}
+
+type subscription struct {
+	sub   *nats.Subscription
+	c     *conn
+	sopts *SubOptions
+	// See subscribemany.go.
+	set *SubscriptionSet
+	// See lameduck.go.
+	handler nats.MsgHandler
+	moved   atomic.Pointer[nats.Subscription]
+}
+
+func (s *subscription) Close() {
+	s.Unsubscribe()
+}
+
+func (s *subscription) Unsubscribe() error {
+	defer s.done()
+	return s.current().Unsubscribe()
+}
+
+// done stops whatever runs alongside the subscription.
+func (s *subscription) done() {
+	s.c.slow.Delete(s.current())
+	s.c.unsubscribed(s.sopts)
+}
+
+func (c *conn) unsubscribed(sopts *SubOptions) {
+	sopts.cancel()
+	c.subs.Delete(sopts)
+	if sopts.route != nil {
+		c.routes.remove(sopts.route)
+	}
+	sopts.lanes.stop()
+	sopts.queue.stop()
+	sopts.pool.stop()
+	sopts.batch.stop()
+	sopts.auto.finish(nil)
+}
+
+func (s *subscription) Drain(ctx context.Context) error {
+	s.sopts.cancel()
+	defer s.done()
+	return s.c.drainSubs(ctx, s.sopts, s.current())
+}
+
+// drainSubs drains subs, which share sopts, and waits for their handlers.
+func (c *conn) drainSubs(ctx context.Context, sopts *SubOptions, subs ...*nats.Subscription) error {
+	for _, sub := range subs {
+		if err := sub.Drain(); err != nil {
+			return err
+		}
+	}
+	// nats.go does not tell us when a drain is done, so poll.
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for _, sub := range subs {
+		for sub.IsValid() {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				for _, sub := range subs {
+					sub.Unsubscribe()
+				}
+				return ctx.Err()
+			}
+		}
+	}
+	if err := sopts.queue.wait(ctx); err != nil {
+		return err
+	}
+	if err := sopts.lanes.wait(ctx); err != nil {
+		return err
+	}
+	if err := sopts.pool.wait(ctx); err != nil {
+		return err
+	}
+	if err := sopts.batch.wait(ctx); err != nil {
+		return err
+	}
+	return sopts.limits.wait(ctx)
+}
+
+// SubscribeMulti subscribes to each subject with the same options, the
+// returned Subscription closes them all. msg.Subject tells them apart. See
+// SubscribeMany for one subscription sharing its workers and limits.
+func (c *conn) SubscribeMulti(subjects []string, opts ...SubOption) (Subscription, error) {
+	if len(subjects) == 0 {
+		return nil, fmt.Errorf("%w: no subjects", ErrBadSubject)
+	}
+	var msub multiSubscription
+	for _, subject := range subjects {
+		sub, err := c.Subscribe(subject, opts...)
+		if err != nil {
+			msub.Close()
+			return nil, err
+		}
+		msub = append(msub, sub)
+	}
+	return msub, nil
+}
+
+type multiSubscription []Subscription
+
+func (ms multiSubscription) Close() {
+	for _, s := range ms {
+		s.Close()
+	}
+}
+
+func (ms multiSubscription) Unsubscribe() error {
+	var first error
+	for _, s := range ms {
+		if err := s.Unsubscribe(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ms multiSubscription) Drain(ctx context.Context) error {
+	var first error
+	for _, s := range ms {
+		if err := s.Drain(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (c *conn) Publish(subject string, msg interface{}, opts ...PubOption) error {
+	return c.PublishCtx(context.Background(), subject, msg, opts...)
+}
+
+// PublishCtx is Publish with a context, which only matters if the publish
+// could block, e.g. waiting on the rate limiter.
+func (c *conn) PublishCtx(ctx context.Context, subject string, msg interface{}, opts ...PubOption) error {
+	m, err := c.codecs.encode(subject, msg, c.codecs.out)
+	if err != nil {
+		return err
+	}
+	return c.publishMsg(ctx, m, opts...)
+}
+
+func (c *conn) publishMsg(ctx context.Context, m *nats.Msg, opts ...PubOption) error {
+	popts := &PubOptions{}
+	for _, opt := range opts {
+		if err := opt(popts); err != nil {
+			return err
+		}
+	}
+	if popts.jetStreamOnly() {
+		return ErrJetStreamRequired
+	}
+	m.Subject = c.outSubject(m.Subject)
+	setHeaders(m, popts.Headers)
+	popts.setExpires(m, popts.Delay > 0)
+	if popts.Delay > 0 {
+		return c.publishDelayed(ctx, m, time.Now().Add(popts.Delay))
+	}
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
+		}
+	}
+	return c.send(ctx, m, c.publishOrChunk)
+}
+
+// Close drains by default. Subscriptions stop taking new messages, handlers
+// finish what they already have, pending publishes are flushed and only then
+// is the connection closed. This is bounded by the client's drain timeout,
+// 30s unless set with nats.DrainTimeout, use Drain to pick the deadline.
+// WithHardClose closes right away and can drop buffered and in flight
+// messages, mostly useful for tests.
+func (c *conn) Close() {
+	if !c.closed.CompareAndSwap(false, true) {
+		return
+	}
+	if !c.opts.HardClose {
+		c.drain(context.Background())
+		return
+	}
+	c.hcancel()
+	c.nc.Close()
+	c.cancel()
+	c.closeOffline()
+}
+
+// Drain is the draining Close with a deadline. If ctx is done first the
+// connection is closed anyway, dropping whatever was left, and ctx.Err() is
+// returned.
+func (c *conn) Drain(ctx context.Context) error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	return c.drain(ctx)
+}
+
+func (c *conn) drain(ctx context.Context) error {
+	c.hcancel()
+	defer func() {
+		c.cancel()
+		c.closeOffline()
+	}()
+	closed := c.nc.StatusChanged(nats.CLOSED)
+	// Drain fails if we are not connected, nothing to flush then anyway.
+	if err := c.nc.Drain(); err != nil {
+		c.nc.Close()
+		return nil
+	}
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		c.nc.Close()
+		return ctx.Err()
+	}
+}
+
+func WithHardClose() ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.HardClose = true
+		return nil
+	}
+}
+
+// WithDrainTimeout bounds how long Close drains for.
+func WithDrainTimeout(d time.Duration) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.NATS = append(o.NATS, nats.DrainTimeout(d))
+		return nil
+	}
+}
+
+// For now reuse low level NATS client lib
+type conn struct {
+	nc      *nats.Conn
+	js      nats.JetStreamContext
+	opts    *ConnectOptions
+	routes  router
+	codecs  *codecs
+	limiter *rateLimiter
+	log     Logger
+	metrics Metrics
+	// Done once closed, ends waits in the handler limits.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Done once Drain or Close starts, see context.go.
+	hctx    context.Context
+	hcancel context.CancelFunc
+	// Subscriptions with a slow consumer policy, by client subscription.
+	slow sync.Map
+	// Circuit breakers by subject.
+	breakers sync.Map
+	// See cache.go.
+	cache        RequestCache
+	revalidating sync.Map
+	// See credreload.go.
+	creds credWatch
+	// Subscriptions by their options and services, for Shutdown.
+	subs     sync.Map
+	services sync.Map
+	// See registry.go.
+	streams   sync.Map
+	consumers sync.Map
+	// See inboxmux.go.
+	replies replyMux
+	// See offline.go.
+	offline *spool
+	// See schedule.go.
+	schedMu       sync.Mutex
+	schedDeclared bool
+	// See lameduck.go.
+	handingOver atomic.Bool
+	// Set once Close or Drain starts. nc stays, nats.go has it return
+	// ErrConnectionClosed from then on.
+	closed atomic.Bool
+}
+
+type ConnectOption func(*ConnectOptions) error
+
+type ConnectOptions struct {
+	// Passed straight through to the low level client.
+	NATS         []nats.Option
+	Codecs       []Codec
+	Encodings    []Encoding
+	DefaultCodec string
+	Pipeline     []string
+
+	MaxDecompressedSize int
+
+	PublishInterceptors   []PublishInterceptor
+	SubscribeInterceptors []SubscribeInterceptor
+
+	RateLimit      int
+	RateLimitError bool
+
+	HardClose bool
+	ChunkSize int
+	// See compress.go.
+	CompressMinSize int
+	// See subjectmap.go.
+	SubjectMapper SubjectMapper
+	// See offline.go.
+	OfflineDir      string
+	OfflineMaxBytes int64
+	// See transport.go.
+	Websocket *WebsocketOptions
+	Dialer    nats.CustomDialer
+	Proxy     ProxyFunc
+
+	ErrorHandler ErrorHandler
+	Logger       Logger
+	Metrics      Metrics
+
+	// See sign.go.
+	Signer nkeys.KeyPair
+	Verify *VerifyOptions
+	// See correlate.go.
+	Correlation bool
+	// See validate.go.
+	validators []subjectValidator
+
+	// See events.go.
+	OnDisconnect []func(DisconnectEvent)
+	OnReconnect  []func(ReconnectEvent)
+	OnClosed     []func(error)
+	OnError      []func(error)
+	// See breaker.go.
+	OnBreakerChange []func(BreakerEvent)
+	// See failover.go.
+	Failback        time.Duration
+	OnClusterSwitch []func(ClusterSwitchEvent)
+	// See cache.go.
+	RequestCache RequestCache
+	// See maxpayload.go.
+	ChunkOversize bool
+	// See deadline.go.
+	DeadlineSkew *time.Duration
+	// See credreload.go.
+	WatchCredentials    time.Duration
+	OnCredentialsReload []func(CredentialsReloadEvent)
+	credFiles           credFiles
+	// See permissions.go.
+	RequirePublish   []string
+	RequireSubscribe []string
+	// See lameduck.go.
+	OnLameDuck       []func(LameDuckEvent)
+	LameDuckHandover time.Duration
+	// See panics.go.
+	Panics       PanicPolicy
+	PanicReports string
+	// See defaults.go.
+	RequestDefaults []ReqOption
+	StreamDefaults  []StreamOption
+	ServiceDefaults []ServiceOption
+	// See auth.go.
+	tokens *tokenSource
+}
+
+// NATSOptions allows any of the low level client options to be used.
+func NATSOptions(opts ...nats.Option) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.NATS = append(o.NATS, opts...)
+		return nil
+	}
+}
+
+func Connect(url string, opts ...ConnectOption) (Connection, error) {
+	copts := &ConnectOptions{DefaultCodec: JSONContentType, NATS: defaultNATSOptions()}
+	for _, opt := range opts {
+		if err := opt(copts); err != nil {
+			return nil, err
+		}
+	}
+	codecs, err := newCodecs(copts)
+	if err != nil {
+		return nil, err
+	}
+	var offline *spool
+	if copts.OfflineDir != "" {
+		if offline, err = openSpool(copts.OfflineDir, copts.OfflineMaxBytes); err != nil {
+			return nil, err
+		}
+	}
+	var lameDuck atomic.Pointer[conn]
+	nc, err := nats.Connect(url, append(append(copts.NATS, copts.transport()...), watchLameDuck(&lameDuck))...)
+	if err != nil {
+		if offline != nil {
+			offline.close()
+		}
+		return nil, err
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		if offline != nil {
+			offline.close()
+		}
+		return nil, err
+	}
+	c := &conn{nc: nc, js: js, opts: copts, codecs: codecs, log: copts.Logger, offline: offline}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.hctx, c.hcancel = context.WithCancel(c.ctx)
+	if c.log == nil {
+		c.log = nopLogger{}
+	}
+	c.metrics = copts.Metrics
+	if c.metrics == nil {
+		c.metrics = nopMetrics{}
+	}
+	c.cache = copts.RequestCache
+	if c.cache == nil {
+		c.cache = NewMemoryCache(DefaultRequestCacheSize)
+	}
+	c.watchLifecycle()
+	lameDuck.Store(c)
+	if copts.tokens != nil {
+		go c.refreshTokens(copts.tokens, nc)
+	}
+	if copts.WatchCredentials > 0 && len(copts.credFiles.list()) > 0 {
+		c.creds.stamps = stampFiles(copts.credFiles.list())
+		go c.watchCredentials(copts.WatchCredentials)
+	}
+	c.log.Info("connected", "server", nc.ConnectedUrlRedacted())
+	if copts.RateLimit > 0 {
+		c.limiter = newRateLimiter(copts.RateLimit, copts.RateLimitError)
+	}
+	if len(copts.RequirePublish)+len(copts.RequireSubscribe) > 0 {
+		if err := c.checkPermissions(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if c.offline != nil {
+		c.replayOffline()
+	}
+	return c, nil
+}
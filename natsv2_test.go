@@ -0,0 +1,91 @@
+package natsv2
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// testConn is a connection to a server of its own, gone when tb is.
+func testConn(tb testing.TB, opts ...ConnectOption) Connection {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s.Start()
+	tb.Cleanup(s.Shutdown)
+	if !s.ReadyForConnections(10 * time.Second) {
+		tb.Fatal("server not ready")
+	}
+	nc, err := Connect(s.ClientURL(), opts...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(nc.Close)
+	return nc
+}
+
+func TestRequestAfterClose(t *testing.T) {
+	nc := testConn(t)
+	nc.Close()
+	if _, err := nc.Request("orders.get", "o-1"); !errors.Is(err, nats.ErrConnectionClosed) {
+		t.Fatalf("got %v, want %v", err, nats.ErrConnectionClosed)
+	}
+}
+
+func TestSubscribeAfterClose(t *testing.T) {
+	nc := testConn(t)
+	nc.Close()
+	for name, opts := range map[string][]SubOption{
+		"sync":    nil,
+		"handler": {Handler(func(*Msg) {})},
+	} {
+		if _, err := nc.Subscribe("orders", opts...); !errors.Is(err, nats.ErrConnectionClosed) {
+			t.Errorf("%s: got %v, want %v", name, err, nats.ErrConnectionClosed)
+		}
+	}
+}
+
+func TestChunkedReplyHandlerTime(t *testing.T) {
+	nc := testConn(t, WithChunkSize(1024))
+	body := make([]byte, 5000)
+	sub, err := nc.Subscribe("blob", Handler(func(m *Msg) {
+		time.Sleep(20 * time.Millisecond)
+		nc.Respond(m, body)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	reply, err := nc.Request("blob", 1, Chunked(), TrackLatency())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Data()) != len(body) {
+		t.Fatalf("got %d bytes, want %d", len(reply.Data()), len(body))
+	}
+	if l := reply.Latency(); l.Handler < 20*time.Millisecond || l.Handler > l.RTT {
+		t.Fatalf("handler %v of %v", l.Handler, l.RTT)
+	}
+}
+
+func TestTrackLatencyRawPayload(t *testing.T) {
+	nc := testConn(t)
+	sub, err := nc.Subscribe("lat", Handler(func(m *Msg) { nc.Respond(m, m.Data()) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	for _, payload := range []interface{}{[]byte("b"), "s"} {
+		reply, err := nc.Request("lat", payload, TrackLatency())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Latency().RTT <= 0 {
+			t.Fatalf("%T: no RTT", payload)
+		}
+	}
+}
@@ -0,0 +1,902 @@
+// Package natsv2test is an in-memory natsv2.Connection, for unit testing
+// handlers and services without a server. Subjects match the way they do
+// on a server, wildcards and queue groups included, and everything
+// published is kept so tests can check it:
+//
+//	nc := natsv2test.New()
+//	nc.Subscribe("orders.*", natsv2.HandleFunc(place))
+//	reply, err := nc.Request("orders.new", order)
+//	nc.AssertPublished(t, "billing.charge", Charge{Order: order.ID, Amount: 42})
+//
+// Handlers run on the publishing goroutine, so by the time Publish or
+// Request returns they are done, and a Channel subscription blocks Publish
+// when full. Values are encoded with the default codecs, anything that needs
+// a server, JetStream, KV, services and the HTTP bridge, returns
+// ErrNotSupported. RunServer is for those, see server.go, and Replay serves
+// replies recorded from a real server, see record.go.
+package natsv2test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+var ErrNotSupported = errors.New("natsv2test: not supported in memory")
+
+type Conn struct {
+	mu        sync.Mutex
+	subs      []*subscription
+	queues    map[string]int
+	published []*nats.Msg
+	closed    bool
+	// See record.go.
+	cassette *cassette
+}
+
+var _ natsv2.Connection = (*Conn)(nil)
+
+func New() *Conn {
+	return &Conn{queues: map[string]int{}}
+}
+
+// Published is everything published so far, replies included, oldest first.
+func (c *Conn) Published() []*natsv2.Msg {
+	return c.PublishedOn(">")
+}
+
+// PublishedOn is what was published on subjects matching subject.
+func (c *Conn) PublishedOn(subject string) []*natsv2.Msg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var msgs []*natsv2.Msg
+	for _, m := range c.published {
+		if natsv2.Matches(subject, m.Subject) {
+			msgs = append(msgs, natsv2.FromNATS(copyMsg(m)))
+		}
+	}
+	return msgs
+}
+
+// Reset forgets what was published, subscriptions stay.
+func (c *Conn) Reset() {
+	c.mu.Lock()
+	c.published = nil
+	c.mu.Unlock()
+}
+
+// AssertPublished checks a message on subject decodes to want, comparing it
+// to each message decoded into a value of want's type, and returns the last
+// one that matched. []byte and string compare the raw payload.
+func (c *Conn) AssertPublished(t testing.TB, subject string, want interface{}) *natsv2.Msg {
+	t.Helper()
+	msgs := c.PublishedOn(subject)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if equal(msgs[i], want) {
+			return msgs[i]
+		}
+	}
+	if len(msgs) == 0 {
+		t.Errorf("natsv2test: nothing published on %q", subject)
+		return nil
+	}
+	var got []string
+	for _, m := range msgs {
+		got = append(got, fmt.Sprintf("%s: %q", m.Subject(), m.Data()))
+	}
+	t.Errorf("natsv2test: no message on %q is %+v, got:\n\t%s", subject, want, strings.Join(got, "\n\t"))
+	return nil
+}
+
+func (c *Conn) AssertNotPublished(t testing.TB, subject string) {
+	t.Helper()
+	if msgs := c.PublishedOn(subject); len(msgs) > 0 {
+		t.Errorf("natsv2test: %d messages published on %q", len(msgs), subject)
+	}
+}
+
+func equal(m *natsv2.Msg, want interface{}) bool {
+	switch w := want.(type) {
+	case []byte:
+		return bytes.Equal(m.Data(), w)
+	case string:
+		return string(m.Data()) == w
+	}
+	got := reflect.New(reflect.TypeOf(want))
+	if err := m.Decode(got.Interface()); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(got.Elem().Interface(), want)
+}
+
+func (c *Conn) Publish(subject string, v interface{}, opts ...natsv2.PubOption) error {
+	return c.PublishCtx(context.Background(), subject, v, opts...)
+}
+
+func (c *Conn) PublishCtx(ctx context.Context, subject string, v interface{}, opts ...natsv2.PubOption) error {
+	m, err := c.encode(subject, v, "", opts)
+	if err != nil {
+		return err
+	}
+	return c.publish(m)
+}
+
+func (c *Conn) PublishTo(subject string, write func(io.Writer) error, opts ...natsv2.PubOption) error {
+	return c.PublishToCtx(context.Background(), subject, write, opts...)
+}
+
+func (c *Conn) PublishToCtx(ctx context.Context, subject string, write func(io.Writer) error, opts ...natsv2.PubOption) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	return c.PublishCtx(ctx, subject, buf.Bytes(), opts...)
+}
+
+func (c *Conn) PublishSync(ctx context.Context, subject string, v interface{}, opts ...natsv2.PubOption) (time.Duration, error) {
+	return 0, c.PublishCtx(ctx, subject, v, opts...)
+}
+
+func (c *Conn) PublishBatch(subject string, msgs []interface{}, opts ...natsv2.BatchOption) error {
+	b := c.Batch(opts...)
+	for _, v := range msgs {
+		b.Add(subject, v)
+	}
+	_, err := b.Send(context.Background())
+	return err
+}
+
+func (c *Conn) PublishBatchMsgs(msgs []natsv2.BatchMsg, opts ...natsv2.BatchOption) error {
+	b := c.Batch(opts...)
+	for _, bm := range msgs {
+		b.Add(bm.Subject, bm.Msg)
+	}
+	_, err := b.Send(context.Background())
+	return err
+}
+
+func (c *Conn) Batch(opts ...natsv2.BatchOption) natsv2.Batch {
+	b := &batch{c: c}
+	for _, opt := range opts {
+		if b.err = opt(&b.opts); b.err != nil {
+			break
+		}
+	}
+	if b.err == nil && b.opts.JetStream != "" {
+		b.err = ErrNotSupported
+	}
+	return b
+}
+
+type batch struct {
+	c    *Conn
+	opts natsv2.BatchOptions
+	msgs []*nats.Msg
+	err  error
+}
+
+func (b *batch) Add(subject string, v interface{}, opts ...natsv2.PubOption) natsv2.Batch {
+	if b.err != nil {
+		return b
+	}
+	m, err := b.c.encode(subject, v, "", opts)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	b.msgs = append(b.msgs, m)
+	return b
+}
+
+func (b *batch) Len() int { return len(b.msgs) }
+
+func (b *batch) Send(ctx context.Context) ([]*nats.PubAck, error) {
+	msgs, err := b.msgs, b.err
+	b.msgs, b.err = nil, nil
+	if err != nil {
+		return nil, err
+	}
+	berr := &natsv2.BatchError{Errors: map[int]error{}}
+	for i, m := range msgs {
+		if err := b.c.publish(m); err != nil {
+			berr.Errors[i] = err
+			if b.opts.Strict {
+				break
+			}
+		}
+	}
+	if len(berr.Errors) > 0 {
+		return nil, berr
+	}
+	return nil, nil
+}
+
+func (c *Conn) encode(subject string, v interface{}, contentType string, opts []natsv2.PubOption) (*nats.Msg, error) {
+	if err := checkSubject(subject); err != nil {
+		return nil, err
+	}
+	popts := &natsv2.PubOptions{}
+	for _, opt := range opts {
+		if err := opt(popts); err != nil {
+			return nil, err
+		}
+	}
+	if popts.MsgID != "" || popts.MsgIDFunc != nil || popts.ContentMsgID {
+		return nil, natsv2.ErrJetStreamRequired
+	}
+	if popts.Delay > 0 {
+		return nil, ErrNotSupported
+	}
+	m, err := natsv2.Encode(subject, v, contentType)
+	if err != nil {
+		return nil, err
+	}
+	setHeaders(m, popts.Headers)
+	return m, nil
+}
+
+func setHeaders(m *nats.Msg, h natsv2.Header) {
+	if len(h) == 0 {
+		return
+	}
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	for k, v := range h {
+		m.Header[k] = v
+	}
+}
+
+// publish records m and hands it to the matching subscriptions, one per
+// queue group.
+func (c *Conn) publish(m *nats.Msg) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nats.ErrConnectionClosed
+	}
+	c.published = append(c.published, copyMsg(m))
+	var to []*subscription
+	groups := map[string][]*subscription{}
+	for _, s := range c.subs {
+		if !natsv2.Matches(s.subject, m.Subject) {
+			continue
+		}
+		if s.queue == "" {
+			to = append(to, s)
+		} else {
+			groups[s.queue] = append(groups[s.queue], s)
+		}
+	}
+	for queue, members := range groups {
+		to = append(to, members[c.queues[queue]%len(members)])
+		c.queues[queue]++
+	}
+	c.mu.Unlock()
+
+	for _, s := range to {
+		s.deliver(natsv2.FromNATSWith(copyMsg(m), c.publish))
+	}
+	return nil
+}
+
+// hasInterest is whether anything would get a message on subject.
+func (c *Conn) hasInterest(subject string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.subs {
+		if natsv2.Matches(s.subject, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyMsg(m *nats.Msg) *nats.Msg {
+	cm := &nats.Msg{Subject: m.Subject, Reply: m.Reply, Data: m.Data}
+	if m.Header != nil {
+		cm.Header = nats.Header{}
+		for k, v := range m.Header {
+			cm.Header[k] = append([]string(nil), v...)
+		}
+	}
+	return cm
+}
+
+func (c *Conn) Subscribe(subject string, opts ...natsv2.SubOption) (natsv2.Subscription, error) {
+	if err := checkPattern(subject); err != nil {
+		return nil, err
+	}
+	sopts := &natsv2.SubOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, err
+		}
+	}
+	if sopts.Consumer != nil {
+		return nil, ErrNotSupported
+	}
+	return c.subscribe(subject, sopts.Queue, sopts.Handler, sopts.Channel, sopts.Max)
+}
+
+func (c *Conn) subscribe(subject, queue string, handler func(*natsv2.Msg), ch chan *natsv2.Msg, max int) (*subscription, error) {
+	s := &subscription{c: c, subject: subject, queue: queue, handler: handler, ch: ch, max: max}
+	if handler == nil && ch == nil {
+		s.next = make(chan struct{}, 1)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, nats.ErrConnectionClosed
+	}
+	c.subs = append(c.subs, s)
+	return s, nil
+}
+
+func (c *Conn) SubscribeMulti(subjects []string, opts ...natsv2.SubOption) (natsv2.Subscription, error) {
+	var subs multiSubscription
+	for _, subject := range subjects {
+		s, err := c.Subscribe(subject, opts...)
+		if err != nil {
+			subs.Unsubscribe()
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, nil
+}
+
+func (c *Conn) unsubscribe(s *subscription) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, sub := range c.subs {
+		if sub == s {
+			c.subs = append(c.subs[:i], c.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+type subscription struct {
+	c       *Conn
+	subject string
+	queue   string
+	handler func(*natsv2.Msg)
+	ch      chan *natsv2.Msg
+
+	mu      sync.Mutex
+	max     int
+	count   int
+	pending []*natsv2.Msg
+	next    chan struct{}
+	// Closed on Resume, nil unless paused.
+	resumed chan struct{}
+	held    []*natsv2.Msg
+}
+
+func (s *subscription) deliver(m *natsv2.Msg) {
+	s.mu.Lock()
+	s.count++
+	if s.max > 0 && s.count >= s.max {
+		s.c.unsubscribe(s)
+		if s.count > s.max {
+			s.mu.Unlock()
+			return
+		}
+	}
+	if s.resumed != nil && s.next == nil {
+		s.held = append(s.held, m)
+		s.mu.Unlock()
+		return
+	}
+	if s.next != nil {
+		s.pending = append(s.pending, m)
+		select {
+		case s.next <- struct{}{}:
+		default:
+		}
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.handle(m)
+}
+
+func (s *subscription) handle(m *natsv2.Msg) {
+	if s.handler != nil {
+		s.handler(m)
+		return
+	}
+	s.ch <- m
+}
+
+// Pause holds messages back until Resume, which hands them over before it
+// returns.
+func (s *subscription) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resumed == nil {
+		s.resumed = make(chan struct{})
+	}
+}
+
+func (s *subscription) Resume() {
+	s.mu.Lock()
+	if s.resumed == nil {
+		s.mu.Unlock()
+		return
+	}
+	close(s.resumed)
+	s.resumed = nil
+	held := s.held
+	s.held = nil
+	s.mu.Unlock()
+	for _, m := range held {
+		s.handle(m)
+	}
+}
+
+func (s *subscription) Close()             { s.Unsubscribe() }
+func (s *subscription) Unsubscribe() error { s.c.unsubscribe(s); return nil }
+
+// Drain has nothing to wait for, handlers are done when Publish returns.
+func (s *subscription) Drain(ctx context.Context) error { return s.Unsubscribe() }
+
+func (s *subscription) Next(ctx context.Context) (*natsv2.Msg, error) {
+	if s.next == nil {
+		return nil, natsv2.ErrNotSync
+	}
+	for {
+		s.mu.Lock()
+		if resumed := s.resumed; resumed != nil {
+			s.mu.Unlock()
+			select {
+			case <-resumed:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if len(s.pending) > 0 {
+			m := s.pending[0]
+			s.pending = s.pending[1:]
+			s.mu.Unlock()
+			return m, nil
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.next:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type multiSubscription []natsv2.Subscription
+
+func (ms multiSubscription) Close() { ms.Unsubscribe() }
+
+func (ms multiSubscription) Unsubscribe() error {
+	for _, s := range ms {
+		s.Unsubscribe()
+	}
+	return nil
+}
+
+func (ms multiSubscription) Drain(ctx context.Context) error { return ms.Unsubscribe() }
+
+func (ms multiSubscription) Pause() {
+	for _, s := range ms {
+		s.Pause()
+	}
+}
+
+func (ms multiSubscription) Resume() {
+	for _, s := range ms {
+		s.Resume()
+	}
+}
+
+func (ms multiSubscription) Next(ctx context.Context) (*natsv2.Msg, error) {
+	return nil, errors.New("natsv2test: no Next on SubscribeMulti, use a Channel")
+}
+
+func (c *Conn) Request(subject string, v interface{}, opts ...natsv2.ReqOption) (*natsv2.Msg, error) {
+	replies, err := c.request(subject, v, 1, opts)
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], replyError(subject, replies[0])
+}
+
+func (c *Conn) RequestAll(subject string, v interface{}, opts ...natsv2.ReqOption) ([]*natsv2.Msg, error) {
+	return c.request(subject, v, 0, opts)
+}
+
+func (c *Conn) request(subject string, v interface{}, n int, opts []natsv2.ReqOption) ([]*natsv2.Msg, error) {
+	ropts := &natsv2.ReqOptions{}
+	for _, opt := range opts {
+		if err := opt(ropts); err != nil {
+			return nil, err
+		}
+	}
+	m, err := c.encode(subject, v, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	setHeaders(m, ropts.Headers)
+	timeout := ropts.Timeout
+	var onReply func(*natsv2.Msg)
+	if g := ropts.Gather; g != nil && n == 0 {
+		n, onReply = g.N, g.OnReply
+		if g.Window > 0 {
+			timeout = g.Window
+		}
+	}
+	ctx := ropts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := ctx.Deadline(); !ok && timeout == 0 {
+		timeout = natsv2.DefaultRequestTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if in, ok := c.cassette.find(m); ok {
+		return in.replay(c, m)
+	}
+	if !c.hasInterest(subject) {
+		return nil, fmt.Errorf("%w on %q", natsv2.ErrNoResponders, subject)
+	}
+	m.Reply = "_INBOX." + nuid.Next()
+	ch := make(chan *natsv2.Msg, 1024)
+	inbox, err := c.subscribe(m.Reply, "", nil, ch, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer inbox.Unsubscribe()
+	if err := c.publish(m); err != nil {
+		return nil, err
+	}
+
+	var replies []*natsv2.Msg
+	for n == 0 || len(replies) < n {
+		select {
+		case r := <-ch:
+			if onReply != nil {
+				onReply(r)
+			}
+			replies = append(replies, r)
+		case <-ctx.Done():
+			if len(replies) > 0 && n != 1 {
+				return replies, nil
+			}
+			return nil, fmt.Errorf("%w on %q: %v", natsv2.ErrTimeout, subject, ctx.Err())
+		}
+	}
+	return replies, nil
+}
+
+func replyError(subject string, reply *natsv2.Msg) error {
+	desc, code := reply.Header().Get(natsv2.ServiceErrorHeader), reply.Header().Get(natsv2.ServiceErrorCodeHeader)
+	if desc == "" && code == "" {
+		return nil
+	}
+	return &natsv2.RequestError{Subject: subject, Code: code, Description: desc}
+}
+
+func (c *Conn) Stream(subject string, opts ...natsv2.StreamOption) natsv2.Stream {
+	s := &stream{c: c, subject: subject}
+	for _, opt := range opts {
+		if s.err = opt(&s.opts); s.err != nil {
+			return s
+		}
+	}
+	if s.opts.JetStream != "" || s.opts.Declare != nil || len(s.opts.Codecs) > 0 || len(s.opts.Pipeline) > 0 {
+		s.err = ErrNotSupported
+	}
+	return s
+}
+
+type stream struct {
+	c       *Conn
+	subject string
+	opts    natsv2.StreamOptions
+	err     error
+}
+
+func (s *stream) Subject() string { return s.subject }
+
+func (s *stream) WithEncoder(contentType string) natsv2.Stream {
+	ns := *s
+	ns.opts.ContentType = contentType
+	return &ns
+}
+
+func (s *stream) Publish(v interface{}, opts ...natsv2.PubOption) error {
+	return s.PublishCtx(context.Background(), v, opts...)
+}
+
+func (s *stream) PublishCtx(ctx context.Context, v interface{}, opts ...natsv2.PubOption) error {
+	if s.err != nil {
+		return s.err
+	}
+	m, err := s.c.encode(s.subject, v, s.opts.ContentType, opts)
+	if err != nil {
+		return err
+	}
+	return s.c.publish(m)
+}
+
+func (s *stream) PublishAsync(interface{}, ...natsv2.PubOption) (nats.PubAckFuture, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return nil, natsv2.ErrJetStreamRequired
+}
+
+func (s *stream) PublishAsyncComplete() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func (s *stream) Subscribe(opts ...natsv2.SubOption) (natsv2.Subscription, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.c.Subscribe(s.subject, opts...)
+}
+
+func (s *stream) Decode(m *natsv2.Msg, v interface{}) error { return m.Decode(v) }
+
+func (s *stream) Replay(interface{}, func(*natsv2.Msg), ...natsv2.ReplayOption) (*natsv2.Replay, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *stream) Channel(int, ...natsv2.SubOption) (<-chan *natsv2.Msg, func(), error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (c *Conn) Decode(m *natsv2.Msg, v interface{}) error  { return m.Decode(v) }
+func (c *Conn) Respond(m *natsv2.Msg, v interface{}) error { return m.Respond(v) }
+
+// ReplyStream sends just the first reply, there is no streamed Request here.
+func (c *Conn) ReplyStream(m *natsv2.Msg) natsv2.ReplyStream {
+	return &replyStream{m: m}
+}
+
+type replyStream struct {
+	m    *natsv2.Msg
+	sent bool
+}
+
+func (rs *replyStream) Send(v interface{}) error {
+	if rs.sent {
+		return nil
+	}
+	rs.sent = true
+	return rs.m.Respond(v)
+}
+
+func (rs *replyStream) Close(err error) error {
+	if err == nil || rs.sent {
+		return nil
+	}
+	rs.sent = true
+	return rs.m.RespondError("500", err)
+}
+
+func (c *Conn) Status() natsv2.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := natsv2.Status{State: nats.CONNECTED, Server: "memory"}
+	if c.closed {
+		st.State = nats.CLOSED
+	}
+	return st
+}
+
+func (c *Conn) Healthy(context.Context) error { return c.FlushTimeout(0) }
+
+func (c *Conn) Flush(context.Context) error { return c.FlushTimeout(0) }
+
+func (c *Conn) FlushTimeout(time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nats.ErrConnectionClosed
+	}
+	return nil
+}
+
+func (c *Conn) Drain(context.Context) error {
+	c.Close()
+	return nil
+}
+
+func (c *Conn) Shutdown(context.Context, ...natsv2.ShutdownOption) error {
+	c.Close()
+	return nil
+}
+
+func (c *Conn) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.subs = nil
+	c.mu.Unlock()
+}
+
+func (c *Conn) PullChannel(string, string, int, ...natsv2.SubOption) (<-chan *natsv2.Msg, func(), error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (c *Conn) Service(string, string, ...natsv2.ServiceOption) (natsv2.Service, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) Handle(string, natsv2.HTTPHandlerFunc, ...natsv2.HandleOption) (natsv2.Subscription, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) Mount(string, http.Handler, ...natsv2.Middleware) (natsv2.Subscription, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) RoundTrip(string, *http.Request) (*http.Response, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) KV(string, ...natsv2.KVOption) (natsv2.KV, error) { return nil, ErrNotSupported }
+
+func (c *Conn) ObjectStore(string, ...natsv2.ObjectStoreOption) (natsv2.ObjectStore, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) Consumer(string, string) (natsv2.Consumer, error) { return nil, ErrNotSupported }
+
+func (c *Conn) Scheduler() (natsv2.Scheduler, error) { return nil, ErrNotSupported }
+
+func (c *Conn) Tap(string, natsv2.TapSink, ...natsv2.TapOption) (*natsv2.Tap, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) SubscribeMany([]string, ...natsv2.SubOption) (*natsv2.SubscriptionSet, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) ReloadCredentials() error { return ErrNotSupported }
+
+func (c *Conn) Can(string, natsv2.PermissionOp) (bool, error) { return false, ErrNotSupported }
+
+func (c *Conn) Permissions() (*natsv2.Permissions, error) { return nil, ErrNotSupported }
+
+// RequestOptions are opts as a request here takes them, there are no
+// connection defaults.
+func (c *Conn) RequestOptions(opts ...natsv2.ReqOption) (*natsv2.ReqOptions, error) {
+	ropts := &natsv2.ReqOptions{}
+	for _, opt := range opts {
+		if err := opt(ropts); err != nil {
+			return nil, err
+		}
+	}
+	if ropts.Timeout == 0 {
+		if ropts.Context == nil {
+			ropts.Timeout = natsv2.DefaultRequestTimeout
+		} else if _, ok := ropts.Context.Deadline(); !ok {
+			ropts.Timeout = natsv2.DefaultRequestTimeout
+		}
+	}
+	return ropts, nil
+}
+
+func (c *Conn) StreamOptions(opts ...natsv2.StreamOption) (*natsv2.StreamOptions, error) {
+	sopts := &natsv2.StreamOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, err
+		}
+	}
+	return sopts, nil
+}
+
+func (c *Conn) ServiceOptions(string, string, ...natsv2.ServiceOption) (*natsv2.ServiceOptions, error) {
+	return nil, ErrNotSupported
+}
+
+// Registry has the subscriptions, there are no services or streams.
+func (c *Conn) Registry() *natsv2.Registry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := &natsv2.Registry{}
+	for _, s := range c.subs {
+		s.mu.Lock()
+		rs := natsv2.RegisteredSubscription{Subject: s.subject, Queue: s.queue, Paused: s.resumed != nil, Valid: true}
+		rs.Stats.Pending = len(s.pending)
+		s.mu.Unlock()
+		if s.ch != nil {
+			rs.Handler = "channel"
+		}
+		r.Subscriptions = append(r.Subscriptions, rs)
+	}
+	return r
+}
+
+func (c *Conn) Lock(string, ...natsv2.LockOption) (*natsv2.Semaphore, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) Semaphore(string, int, ...natsv2.LockOption) (*natsv2.Semaphore, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *Conn) JetStream() natsv2.JetStreamManager { return noJetStream{} }
+
+type noJetStream struct{}
+
+func (noJetStream) DeclareStream(nats.StreamConfig) (*nats.StreamInfo, error) {
+	return nil, ErrNotSupported
+}
+func (noJetStream) AddStream(nats.StreamConfig) (*nats.StreamInfo, error) {
+	return nil, ErrNotSupported
+}
+func (noJetStream) UpdateStream(nats.StreamConfig) (*nats.StreamInfo, error) {
+	return nil, ErrNotSupported
+}
+func (noJetStream) DeleteStream(string) error                       { return ErrNotSupported }
+func (noJetStream) PurgeStream(string, ...natsv2.PurgeOption) error { return ErrNotSupported }
+func (noJetStream) StreamInfo(string) (*nats.StreamInfo, error)     { return nil, ErrNotSupported }
+func (noJetStream) StreamNames() ([]string, error)                  { return nil, ErrNotSupported }
+func (noJetStream) DeleteConsumer(string, string) error             { return ErrNotSupported }
+func (noJetStream) ConsumerNames(string) ([]string, error)          { return nil, ErrNotSupported }
+func (noJetStream) ConsumerInfo(string, string) (*nats.ConsumerInfo, error) {
+	return nil, ErrNotSupported
+}
+func (noJetStream) DeclareConsumer(string, nats.ConsumerConfig) (*nats.ConsumerInfo, error) {
+	return nil, ErrNotSupported
+}
+func (noJetStream) PauseConsumer(string, string, time.Time) (*natsv2.ConsumerPause, error) {
+	return nil, ErrNotSupported
+}
+func (noJetStream) ResumeConsumer(string, string) (*natsv2.ConsumerPause, error) {
+	return nil, ErrNotSupported
+}
+
+func checkSubject(subject string) error {
+	if err := checkPattern(subject); err != nil {
+		return err
+	}
+	if strings.ContainsAny(subject, "*>") {
+		return fmt.Errorf("natsv2test: can't publish to wildcard subject %q", subject)
+	}
+	return nil
+}
+
+func checkPattern(subject string) error {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("natsv2test: invalid subject %q", subject)
+	}
+	for _, tok := range strings.Split(subject, ".") {
+		if tok == "" {
+			return fmt.Errorf("natsv2test: invalid subject %q", subject)
+		}
+	}
+	return nil
+}
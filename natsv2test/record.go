@@ -0,0 +1,223 @@
+package natsv2test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+// Recording and replaying requests, for code that calls services the tests
+// can't run. Record wraps a real connection and keeps every Request and
+// RequestAll with its replies, Save writes them out as JSON to check in.
+// Replay answers the same requests from the file without a server:
+//
+//	nc := natsv2test.Cassette(t, "testdata/pricing.json", func() (natsv2.Connection, error) {
+//		return natsv2.Connect(os.Getenv("NATS_URL"))
+//	})
+//
+// Cassette replays when the file is there and records otherwise, or when
+// NATSV2TEST_RECORD is set, to refresh it. Requests are matched on subject
+// and payload, a request made several times gets the recorded replies in
+// order, the last ones again once they run out. Anything not in the file
+// goes to the Conn's subscriptions as usual.
+
+// RecordEnv set to anything makes Cassette record.
+const RecordEnv = "NATSV2TEST_RECORD"
+
+type interaction struct {
+	Subject string        `json:"subject"`
+	Request recordedMsg   `json:"request"`
+	Replies []recordedMsg `json:"replies,omitempty"`
+	// "no responders", "timeout", or any other error's text.
+	Error string `json:"error,omitempty"`
+}
+
+type recordedMsg struct {
+	Header nats.Header `json:"header,omitempty"`
+	Data   string      `json:"data"`
+	// Data is base64 when it isn't UTF-8.
+	Binary bool `json:"binary,omitempty"`
+}
+
+func record(m *nats.Msg) recordedMsg {
+	rm := recordedMsg{Header: m.Header, Data: string(m.Data)}
+	if !utf8.Valid(m.Data) {
+		rm.Data, rm.Binary = base64.StdEncoding.EncodeToString(m.Data), true
+	}
+	return rm
+}
+
+func (rm recordedMsg) data() []byte {
+	if rm.Binary {
+		data, _ := base64.StdEncoding.DecodeString(rm.Data)
+		return data
+	}
+	return []byte(rm.Data)
+}
+
+// A Recorder is the Connection it wraps, with Request and RequestAll kept.
+type Recorder struct {
+	natsv2.Connection
+	path string
+
+	mu           sync.Mutex
+	interactions []interaction
+}
+
+func Record(nc natsv2.Connection, path string) *Recorder {
+	return &Recorder{Connection: nc, path: path}
+}
+
+func (r *Recorder) Request(subject string, v interface{}, opts ...natsv2.ReqOption) (*natsv2.Msg, error) {
+	reply, err := r.Connection.Request(subject, v, opts...)
+	var replies []*natsv2.Msg
+	if reply != nil {
+		replies = append(replies, reply)
+	}
+	r.record(subject, v, replies, err)
+	return reply, err
+}
+
+func (r *Recorder) RequestAll(subject string, v interface{}, opts ...natsv2.ReqOption) ([]*natsv2.Msg, error) {
+	replies, err := r.Connection.RequestAll(subject, v, opts...)
+	r.record(subject, v, replies, err)
+	return replies, err
+}
+
+func (r *Recorder) record(subject string, v interface{}, replies []*natsv2.Msg, err error) {
+	req, eerr := natsv2.Encode(subject, v, "")
+	if eerr != nil {
+		return
+	}
+	in := interaction{Subject: subject, Request: record(req)}
+	for _, reply := range replies {
+		in.Replies = append(in.Replies, record(reply.NATS()))
+	}
+	var rerr *natsv2.RequestError
+	switch {
+	case err == nil, errors.As(err, &rerr):
+	case errors.Is(err, natsv2.ErrNoResponders):
+		in.Error = "no responders"
+	case errors.Is(err, natsv2.ErrTimeout):
+		in.Error = "timeout"
+	default:
+		in.Error = err.Error()
+	}
+	r.mu.Lock()
+	r.interactions = append(r.interactions, in)
+	r.mu.Unlock()
+}
+
+// Save writes what was recorded so far.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
+
+// Replay is a Conn that answers the requests recorded in path.
+func Replay(path string) (*Conn, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var interactions []interaction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		return nil, fmt.Errorf("natsv2test: %s: %w", path, err)
+	}
+	c := New()
+	c.cassette = &cassette{interactions: interactions, used: map[int]bool{}}
+	return c, nil
+}
+
+// Cassette records or replays, see above. The recording is saved once the
+// test is done.
+func Cassette(t testing.TB, path string, connect func() (natsv2.Connection, error)) natsv2.Connection {
+	t.Helper()
+	if _, err := os.Stat(path); err == nil && os.Getenv(RecordEnv) == "" {
+		c, err := Replay(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	nc, err := connect()
+	if err != nil {
+		t.Fatalf("natsv2test: connect to record %s: %v", path, err)
+	}
+	r := Record(nc, path)
+	t.Cleanup(func() {
+		if err := r.Save(); err != nil {
+			t.Errorf("natsv2test: saving %s: %v", path, err)
+		}
+		nc.Close()
+	})
+	return r
+}
+
+type cassette struct {
+	mu           sync.Mutex
+	interactions []interaction
+	used         map[int]bool
+}
+
+// find is the next unused interaction for the request, or the last used
+// one once they are all used.
+func (cs *cassette) find(m *nats.Msg) (interaction, bool) {
+	if cs == nil {
+		return interaction{}, false
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	last := -1
+	for i, in := range cs.interactions {
+		if in.Subject != m.Subject || !bytes.Equal(in.Request.data(), m.Data) {
+			continue
+		}
+		if !cs.used[i] {
+			cs.used[i] = true
+			return in, true
+		}
+		last = i
+	}
+	if last < 0 {
+		return interaction{}, false
+	}
+	return cs.interactions[last], true
+}
+
+func (in interaction) replay(c *Conn, m *nats.Msg) ([]*natsv2.Msg, error) {
+	switch {
+	case in.Error == "" && len(in.Replies) > 0:
+	case in.Error == "no responders":
+		return nil, fmt.Errorf("%w on %q", natsv2.ErrNoResponders, in.Subject)
+	case in.Error == "timeout", in.Error == "":
+		return nil, fmt.Errorf("%w on %q", natsv2.ErrTimeout, in.Subject)
+	default:
+		return nil, errors.New(in.Error)
+	}
+	m.Reply = "_INBOX." + nuid.Next()
+	c.mu.Lock()
+	c.published = append(c.published, copyMsg(m))
+	c.mu.Unlock()
+	replies := make([]*natsv2.Msg, len(in.Replies))
+	for i, rm := range in.Replies {
+		replies[i] = natsv2.FromNATS(&nats.Msg{Subject: m.Reply, Header: rm.Header, Data: rm.data()})
+	}
+	return replies, nil
+}
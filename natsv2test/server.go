@@ -0,0 +1,91 @@
+package natsv2test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+
+	natsv2 "github.com/derekcollison/natsv2.go"
+)
+
+// For tests that need a real server, RunServer starts one in the test's
+// process on a random port and connects to it, both go away with the test:
+//
+//	nc := natsv2test.RunServer(t, natsv2test.JetStream())
+//
+// StartServer is just the server, for a URL to connect several times to.
+
+type ServerOption func(*serverConfig)
+
+type serverConfig struct {
+	opts    server.Options
+	connect []natsv2.ConnectOption
+}
+
+// JetStream enables JetStream, stored in a temp dir of the test's.
+func JetStream() ServerOption {
+	return func(c *serverConfig) {
+		c.opts.JetStream = true
+	}
+}
+
+// ServerOptions changes the server's options directly.
+func ServerOptions(fn func(*server.Options)) ServerOption {
+	return func(c *serverConfig) {
+		fn(&c.opts)
+	}
+}
+
+// ConnectOptions are used by RunServer to connect.
+func ConnectOptions(opts ...natsv2.ConnectOption) ServerOption {
+	return func(c *serverConfig) {
+		c.connect = append(c.connect, opts...)
+	}
+}
+
+func newServerConfig(t testing.TB, opts []ServerOption) *serverConfig {
+	c := &serverConfig{opts: server.Options{Host: "127.0.0.1", Port: server.RANDOM_PORT, NoLog: true, NoSigs: true}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.opts.JetStream && c.opts.StoreDir == "" {
+		c.opts.StoreDir = t.TempDir()
+	}
+	return c
+}
+
+func StartServer(t testing.TB, opts ...ServerOption) *server.Server {
+	t.Helper()
+	return startServer(t, newServerConfig(t, opts))
+}
+
+func startServer(t testing.TB, c *serverConfig) *server.Server {
+	t.Helper()
+	s, err := server.NewServer(&c.opts)
+	if err != nil {
+		t.Fatalf("natsv2test: server: %v", err)
+	}
+	s.Start()
+	t.Cleanup(func() {
+		s.Shutdown()
+		s.WaitForShutdown()
+	})
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("natsv2test: server not ready")
+	}
+	return s
+}
+
+func RunServer(t testing.TB, opts ...ServerOption) natsv2.Connection {
+	t.Helper()
+	c := newServerConfig(t, opts)
+	s := startServer(t, c)
+	nc, err := natsv2.Connect(s.ClientURL(), c.connect...)
+	if err != nil {
+		t.Fatalf("natsv2test: connect: %v", err)
+	}
+	// Registered after the server's, so it runs first.
+	t.Cleanup(nc.Close)
+	return nc
+}
@@ -0,0 +1,153 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Accept style codec negotiation. A requester lists the content types it can
+// read and the responder encodes with its preferred codec if that is on the
+// list, otherwise the best match it has, otherwise it replies with a service
+// error instead of bytes the requester can't read.
+
+const AcceptHeader = "Accept"
+
+var ErrNotAcceptable = errors.New("natsv2: no acceptable content type")
+
+// Accept sets the content types, highest preference first, a request will take
+// for the reply. Same syntax as HTTP, "application/*" and q values work.
+func Accept(contentTypes ...string) ReqOption {
+	return func(o *ReqOptions) error {
+		o.Accept = append(o.Accept, contentTypes...)
+		return nil
+	}
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mt, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+	return ranges
+}
+
+func (ar acceptRange) matches(contentType string) bool {
+	if ar.mediaType == "*/*" || ar.mediaType == contentType {
+		return true
+	}
+	if strings.HasSuffix(ar.mediaType, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(ar.mediaType, "*"))
+	}
+	return false
+}
+
+// negotiate picks the codec for a reply given the request's Accept header.
+func (cs *codecs) negotiate(accept string, preferred Codec) (Codec, error) {
+	if accept == "" {
+		return preferred, nil
+	}
+	ranges := parseAccept(accept)
+	for _, ar := range ranges {
+		if ar.matches(preferred.ContentType()) {
+			return preferred, nil
+		}
+	}
+	for _, ar := range ranges {
+		if codec := cs.byType[ar.mediaType]; codec != nil {
+			return codec, nil
+		}
+		// A wildcard range takes the first codec registered that it covers.
+		for _, ct := range cs.types {
+			if ar.matches(ct) {
+				return cs.byType[ct], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNotAcceptable, accept)
+}
+
+// Respond replies to req with v, encoded with the default codec unless the
+// request's Accept header asks for something else. If nothing acceptable is
+// registered the requester gets a 406 service error.
+func (c *conn) Respond(req *Msg, v interface{}) error {
+	return c.respond(req.m, v, c.codecs.def)
+}
+
+func (c *conn) respond(req *nats.Msg, v interface{}, preferred Codec) error {
+	reply, err := c.encodeReply(req, v, preferred)
+	if errors.Is(err, ErrNotAcceptable) {
+		if rerr := c.respondMsg(req, reply); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.codecs.compressReply(req, reply); err != nil {
+		return err
+	}
+	if req.Header.Get(ChunkedHeader) != "" {
+		return c.respondChunked(req, reply)
+	}
+	return c.respondMsg(req, reply)
+}
+
+// encodeReply encodes v in a codec req accepts. On ErrNotAcceptable the
+// returned reply is the 406 service error to send instead. Same as Publish
+// []byte, string and Payload go out as is.
+func (c *conn) encodeReply(req *nats.Msg, v interface{}, preferred Codec) (*nats.Msg, error) {
+	reply := nats.NewMsg(req.Reply)
+	switch raw := v.(type) {
+	case []byte:
+		reply.Data = raw
+		return reply, nil
+	case string:
+		reply.Data = []byte(raw)
+		return reply, nil
+	case Payload:
+		if raw.err != nil {
+			return nil, raw.err
+		}
+		reply.Data = raw.data
+		for k, v := range raw.header() {
+			reply.Header[k] = v
+		}
+		return reply, nil
+	}
+	codec, err := c.codecs.negotiate(req.Header.Get(AcceptHeader), preferred)
+	if err != nil {
+		reply.Header.Set(ServiceErrorHeader, "not acceptable")
+		reply.Header.Set(ServiceErrorCodeHeader, "406")
+		return reply, err
+	}
+	if reply.Data, err = codec.Encode(v); err != nil {
+		return nil, err
+	}
+	reply.Header.Set(ContentTypeHeader, codec.ContentType())
+	return reply, nil
+}
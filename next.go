@@ -0,0 +1,58 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A subscription without Handler or Channel is pulled from with Next, for
+// worker loops, tests and anything that wants to decide when it takes the
+// next message. Messages go through the same interceptors, Decompress,
+// AutoAck and DeadLetter as they would on the way to a handler.
+//
+//	sub, err := nc.Subscribe("jobs", Queue("workers"))
+//	for {
+//		m, err := sub.Next(ctx)
+//		if err != nil {
+//			break
+//		}
+//		...
+//	}
+
+var ErrNotSync = errors.New("natsv2: Next needs a subscription without Handler or Channel")
+
+// Next waits for the next message until ctx is done.
+func (s *subscription) Next(ctx context.Context) (*Msg, error) {
+	if s.sub.Type() != nats.SyncSubscription {
+		return nil, ErrNotSync
+	}
+	for {
+		if err := s.sopts.pause.wait(ctx); err != nil {
+			return nil, err
+		}
+		m, err := s.sub.NextMsgWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if last, _ := s.sopts.auto.counted(); last {
+			defer s.sopts.auto.finish(nil)
+		}
+		// Dropped on the way in, e.g. dead lettered.
+		var got *nats.Msg
+		s.c.wrapHandler(s.sopts, func(m *nats.Msg) { got = m })(m)
+		if got != nil {
+			return s.c.wrap(got), nil
+		}
+	}
+}
+
+// There is no waiting on several at once, a Channel does that.
+func (ms multiSubscription) Next(ctx context.Context) (*Msg, error) {
+	return nil, errors.New("natsv2: no Next on SubscribeMulti, use a Channel")
+}
+
+func (kw *kvWatch) Next(ctx context.Context) (*Msg, error) {
+	return nil, ErrNotSync
+}
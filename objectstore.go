@@ -0,0 +1,199 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ObjectStore is a JetStream object store bucket, for things too big for one
+// message. Objects are put from an io.Reader, or written with Writer, and read
+// back as an io.ReadCloser, JetStream cuts them into chunks and checks the
+// digest on the way back. Chunks are the size set with WithChunkSize, the same
+// as chunked requests use, DefaultChunkSize unless changed.
+//
+//	store, err := nc.ObjectStore("backups")
+//	store.Put("db.tar", f, ObjectDescription("nightly"))
+//	r, err := store.Get("db.tar")
+//	defer r.Close()
+//	io.Copy(dst, r)
+//
+// A link is a name that points at another object, in this bucket or
+// another, or at a whole bucket. Get on an object link reads the target.
+type ObjectStore interface {
+	Bucket() string
+	Put(name string, r io.Reader, opts ...ObjectOption) (*ObjectInfo, error)
+	Writer(name string, opts ...ObjectOption) io.WriteCloser
+	Get(name string) (io.ReadCloser, error)
+	Info(name string) (*ObjectInfo, error)
+	UpdateMeta(name string, opts ...ObjectOption) error
+	Delete(name string) error
+	List() ([]*ObjectInfo, error)
+	Link(name string, target *ObjectInfo) (*ObjectInfo, error)
+	LinkBucket(name string, target ObjectStore) (*ObjectInfo, error)
+	// See watchers.go.
+	Updates(ctx context.Context) (<-chan *ObjectInfo, error)
+}
+
+type ObjectInfo = nats.ObjectInfo
+
+var ErrObjectNotFound = nats.ErrObjectNotFound
+
+type ObjectStoreOption func(*ObjectStoreOptions) error
+
+type ObjectStoreOptions struct {
+	// Create the bucket with this if it does not exist.
+	Create *nats.ObjectStoreConfig
+}
+
+// CreateStore makes the bucket with cfg if it is not there yet, the bucket
+// name is filled in.
+func CreateStore(cfg nats.ObjectStoreConfig) ObjectStoreOption {
+	return func(o *ObjectStoreOptions) error {
+		o.Create = &cfg
+		return nil
+	}
+}
+
+type ObjectOption func(*nats.ObjectMeta) error
+
+func ObjectDescription(description string) ObjectOption {
+	return func(m *nats.ObjectMeta) error {
+		m.Description = description
+		return nil
+	}
+}
+
+func ObjectMetadata(md map[string]string) ObjectOption {
+	return func(m *nats.ObjectMeta) error {
+		m.Metadata = md
+		return nil
+	}
+}
+
+func ObjectHeaders(h nats.Header) ObjectOption {
+	return func(m *nats.ObjectMeta) error {
+		m.Headers = h
+		return nil
+	}
+}
+
+func (c *conn) ObjectStore(bucket string, opts ...ObjectStoreOption) (ObjectStore, error) {
+	oopts := &ObjectStoreOptions{}
+	for _, opt := range opts {
+		if err := opt(oopts); err != nil {
+			return nil, err
+		}
+	}
+	obs, err := c.js.ObjectStore(bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) && oopts.Create != nil {
+		cfg := *oopts.Create
+		cfg.Bucket = bucket
+		obs, err = c.js.CreateObjectStore(&cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &objectStore{c: c, bucket: bucket, obs: obs}, nil
+}
+
+type objectStore struct {
+	c      *conn
+	bucket string
+	obs    nats.ObjectStore
+}
+
+func (s *objectStore) Bucket() string {
+	return s.bucket
+}
+
+func (s *objectStore) meta(name string, opts []ObjectOption) (*nats.ObjectMeta, error) {
+	meta := &nats.ObjectMeta{Name: name, Opts: &nats.ObjectMetaOptions{ChunkSize: uint32(s.c.chunkSize())}}
+	for _, opt := range opts {
+		if err := opt(meta); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+func (s *objectStore) Put(name string, r io.Reader, opts ...ObjectOption) (*ObjectInfo, error) {
+	meta, err := s.meta(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.obs.Put(meta, r)
+}
+
+// Writer puts what is written as the object, it is stored once Close
+// returns nil.
+func (s *objectStore) Writer(name string, opts ...ObjectOption) io.WriteCloser {
+	pr, pw := io.Pipe()
+	w := &objectWriter{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := s.Put(name, pr, opts...)
+		pr.CloseWithError(err)
+		w.done <- err
+	}()
+	return w
+}
+
+type objectWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *objectWriter) Close() error {
+	w.pw.Close()
+	return <-w.done
+}
+
+func (s *objectStore) Get(name string) (io.ReadCloser, error) {
+	return s.obs.Get(name)
+}
+
+func (s *objectStore) Info(name string) (*ObjectInfo, error) {
+	return s.obs.GetInfo(name)
+}
+
+// UpdateMeta replaces the description, metadata and headers of name.
+func (s *objectStore) UpdateMeta(name string, opts ...ObjectOption) error {
+	meta := &nats.ObjectMeta{Name: name}
+	for _, opt := range opts {
+		if err := opt(meta); err != nil {
+			return err
+		}
+	}
+	return s.obs.UpdateMeta(name, meta)
+}
+
+func (s *objectStore) Delete(name string) error {
+	return s.obs.Delete(name)
+}
+
+// List is empty, not an error, for an empty bucket.
+func (s *objectStore) List() ([]*ObjectInfo, error) {
+	infos, err := s.obs.List()
+	if errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, nil
+	}
+	return infos, err
+}
+
+func (s *objectStore) Link(name string, target *ObjectInfo) (*ObjectInfo, error) {
+	return s.obs.AddLink(name, target)
+}
+
+func (s *objectStore) LinkBucket(name string, target ObjectStore) (*ObjectInfo, error) {
+	ts, ok := target.(*objectStore)
+	if !ok {
+		return nil, errors.New("natsv2: can only link to a bucket from ObjectStore")
+	}
+	return s.obs.AddBucketLink(name, ts.obs)
+}
@@ -0,0 +1,354 @@
+package natsv2
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Publishes made while disconnected normally wait in the client's reconnect
+// buffer, in memory and gone with the process. WithOfflineBuffer spills them
+// to files in dir instead, up to maxBytes, and publishes them in order once
+// connected again, after a restart too:
+//
+//	nc, err := Connect(url, WithOfflineBuffer("/var/lib/sensor/spool", 64<<20), WithMaxReconnects(-1))
+//
+// When full the oldest are dropped, a segment file of a sixteenth of
+// maxBytes at a time. Only publishes are kept, requests want their answer
+// now and use the reconnect buffer as before. Until the spool is empty new
+// publishes queue behind it so order holds. Getting cut off while replaying
+// sends that segment's messages again, a stream with Nats-Msg-Id drops the
+// second copies.
+//
+// A directory belongs to one connection at a time, so this doesn't go with
+// ConnectPool.
+
+const offlineSegments = 16
+
+func WithOfflineBuffer(dir string, maxBytes int64) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if dir == "" {
+			return errors.New("natsv2: empty offline buffer directory")
+		}
+		if maxBytes < offlineSegments {
+			return errors.New("natsv2: offline buffer too small")
+		}
+		o.OfflineDir, o.OfflineMaxBytes = dir, maxBytes
+		return nil
+	}
+}
+
+// Directories in use, by absolute path.
+var offlineDirs sync.Map
+
+type spool struct {
+	dir    string
+	max    int64
+	segMax int64
+
+	mu sync.Mutex
+	// Oldest first, f is the last one if it is still written to.
+	segs []spoolSeg
+	size int64
+	f    *os.File
+	// Set while anything is spooled, new publishes go behind it.
+	active    bool
+	replaying bool
+	closed    bool
+}
+
+type spoolSeg struct {
+	seq  uint64
+	size int64
+}
+
+func openSpool(dir string, max int64) (*spool, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o700); err != nil {
+		return nil, err
+	}
+	if _, loaded := offlineDirs.LoadOrStore(abs, true); loaded {
+		return nil, fmt.Errorf("natsv2: offline buffer %s is in use", abs)
+	}
+	s := &spool{dir: abs, max: max, segMax: max / offlineSegments}
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		offlineDirs.Delete(abs)
+		return nil, err
+	}
+	for _, e := range entries {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), ".spool"), 10, 64)
+		if err != nil || !strings.HasSuffix(e.Name(), ".spool") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		s.segs = append(s.segs, spoolSeg{seq, info.Size()})
+		s.size += info.Size()
+	}
+	sort.Slice(s.segs, func(i, j int) bool { return s.segs[i].seq < s.segs[j].seq })
+	s.active = len(s.segs) > 0
+	return s, nil
+}
+
+func (s *spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d.spool", seq))
+}
+
+// spill adds m if we are disconnected or still replaying, and reports
+// whether it did.
+func (s *spool) spill(m *nats.Msg, disconnected bool) (bool, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || !s.active && !disconnected {
+		return false, 0, nil
+	}
+	rec, err := spoolRecord(m)
+	if err != nil {
+		return true, 0, err
+	}
+	if int64(len(rec)) > s.segMax {
+		return true, 0, fmt.Errorf("natsv2: %d byte message is too big for the offline buffer", len(rec))
+	}
+	if s.f == nil || s.segs[len(s.segs)-1].size+int64(len(rec)) > s.segMax {
+		if err := s.rotate(); err != nil {
+			return true, 0, err
+		}
+	}
+	if _, err := s.f.Write(rec); err != nil {
+		return true, 0, err
+	}
+	s.active = true
+	s.segs[len(s.segs)-1].size += int64(len(rec))
+	s.size += int64(len(rec))
+	var dropped int64
+	for s.size > s.max && len(s.segs) > 1 {
+		dropped += s.segs[0].size
+		s.remove(s.segs[0].seq)
+	}
+	return true, dropped, nil
+}
+
+func (s *spool) rotate() error {
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	var seq uint64 = 1
+	if len(s.segs) > 0 {
+		seq = s.segs[len(s.segs)-1].seq + 1
+	}
+	f, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.segs = append(s.segs, spoolSeg{seq: seq})
+	return nil
+}
+
+func (s *spool) remove(seq uint64) {
+	for i, seg := range s.segs {
+		if seg.seq == seq {
+			if s.f != nil && i == len(s.segs)-1 {
+				s.f.Close()
+				s.f = nil
+			}
+			s.segs = append(s.segs[:i], s.segs[i+1:]...)
+			s.size -= seg.size
+			os.Remove(s.path(seq))
+			return
+		}
+	}
+}
+
+// next is the oldest segment to replay, closed for writing so it doesn't
+// grow meanwhile. Once there are none new publishes go straight out again.
+func (s *spool) next() (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segs) == 0 || s.closed {
+		s.active = len(s.segs) > 0
+		return 0, false
+	}
+	if s.f != nil && len(s.segs) == 1 {
+		s.f.Close()
+		s.f = nil
+	}
+	return s.segs[0].seq, true
+}
+
+func (s *spool) done(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(seq)
+}
+
+func (s *spool) bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *spool) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	offlineDirs.Delete(s.dir)
+}
+
+// A record is the subject, the header as JSON and the data, each after its
+// length as a uvarint.
+func spoolRecord(m *nats.Msg) ([]byte, error) {
+	var header []byte
+	if len(m.Header) > 0 {
+		var err error
+		if header, err = json.Marshal(m.Header); err != nil {
+			return nil, err
+		}
+	}
+	rec := make([]byte, 0, len(m.Subject)+len(header)+len(m.Data)+3*binary.MaxVarintLen32)
+	for _, b := range [][]byte{[]byte(m.Subject), header, m.Data} {
+		rec = binary.AppendUvarint(rec, uint64(len(b)))
+		rec = append(rec, b...)
+	}
+	return rec, nil
+}
+
+// read calls fn with each message in the segment. One cut short by a
+// crash ends where it was cut.
+func (s *spool) read(seq uint64, fn func(*nats.Msg) error) error {
+	f, err := os.Open(s.path(seq))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		var parts [3][]byte
+		for i := range parts {
+			n, err := binary.ReadUvarint(r)
+			if err == nil && n > uint64(s.segMax) {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == nil {
+				parts[i] = make([]byte, n)
+				_, err = io.ReadFull(r, parts[i])
+			}
+			if err != nil {
+				return nil
+			}
+		}
+		m := &nats.Msg{Subject: string(parts[0]), Data: parts[2]}
+		if len(parts[1]) > 0 && json.Unmarshal(parts[1], &m.Header) != nil {
+			continue
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+}
+
+// spill is publish's way out while the offline buffer is in use.
+func (c *conn) spill(m *nats.Msg) (bool, error) {
+	if c.offline == nil || m.Reply != "" {
+		return false, nil
+	}
+	// Not while closing, those publishes fail as usual.
+	var disconnected bool
+	switch c.nc.Status() {
+	case nats.DISCONNECTED, nats.RECONNECTING, nats.CONNECTING:
+		disconnected = true
+	}
+	ok, dropped, err := c.offline.spill(m, disconnected)
+	if dropped > 0 {
+		c.log.Warn("offline buffer full, dropped the oldest", "bytes", dropped)
+	}
+	if ok && !disconnected {
+		// Connected with something left over, e.g. after a failed flush.
+		c.replayOffline()
+	}
+	return ok, err
+}
+
+// replayOffline starts publishing what is spooled unless that is already
+// going on.
+func (c *conn) replayOffline() {
+	s := c.offline
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.replaying || !s.active || s.closed {
+		return
+	}
+	s.replaying = true
+	go c.replay()
+}
+
+// replay publishes oldest first, a segment at a time and each flushed before
+// it is removed. It stops when cut off again, the next reconnect carries on.
+func (c *conn) replay() {
+	s := c.offline
+	defer func() {
+		s.mu.Lock()
+		s.replaying = false
+		s.mu.Unlock()
+	}()
+	for {
+		seq, ok := s.next()
+		if !ok {
+			return
+		}
+		now := time.Now()
+		err := s.read(seq, func(m *nats.Msg) error {
+			if c.nc.Status() != nats.CONNECTED {
+				return nats.ErrDisconnected
+			}
+			if expired(m, now) {
+				return nil
+			}
+			return c.nc.PublishMsg(m)
+		})
+		if err == nil {
+			err = c.nc.Flush()
+		}
+		if err != nil {
+			c.log.Warn("offline buffer replay stopped", "error", err)
+			return
+		}
+		s.done(seq)
+	}
+}
+
+// closeOffline lets go of the directory, what is left stays for next time.
+func (c *conn) closeOffline() {
+	if c.offline != nil {
+		c.offline.close()
+	}
+}
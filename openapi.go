@@ -0,0 +1,466 @@
+package natsv2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An OpenAPI 3 document for what is served with Handle, Mount and service
+// endpoints with an HTTPHandler, so a gateway in front of HTTPProxy can be
+// set up from it. Routes say what they take and give with Operations, the
+// schemas come from the Go types by their json tags:
+//
+//	nc.Handle("api.users.{id}", getUser, Operations(
+//		APIOperation{Method: "GET", Summary: "Get a user", Response: User{}},
+//		APIOperation{Method: "PUT", Request: User{}, Status: 204},
+//	))
+//	nc.Handle("api.openapi", OpenAPIHandler(nc, OpenAPIInfo{Title: "Users", Version: "1.0.0", Prefix: "api"}))
+//	http.ListenAndServe(":8080", HTTPProxy(nc, "api"))
+//
+// The gateway then gets it from GET /openapi, or over NATS with
+// ServeOpenAPI. Subjects map to paths as in HTTPProxy, named tokens become
+// path parameters, '*' the number Params has for it and '>' {rest}, which
+// only matches one path segment in most gateways. Routes without operations
+// are listed with every method as they take any. The document is made when
+// asked for, so it has the routes of that moment.
+
+// An APIOperation is one method of a route.
+type APIOperation struct {
+	// GET if empty.
+	Method      string
+	ID          string
+	Summary     string
+	Description string
+	Tags        []string
+	// Values of the body types, nil for none, e.g. User{} or []User(nil).
+	Request  interface{}
+	Response interface{}
+	// Of a good response, 200 if 0.
+	Status int
+}
+
+type HandleOption func(*HandleOptions) error
+
+type HandleOptions struct {
+	Operations []APIOperation
+}
+
+func Operations(ops ...APIOperation) HandleOption {
+	return func(o *HandleOptions) error {
+		if err := checkOperations(ops); err != nil {
+			return err
+		}
+		o.Operations = append(o.Operations, ops...)
+		return nil
+	}
+}
+
+// ServiceOperations documents an endpoint with an HTTPHandler, the service
+// name is added to the tags.
+func ServiceOperations(ops ...APIOperation) ServiceOption {
+	return func(o *ServiceOptions) error {
+		if err := checkOperations(ops); err != nil {
+			return err
+		}
+		o.Operations = append(o.Operations, ops...)
+		return nil
+	}
+}
+
+func checkOperations(ops []APIOperation) error {
+	for _, op := range ops {
+		if op.Method != "" && strings.ToUpper(op.Method) != op.Method || strings.ContainsAny(op.Method, " /") {
+			return fmt.Errorf("natsv2: invalid http method %q", op.Method)
+		}
+		if op.Status != 0 && (op.Status < 100 || op.Status > 599) {
+			return fmt.Errorf("natsv2: invalid http status %d", op.Status)
+		}
+	}
+	return nil
+}
+
+type OpenAPIInfo struct {
+	Title       string
+	Version     string
+	Description string
+	// Only subjects under it are listed, with it taken off, as HTTPProxy's
+	// subjectPrefix.
+	Prefix string
+	// URLs of the bridges.
+	Servers []string
+}
+
+// OpenAPI is the document as JSON. c is a connection from Connect or a
+// Pool, others have no routes to tell about.
+func OpenAPI(c Connection, info OpenAPIInfo) ([]byte, error) {
+	var conns []*conn
+	switch c := c.(type) {
+	case *conn:
+		conns = []*conn{c}
+	case *Pool:
+		conns = c.conns
+	default:
+		return nil, fmt.Errorf("natsv2: no openapi for a %T", c)
+	}
+	if info.Title == "" || info.Version == "" {
+		return nil, errors.New("natsv2: openapi needs a title and version")
+	}
+	doc := &openAPIDoc{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: info.Title, Version: info.Version, Description: info.Description},
+		Paths:   map[string]map[string]*openAPIOperation{},
+	}
+	for _, u := range info.Servers {
+		doc.Servers = append(doc.Servers, openAPIServer{URL: u})
+	}
+	schemas := newSchemaSet()
+	for _, c := range conns {
+		for _, rt := range c.routes.list() {
+			doc.add(info.Prefix, rt, rt.opts.Operations, nil, schemas)
+		}
+		c.services.Range(func(k, _ interface{}) bool {
+			s := k.(*service)
+			s.mu.Lock()
+			endpoints := append([]*endpoint(nil), s.endpoints...)
+			s.mu.Unlock()
+			for _, e := range endpoints {
+				if e.opts.HTTPHandler == nil {
+					continue
+				}
+				rt, err := newRoute(e.opts.Subject, nil)
+				if err != nil {
+					continue
+				}
+				doc.add(info.Prefix, rt, e.opts.Operations, []string{s.name}, schemas)
+			}
+			return true
+		})
+	}
+	if len(schemas.defs) > 0 {
+		doc.Components = &openAPIComponents{Schemas: schemas.defs}
+	}
+	return json.Marshal(doc)
+}
+
+// ServeOpenAPI answers requests on subject with the document.
+func ServeOpenAPI(c Connection, subject string, info OpenAPIInfo) (Subscription, error) {
+	if _, err := OpenAPI(c, info); err != nil {
+		return nil, err
+	}
+	return c.Subscribe(subject, Handler(func(m *Msg) {
+		doc, err := OpenAPI(c, info)
+		if err != nil {
+			m.RespondError("500", err)
+			return
+		}
+		m.Respond(doc)
+	}))
+}
+
+// OpenAPIHandler serves the document, for Handle or an HTTP server.
+func OpenAPIHandler(c Connection, info OpenAPIInfo) HTTPHandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		doc, err := OpenAPI(c, info)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
+
+// Just enough of the model for what we write.
+type openAPIDoc struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers,omitempty"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components *openAPIComponents                      `json:"components,omitempty"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIOperation struct {
+	ID          string                      `json:"operationId,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Required bool                    `json:"required"`
+	Content  map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIMedia struct {
+	Schema *schema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*schema `json:"schemas"`
+}
+
+// Methods of a route that doesn't say.
+var anyMethod = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+func (doc *openAPIDoc) add(prefix string, rt *route, ops []APIOperation, tags []string, schemas *schemaSet) {
+	path, params, ok := rt.openAPIPath(prefix)
+	if !ok {
+		return
+	}
+	if len(ops) == 0 {
+		for _, method := range anyMethod {
+			ops = append(ops, APIOperation{Method: method})
+		}
+	}
+	methods := doc.Paths[path]
+	if methods == nil {
+		methods = map[string]*openAPIOperation{}
+		doc.Paths[path] = methods
+	}
+	for _, op := range ops {
+		method := op.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		o := &openAPIOperation{
+			ID:          op.ID,
+			Summary:     op.Summary,
+			Description: op.Description,
+			Tags:        append(append([]string(nil), tags...), op.Tags...),
+			Parameters:  params,
+			Responses:   map[string]*openAPIResponse{},
+		}
+		if op.Request != nil {
+			o.RequestBody = &openAPIBody{Required: true, Content: schemas.content(op.Request)}
+		}
+		resp := &openAPIResponse{Description: http.StatusText(status)}
+		if op.Response != nil {
+			resp.Content = schemas.content(op.Response)
+		}
+		o.Responses[fmt.Sprint(status)] = resp
+		methods[strings.ToLower(method)] = o
+	}
+}
+
+// openAPIPath is the path HTTPProxy serves rt on under prefix, and its
+// parameters.
+func (rt *route) openAPIPath(prefix string) (string, []openAPIParameter, bool) {
+	kinds, tokens, names := rt.kinds, rt.tokens, rt.names
+	if prefix != "" {
+		pt := strings.Split(prefix, ".")
+		if len(pt) > len(tokens) {
+			return "", nil, false
+		}
+		for i, t := range pt {
+			if kinds[i] != literalToken || tokens[i] != t {
+				return "", nil, false
+			}
+		}
+		kinds, tokens, names = kinds[len(pt):], tokens[len(pt):], names[len(pt):]
+	}
+	var params []openAPIParameter
+	segs := make([]string, len(tokens))
+	for i, t := range tokens {
+		switch kinds[i] {
+		case literalToken:
+			segs[i] = t
+			continue
+		case fwcToken:
+			segs[i] = "{rest}"
+			params = append(params, openAPIParameter{Name: "rest", In: "path", Required: true, Schema: &schema{Type: "string"}})
+			continue
+		}
+		segs[i] = "{" + names[i] + "}"
+		params = append(params, openAPIParameter{Name: names[i], In: "path", Required: true, Schema: &schema{Type: "string"}})
+	}
+	return "/" + strings.Join(segs, "/"), params, true
+}
+
+// A JSON schema, the OpenAPI 3.0 flavor.
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+}
+
+// schemaSet gives named structs a schema in the components, so they are
+// written once and can refer to themselves.
+type schemaSet struct {
+	names map[reflect.Type]string
+	defs  map[string]*schema
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{names: map[reflect.Type]string{}, defs: map[string]*schema{}}
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	rawJSONType   = reflect.TypeOf(json.RawMessage(nil))
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func (s *schemaSet) content(v interface{}) map[string]openAPIMedia {
+	t := reflect.TypeOf(v)
+	switch {
+	case t.Kind() == reflect.String:
+		return map[string]openAPIMedia{"text/plain": {Schema: &schema{Type: "string"}}}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && t != rawJSONType:
+		return map[string]openAPIMedia{"application/octet-stream": {Schema: &schema{Type: "string", Format: "binary"}}}
+	}
+	return map[string]openAPIMedia{"application/json": {Schema: s.of(t)}}
+}
+
+func (s *schemaSet) of(t reflect.Type) *schema {
+	switch t {
+	case timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &schema{Type: "integer", Format: "int64"}
+	}
+	if t.Kind() != reflect.Ptr && (t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType)) {
+		// Whatever it makes of itself.
+		return &schema{}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		sc := s.of(t.Elem())
+		if sc.Ref != "" {
+			return sc
+		}
+		sc.Nullable = true
+		return sc
+	case reflect.Bool:
+		return &schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &schema{Type: "string", Format: "byte"}
+		}
+		return &schema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name, ok := s.names[t]
+		if !ok {
+			name = s.name(t)
+			s.names[t] = name
+			// Taken while it is made.
+			s.defs[name] = nil
+			s.defs[name] = s.object(t)
+		}
+		return &schema{Ref: "#/components/schemas/" + name}
+	}
+	return &schema{}
+}
+
+// name is the type's, with a number when another package has one of the
+// same name.
+func (s *schemaSet) name(t reflect.Type) string {
+	// Generic types have the type arguments in there.
+	base := strings.NewReplacer("[", "_", "]", "", "*", "", "/", "_", " ", "", ",", "_").Replace(t.Name())
+	name := base
+	for i := 2; ; i++ {
+		if _, taken := s.defs[name]; !taken {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+func (s *schemaSet) object(t reflect.Type) *schema {
+	sc := &schema{Type: "object", Properties: map[string]*schema{}}
+	s.fields(sc, t)
+	sort.Strings(sc.Required)
+	return sc
+}
+
+// fields adds t's fields as encoding/json sees them, embedded structs
+// without a name in the tag are flattened.
+func (s *schemaSet) fields(sc *schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.fields(sc, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := s.of(ft)
+		if strings.Contains(","+opts+",", ",string,") {
+			fs = &schema{Type: "string"}
+		}
+		sc.Properties[name] = fs
+		if !strings.Contains(","+opts+",", ",omitempty,") && ft.Kind() != reflect.Ptr {
+			sc.Required = append(sc.Required, name)
+		}
+	}
+}
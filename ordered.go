@@ -0,0 +1,51 @@
+package natsv2
+
+import (
+	"errors"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Ordered consumers, for building a view of a stream. The consumer is an
+// ephemeral push consumer the client tracks the sequences of, messages are
+// handed over one at a time in stream order and never need acking. A gap,
+// say after a slow consumer drop, or missed heartbeats from the server
+// recreate the consumer from the last sequence seen, so the handler sees
+// every message once and in order even across server restarts.
+//
+//	view := map[string]Order{}
+//	nc.Stream("orders.>", JetStreamStream("ORDERS")).Subscribe(Ordered(), Handler(func(m *Msg) { apply(view, m) }))
+//
+// Heartbeats are every 5 seconds and two missed ones recreate the consumer,
+// for sooner set Heartbeat with JetStreamConsumer before Ordered.
+//
+// Workers and queues would undo the ordering so they can't be used with it,
+// neither can a durable name or pulling.
+
+func Ordered() SubOption {
+	return func(o *SubOptions) error {
+		if o.Consumer == nil {
+			o.Consumer = &ConsumerOptions{}
+		}
+		o.Consumer.Ordered = true
+		o.Consumer.AckPolicy = AckNone
+		return nil
+	}
+}
+
+func (c *conn) orderedOpts(sopts *SubOptions) ([]nats.SubOpt, error) {
+	co := sopts.Consumer
+	switch {
+	case co.Durable != "" || co.Pull:
+		return nil, errors.New("natsv2: ordered consumers are ephemeral push consumers")
+	case sopts.Queue != "":
+		return nil, errors.New("natsv2: ordered consumers can not be shared by a queue")
+	case sopts.Workers > 1 || sopts.OrderBySubject || sopts.MaxConcurrent > 1:
+		return nil, errors.New("natsv2: ordered consumers deliver one at a time, not to workers")
+	}
+	jopts := []nats.SubOpt{nats.OrderedConsumer()}
+	if sopts.stream != "" {
+		jopts = append(jopts, nats.BindStream(sopts.stream))
+	}
+	return jopts, nil
+}
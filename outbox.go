@@ -0,0 +1,284 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// The outbox pattern: messages are written to a local store first, in the
+// same transaction as the data they are about where the store allows it,
+// and a background pump publishes them and deletes them once the server
+// has them. A crash or an outage only delays them, at least once.
+//
+//	ob, err := NewOutbox(nc, natsbolt.New(db), OutboxJetStream("ORDERS"))
+//	err = ob.Publish(ctx, "orders.placed", order)
+//
+// natssql stores them in a table, so they commit or roll back with the
+// rest of a database transaction:
+//
+//	store := natssql.New(db, "outbox")
+//	msg, err := ob.Message("orders.placed", order)
+//	err = store.AddTx(ctx, tx, msg)
+//	err = tx.Commit()
+//	ob.Notify()
+//
+// Messages go out in the order they were added. Each carries its outbox id
+// as Nats-Msg-Id, so with OutboxJetStream a message sent twice, after a
+// crash between publishing and deleting it, is stored once. On core NATS a
+// publish counts as done once a flush comes back.
+
+type OutboxStore interface {
+	Add(ctx context.Context, msgs ...OutboxMsg) error
+	// Pending is up to max of the oldest messages not yet deleted.
+	Pending(ctx context.Context, max int) ([]OutboxMsg, error)
+	Delete(ctx context.Context, ids ...string) error
+}
+
+type OutboxMsg struct {
+	// Orders by when it was made, see NewOutboxID.
+	ID      string
+	Subject string
+	Header  Header
+	Data    []byte
+}
+
+var outboxSeq atomic.Uint64
+
+// NewOutboxID is the time, then a counter and a nuid to be unique, so ids
+// sort in the order they were made.
+func NewOutboxID() string {
+	return fmt.Sprintf("%020d-%010d-%s", time.Now().UnixNano(), outboxSeq.Add(1)%1e10, nuid.Next())
+}
+
+type OutboxOption func(*OutboxOptions) error
+
+type OutboxOptions struct {
+	// Publish through JetStream to this stream and wait for the ack.
+	Stream string
+	// How often to look for messages besides when told, DefaultOutboxInterval
+	// if 0.
+	Interval time.Duration
+	// Messages per pass, DefaultOutboxBatch if 0.
+	Batch int
+}
+
+const (
+	DefaultOutboxInterval = time.Second
+	DefaultOutboxBatch    = 100
+)
+
+func OutboxJetStream(stream string) OutboxOption {
+	return func(o *OutboxOptions) error {
+		if stream == "" {
+			return errors.New("natsv2: empty stream name")
+		}
+		o.Stream = stream
+		return nil
+	}
+}
+
+func OutboxInterval(d time.Duration) OutboxOption {
+	return func(o *OutboxOptions) error {
+		if d <= 0 {
+			return errors.New("natsv2: outbox interval must be positive")
+		}
+		o.Interval = d
+		return nil
+	}
+}
+
+func OutboxBatch(n int) OutboxOption {
+	return func(o *OutboxOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: outbox batch must be at least 1")
+		}
+		o.Batch = n
+		return nil
+	}
+}
+
+type Outbox struct {
+	nc     Connection
+	store  OutboxStore
+	opts   OutboxOptions
+	notify chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+	log    Logger
+}
+
+func NewOutbox(nc Connection, store OutboxStore, opts ...OutboxOption) (*Outbox, error) {
+	o := &Outbox{nc: nc, store: store, notify: make(chan struct{}, 1), done: make(chan struct{}), log: nopLogger{}}
+	for _, opt := range opts {
+		if err := opt(&o.opts); err != nil {
+			return nil, err
+		}
+	}
+	if o.opts.Interval == 0 {
+		o.opts.Interval = DefaultOutboxInterval
+	}
+	if o.opts.Batch == 0 {
+		o.opts.Batch = DefaultOutboxBatch
+	}
+	if c := connFor(nc, ""); c != nil {
+		o.log = c.log
+	}
+	var ctx context.Context
+	ctx, o.cancel = context.WithCancel(context.Background())
+	go o.pump(ctx)
+	return o, nil
+}
+
+// connFor is the connection subject goes out on, nil for other kinds of
+// Connection.
+func connFor(nc Connection, subject string) *conn {
+	switch c := nc.(type) {
+	case *conn:
+		return c
+	case *Pool:
+		return c.Conn(subject).(*conn)
+	}
+	return nil
+}
+
+// Message encodes v as Publish would, for adding to the store yourself.
+func (o *Outbox) Message(subject string, v interface{}, opts ...PubOption) (OutboxMsg, error) {
+	if err := checkSubject(subject, false); err != nil {
+		return OutboxMsg{}, err
+	}
+	popts := &PubOptions{}
+	for _, opt := range opts {
+		if err := opt(popts); err != nil {
+			return OutboxMsg{}, err
+		}
+	}
+	if popts.jetStreamOnly() || popts.Delay > 0 {
+		return OutboxMsg{}, errors.New("natsv2: outbox messages take headers only, the id is the outbox's")
+	}
+	var m *nats.Msg
+	var err error
+	if c := connFor(o.nc, subject); c != nil {
+		m, err = c.codecs.encode(subject, v, c.codecs.out)
+	} else {
+		m, err = Encode(subject, v, "")
+	}
+	if err != nil {
+		return OutboxMsg{}, err
+	}
+	setHeaders(m, popts.Headers)
+	popts.setExpires(m, false)
+	return OutboxMsg{ID: NewOutboxID(), Subject: subject, Header: Header(m.Header), Data: m.Data}, nil
+}
+
+// Publish adds v to the store, it goes out shortly after.
+func (o *Outbox) Publish(ctx context.Context, subject string, v interface{}, opts ...PubOption) error {
+	msg, err := o.Message(subject, v, opts...)
+	if err != nil {
+		return err
+	}
+	if err := o.store.Add(ctx, msg); err != nil {
+		return err
+	}
+	o.Notify()
+	return nil
+}
+
+// Notify wakes the pump, for messages added to the store directly.
+func (o *Outbox) Notify() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the pump, whatever is left stays in the store for next time.
+func (o *Outbox) Close() {
+	o.cancel()
+	<-o.done
+}
+
+func (o *Outbox) pump(ctx context.Context) {
+	defer close(o.done)
+	t := time.NewTicker(o.opts.Interval)
+	defer t.Stop()
+	for {
+		for {
+			n, err := o.send(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					o.log.Warn("outbox publish failed", "error", err)
+				}
+				break
+			}
+			if n < o.opts.Batch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.notify:
+		case <-t.C:
+		}
+	}
+}
+
+// send publishes one batch in order, stopping at the first failure so
+// nothing overtakes it.
+func (o *Outbox) send(ctx context.Context) (int, error) {
+	msgs, err := o.store.Pending(ctx, o.opts.Batch)
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+	var sent []string
+	var serr error
+	for _, msg := range msgs {
+		if serr = o.publish(ctx, msg); serr != nil {
+			break
+		}
+		sent = append(sent, msg.ID)
+	}
+	if len(sent) > 0 && o.opts.Stream == "" {
+		// Core publishes are only known to be there after a flush.
+		if err := o.nc.Flush(ctx); err != nil {
+			return 0, err
+		}
+	}
+	if len(sent) > 0 {
+		if err := o.store.Delete(ctx, sent...); err != nil {
+			return 0, err
+		}
+	}
+	return len(sent), serr
+}
+
+func (o *Outbox) publish(ctx context.Context, msg OutboxMsg) error {
+	m := &nats.Msg{Subject: msg.Subject, Header: nats.Header{}, Data: msg.Data}
+	for k, v := range msg.Header {
+		m.Header[k] = v
+	}
+	c := connFor(o.nc, msg.Subject)
+	if c != nil {
+		m.Subject = c.outSubject(msg.Subject)
+	}
+	switch {
+	case c != nil && o.opts.Stream != "":
+		ctx, cancel := context.WithTimeout(ctx, jetStreamAckWait)
+		defer cancel()
+		_, err := c.publishJetStream(ctx, o.opts.Stream, m, nil, []PubOption{WithMsgID(msg.ID)})
+		return err
+	case c != nil:
+		m.Header.Set(MsgIDHeader, msg.ID)
+		return c.send(ctx, m, c.publish)
+	case o.opts.Stream != "":
+		return o.nc.Stream(msg.Subject, JetStreamStream(o.opts.Stream)).PublishCtx(ctx, msg.Data, Headers(m.Header), WithMsgID(msg.ID))
+	}
+	m.Header.Set(MsgIDHeader, msg.ID)
+	return o.nc.PublishCtx(ctx, msg.Subject, msg.Data, Headers(m.Header))
+}
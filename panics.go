@@ -0,0 +1,174 @@
+package natsv2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// What a panicking handler does to the process. By default, PanicRecover,
+// the panic is recovered as recover.go says and the subscription or service
+// goes on with the next message; PanicRethrow reports it the same way and
+// then panics again with the *PanicError, for those who would rather crash
+// and be restarted than go on in a state nobody thought of. The connection's
+// policy is set with WithPanicPolicy, a subscription's with Panics and a
+// service's, or an endpoint's, with ServicePanics:
+//
+//	nc, err := natsv2.Connect(url, WithPanicReports("ops.crashes"))
+//	nc.Subscribe("ledger.post", Panics(PanicRethrow), Handler(post))
+//
+// With WithPanicReports each panic is also published as a CrashReport, in
+// JSON, to the subject given, where whoever watches the fleet can pick them
+// up. A Metrics that is also a PanicMetrics counts them. A request whose
+// handler panicked gets a 500 back where it can, JetStream messages are nak'ed
+// or dead lettered as for an error.
+
+type PanicPolicy int
+
+const (
+	// The connection's, PanicRecover unless WithPanicPolicy says.
+	PanicDefault PanicPolicy = iota
+	PanicRecover
+	PanicRethrow
+)
+
+// CrashReport is what WithPanicReports publishes for a panic.
+type CrashReport struct {
+	Subject string `json:"subject"`
+	Queue   string `json:"queue,omitempty"`
+	Service string `json:"service,omitempty"`
+	Panic   string `json:"panic"`
+	Stack   string `json:"stack"`
+	// The connection's name, if it has one.
+	Client string    `json:"client,omitempty"`
+	Host   string    `json:"host"`
+	PID    int       `json:"pid"`
+	Time   time.Time `json:"time"`
+}
+
+// PanicMetrics is for a Metrics that counts handler panics.
+type PanicMetrics interface {
+	Panicked(subject string)
+}
+
+func checkPanicPolicy(p PanicPolicy) error {
+	switch p {
+	case PanicDefault, PanicRecover, PanicRethrow:
+		return nil
+	}
+	return fmt.Errorf("natsv2: unknown panic policy %d", p)
+}
+
+func WithPanicPolicy(p PanicPolicy) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if err := checkPanicPolicy(p); err != nil {
+			return err
+		}
+		o.Panics = p
+		return nil
+	}
+}
+
+// WithPanicReports publishes a CrashReport to subject for every panic.
+func WithPanicReports(subject string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if err := checkSubject(subject, false); err != nil {
+			return err
+		}
+		o.PanicReports = subject
+		return nil
+	}
+}
+
+func Panics(p PanicPolicy) SubOption {
+	return func(o *SubOptions) error {
+		if err := checkPanicPolicy(p); err != nil {
+			return err
+		}
+		o.Panics = p
+		return nil
+	}
+}
+
+func ServicePanics(p PanicPolicy) ServiceOption {
+	return func(o *ServiceOptions) error {
+		if err := checkPanicPolicy(p); err != nil {
+			return err
+		}
+		o.Panics = p
+		return nil
+	}
+}
+
+// panicSite is where a handler runs, for its panics.
+type panicSite struct {
+	policy         PanicPolicy
+	queue, service string
+}
+
+func (o *SubOptions) panicSite() panicSite {
+	if o == nil {
+		return panicSite{}
+	}
+	return panicSite{policy: o.Panics, queue: o.Queue}
+}
+
+func (e *endpoint) panicSite() panicSite {
+	return panicSite{policy: e.opts.Panics, queue: e.opts.Queue, service: e.s.name}
+}
+
+// panicked reports the panic r on subject, and with PanicRethrow panics
+// again once the report is out. It is the *PanicError, for those who go on.
+// One rethrown further in is on its way out already.
+func (c *conn) panicked(subject string, r interface{}, stack []byte, site panicSite) *PanicError {
+	if perr, ok := r.(*PanicError); ok {
+		panic(perr)
+	}
+	perr := &PanicError{Subject: subject, Value: r, Stack: stack, Queue: site.queue, Service: site.service}
+	c.handleError(perr)
+	if c != nil && c.rethrows(site) {
+		c.nc.FlushTimeout(time.Second)
+		panic(perr)
+	}
+	return perr
+}
+
+func (c *conn) rethrows(site panicSite) bool {
+	if site.policy == PanicDefault {
+		site.policy = c.opts.Panics
+	}
+	return site.policy == PanicRethrow
+}
+
+// reportPanic counts perr and publishes its CrashReport, on the way to the
+// ErrorHandler.
+func (c *conn) reportPanic(perr *PanicError) {
+	if pm, ok := c.metrics.(PanicMetrics); ok {
+		pm.Panicked(perr.Subject)
+	}
+	if c.opts.PanicReports == "" {
+		return
+	}
+	host, _ := os.Hostname()
+	data, err := json.Marshal(CrashReport{
+		Subject: perr.Subject,
+		Queue:   perr.Queue,
+		Service: perr.Service,
+		Panic:   fmt.Sprint(perr.Value),
+		Stack:   string(perr.Stack),
+		Client:  c.nc.Opts.Name,
+		Host:    host,
+		PID:     os.Getpid(),
+		Time:    time.Now().UTC(),
+	})
+	if err == nil {
+		err = c.nc.Publish(c.outSubject(c.opts.PanicReports), data)
+	}
+	if err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+		c.log.Warn("crash report not sent", "subject", c.opts.PanicReports, "error", err)
+	}
+}
@@ -0,0 +1,517 @@
+package natsv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Partitioned consumption, for when a queue group would do except that the
+// messages of one customer, device or order have to be handled in order.
+// The keys are hashed into n partitions and each partition is handled by
+// one member of the group, the Queue, at a time:
+//
+//	nc.Subscribe("orders.>", Queue("billing"), Handler(bill), Partitioned(16, func(m *Msg) string {
+//		return m.Header().Get("Customer")
+//	}))
+//
+// Members find each other through heartbeats every PartitionHeartbeat and
+// split the partitions by rendezvous hashing, so one joining or leaving only
+// moves its own share, OnRebalance says which a member has after each
+// change. All members must agree on n.
+//
+// With a key every member receives every message and drops those of other
+// members' partitions, fine for moderate rates on core NATS. Otherwise have
+// the server put the partition in the subject with a deterministic mapping,
+// which hashes like PartitionOf, and each member only subscribes to its own:
+//
+//	mappings: {"orders.*": "orders.{{partition(16,1)}}.{{wildcard(1)}}"}
+//	nc.Subscribe("orders.*.*", Queue("billing"), Handler(bill), PartitionedBySubject(16, 2))
+//
+// That is also the way for JetStream, each partition is a durable consumer
+// of its own named after the group, e.g. billing-3, filtered on its subject
+// and left on the server, so a partition changing hands carries on where
+// the last member acked.
+// A push consumer only goes to the new member once the old one has drained
+// it, set MaxAckPending to 1 to keep the order through redeliveries too.
+// On core NATS members may briefly overlap or miss messages during a
+// rebalance. Workers without OrderBySubject undo the ordering within a
+// member.
+
+const (
+	// Members are gone after three missed.
+	PartitionHeartbeat = time.Second
+
+	partitionSubject = "natsv2.partitions."
+	// For the others to answer a new member before it takes partitions.
+	partitionSettle = 100 * time.Millisecond
+)
+
+func Partitioned(n int, key func(*Msg) string) SubOption {
+	return func(o *SubOptions) error {
+		if n < 1 || key == nil {
+			return errors.New("natsv2: partitioned needs at least one partition and a key")
+		}
+		o.Partitions, o.PartitionKey, o.PartitionToken = n, key, 0
+		return nil
+	}
+}
+
+// PartitionedBySubject takes the partition from the subject's token-th
+// token, counting from 1, which has to be a * in the subscription.
+func PartitionedBySubject(n, token int) SubOption {
+	return func(o *SubOptions) error {
+		if n < 1 || token < 1 {
+			return errors.New("natsv2: partitioned needs at least one partition and a token")
+		}
+		o.Partitions, o.PartitionKey, o.PartitionToken = n, nil, token
+		return nil
+	}
+}
+
+func OnRebalance(cb func(partitions []int)) SubOption {
+	return func(o *SubOptions) error {
+		o.OnRebalance = cb
+		return nil
+	}
+}
+
+// PartitionOf is key's partition, the same as the server's partition
+// mapping function gives.
+func PartitionOf(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+func (c *conn) subscribePartitioned(subject string, sopts *SubOptions) (Subscription, error) {
+	group := sopts.Queue
+	switch {
+	case group == "":
+		return nil, errors.New("natsv2: partitioned subscriptions need a Queue to name the group")
+	case strings.ContainsAny(group, ".*>"):
+		return nil, fmt.Errorf("natsv2: partition group %q must be a single subject token", group)
+	case sopts.Handler == nil && sopts.Channel == nil:
+		return nil, errors.New("natsv2: partitioned subscriptions need a Handler or Channel")
+	case sopts.Max > 0 || sopts.Until != nil || !sopts.Deadline.IsZero():
+		return nil, errors.New("natsv2: partitioned subscriptions don't end by themselves")
+	case sopts.PartitionKey != nil && sopts.Consumer != nil:
+		return nil, errors.New("natsv2: partitioned consumers need the partition in the subject, see PartitionedBySubject")
+	case sopts.Consumer != nil && sopts.Consumer.Ordered:
+		return nil, errors.New("natsv2: ordered consumers can't be partitioned")
+	}
+	if t := sopts.PartitionToken; t > 0 {
+		if tokens := strings.Split(subject, "."); t > len(tokens) || tokens[t-1] != "*" {
+			return nil, fmt.Errorf("natsv2: token %d of %q is not a *", t, subject)
+		}
+	}
+	ps := &partitionedSub{c: c, subject: subject, sopts: sopts, subs: map[int]Subscription{}}
+	pm := &partitionMember{
+		c:         c,
+		subject:   c.outSubject(partitionSubject + group),
+		of:        subject,
+		id:        nuid.Next(),
+		n:         sopts.Partitions,
+		key:       sopts.PartitionKey,
+		rebalance: sopts.OnRebalance,
+		apply:     ps.assign,
+		seen:      map[string]time.Time{},
+		changed:   make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	ps.pm = pm
+	if sopts.PartitionKey != nil {
+		// One subscription to everything, filtered by the partitions owned.
+		child := ps.child()
+		child.member = pm
+		sub, err := c.subscribeWith(c.outSubject(subject), child)
+		if err != nil {
+			return nil, err
+		}
+		ps.one = sub
+		pm.apply = pm.own
+	}
+	if err := pm.start(); err != nil {
+		if ps.one != nil {
+			ps.one.Unsubscribe()
+		}
+		return nil, err
+	}
+	return ps, nil
+}
+
+type partitionBeat struct {
+	ID string `json:"id"`
+	// The same group may partition several subjects.
+	Subject    string `json:"subject"`
+	Partitions int    `json:"partitions"`
+	Leaving    bool   `json:"leaving,omitempty"`
+}
+
+// A partitionMember keeps track of the group and which partitions are ours.
+type partitionMember struct {
+	c         *conn
+	subject   string
+	of        string
+	id        string
+	n         int
+	key       func(*Msg) string
+	rebalance func([]int)
+	apply     func(owned []bool)
+
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	warned  bool
+	sub     *nats.Subscription
+	owned   atomic.Value
+	changed chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (pm *partitionMember) start() error {
+	sub, err := pm.c.nc.Subscribe(pm.subject, pm.heard)
+	if err != nil {
+		return err
+	}
+	pm.sub = sub
+	pm.beat(false)
+	go pm.run()
+	return nil
+}
+
+func (pm *partitionMember) beat(leaving bool) {
+	data, _ := json.Marshal(partitionBeat{ID: pm.id, Subject: pm.of, Partitions: pm.n, Leaving: leaving})
+	pm.c.nc.Publish(pm.subject, data)
+}
+
+func (pm *partitionMember) heard(m *nats.Msg) {
+	var b partitionBeat
+	if json.Unmarshal(m.Data, &b) != nil || b.ID == pm.id || b.Subject != pm.of {
+		return
+	}
+	pm.mu.Lock()
+	if b.Partitions != pm.n {
+		warn := !pm.warned
+		pm.warned = true
+		pm.mu.Unlock()
+		if warn {
+			pm.c.log.Warn("partition group member has a different count, ignoring it", "subject", pm.subject, "partitions", b.Partitions, "want", pm.n)
+		}
+		return
+	}
+	_, known := pm.seen[b.ID]
+	if b.Leaving {
+		delete(pm.seen, b.ID)
+	} else {
+		pm.seen[b.ID] = time.Now()
+	}
+	pm.mu.Unlock()
+	if !known && !b.Leaving {
+		// So a new member needn't wait a heartbeat to hear of us.
+		pm.beat(false)
+	}
+	if known == b.Leaving {
+		select {
+		case pm.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (pm *partitionMember) run() {
+	defer close(pm.done)
+	t := time.NewTicker(PartitionHeartbeat)
+	defer t.Stop()
+	settle := time.NewTimer(partitionSettle)
+	defer settle.Stop()
+	settled := false
+	for {
+		select {
+		case <-settle.C:
+			settled = true
+		case <-pm.changed:
+		case <-t.C:
+			pm.beat(false)
+			pm.expire()
+		case <-pm.stop:
+			pm.beat(true)
+			return
+		case <-pm.c.hctx.Done():
+			// Shutdown drains the partitions' subscriptions.
+			pm.beat(true)
+			return
+		}
+		if settled {
+			pm.reconcile()
+		}
+	}
+}
+
+func (pm *partitionMember) expire() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	for id, at := range pm.seen {
+		if time.Since(at) > 3*PartitionHeartbeat {
+			delete(pm.seen, id)
+		}
+	}
+}
+
+// reconcile works out which partitions are ours and applies that, every
+// time, so a partition that couldn't be taken is tried again.
+func (pm *partitionMember) reconcile() {
+	pm.mu.Lock()
+	members := []string{pm.id}
+	for id := range pm.seen {
+		members = append(members, id)
+	}
+	pm.mu.Unlock()
+	sort.Strings(members)
+	owned := make([]bool, pm.n)
+	var mine []int
+	for p := range owned {
+		if owned[p] = partitionOwner(members, p) == pm.id; owned[p] {
+			mine = append(mine, p)
+		}
+	}
+	prev, _ := pm.owned.Load().([]bool)
+	pm.apply(owned)
+	if prev != nil && equalOwned(prev, owned) {
+		return
+	}
+	pm.owned.Store(owned)
+	pm.c.log.Info("partitions rebalanced", "subject", pm.subject, "members", len(members), "partitions", mine)
+	if pm.rebalance != nil {
+		pm.rebalance(mine)
+	}
+}
+
+// partitionOwner is the member hashing highest with p.
+func partitionOwner(members []string, p int) string {
+	var owner string
+	var best uint64
+	for _, id := range members {
+		h := fnv.New64a()
+		h.Write([]byte(id + "." + strconv.Itoa(p)))
+		if s := mix64(h.Sum64()); owner == "" || s > best {
+			owner, best = id, s
+		}
+	}
+	return owner
+}
+
+// mix64 is murmur3's finalizer, ids from one process only differ at the
+// end, which FNV alone leaves in the low bits.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func equalOwned(a, b []bool) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+// own is apply for a key, the filter reads owned.
+func (pm *partitionMember) own(owned []bool) {
+	pm.owned.Store(owned)
+}
+
+func (pm *partitionMember) filter(handler nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		owned, _ := pm.owned.Load().([]bool)
+		if owned != nil && owned[PartitionOf(pm.key(pm.c.wrap(m)), pm.n)] {
+			handler(m)
+		}
+	}
+}
+
+// leave tells the group, which takes over our partitions at once.
+func (pm *partitionMember) leave() {
+	pm.once.Do(func() {
+		close(pm.stop)
+		<-pm.done
+		pm.sub.Unsubscribe()
+	})
+}
+
+type partitionedSub struct {
+	c       *conn
+	pm      *partitionMember
+	subject string
+	sopts   *SubOptions
+	// With a key.
+	one Subscription
+
+	mu      sync.Mutex
+	subs    map[int]Subscription
+	paused  bool
+	stopped bool
+}
+
+// child is the options of a partition's subscription.
+func (ps *partitionedSub) child() *SubOptions {
+	child := *ps.sopts
+	child.Queue = ""
+	child.Partitions, child.PartitionKey, child.PartitionToken, child.OnRebalance = 0, nil, 0, nil
+	return &child
+}
+
+func (ps *partitionedSub) assign(owned []bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.stopped {
+		return
+	}
+	for p, mine := range owned {
+		sub, ok := ps.subs[p]
+		switch {
+		case mine && !ok:
+			sub, err := ps.subscribe(p)
+			if err != nil {
+				ps.c.log.Warn("partition not taken, trying again", "subject", ps.subject, "partition", p, "error", err)
+				continue
+			}
+			ps.subs[p] = sub
+		case !mine && ok:
+			delete(ps.subs, p)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+				defer cancel()
+				sub.Drain(ctx)
+			}()
+		}
+	}
+}
+
+func (ps *partitionedSub) subscribe(p int) (Subscription, error) {
+	tokens := strings.Split(ps.subject, ".")
+	tokens[ps.sopts.PartitionToken-1] = strconv.Itoa(p)
+	subject := ps.c.outSubject(strings.Join(tokens, "."))
+	child := ps.child()
+	if co := ps.sopts.Consumer; co != nil {
+		pco := *co
+		pco.Durable = fmt.Sprintf("%s-%d", ps.sopts.Queue, p)
+		child.Consumer = &pco
+		if err := ps.declare(subject, &pco); err != nil {
+			return nil, err
+		}
+	}
+	sub, err := ps.c.subscribeWith(subject, child)
+	if err == nil && ps.paused {
+		sub.Pause()
+	}
+	return sub, err
+}
+
+// declare creates a partition's consumer unless it is there already. Those
+// nats.go creates go when the subscription ends, and the next owner would
+// start over from the beginning of the stream.
+func (ps *partitionedSub) declare(subject string, co *ConsumerOptions) error {
+	js := ps.c.js
+	stream := ps.sopts.stream
+	if stream == "" {
+		var err error
+		if stream, err = js.StreamNameBySubject(subject); err != nil {
+			return err
+		}
+	}
+	if _, err := js.ConsumerInfo(stream, co.Durable); !errors.Is(err, nats.ErrConsumerNotFound) {
+		return err
+	}
+	cfg := &nats.ConsumerConfig{Durable: co.Durable, FilterSubject: subject, AckPolicy: co.natsAckPolicy(), MaxAckPending: co.MaxAckPending}
+	if !co.Pull {
+		cfg.DeliverSubject = ps.c.nc.NewInbox()
+		cfg.Heartbeat = co.Heartbeat
+	}
+	_, err := js.AddConsumer(stream, cfg)
+	return err
+}
+
+// all is every subscription, ending them if stop.
+func (ps *partitionedSub) all(stop bool) []Subscription {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.stopped = ps.stopped || stop
+	subs := make([]Subscription, 0, len(ps.subs)+1)
+	if ps.one != nil {
+		subs = append(subs, ps.one)
+	}
+	for _, sub := range ps.subs {
+		subs = append(subs, sub)
+	}
+	if stop {
+		ps.subs = map[int]Subscription{}
+	}
+	return subs
+}
+
+func (ps *partitionedSub) Close() {
+	ps.Unsubscribe()
+}
+
+func (ps *partitionedSub) Unsubscribe() error {
+	ps.pm.leave()
+	var first error
+	for _, sub := range ps.all(true) {
+		if err := sub.Unsubscribe(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ps *partitionedSub) Drain(ctx context.Context) error {
+	ps.pm.leave()
+	var first error
+	for _, sub := range ps.all(true) {
+		if err := sub.Drain(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ps *partitionedSub) Next(ctx context.Context) (*Msg, error) {
+	return nil, errors.New("natsv2: no Next on partitioned subscriptions, use a Handler or Channel")
+}
+
+func (ps *partitionedSub) Pause() {
+	ps.mu.Lock()
+	ps.paused = true
+	ps.mu.Unlock()
+	for _, sub := range ps.all(false) {
+		sub.Pause()
+	}
+}
+
+func (ps *partitionedSub) Resume() {
+	ps.mu.Lock()
+	ps.paused = false
+	ps.mu.Unlock()
+	for _, sub := range ps.all(false) {
+		sub.Resume()
+	}
+}
@@ -0,0 +1,128 @@
+package natsv2
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Pause stops a subscription's handler being given messages without
+// unsubscribing, for maintenance windows and migrations, Resume carries on:
+//
+//	sub.Pause()
+//	migrate()
+//	sub.Resume()
+//
+// Core NATS messages wait in the client, up to the pending limits, after
+// that it is a slow consumer as usual. Pull consumers stop fetching. Push
+// consumers with acks can't be held back from the client, what arrives
+// while paused is nacked to come again after PauseNakDelay. Those count as
+// deliveries towards MaxDeliver, for long pauses pause the consumer on the
+// server instead, see JetStreamManager.PauseConsumer. Next waits while
+// paused. Drain and Shutdown let the waiting messages through.
+
+const PauseNakDelay = 5 * time.Second
+
+// A pauseGate is open unless paused, nil is always open.
+type pauseGate struct {
+	mu sync.Mutex
+	// Closed on resume, nil while not paused.
+	resumed chan struct{}
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+func (g *pauseGate) paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// wait returns once resumed, or with ctx's error.
+func (g *pauseGate) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pausable holds handler back while sopts is paused. It goes outside the
+// interceptors so a pause doesn't count as handling time.
+func (c *conn) pausable(sopts *SubOptions, handler nats.MsgHandler) nats.MsgHandler {
+	g := sopts.pause
+	if g == nil {
+		return handler
+	}
+	nak := sopts.Consumer != nil && sopts.Consumer.AckPolicy != AckNone
+	return func(m *nats.Msg) {
+		if nak && g.paused() {
+			m.NakWithDelay(PauseNakDelay)
+			return
+		}
+		// Until Drain or Unsubscribe ends the context.
+		g.wait(sopts.ctx)
+		handler(m)
+	}
+}
+
+func (s *subscription) Pause() {
+	s.sopts.pause.pause()
+	s.c.log.Info("subscription paused", "subject", s.sopts.subject)
+}
+
+func (s *subscription) Resume() {
+	s.sopts.pause.resume()
+	s.c.log.Info("subscription resumed", "subject", s.sopts.subject)
+}
+
+func (ms multiSubscription) Pause() {
+	for _, s := range ms {
+		s.Pause()
+	}
+}
+
+func (ms multiSubscription) Resume() {
+	for _, s := range ms {
+		s.Resume()
+	}
+}
+
+// KV updates wait in the watcher.
+func (kw *kvWatch) Pause() {
+	kw.pause.pause()
+}
+
+func (kw *kvWatch) Resume() {
+	kw.pause.resume()
+}
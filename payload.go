@@ -0,0 +1,94 @@
+package natsv2
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A Payload is what the helpers build, the bytes plus how they were made.
+// Helpers compose and carry the first error along, Publish and Request
+// return it instead of sending garbage:
+//
+//	nc.Publish(subject, Base64(Gzip(JSON(me))))
+//
+// The message also gets Content-Type and Content-Encoding, the same as a
+// Pipeline would set, so receivers can Decode it.
+type Payload struct {
+	data        []byte
+	contentType string
+	// In the order they were applied.
+	encodings []string
+	err       error
+}
+
+// Raw wraps already encoded bytes so the helpers can be used on them.
+func Raw(data []byte) Payload {
+	return Payload{data: data}
+}
+
+// Bytes is the encoded payload, or the error from building it.
+func (p Payload) Bytes() ([]byte, error) {
+	return p.data, p.err
+}
+
+func (p Payload) Err() error {
+	return p.err
+}
+
+func (p Payload) header() nats.Header {
+	if p.contentType == "" && len(p.encodings) == 0 {
+		return nil
+	}
+	h := nats.Header{}
+	if p.contentType != "" {
+		h.Set(ContentTypeHeader, p.contentType)
+	}
+	if len(p.encodings) > 0 {
+		h.Set(ContentEncodingHeader, strings.Join(p.encodings, ", "))
+	}
+	return h
+}
+
+func (p Payload) apply(enc Encoding) Payload {
+	if p.err != nil {
+		return p
+	}
+	data, err := enc.Encode(p.data)
+	if err != nil {
+		return Payload{err: err}
+	}
+	encodings := append(append([]string(nil), p.encodings...), enc.Name())
+	return Payload{data: data, contentType: p.contentType, encodings: encodings}
+}
+
+func JSON(v interface{}) Payload {
+	data, err := json.Marshal(v)
+	return Payload{data: data, contentType: JSONContentType, err: err}
+}
+
+// MustJSON is JSON for values that can't fail to marshal, it panics if they do.
+func MustJSON(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func Gzip(p Payload) Payload {
+	return p.apply(gzipEncoding{})
+}
+
+func Base64(p Payload) Payload {
+	return p.apply(base64Encoding{})
+}
+
+func Zstd(p Payload) Payload {
+	return p.apply(zstdEncoding{})
+}
+
+func S2(p Payload) Payload {
+	return p.apply(s2Encoding{})
+}
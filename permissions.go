@@ -0,0 +1,172 @@
+package natsv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Finding out what the connection's user may do, before a publish is
+// dropped or a subscription refused with nothing but an async error to show
+// for it. The server says what the user's permissions are and Can checks a
+// subject against them, nothing is sent on the subject itself:
+//
+//	ok, err := nc.Can("orders.new", PublishOp|SubscribeOp)
+//
+// RequirePermissions checks a list of subjects while connecting, so bad
+// credentials fail at boot:
+//
+//	nc, err := Connect(url, RequirePermissions([]string{"orders.>"}, []string{"billing.*"}))
+//
+// Subjects are the application's, before any SubjectMapper. A wildcard
+// subscription is allowed when an allow covers all of it and no deny does,
+// as the server sees it; one partly denied is allowed with the denied
+// messages left out. Servers before 2.10 don't say, for them Can fails.
+
+type PermissionOp int
+
+const (
+	PublishOp PermissionOp = 1 << iota
+	SubscribeOp
+)
+
+var ErrPermissionDenied = errors.New("natsv2: permission denied")
+
+const userInfoSubject = "$SYS.REQ.USER.INFO"
+
+// Permissions are nil when the user may do anything.
+type Permissions struct {
+	User      string             `json:"user"`
+	Account   string             `json:"account"`
+	Publish   *SubjectPermission `json:"publish,omitempty"`
+	Subscribe *SubjectPermission `json:"subscribe,omitempty"`
+}
+
+// An empty Allow allows everything not denied.
+type SubjectPermission struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+func RequirePermissions(publish, subscribe []string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		for _, s := range publish {
+			if err := checkSubject(s, false); err != nil {
+				return err
+			}
+		}
+		for _, s := range subscribe {
+			if err := checkSubject(s, true); err != nil {
+				return err
+			}
+		}
+		o.RequirePublish = append(o.RequirePublish, publish...)
+		o.RequireSubscribe = append(o.RequireSubscribe, subscribe...)
+		return nil
+	}
+}
+
+// Permissions asks the server what the connection's user may do.
+func (c *conn) Permissions() (*Permissions, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, DefaultRequestTimeout)
+	defer cancel()
+	reply, err := c.nc.RequestWithContext(ctx, userInfoSubject, nil)
+	if err != nil {
+		return nil, fmt.Errorf("natsv2: server permissions: %w", err)
+	}
+	var resp struct {
+		Data struct {
+			User        string `json:"user"`
+			Account     string `json:"account"`
+			Permissions *struct {
+				Publish   *SubjectPermission `json:"publish"`
+				Subscribe *SubjectPermission `json:"subscribe"`
+			} `json:"permissions"`
+		} `json:"data"`
+		Error *struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(reply.Data, &resp); err != nil {
+		return nil, fmt.Errorf("natsv2: server permissions: %w", err)
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("natsv2: server permissions: %s", resp.Error.Description)
+	}
+	p := &Permissions{User: resp.Data.User, Account: resp.Data.Account}
+	if resp.Data.Permissions != nil {
+		p.Publish, p.Subscribe = resp.Data.Permissions.Publish, resp.Data.Permissions.Subscribe
+	}
+	return p, nil
+}
+
+func (c *conn) Can(subject string, op PermissionOp) (bool, error) {
+	if err := checkSubject(subject, op&SubscribeOp != 0); err != nil {
+		return false, err
+	}
+	p, err := c.Permissions()
+	if err != nil {
+		return false, err
+	}
+	return p.Can(c.outSubject(subject), op), nil
+}
+
+// Can is whether subject, as it goes on the wire, may be used for all of
+// op.
+func (p *Permissions) Can(subject string, op PermissionOp) bool {
+	if op&PublishOp != 0 && !p.Publish.allows(subject) {
+		return false
+	}
+	if op&SubscribeOp != 0 && !p.Subscribe.allows(subject) {
+		return false
+	}
+	return true
+}
+
+func (sp *SubjectPermission) allows(subject string) bool {
+	if sp == nil {
+		return true
+	}
+	allowed := len(sp.Allow) == 0
+	for _, a := range sp.Allow {
+		if subjectCovers(a, subject) {
+			allowed = true
+			break
+		}
+	}
+	for _, d := range sp.Deny {
+		if subjectCovers(d, subject) {
+			return false
+		}
+	}
+	return allowed
+}
+
+// checkPermissions is RequirePermissions, with every subject that isn't
+// allowed in the error.
+func (c *conn) checkPermissions() error {
+	start := time.Now()
+	p, err := c.Permissions()
+	if err != nil {
+		return err
+	}
+	var denied []string
+	for _, s := range c.opts.RequirePublish {
+		if !p.Can(c.outSubject(s), PublishOp) {
+			denied = append(denied, "publish "+s)
+		}
+	}
+	for _, s := range c.opts.RequireSubscribe {
+		if !p.Can(c.outSubject(s), SubscribeOp) {
+			denied = append(denied, "subscribe "+s)
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("%w for user %q: %s", ErrPermissionDenied, p.User, strings.Join(denied, ", "))
+	}
+	c.log.Debug("permissions checked", "publish", len(c.opts.RequirePublish), "subscribe", len(c.opts.RequireSubscribe), "took", time.Since(start))
+	return nil
+}
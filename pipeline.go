@@ -0,0 +1,112 @@
+package natsv2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A pipeline is the codec plus the content encodings applied on the way out,
+// declared once instead of wrapping every payload by hand. Stages are listed
+// outermost first, so they read like the helpers they replace:
+//
+//	nc.Stream(subject, Pipeline("base64", "gzip", JSONContentType))
+//	// same as publishing Base64(Gzip(JSON(v))) every time
+//
+// The codec, if any, is the last stage, the connection default if left out.
+// Receivers need no setup, Content-Type and Content-Encoding tell Decode what
+// to undo. []byte and string skip the codec but still get the encodings.
+
+// Pipeline sets the stages for publishes on the stream, WithEncoder still
+// swaps just the codec.
+func Pipeline(stages ...string) StreamOption {
+	return func(o *StreamOptions) error {
+		if len(stages) == 0 {
+			return fmt.Errorf("%w: empty pipeline", ErrUnknownEncoding)
+		}
+		o.Pipeline = stages
+		return nil
+	}
+}
+
+// WithPipeline sets the stages for Publish and Request on the connection,
+// streams use it too unless they have their own.
+func WithPipeline(stages ...string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if len(stages) == 0 {
+			return fmt.Errorf("%w: empty pipeline", ErrUnknownEncoding)
+		}
+		o.Pipeline = stages
+		return nil
+	}
+}
+
+type pipeline struct {
+	codec Codec
+	// In the order they are applied, innermost first.
+	encodings []Encoding
+	// Smaller payloads aren't compressed, see compress.go.
+	minSize int
+}
+
+func (cs *codecs) pipeline(stages []string) (pipeline, error) {
+	p := pipeline{codec: cs.def, minSize: cs.minCompress}
+	for i := len(stages) - 1; i >= 0; i-- {
+		stage := stages[i]
+		if enc, ok := cs.encodings[strings.ToLower(stage)]; ok {
+			p.encodings = append(p.encodings, enc)
+			continue
+		}
+		codec, ok := cs.byType[stage]
+		if !ok {
+			return pipeline{}, fmt.Errorf("%w: %q is not a codec or encoding", ErrUnknownEncoding, stage)
+		}
+		if i != len(stages)-1 {
+			return pipeline{}, fmt.Errorf("%w: codec %q must be the last stage", ErrUnknownContentType, stage)
+		}
+		p.codec = codec
+	}
+	return p, nil
+}
+
+// withCodec is p with just the codec swapped.
+func (p pipeline) withCodec(codec Codec) pipeline {
+	p.codec = codec
+	return p
+}
+
+func (p pipeline) applyEncodings(m *nats.Msg) error {
+	if len(p.encodings) == 0 || len(m.Data) == 0 {
+		return nil
+	}
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	names := make([]string, 0, len(p.encodings))
+	for _, enc := range p.encodings {
+		if compresses(enc) && len(m.Data) < p.minSize {
+			continue
+		}
+		var data []byte
+		var err error
+		if me, ok := enc.(msgEncoding); ok {
+			data, err = me.encodeMsg(m, m.Data)
+		} else {
+			data, err = enc.Encode(m.Data)
+		}
+		if err != nil {
+			return err
+		}
+		m.Data = data
+		names = append(names, enc.Name())
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	if ce := m.Header.Get(ContentEncodingHeader); ce != "" {
+		names = append([]string{ce}, names...)
+	}
+	m.Header.Set(ContentEncodingHeader, strings.Join(names, ", "))
+	return nil
+}
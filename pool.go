@@ -0,0 +1,232 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A Pool is several connections to the same cluster, for when one TCP
+// connection is the bottleneck. It is a Connection itself: publishes,
+// requests, subscriptions and the rest that take a subject go to the
+// connection that subject hashes to, so everything on one subject stays on
+// one connection and in order. Everything else, services, KV, JetStream
+// management and batches, uses the first one.
+//
+//	pool, _ := ConnectPool(url, 4)
+//	pool.Publish("telemetry.eu", reading)
+//	st := pool.Stats()
+//	if err := pool.Healthy(ctx); err != nil {
+//		log.Printf("%d of %d connected: %v", st.Connected, len(st.Conns), err)
+//	}
+//
+// Subscriptions with a wildcard hash on the pattern, not what they get.
+
+type Pool struct {
+	// The first connection, for everything not by subject.
+	Connection
+	conns []*conn
+}
+
+var _ Connection = (*Pool)(nil)
+
+func ConnectPool(url string, size int, opts ...ConnectOption) (*Pool, error) {
+	if size < 1 {
+		return nil, errors.New("natsv2: pool size must be at least 1")
+	}
+	p := &Pool{}
+	for i := 0; i < size; i++ {
+		nc, err := Connect(url, opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.conns = append(p.conns, nc.(*conn))
+	}
+	p.Connection = p.conns[0]
+	return p, nil
+}
+
+// Conn is the connection for subject.
+func (p *Pool) Conn(subject string) Connection {
+	h := fnv.New32a()
+	h.Write([]byte(subject))
+	return p.conns[h.Sum32()%uint32(len(p.conns))]
+}
+
+func (p *Pool) Conns() []Connection {
+	conns := make([]Connection, len(p.conns))
+	for i, c := range p.conns {
+		conns[i] = c
+	}
+	return conns
+}
+
+func (p *Pool) Publish(subject string, msg interface{}, opts ...PubOption) error {
+	return p.Conn(subject).Publish(subject, msg, opts...)
+}
+
+func (p *Pool) PublishCtx(ctx context.Context, subject string, msg interface{}, opts ...PubOption) error {
+	return p.Conn(subject).PublishCtx(ctx, subject, msg, opts...)
+}
+
+func (p *Pool) PublishTo(subject string, write func(io.Writer) error, opts ...PubOption) error {
+	return p.Conn(subject).PublishTo(subject, write, opts...)
+}
+
+func (p *Pool) PublishToCtx(ctx context.Context, subject string, write func(io.Writer) error, opts ...PubOption) error {
+	return p.Conn(subject).PublishToCtx(ctx, subject, write, opts...)
+}
+
+func (p *Pool) PublishSync(ctx context.Context, subject string, msg interface{}, opts ...PubOption) (time.Duration, error) {
+	return p.Conn(subject).PublishSync(ctx, subject, msg, opts...)
+}
+
+func (p *Pool) PublishBatch(subject string, msgs []interface{}, opts ...BatchOption) error {
+	return p.Conn(subject).PublishBatch(subject, msgs, opts...)
+}
+
+func (p *Pool) Subscribe(subject string, opts ...SubOption) (Subscription, error) {
+	return p.Conn(subject).Subscribe(subject, opts...)
+}
+
+func (p *Pool) SubscribeMulti(subjects []string, opts ...SubOption) (Subscription, error) {
+	if len(subjects) == 0 {
+		return nil, fmt.Errorf("%w: no subjects", ErrBadSubject)
+	}
+	var msub multiSubscription
+	for _, subject := range subjects {
+		sub, err := p.Subscribe(subject, opts...)
+		if err != nil {
+			msub.Close()
+			return nil, err
+		}
+		msub = append(msub, sub)
+	}
+	return msub, nil
+}
+
+func (p *Pool) Request(subject string, v interface{}, opts ...ReqOption) (*Msg, error) {
+	return p.Conn(subject).Request(subject, v, opts...)
+}
+
+func (p *Pool) RequestAll(subject string, v interface{}, opts ...ReqOption) ([]*Msg, error) {
+	return p.Conn(subject).RequestAll(subject, v, opts...)
+}
+
+func (p *Pool) Stream(subject string, opts ...StreamOption) Stream {
+	return p.Conn(subject).Stream(subject, opts...)
+}
+
+func (p *Pool) Handle(subject string, handler HTTPHandlerFunc, opts ...HandleOption) (Subscription, error) {
+	return p.Conn(subject).Handle(subject, handler, opts...)
+}
+
+func (p *Pool) Mount(subject string, h http.Handler, mw ...Middleware) (Subscription, error) {
+	return p.Conn(subject).Mount(subject, h, mw...)
+}
+
+func (p *Pool) RoundTrip(subject string, req *http.Request) (*http.Response, error) {
+	return p.Conn(subject).RoundTrip(subject, req)
+}
+
+// each calls fn on every connection at once, the first error wins.
+func (p *Pool) each(fn func(*conn) error) error {
+	errs := make(chan error, len(p.conns))
+	for _, c := range p.conns {
+		go func(c *conn) { errs <- fn(c) }(c)
+	}
+	var first error
+	for range p.conns {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (p *Pool) Flush(ctx context.Context) error {
+	return p.each(func(c *conn) error { return c.Flush(ctx) })
+}
+
+func (p *Pool) FlushTimeout(timeout time.Duration) error {
+	return p.each(func(c *conn) error { return c.FlushTimeout(timeout) })
+}
+
+func (p *Pool) Drain(ctx context.Context) error {
+	return p.each(func(c *conn) error { return c.Drain(ctx) })
+}
+
+// Shutdown shuts the connections down together, a *ShutdownError has the
+// failures of all of them.
+func (p *Pool) Shutdown(ctx context.Context, opts ...ShutdownOption) error {
+	var mu sync.Mutex
+	var failures []ShutdownFailure
+	err := p.each(func(c *conn) error {
+		err := c.Shutdown(ctx, opts...)
+		var serr *ShutdownError
+		if errors.As(err, &serr) {
+			mu.Lock()
+			failures = append(failures, serr.Failures...)
+			mu.Unlock()
+			return nil
+		}
+		return err
+	})
+	if err == nil && len(failures) > 0 {
+		return &ShutdownError{Failures: failures}
+	}
+	return err
+}
+
+func (p *Pool) Close() {
+	p.each(func(c *conn) error {
+		c.Close()
+		return nil
+	})
+}
+
+type PoolStats struct {
+	// Summed over the connections.
+	nats.Statistics
+	Connected int
+	Conns     []Status
+}
+
+func (p *Pool) Stats() PoolStats {
+	var st PoolStats
+	for _, c := range p.conns {
+		s := c.nc.Stats()
+		st.InMsgs += s.InMsgs
+		st.OutMsgs += s.OutMsgs
+		st.InBytes += s.InBytes
+		st.OutBytes += s.OutBytes
+		st.Reconnects += s.Reconnects
+		status := c.Status()
+		if status.State == nats.CONNECTED {
+			st.Connected++
+		}
+		st.Conns = append(st.Conns, status)
+	}
+	return st
+}
+
+// Healthy is nil while every connection is connected and answers a ping.
+func (p *Pool) Healthy(ctx context.Context) error {
+	for i, c := range p.conns {
+		if c.closed.Load() {
+			return fmt.Errorf("natsv2: pool connection %d is closed", i)
+		}
+		if s := c.nc.Status(); s != nats.CONNECTED {
+			return fmt.Errorf("natsv2: pool connection %d is %v", i, s)
+		}
+	}
+	return p.each(func(c *conn) error { return c.Healthy(ctx) })
+}
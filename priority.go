@@ -0,0 +1,252 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Priority lanes keep a few control messages from waiting behind a flood
+// of bulk ones on the same subscription. Each lane is a queue of its own,
+// messages go to the lane named in their PriorityHeader, and the handler
+// takes from the lanes by weight, here four high for each low one while
+// both have messages waiting:
+//
+//	nc.Subscribe("devices.>", Handler(h), Workers(4), Priority(
+//		PriorityLane{Name: "high", Weight: 4},
+//		PriorityLane{Name: "normal", Weight: 2},
+//		PriorityLane{Name: "low", Weight: 1},
+//	))
+//	nc.Publish("devices.7.reboot", cmd, Lane("high"))
+//
+// Messages with no lane, or one that isn't listed, go to the last. With
+// PriorityBy the lane is whatever the func makes of the message, e.g. of
+// its subject. A full lane drops the new messages, as a slow consumer, and
+// calls OnDrop; JetStream ones are delivered again later. Weights only
+// decide anything when the handler falls behind, with Workers that is once
+// MaxInFlight is reached.
+
+const (
+	PriorityHeader = "Nats-Priority"
+
+	DefaultLanePending = 65536
+)
+
+type PriorityLane struct {
+	Name string
+	// Messages taken for each one of a lane of weight 1, 1 if 0.
+	Weight int
+	// How many can wait, DefaultLanePending if 0.
+	MaxPending int
+}
+
+func Priority(lanes ...PriorityLane) SubOption {
+	return func(o *SubOptions) error {
+		if len(lanes) < 2 {
+			return errors.New("natsv2: priority needs at least two lanes")
+		}
+		seen := map[string]bool{}
+		for _, l := range lanes {
+			if l.Name == "" || seen[l.Name] {
+				return fmt.Errorf("natsv2: lane names must be set and different, got %q twice or empty", l.Name)
+			}
+			seen[l.Name] = true
+			if l.Weight < 0 || l.MaxPending < 0 {
+				return fmt.Errorf("natsv2: lane %q has a negative weight or max pending", l.Name)
+			}
+		}
+		o.Lanes = lanes
+		return nil
+	}
+}
+
+// PriorityBy picks the lane instead of PriorityHeader.
+func PriorityBy(lane func(*Msg) string) SubOption {
+	return func(o *SubOptions) error {
+		o.LaneFunc = lane
+		return nil
+	}
+}
+
+// Lane publishes in the named lane, see priority.go.
+func Lane(name string) PubOption {
+	return func(o *PubOptions) error {
+		if o.Headers == nil {
+			o.Headers = Header{}
+		}
+		o.Headers.Set(PriorityHeader, name)
+		return nil
+	}
+}
+
+type lane struct {
+	name    string
+	weight  int
+	max     int
+	msgs    []*nats.Msg
+	current int
+	dropped int
+	full    bool
+}
+
+type priorityQueue struct {
+	c      *conn
+	lanes  []*lane
+	byName map[string]*lane
+	laneOf func(*Msg) string
+	onDrop func(DropStats)
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queued  int
+	busy    bool
+	stopped bool
+}
+
+func (o *SubOptions) newPriorityQueue(handler nats.MsgHandler, c *conn) (nats.MsgHandler, error) {
+	if o.Lanes == nil {
+		if o.LaneFunc != nil {
+			return nil, errors.New("natsv2: PriorityBy needs Priority")
+		}
+		return handler, nil
+	}
+	if handler == nil {
+		return nil, errors.New("natsv2: priority lanes need a Handler or Channel")
+	}
+	if o.queue != nil {
+		return nil, errors.New("natsv2: priority lanes drop new messages, they don't go with SlowConsumerDropOld")
+	}
+	q := &priorityQueue{c: c, byName: map[string]*lane{}, laneOf: o.LaneFunc, onDrop: o.OnDrop}
+	q.cond = sync.NewCond(&q.mu)
+	for _, l := range o.Lanes {
+		ln := &lane{name: l.Name, weight: l.Weight, max: l.MaxPending}
+		if ln.weight == 0 {
+			ln.weight = 1
+		}
+		if ln.max == 0 {
+			ln.max = DefaultLanePending
+		}
+		q.lanes = append(q.lanes, ln)
+		q.byName[ln.name] = ln
+	}
+	go q.run(handler)
+	o.lanes = q
+	return q.push, nil
+}
+
+func (q *priorityQueue) push(m *nats.Msg) {
+	var name string
+	if q.laneOf != nil {
+		name = q.laneOf(q.c.wrap(m))
+	} else if m.Header != nil {
+		name = m.Header.Get(PriorityHeader)
+	}
+	ln, ok := q.byName[name]
+	if !ok {
+		ln = q.lanes[len(q.lanes)-1]
+	}
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return
+	}
+	if len(ln.msgs) >= ln.max {
+		ln.dropped++
+		starting := !ln.full
+		ln.full = true
+		stats := DropStats{Subject: m.Subject, Dropped: ln.dropped, Pending: len(ln.msgs)}
+		q.mu.Unlock()
+		if starting {
+			q.c.log.Warn("priority lane full, dropping", "lane", ln.name, "subject", m.Subject, "dropped", stats.Dropped)
+			if q.onDrop != nil {
+				q.onDrop(stats)
+			}
+		}
+		return
+	}
+	ln.full = false
+	ln.msgs = append(ln.msgs, m)
+	q.queued++
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// next is smooth weighted round robin over the lanes with messages, so a
+// lane's turns are spread out rather than bunched. Called with mu held and
+// something queued.
+func (q *priorityQueue) next() *nats.Msg {
+	var best *lane
+	total := 0
+	for _, ln := range q.lanes {
+		if len(ln.msgs) == 0 {
+			ln.current = 0
+			continue
+		}
+		ln.current += ln.weight
+		total += ln.weight
+		if best == nil || ln.current > best.current {
+			best = ln
+		}
+	}
+	best.current -= total
+	m := best.msgs[0]
+	best.msgs[0] = nil
+	best.msgs = best.msgs[1:]
+	q.queued--
+	return m
+}
+
+func (q *priorityQueue) run(handler nats.MsgHandler) {
+	q.mu.Lock()
+	for {
+		for q.queued == 0 && !q.stopped {
+			q.busy = false
+			q.cond.Broadcast()
+			q.cond.Wait()
+		}
+		if q.stopped {
+			q.mu.Unlock()
+			return
+		}
+		m := q.next()
+		q.busy = true
+		q.mu.Unlock()
+		handler(m)
+		q.mu.Lock()
+	}
+}
+
+// wait is for the lanes to be handled, for Drain.
+func (q *priorityQueue) wait(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+	idle := make(chan struct{})
+	go func() {
+		q.mu.Lock()
+		for (q.queued > 0 || q.busy) && !q.stopped {
+			q.cond.Wait()
+		}
+		q.mu.Unlock()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *priorityQueue) stop() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
@@ -0,0 +1,119 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Protobuf messages go out as application/protobuf with the full message
+// name as a parameter, e.g. "application/protobuf; proto=orders.v1.Order",
+// so a receiver can decode into the right type, or into a *proto.Message
+// when it doesn't know which one to expect:
+//
+//	nc.Publish("orders", Protobuf(order))
+//	var pm proto.Message
+//	msg.Decode(&pm)
+//
+// The types come from the generated code's registry unless the codec is
+// registered with a resolver, for schema registries and the like:
+//
+//	natsv2.WithCodec(natsv2.ProtobufCodec(func(name string) (proto.Message, error) { return registry.New(name) }))
+
+const (
+	ProtobufContentType = "application/protobuf"
+	// The Content-Type parameter with the full message name.
+	ProtoMessageParam = "proto"
+)
+
+var ErrUnknownProtoMessage = errors.New("natsv2: unknown protobuf message")
+
+// A ProtoResolver returns a new, empty message for a full message name.
+type ProtoResolver func(name string) (proto.Message, error)
+
+func Protobuf(m proto.Message) Payload {
+	data, err := proto.Marshal(m)
+	return Payload{data: data, contentType: protoContentType(m), err: err}
+}
+
+func ProtobufCodec(resolve ProtoResolver) Codec {
+	return protoCodec{resolve: resolve}
+}
+
+func protoContentType(m proto.Message) string {
+	if m == nil {
+		return ProtobufContentType
+	}
+	name := string(m.ProtoReflect().Descriptor().FullName())
+	return mime.FormatMediaType(ProtobufContentType, map[string]string{ProtoMessageParam: name})
+}
+
+type protoCodec struct {
+	resolve ProtoResolver
+}
+
+func (protoCodec) ContentType() string { return ProtobufContentType }
+
+func (c protoCodec) contentTypeFor(v interface{}) string {
+	m, _ := v.(proto.Message)
+	return protoContentType(m)
+}
+
+func (c protoCodec) Encode(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("natsv2: %T is not a protobuf message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (c protoCodec) Decode(data []byte, v interface{}) error {
+	return c.decodeParams(data, nil, v)
+}
+
+func (c protoCodec) decodeParams(data []byte, params map[string]string, v interface{}) error {
+	name := params[ProtoMessageParam]
+	switch v := v.(type) {
+	case proto.Message:
+		if got := string(v.ProtoReflect().Descriptor().FullName()); name != "" && name != got {
+			return fmt.Errorf("natsv2: protobuf message is %s, not %s", name, got)
+		}
+		return proto.Unmarshal(data, v)
+	case *proto.Message:
+		if name == "" {
+			return fmt.Errorf("%w: no %s in the content type", ErrUnknownProtoMessage, ProtoMessageParam)
+		}
+		m, err := c.new(name)
+		if err != nil {
+			return err
+		}
+		if err := proto.Unmarshal(data, m); err != nil {
+			return err
+		}
+		*v = m
+		return nil
+	}
+	return fmt.Errorf("natsv2: can't decode protobuf into %T", v)
+}
+
+func (c protoCodec) new(name string) (proto.Message, error) {
+	if c.resolve != nil {
+		m, err := c.resolve(name)
+		if err == nil && m == nil {
+			err = errors.New("resolver returned nil")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrUnknownProtoMessage, name, err)
+		}
+		return m, nil
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProtoMessage, name)
+	}
+	return mt.New().Interface(), nil
+}
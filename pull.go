@@ -0,0 +1,223 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Pull consumers that already exist, declared with the JetStreamManager or
+// the CLI. Fetch takes one batch, returning what came by the expiry, maybe
+// nothing. Consume keeps fetching and hands the messages to a handler the
+// same as Subscribe, so AutoAck, Workers and the rest work:
+//
+//	billing, _ := nc.Consumer("ORDERS", "billing")
+//	msgs, _ := billing.Fetch(10, FetchMaxBytes(1<<20), FetchExpires(2*time.Second))
+//	sub, _ := billing.Consume(bill, Fetch(FetchBatch(100), FetchHeartbeat(5*time.Second)), AutoAck())
+//
+// Consume only asks for the next batch once the last one is handled, so a
+// slow handler slows the pulling instead of having messages pile up, and
+// FetchMaxBytes bounds the memory a batch takes. With FetchHeartbeat the
+// server sends heartbeats while a pull waits, a missing one ends it early
+// so a lost server or consumer is noticed and we pull again. Heartbeats
+// need to be under half the expiry.
+
+type Consumer interface {
+	Fetch(batch int, opts ...FetchOption) ([]*Msg, error)
+	FetchCtx(ctx context.Context, batch int, opts ...FetchOption) ([]*Msg, error)
+	Consume(handler func(*Msg), opts ...SubOption) (Subscription, error)
+	Info() (*nats.ConsumerInfo, error)
+}
+
+type FetchOption func(*FetchOptions) error
+
+type FetchOptions struct {
+	// Messages per fetch for Consume, DefaultPullBatch if 0.
+	Batch    int
+	MaxBytes int
+	// How long a pull waits for messages, DefaultFetchExpires if 0.
+	Expires   time.Duration
+	Heartbeat time.Duration
+}
+
+const DefaultFetchExpires = 5 * time.Second
+
+func FetchBatch(n int) FetchOption {
+	return func(o *FetchOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: batch must be at least 1")
+		}
+		o.Batch = n
+		return nil
+	}
+}
+
+func FetchMaxBytes(n int) FetchOption {
+	return func(o *FetchOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: max bytes must be at least 1")
+		}
+		o.MaxBytes = n
+		return nil
+	}
+}
+
+func FetchExpires(d time.Duration) FetchOption {
+	return func(o *FetchOptions) error {
+		if d <= 0 {
+			return errors.New("natsv2: fetch expiry must be positive")
+		}
+		o.Expires = d
+		return nil
+	}
+}
+
+func FetchHeartbeat(d time.Duration) FetchOption {
+	return func(o *FetchOptions) error {
+		if d <= 0 {
+			return errors.New("natsv2: heartbeat must be positive")
+		}
+		o.Heartbeat = d
+		return nil
+	}
+}
+
+// Fetch sets how Consume, or a pull JetStreamConsumer, fetches.
+func Fetch(opts ...FetchOption) SubOption {
+	return func(o *SubOptions) error {
+		for _, opt := range opts {
+			if err := opt(&o.Fetch); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (o *FetchOptions) batch() int {
+	if o == nil || o.Batch == 0 {
+		return DefaultPullBatch
+	}
+	return o.Batch
+}
+
+// pull is the context and options for one fetch.
+func (o *FetchOptions) pull(ctx context.Context) (context.Context, context.CancelFunc, []nats.PullOpt) {
+	expires := DefaultFetchExpires
+	if o != nil && o.Expires > 0 {
+		expires = o.Expires
+	}
+	ctx, cancel := context.WithTimeout(ctx, expires)
+	popts := []nats.PullOpt{nats.Context(ctx)}
+	if o != nil && o.MaxBytes > 0 {
+		popts = append(popts, nats.PullMaxBytes(o.MaxBytes))
+	}
+	if o != nil && o.Heartbeat > 0 {
+		popts = append(popts, nats.PullHeartbeat(o.Heartbeat))
+	}
+	return ctx, cancel, popts
+}
+
+func (o *FetchOptions) check() error {
+	if o == nil || o.Heartbeat == 0 {
+		return nil
+	}
+	expires := o.Expires
+	if expires == 0 {
+		expires = DefaultFetchExpires
+	}
+	if 2*o.Heartbeat >= expires {
+		return fmt.Errorf("natsv2: heartbeat %v needs to be under half the fetch expiry %v", o.Heartbeat, expires)
+	}
+	return nil
+}
+
+type consumer struct {
+	c      *conn
+	stream string
+	name   string
+	ack    AckPolicy
+}
+
+// Consumer binds to an existing pull consumer.
+func (c *conn) Consumer(stream, name string) (Consumer, error) {
+	info, err := c.js.ConsumerInfo(stream, name)
+	if err != nil {
+		return nil, err
+	}
+	if info.Config.DeliverSubject != "" {
+		return nil, fmt.Errorf("natsv2: %s on %s is a push consumer", name, stream)
+	}
+	cs := &consumer{c: c, stream: stream, name: name}
+	switch info.Config.AckPolicy {
+	case nats.AckNonePolicy:
+		cs.ack = AckNone
+	case nats.AckAllPolicy:
+		cs.ack = AckAll
+	}
+	return cs, nil
+}
+
+func (cs *consumer) Info() (*nats.ConsumerInfo, error) {
+	return cs.c.js.ConsumerInfo(cs.stream, cs.name)
+}
+
+func (cs *consumer) Fetch(batch int, opts ...FetchOption) ([]*Msg, error) {
+	return cs.FetchCtx(context.Background(), batch, opts...)
+}
+
+func (cs *consumer) FetchCtx(ctx context.Context, batch int, opts ...FetchOption) ([]*Msg, error) {
+	if batch < 1 {
+		return nil, errors.New("natsv2: batch must be at least 1")
+	}
+	fo := &FetchOptions{}
+	for _, opt := range opts {
+		if err := opt(fo); err != nil {
+			return nil, err
+		}
+	}
+	if err := fo.check(); err != nil {
+		return nil, err
+	}
+	sub, err := cs.c.js.PullSubscribe("", cs.name, nats.Bind(cs.stream, cs.name))
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	fctx, cancel, popts := fo.pull(ctx)
+	defer cancel()
+	ms, err := sub.Fetch(batch, popts...)
+	if err != nil {
+		// Nothing came by the expiry.
+		if ctx.Err() == nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout)) && fctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	msgs := make([]*Msg, len(ms))
+	for i, m := range ms {
+		msgs[i] = cs.c.wrap(cs.c.unmap(m))
+	}
+	return msgs, nil
+}
+
+func (cs *consumer) Consume(handler func(*Msg), opts ...SubOption) (Subscription, error) {
+	if handler == nil {
+		return nil, errors.New("natsv2: consume needs a handler")
+	}
+	sopts := &SubOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, err
+		}
+	}
+	sopts.Handler, sopts.handlerName = handler, funcName(handler)
+	sopts.Consumer = &ConsumerOptions{Durable: cs.name, Pull: true, AckPolicy: cs.ack}
+	sopts.stream = cs.stream
+	sopts.bound = true
+	return cs.c.subscribeWith("", sopts)
+}
@@ -0,0 +1,112 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// AutoAck acks JetStream messages as they are handed over, otherwise it is up
+// to the receiver to Ack each one.
+func AutoAck() SubOption {
+	return func(o *SubOptions) error {
+		o.AutoAck = true
+		return nil
+	}
+}
+
+// PullChannel fetches from an existing pull consumer in batches and delivers
+// the messages on the returned channel, so it can be ranged over. The channel
+// holds one batch, when it is full we stop fetching until there is room. The
+// returned func stops fetching, cleans up and closes the channel.
+//
+// On a Stream published with JetStreamStream, Channel is the same for the
+// consumer JetStreamConsumer names, created on the stream's subject if it
+// isn't there yet:
+//
+//	ch, stop, err := nc.Stream("orders.new", JetStreamStream("ORDERS")).Channel(100, JetStreamConsumer(ConsumerOptions{Durable: "billing"}), AutoAck())
+func (c *conn) PullChannel(stream, consumer string, batch int, opts ...SubOption) (<-chan *Msg, func(), error) {
+	if batch < 1 {
+		return nil, nil, errors.New("natsv2: batch must be at least 1")
+	}
+	sopts := &SubOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, nil, err
+		}
+	}
+	// nats.go won't bind to a filtered consumer without its subject.
+	info, err := c.js.ConsumerInfo(stream, consumer)
+	if err != nil {
+		return nil, nil, err
+	}
+	sub, err := c.js.PullSubscribe(info.Config.FilterSubject, consumer, nats.Bind(stream, consumer))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch := make(chan *Msg, batch)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.fetchLoop(ctx, sub, batch, nil, nil, func(ctx context.Context, m *nats.Msg) bool {
+			select {
+			case ch <- c.wrap(c.unmap(m)):
+				if sopts.AutoAck {
+					m.Ack()
+				}
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			wg.Wait()
+			sub.Unsubscribe()
+			close(ch)
+		})
+	}
+	return ch, stop, nil
+}
+
+func (s *stream) Channel(batch int, opts ...SubOption) (<-chan *Msg, func(), error) {
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	if s.opts.JetStream == "" {
+		return nil, nil, errors.New("natsv2: Channel needs a JetStreamStream")
+	}
+	sopts := &SubOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, nil, err
+		}
+	}
+	co := sopts.Consumer
+	if co == nil || co.Durable == "" {
+		return nil, nil, errors.New("natsv2: Channel needs a JetStreamConsumer with a Durable name")
+	}
+	js := s.c.js
+	_, err := js.ConsumerInfo(s.opts.JetStream, co.Durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(s.opts.JetStream, &nats.ConsumerConfig{
+			Durable:       co.Durable,
+			FilterSubject: s.c.outSubject(s.subject),
+			AckPolicy:     co.natsAckPolicy(),
+			MaxAckPending: co.MaxAckPending,
+		})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.c.PullChannel(s.opts.JetStream, co.Durable, batch, opts...)
+}
@@ -0,0 +1,216 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Retrying failed messages in process and quarantining the ones that keep
+// failing, for core NATS subscriptions as much as JetStream ones. With
+// HandlerRetry a HandleJetStream handler that returns an error or panics is
+// run again, up to attempts times in all, the waits in between starting at
+// backoff and doubling with jitter. One that still fails goes to the
+// quarantine given with QuarantineTo, a KV bucket, instead of to the dead
+// letter subject or nowhere:
+//
+//	q, err := OpenQuarantine(nc, "poison")
+//	nc.Subscribe("orders.new", HandlerRetry(3, 100*time.Millisecond), QuarantineTo(q),
+//		HandleJetStream(func(ctx context.Context, m *Msg) error { return place(ctx, m) }))
+//
+// Later, once whatever it choked on is fixed:
+//
+//	held, _ := q.List()
+//	for _, m := range held {
+//		log.Println(m.Subject, m.Error)
+//		q.Reinject(m.ID)
+//	}
+//
+// The subscription waits while a message is retried, use Workers for others
+// to get through meanwhile. A JetStream message is quarantined when a dead
+// letter would be, see deadletter.go, and acked once it is held; if holding
+// it fails it is nak'ed instead so it isn't lost.
+
+type Quarantine struct {
+	c  *conn
+	kv KV
+}
+
+type QuarantinedMsg struct {
+	ID      string
+	Subject string
+	Header  Header
+	Data    []byte
+	// Of the last attempt, Attempts are those of the last delivery.
+	Error       string
+	Attempts    int
+	Quarantined time.Time
+	// Where a JetStream message came from, Reinject publishes back to it.
+	Stream     string `json:",omitempty"`
+	Sequence   uint64 `json:",omitempty"`
+	Deliveries uint64 `json:",omitempty"`
+}
+
+// OpenQuarantine uses bucket, made if it isn't there, opts can have
+// CreateBucket for limits like a TTL.
+func OpenQuarantine(nc Connection, bucket string, opts ...KVOption) (*Quarantine, error) {
+	c := connFor(nc, bucket)
+	if c == nil {
+		return nil, fmt.Errorf("natsv2: no quarantine on a %T", nc)
+	}
+	opts = append([]KVOption{CreateBucket(nats.KeyValueConfig{Description: "natsv2 quarantine"})}, opts...)
+	kv, err := c.KV(bucket, append(opts, KVCodec(JSONContentType))...)
+	if err != nil {
+		return nil, err
+	}
+	return &Quarantine{c: c, kv: kv}, nil
+}
+
+func HandlerRetry(attempts int, backoff time.Duration) SubOption {
+	return func(o *SubOptions) error {
+		if attempts < 1 {
+			return errors.New("natsv2: handler retry needs at least 1 attempt")
+		}
+		if backoff < 0 {
+			return errors.New("natsv2: handler retry backoff can't be negative")
+		}
+		o.Attempts, o.RetryBackoff = attempts, backoff
+		return nil
+	}
+}
+
+func QuarantineTo(q *Quarantine) SubOption {
+	return func(o *SubOptions) error {
+		if q == nil {
+			return errors.New("natsv2: nil quarantine")
+		}
+		o.Quarantine = q
+		return nil
+	}
+}
+
+// retrying runs h as HandlerRetry asks, with the attempts it took.
+func (o *SubOptions) retrying(m *Msg, h JetStreamHandler) (int, error) {
+	run := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Subject: m.Subject(), Value: r, Stack: debug.Stack(), Queue: o.Queue}
+				if m.c != nil && m.c.rethrows(o.panicSite()) {
+					m.c.panicked(m.Subject(), r, debug.Stack(), o.panicSite())
+				}
+			}
+		}()
+		return h(m.Context(), m)
+	}
+	done := o.ctx
+	if done == nil {
+		done = context.Background()
+	}
+	wait := o.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := run()
+		if err == nil || attempt >= o.Attempts || done.Err() != nil {
+			return attempt, err
+		}
+		m.c.log.Debug("retrying handler", "subject", m.Subject(), "attempt", attempt, "error", err)
+		// Somewhere in the second half of wait.
+		d := wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		select {
+		case <-time.After(d):
+		case <-done.Done():
+			return attempt, err
+		}
+		wait *= 2
+	}
+}
+
+// hold puts m in the quarantine, meta is nil for a core message.
+func (q *Quarantine) hold(m *nats.Msg, meta *nats.MsgMetadata, attempts int, cause error) error {
+	qm := QuarantinedMsg{
+		ID:          nuid.Next(),
+		Subject:     m.Subject,
+		Header:      Header(m.Header),
+		Data:        m.Data,
+		Error:       cause.Error(),
+		Attempts:    attempts,
+		Quarantined: time.Now(),
+	}
+	if meta != nil {
+		qm.Stream, qm.Sequence, qm.Deliveries = meta.Stream, meta.Sequence.Stream, meta.NumDelivered
+	}
+	if _, err := q.kv.Put(qm.ID, &qm); err != nil {
+		return fmt.Errorf("natsv2: quarantine %q: %w", m.Subject, err)
+	}
+	q.c.log.Debug("quarantined", "subject", m.Subject, "id", qm.ID, "attempts", attempts)
+	return nil
+}
+
+// List is everything held, oldest first.
+func (q *Quarantine) List() ([]*QuarantinedMsg, error) {
+	ids, err := q.kv.Keys()
+	if err != nil {
+		return nil, err
+	}
+	held := make([]*QuarantinedMsg, 0, len(ids))
+	for _, id := range ids {
+		qm, err := q.Get(id)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		held = append(held, qm)
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].Quarantined.Before(held[j].Quarantined) })
+	return held, nil
+}
+
+func (q *Quarantine) Get(id string) (*QuarantinedMsg, error) {
+	e, err := q.kv.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	var qm QuarantinedMsg
+	if err := e.Decode(&qm); err != nil {
+		return nil, err
+	}
+	return &qm, nil
+}
+
+// Reinject publishes a held message to where it came from, through
+// JetStream if it came from a stream, and lets go of it.
+func (q *Quarantine) Reinject(id string) error {
+	qm, err := q.Get(id)
+	if err != nil {
+		return err
+	}
+	m := nats.NewMsg(qm.Subject)
+	m.Data = qm.Data
+	for k, v := range qm.Header {
+		m.Header[k] = v
+	}
+	// Or JetStream would drop it as a duplicate.
+	m.Header.Del(MsgIDHeader)
+	if qm.Stream != "" {
+		m.Subject = q.c.outSubject(m.Subject)
+		_, err = q.c.publishJetStream(context.Background(), qm.Stream, m, nil, nil)
+	} else {
+		err = q.c.publishMsg(context.Background(), m)
+	}
+	if err != nil {
+		return err
+	}
+	return q.Delete(id)
+}
+
+func (q *Quarantine) Delete(id string) error {
+	return q.kv.Delete(id)
+}
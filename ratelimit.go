@@ -0,0 +1,87 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("natsv2: publish rate limit exceeded")
+
+// WithRateLimit caps publishes to msgsPerSec using a token bucket. Short
+// bursts of up to a tenth of a second's worth are allowed. By default a
+// publish over the limit blocks until a token is available or its context
+// is done, see PublishCtx.
+func WithRateLimit(msgsPerSec int) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if msgsPerSec <= 0 {
+			return errors.New("natsv2: rate limit must be positive")
+		}
+		o.RateLimit = msgsPerSec
+		return nil
+	}
+}
+
+// WithRateLimitError makes publishes over the limit fail with ErrRateLimited
+// instead of blocking.
+func WithRateLimitError() ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.RateLimitError = true
+		return nil
+	}
+}
+
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	noBlock bool
+}
+
+func newRateLimiter(msgsPerSec int, noBlock bool) *rateLimiter {
+	burst := float64(msgsPerSec / 10)
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    float64(msgsPerSec),
+		burst:   burst,
+		tokens:  burst,
+		last:    time.Now(),
+		noBlock: noBlock,
+	}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		if l.noBlock {
+			l.mu.Unlock()
+			return ErrRateLimited
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
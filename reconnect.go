@@ -0,0 +1,51 @@
+package natsv2
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Reconnect behavior. We inherit the nats.go defaults except for the
+// reconnect buffer, which holds publishes made while disconnected:
+//
+//	MaxReconnects     60 attempts per server, then closed   inherited
+//	ReconnectWait     2s between attempts to the same server inherited
+//	ReconnectBufSize  32MB (nats.go uses 8MB)                overridden
+//
+// Any of these set on Connect, including raw ones through NATSOptions, win.
+// Status().Reconnects counts successful reconnects so far, a fast climbing
+// number means the connection is flapping.
+
+const DefaultReconnectBufSize = 32 * 1024 * 1024
+
+func defaultNATSOptions() []nats.Option {
+	return []nats.Option{
+		nats.ReconnectBufSize(DefaultReconnectBufSize),
+	}
+}
+
+// WithMaxReconnects sets how many reconnect attempts are made before giving
+// up and closing, a negative n retries forever.
+func WithMaxReconnects(n int) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.NATS = append(o.NATS, nats.MaxReconnects(n))
+		return nil
+	}
+}
+
+// WithReconnectBufSize sets how many bytes of publishes are buffered while
+// reconnecting, publishes past that fail. A negative size disables buffering.
+func WithReconnectBufSize(bytes int) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.NATS = append(o.NATS, nats.ReconnectBufSize(bytes))
+		return nil
+	}
+}
+
+func WithReconnectWait(d time.Duration) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.NATS = append(o.NATS, nats.ReconnectWait(d))
+		return nil
+	}
+}
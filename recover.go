@@ -0,0 +1,76 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A panicking handler should not take the whole process with it. Every
+// handler we invoke runs under recoverPanic, the panic is turned into a
+// *PanicError for the ErrorHandler and we carry on with the next message,
+// unless it is to be rethrown, see panics.go.
+
+// ErrorHandler gets errors that happen outside of any call, e.g. in handlers.
+type ErrorHandler func(error)
+
+func WithErrorHandler(eh ErrorHandler) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.ErrorHandler = eh
+		return nil
+	}
+}
+
+type PanicError struct {
+	Subject string
+	Value   interface{}
+	Stack   []byte
+	// The handler's queue group and service, if any.
+	Queue   string
+	Service string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("natsv2: handler panic on %q: %v\n%s", e.Subject, e.Value, e.Stack)
+}
+
+func (c *conn) handleError(err error) {
+	if c == nil {
+		return
+	}
+	var perr *PanicError
+	if errors.As(err, &perr) {
+		c.reportPanic(perr)
+	}
+	for _, cb := range c.opts.OnError {
+		cb(err)
+	}
+	if c.opts.ErrorHandler != nil {
+		c.opts.ErrorHandler(err)
+		return
+	}
+	if errors.Is(err, ErrSlowConsumer) {
+		// Already a warning, see slowConsumer.
+		return
+	}
+	if perr != nil {
+		c.log.Error("handler panic", "subject", perr.Subject, "panic", perr.Value, "stack", string(perr.Stack))
+		return
+	}
+	c.log.Error("async error", "error", err)
+}
+
+func (c *conn) recoverPanic(subject string, site panicSite) {
+	if r := recover(); r != nil {
+		c.panicked(subject, r, debug.Stack(), site)
+	}
+}
+
+func (c *conn) recoverHandler(site panicSite, handler nats.MsgHandler) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		defer c.recoverPanic(m.Subject, site)
+		handler(m)
+	}
+}
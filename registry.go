@@ -0,0 +1,194 @@
+package natsv2
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// What the connection has going: its subscriptions, services, and the
+// streams and consumers declared or added through JetStream(), for finding
+// out which part of a program owns a subject:
+//
+//	for _, s := range nc.Registry().Subscriptions {
+//		log.Println(s.Subject, s.Queue, s.Handler, s.Stats.Pending)
+//	}
+//
+// RegistryHandler serves it as JSON, next to HealthHandler say. Subjects
+// are as they go on the wire, after any SubjectMapper. Streams and
+// consumers are the configs they were made with, ask JetStream for their
+// state; the ones JetStream subscriptions make are in Subscriptions.
+
+type Registry struct {
+	Subscriptions []RegisteredSubscription `json:"subscriptions"`
+	Services      []RegisteredService      `json:"services"`
+	Streams       []RegisteredStream       `json:"streams"`
+	Consumers     []RegisteredConsumer     `json:"consumers"`
+}
+
+type RegisteredSubscription struct {
+	Subject string `json:"subject"`
+	Queue   string `json:"queue,omitempty"`
+	// The handler function's name, "channel" for a Channel.
+	Handler  string            `json:"handler,omitempty"`
+	Stream   string            `json:"stream,omitempty"`
+	Consumer string            `json:"consumer,omitempty"`
+	Paused   bool              `json:"paused,omitempty"`
+	Valid    bool              `json:"valid"`
+	Stats    SubscriptionStats `json:"stats"`
+	// Each subject's, for SubscribeMany.
+	Subjects []SubscriptionStats `json:"subjects,omitempty"`
+}
+
+type RegisteredService struct {
+	ServiceStats
+	// Handler function names by endpoint.
+	Handlers map[string]string `json:"handlers"`
+}
+
+type RegisteredStream struct {
+	nats.StreamConfig
+	Declared time.Time `json:"declared"`
+}
+
+type RegisteredConsumer struct {
+	Stream string `json:"stream"`
+	nats.ConsumerConfig
+	Declared time.Time `json:"declared"`
+}
+
+type consumerKey struct {
+	stream, name string
+}
+
+func (c *conn) Registry() *Registry {
+	r := &Registry{}
+	c.register(r)
+	r.sort()
+	return r
+}
+
+// Registry is all the pool's connections'.
+func (p *Pool) Registry() *Registry {
+	r := &Registry{}
+	for _, c := range p.conns {
+		c.register(r)
+	}
+	r.sort()
+	return r
+}
+
+// register adds what c has to r.
+func (c *conn) register(r *Registry) {
+	c.subs.Range(func(k, v interface{}) bool {
+		sopts := k.(*SubOptions)
+		rs := RegisteredSubscription{
+			Subject: sopts.subject,
+			Queue:   sopts.Queue,
+			Handler: sopts.handlerName,
+			Stream:  sopts.stream,
+			Paused:  sopts.pause.paused(),
+		}
+		if rs.Handler == "" && sopts.Channel != nil {
+			rs.Handler = "channel"
+		}
+		if co := sopts.Consumer; co != nil {
+			rs.Consumer = co.Durable
+		}
+		if ss, ok := v.(*SubscriptionSet); ok {
+			st := ss.Stats()
+			rs.Stats, rs.Subjects = st.SubscriptionStats, st.Subjects
+			rs.Valid = ss.invalid() == nil
+		} else if sub := natsSubscription(v.(Subscription)); sub != nil {
+			rs.Stats.Delivered, _ = sub.Delivered()
+			rs.Stats.Pending, rs.Stats.PendingBytes, _ = sub.Pending()
+			rs.Stats.Dropped, _ = sub.Dropped()
+			rs.Valid = sub.IsValid()
+		}
+		r.Subscriptions = append(r.Subscriptions, rs)
+		return true
+	})
+	c.services.Range(func(k, _ interface{}) bool {
+		s := k.(*service)
+		rs := RegisteredService{ServiceStats: s.Stats(), Handlers: map[string]string{}}
+		s.mu.Lock()
+		for _, e := range s.endpoints {
+			rs.Handlers[e.name] = e.opts.handlerName()
+		}
+		s.mu.Unlock()
+		r.Services = append(r.Services, rs)
+		return true
+	})
+	c.streams.Range(func(_, v interface{}) bool {
+		r.Streams = append(r.Streams, v.(RegisteredStream))
+		return true
+	})
+	c.consumers.Range(func(_, v interface{}) bool {
+		r.Consumers = append(r.Consumers, v.(RegisteredConsumer))
+		return true
+	})
+}
+
+func (r *Registry) sort() {
+	sort.Slice(r.Subscriptions, func(i, j int) bool {
+		a, b := r.Subscriptions[i], r.Subscriptions[j]
+		if a.Subject != b.Subject {
+			return a.Subject < b.Subject
+		}
+		return a.Queue < b.Queue
+	})
+	sort.Slice(r.Services, func(i, j int) bool {
+		a, b := r.Services[i], r.Services[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	sort.Slice(r.Streams, func(i, j int) bool { return r.Streams[i].Name < r.Streams[j].Name })
+	sort.Slice(r.Consumers, func(i, j int) bool {
+		a, b := r.Consumers[i], r.Consumers[j]
+		if a.Stream != b.Stream {
+			return a.Stream < b.Stream
+		}
+		return a.Durable < b.Durable
+	})
+}
+
+func (o *ServiceOptions) handlerName() string {
+	switch {
+	case o.HTTPHandler != nil:
+		return funcName(o.HTTPHandler)
+	case o.HandlerFunc != nil:
+		return funcName(o.HandlerFunc)
+	}
+	return funcName(o.Handler)
+}
+
+// funcName is f's name as the runtime has it, like main.handleOrder, ""
+// for nil.
+func funcName(f interface{}) string {
+	v := reflect.ValueOf(f)
+	if !v.IsValid() || v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+	if fn := runtime.FuncForPC(v.Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return ""
+}
+
+// RegistryHandler serves c.Registry() as JSON.
+func RegistryHandler(c Connection) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(c.Registry())
+	})
+}
@@ -0,0 +1,177 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replaying what a JetStream stream holds, to rebuild a projection or see
+// what happened. Replay starts from a time.Time or a stream sequence and
+// hands the stream's messages on the stream subject to handler one at a
+// time and in order, through an ordered consumer of its own that goes away
+// once it's done. That is when it has caught up with what was there:
+//
+//	r, err := orders.Replay(time.Now().Add(-24*time.Hour), func(m *Msg) { apply(view, m) })
+//	err = r.Wait(ctx)
+//
+// Messages come as fast as they can unless OriginalSpeed is used, then with
+// the gaps they were published with, and ReplayUntil stops at a point in
+// time instead of the end. Nothing needs acking.
+
+var ErrReplayStopped = errors.New("natsv2: replay stopped")
+
+type ReplayOption func(*ReplayOptions) error
+
+type ReplayOptions struct {
+	OriginalSpeed bool
+	// Stop at the first message published after, the end of the stream if
+	// zero.
+	Until time.Time
+	// For the consumer, e.g. Decompress.
+	SubOptions []SubOption
+}
+
+func OriginalSpeed() ReplayOption {
+	return func(o *ReplayOptions) error {
+		o.OriginalSpeed = true
+		return nil
+	}
+}
+
+func ReplayUntil(t time.Time) ReplayOption {
+	return func(o *ReplayOptions) error {
+		o.Until = t
+		return nil
+	}
+}
+
+func ReplaySubOptions(opts ...SubOption) ReplayOption {
+	return func(o *ReplayOptions) error {
+		o.SubOptions = append(o.SubOptions, opts...)
+		return nil
+	}
+}
+
+type Replay struct {
+	n     atomic.Int64
+	err   error
+	done  chan struct{}
+	once  sync.Once
+	until time.Time
+}
+
+// Replay is from a time.Time, or a stream sequence as an int or uint64, 0
+// being the start of the stream.
+func (s *stream) Replay(from interface{}, handler func(*Msg), opts ...ReplayOption) (*Replay, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if handler == nil {
+		return nil, errors.New("natsv2: replay needs a handler")
+	}
+	co := ConsumerOptions{}
+	switch from := from.(type) {
+	case time.Time:
+		co.StartTime = from
+	case uint64:
+		co.StartSeq = from
+	case int:
+		if from < 0 {
+			return nil, fmt.Errorf("natsv2: replay from sequence %d", from)
+		}
+		co.StartSeq = uint64(from)
+	default:
+		return nil, fmt.Errorf("natsv2: replay from a %T, not a time or sequence", from)
+	}
+	var ro ReplayOptions
+	for _, opt := range opts {
+		if err := opt(&ro); err != nil {
+			return nil, err
+		}
+	}
+	co.ReplayOriginal = ro.OriginalSpeed
+
+	r := &Replay{done: make(chan struct{}), until: ro.Until}
+	sopts := append([]SubOption{JetStreamConsumer(co), Ordered(), Handler(r.handler(handler))}, ro.SubOptions...)
+	sub, err := s.Subscribe(sopts...)
+	if err != nil {
+		return nil, err
+	}
+	// An empty replay has no last message to notice.
+	if js, ok := sub.(*subscription); ok {
+		if ci, err := js.sub.ConsumerInfo(); err == nil && ci.NumPending == 0 && ci.Delivered.Consumer == 0 {
+			r.finish(nil)
+		}
+	}
+	go func() {
+		<-r.done
+		sub.Unsubscribe()
+	}()
+	return r, nil
+}
+
+func (r *Replay) handler(handler func(*Msg)) func(*Msg) {
+	return func(m *Msg) {
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+		meta, err := m.m.Metadata()
+		if err != nil {
+			r.finish(err)
+			return
+		}
+		if !r.until.IsZero() && meta.Timestamp.After(r.until) {
+			r.finish(nil)
+			return
+		}
+		handler(m)
+		r.n.Add(1)
+		if meta.NumPending == 0 {
+			r.finish(nil)
+		}
+	}
+}
+
+func (r *Replay) finish(err error) {
+	r.once.Do(func() {
+		r.err = err
+		close(r.done)
+	})
+}
+
+// Done is closed once the replay is over, Err says how.
+func (r *Replay) Done() <-chan struct{} { return r.done }
+
+// Err is nil for a replay that got to the end, only meaningful after Done.
+func (r *Replay) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// Count is how many messages the handler got so far.
+func (r *Replay) Count() int { return int(r.n.Load()) }
+
+// Wait is for the replay to be over, or ctx to be done.
+func (r *Replay) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop ends the replay early, with ErrReplayStopped.
+func (r *Replay) Stop() {
+	r.finish(ErrReplayStopped)
+}
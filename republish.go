@@ -0,0 +1,220 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Glue that takes messages off some subjects, changes them and publishes
+// them on others. Pipeline was taken by the codec stages, so it is
+// Republish:
+//
+//	sub, err := Republish(nc).From("raw.{region}.>", Workers(8)).Transform(func(ctx context.Context, m *Msg) (interface{}, error) {
+//		var r Reading
+//		if err := m.Decode(&r); err != nil {
+//			return nil, err
+//		}
+//		return r.Clean(), nil
+//	}).To("clean.{region}.{>}")
+//
+// From is a pattern like Handle's, with the SubOptions to subscribe with,
+// Queue, Workers, MaxConcurrent and the rest, and To a subject with the
+// wildcard tokens put back in by the names Params has for them, {1} for a
+// plain '*' first among the wildcards and {>} for what '>' matched. A
+// transform sees the message as a handler would and returns the value to
+// publish, encoded with To's StreamOptions, the connection's codec unless
+// they say; returning nil drops it. Several
+// transforms run in turn, each on what the one before returned, encoded with
+// the connection's codec. Without any the payload goes on as it was. Headers
+// other than Content-Type, Content-Encoding and the Nats- ones go along.
+//
+// Consuming with JetStreamConsumer and publishing To a JetStreamStream is at
+// least once: a message is acked once the stream has stored what it became,
+// and nak'ed, retried with HandlerRetry or dead lettered like any
+// HandleJetStream handler's if the transform or the publish fails. The
+// published message's id is the consumed one's stream and sequence, so a
+// redelivery isn't stored twice, unless To is Idempotent. Core NATS on either
+// side is at most once.
+
+type TransformFunc func(ctx context.Context, m *Msg) (interface{}, error)
+
+// A Republisher collects its setup, errors included, until To starts it.
+type Republisher struct {
+	nc     Connection
+	from   *route
+	opts   []SubOption
+	stages []TransformFunc
+	err    error
+}
+
+func Republish(nc Connection) *Republisher {
+	return &Republisher{nc: nc}
+}
+
+func (r *Republisher) From(pattern string, opts ...SubOption) *Republisher {
+	if r.err == nil {
+		r.from, r.err = newRoute(pattern, nil)
+		r.opts = opts
+	}
+	return r
+}
+
+func (r *Republisher) Transform(fn TransformFunc) *Republisher {
+	if fn == nil && r.err == nil {
+		r.err = errors.New("natsv2: nil transform")
+	}
+	r.stages = append(r.stages, fn)
+	return r
+}
+
+// To subscribes and republishes on subject, the returned Subscription stops
+// it.
+func (r *Republisher) To(subject string, opts ...StreamOption) (Subscription, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.from == nil {
+		return nil, errors.New("natsv2: republish needs From")
+	}
+	to, err := r.target(subject)
+	if err != nil {
+		return nil, err
+	}
+	c := connFor(r.nc, r.from.subject())
+	if c == nil {
+		return nil, fmt.Errorf("natsv2: no republish on a %T", r.nc)
+	}
+	s := c.Stream(to.subject, opts...).(*stream)
+	if s.err != nil {
+		return nil, s.err
+	}
+	c.log.Debug("republish", "from", r.from.pattern, "to", subject)
+	return r.nc.Subscribe(r.from.subject(), append(r.opts, HandleJetStream(r.relay(c, s, to)))...)
+}
+
+// republishTarget is To's subject, the tokens taken from From's params
+// where name isn't empty.
+type republishTarget struct {
+	subject string
+	tokens  []string
+	names   []string
+}
+
+func (r *Republisher) target(subject string) (*republishTarget, error) {
+	if subject == "" {
+		return nil, fmt.Errorf("%w: empty", ErrBadSubject)
+	}
+	have := map[string]bool{}
+	for i, kind := range r.from.kinds {
+		if kind != literalToken {
+			have[r.from.names[i]] = true
+		}
+	}
+	to := &republishTarget{}
+	var pattern []string
+	for i, tok := range strings.Split(subject, ".") {
+		if !strings.HasPrefix(tok, "{") || !strings.HasSuffix(tok, "}") {
+			if err := checkToken(tok); err != nil {
+				return nil, fmt.Errorf("%w, in %q", err, subject)
+			}
+			to.tokens, to.names = append(to.tokens, tok), append(to.names, "")
+			pattern = append(pattern, tok)
+			continue
+		}
+		name := tok[1 : len(tok)-1]
+		if !have[name] {
+			return nil, fmt.Errorf("%w: {%s} in %q isn't a wildcard of %q", ErrBadSubject, name, subject, r.from.pattern)
+		}
+		wc := "*"
+		if name == ">" {
+			if i != strings.Count(subject, ".") {
+				return nil, fmt.Errorf("%w: {>} must be the last token in %q", ErrBadSubject, subject)
+			}
+			wc = ">"
+		}
+		to.tokens, to.names = append(to.tokens, ""), append(to.names, name)
+		pattern = append(pattern, wc)
+	}
+	to.subject = strings.Join(pattern, ".")
+	return to, nil
+}
+
+func (to *republishTarget) expand(params map[string]string) string {
+	tokens := make([]string, len(to.tokens))
+	for i, tok := range to.tokens {
+		if name := to.names[i]; name != "" {
+			tok = params[name]
+		}
+		tokens[i] = tok
+	}
+	return strings.Join(tokens, ".")
+}
+
+func (r *Republisher) relay(c *conn, s *stream, to *republishTarget) JetStreamHandler {
+	return func(ctx context.Context, m *Msg) error {
+		params, _ := r.from.match(m.Subject())
+		var v interface{}
+		if len(r.stages) == 0 {
+			v = Payload{data: m.Data(), contentType: m.m.Header.Get(ContentTypeHeader), encodings: contentEncodings(m.m.Header)}
+		}
+		in := m
+		for i, stage := range r.stages {
+			out, err := stage(ctx, in)
+			if err != nil || out == nil {
+				return err
+			}
+			v = out
+			if i == len(r.stages)-1 {
+				break
+			}
+			next, err := c.codecs.encode(m.Subject(), out, c.codecs.out)
+			if err != nil {
+				return err
+			}
+			setHeaders(next, carried(m.m.Header))
+			in = c.wrap(next)
+			in.hctx, in.sopts = m.hctx, m.sopts
+		}
+		var popts []PubOption
+		if h := carried(m.m.Header); len(h) > 0 {
+			popts = append(popts, Headers(h))
+		}
+		if s.opts.JetStream != "" && s.opts.MsgID == nil {
+			if meta, err := m.m.Metadata(); err == nil {
+				popts = append(popts, WithMsgID(fmt.Sprintf("%s.%d", meta.Stream, meta.Sequence.Stream)))
+			}
+		}
+		out := *s
+		out.subject = to.expand(params)
+		return out.PublishCtx(ctx, v, popts...)
+	}
+}
+
+// carried is what of h goes along with a republished message.
+func carried(h nats.Header) Header {
+	var out Header
+	for k, vs := range h {
+		if strings.HasPrefix(k, "Nats-") || k == ContentTypeHeader || k == ContentEncodingHeader {
+			continue
+		}
+		if out == nil {
+			out = Header{}
+		}
+		out[k] = append([]string(nil), vs...)
+	}
+	return out
+}
+
+func contentEncodings(h nats.Header) []string {
+	var names []string
+	for _, name := range strings.Split(h.Get(ContentEncodingHeader), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
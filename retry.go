@@ -0,0 +1,74 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Retry resends a request that timed out or found no responders, up to
+// attempts tries in all. The waits in between start at backoff and double
+// each time, with jitter so retrying clients don't all come back at once.
+//
+// Timeout is per attempt, a context given with Ctx bounds all of them and
+// the waits, nothing is retried once it is done. Every attempt carries the
+// same Nats-Msg-Id, set unless the request has one, so JetStream backed
+// services can drop the duplicates.
+//
+//	nc.Request("orders.create", order, Retry(3, 100*time.Millisecond), Timeout(time.Second), Ctx(ctx))
+func Retry(attempts int, backoff time.Duration) ReqOption {
+	return func(o *ReqOptions) error {
+		if attempts < 1 {
+			return errors.New("natsv2: retry needs at least 1 attempt")
+		}
+		if backoff < 0 {
+			return errors.New("natsv2: retry backoff can't be negative")
+		}
+		o.Attempts, o.Backoff = attempts, backoff
+		return nil
+	}
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrNoResponders)
+}
+
+// requestRetry is request, retried as ropts asks.
+func (c *conn) requestRetry(ropts *ReqOptions, m *nats.Msg) (*nats.Msg, error) {
+	if ropts.Attempts <= 1 {
+		return c.request(ropts, m)
+	}
+	if ropts.Streamed != nil {
+		return nil, errors.New("natsv2: streamed replies can't be retried")
+	}
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	if m.Header.Get(MsgIDHeader) == "" {
+		m.Header.Set(MsgIDHeader, nuid.Next())
+	}
+	budget := ropts.Context
+	if budget == nil {
+		budget = context.Background()
+	}
+	wait := ropts.Backoff
+	for attempt := 1; ; attempt++ {
+		reply, err := c.request(ropts, m)
+		if err == nil || !retryable(err) || attempt == ropts.Attempts || budget.Err() != nil {
+			return reply, err
+		}
+		c.log.Debug("retrying request", "subject", m.Subject, "attempt", attempt, "error", err)
+		// Somewhere in the second half of wait.
+		d := wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		select {
+		case <-time.After(d):
+		case <-budget.Done():
+			return nil, err
+		}
+		wait *= 2
+	}
+}
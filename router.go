@@ -0,0 +1,191 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Subject routing for Handle. Patterns are regular NATS subjects and can
+// use the normal wildcards, plus named tokens like {id} which match a single
+// token just like '*' but expose the value under that name.
+//
+//	nc.Handle("api.users.{id}", h)   // Params(req)["id"]
+//	nc.Handle("api.users.*", h)      // Params(req)["1"]
+//	nc.Handle("api.>", h)            // Params(req)[">"]
+//
+// Unnamed '*' tokens are numbered from 1, same as in server subject mappings.
+
+var ErrBadPattern = errors.New("natsv2: invalid subject pattern")
+
+const (
+	fwcToken = iota
+	pwcToken
+	literalToken
+)
+
+type route struct {
+	pattern string
+	tokens  []string
+	kinds   []int
+	names   []string
+	handler HTTPHandlerFunc
+	opts    HandleOptions
+}
+
+func newRoute(pattern string, handler HTTPHandlerFunc) (*route, error) {
+	if pattern == "" {
+		return nil, ErrBadPattern
+	}
+	rt := &route{pattern: pattern, handler: handler}
+	pwc := 0
+	for i, t := range strings.Split(pattern, ".") {
+		if t == "" || strings.ContainsAny(t, " \t\r\n") {
+			return nil, fmt.Errorf("%w: %q", ErrBadPattern, pattern)
+		}
+		kind, name := literalToken, ""
+		switch {
+		case t == ">":
+			if i != strings.Count(pattern, ".") {
+				return nil, fmt.Errorf("%w: '>' must be the last token in %q", ErrBadPattern, pattern)
+			}
+			kind, name = fwcToken, ">"
+		case t == "*":
+			pwc++
+			kind, name = pwcToken, fmt.Sprintf("%d", pwc)
+		case strings.HasPrefix(t, "{") && strings.HasSuffix(t, "}"):
+			pwc++
+			if name = t[1 : len(t)-1]; name == "" {
+				return nil, fmt.Errorf("%w: empty parameter name in %q", ErrBadPattern, pattern)
+			}
+			kind, t = pwcToken, "*"
+		case strings.ContainsAny(t, "*>{}"):
+			return nil, fmt.Errorf("%w: %q", ErrBadPattern, pattern)
+		}
+		rt.tokens = append(rt.tokens, t)
+		rt.kinds = append(rt.kinds, kind)
+		rt.names = append(rt.names, name)
+	}
+	return rt, nil
+}
+
+// The subject we actually subscribe on, named tokens replaced with '*'.
+func (rt *route) subject() string {
+	return strings.Join(rt.tokens, ".")
+}
+
+// match returns the params for subject if it matches this route.
+func (rt *route) match(subject string) (map[string]string, bool) {
+	if !rt.matches(subject) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	i := 0
+	for n, kind := range rt.kinds {
+		if kind == fwcToken {
+			params[">"] = subject[i:]
+			break
+		}
+		var tok string
+		tok, i = token(subject, i)
+		if kind == pwcToken {
+			params[rt.names[n]] = tok
+		}
+	}
+	return params, true
+}
+
+// matches is match without the params.
+func (rt *route) matches(subject string) bool {
+	i := 0
+	for n, kind := range rt.kinds {
+		if i > len(subject) {
+			return false
+		}
+		if kind == fwcToken {
+			return true
+		}
+		var tok string
+		tok, i = token(subject, i)
+		if kind == literalToken && rt.tokens[n] != tok {
+			return false
+		}
+	}
+	return i > len(subject)
+}
+
+// moreSpecific reports whether rt should win over other for a subject both
+// match. Tokens are compared left to right, literal beats '*' beats '>'.
+func (rt *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(rt.kinds) && i < len(other.kinds); i++ {
+		if rt.kinds[i] != other.kinds[i] {
+			return rt.kinds[i] > other.kinds[i]
+		}
+	}
+	return len(rt.kinds) > len(other.kinds)
+}
+
+type router struct {
+	mu     sync.RWMutex
+	routes []*route
+	// The routes by subject, for lookup.
+	index SubjectTrie[*route]
+}
+
+func (r *router) add(rt *route) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ert, ok := r.index.Get(rt.subject()); ok {
+		return fmt.Errorf("natsv2: pattern %q overlaps existing route %q", rt.pattern, ert.pattern)
+	}
+	if err := r.index.Insert(rt.subject(), rt); err != nil {
+		return err
+	}
+	r.routes = append(r.routes, rt)
+	return nil
+}
+
+func (r *router) remove(rt *route) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, ert := range r.routes {
+		if ert == rt {
+			r.routes = append(r.routes[:i], r.routes[i+1:]...)
+			r.index.Delete(rt.subject())
+			return
+		}
+	}
+}
+
+func (r *router) list() []*route {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*route(nil), r.routes...)
+}
+
+// lookup returns the most specific route matching subject.
+func (r *router) lookup(subject string) (*route, map[string]string) {
+	r.mu.RLock()
+	rt, _, ok := r.index.Lookup(subject)
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	params, _ := rt.match(subject)
+	return rt, params
+}
+
+type paramsKey struct{}
+
+// Params returns the wildcard tokens matched for a request served by Handle.
+func Params(req *http.Request) map[string]string {
+	params, _ := req.Context().Value(paramsKey{}).(map[string]string)
+	return params
+}
+
+func withParams(req *http.Request, params map[string]string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), paramsKey{}, params))
+}
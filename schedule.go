@@ -0,0 +1,251 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// Delayed and scheduled publishes, kept in a JetStream stream until due so
+// they survive restarts. Delay holds back a single publish, a Scheduler
+// adds cron schedules that keep publishing, see cron.go for the specs:
+//
+//	nc.Publish("reminders.send", reminder, Delay(5*time.Minute))
+//
+//	sched, err := nc.Scheduler()
+//	err = sched.Schedule("daily-report", "0 6 * * *", "reports.build", req)
+//	err = sched.Unschedule("daily-report")
+//
+// Something has to publish them when they are due: Run on any number of
+// connections, they share the work through one durable consumer. Until a
+// message is due its delivery is put off with NakWithDelay, so no timers
+// are kept in the process.
+//
+//	sub, err := sched.Run()
+//
+// Due messages are published on core NATS, a stream on the subject still
+// stores them. They get a Nats-Msg-Id unless they have one, so a stream
+// drops the second copy if a scheduler dies between publishing and acking.
+
+const (
+	SchedulerStream   = "NATSV2_SCHEDULER"
+	SchedulerConsumer = "scheduler"
+
+	ScheduleTargetHeader = "Nats-Schedule-Target"
+	ScheduleAtHeader     = "Nats-Schedule-At"
+	ScheduleCronHeader   = "Nats-Schedule-Cron"
+	// See ttl.go.
+	ScheduleTTLHeader = "Nats-Schedule-TTL"
+)
+
+const (
+	schedulerSubjects = "natsv2.scheduler.>"
+	delaySubject      = "natsv2.scheduler.delay."
+	cronSubject       = "natsv2.scheduler.cron."
+)
+
+type Scheduler interface {
+	// Schedule publishes v on subject at every time spec matches, replacing
+	// any schedule of the same name.
+	Schedule(name, spec, subject string, v interface{}, opts ...PubOption) error
+	Unschedule(name string) error
+	// Run publishes what is due until the subscription is closed.
+	Run(opts ...SubOption) (Subscription, error)
+}
+
+// Delay publishes through the scheduler stream, see schedule.go.
+func Delay(d time.Duration) PubOption {
+	return func(o *PubOptions) error {
+		if d <= 0 {
+			return errors.New("natsv2: delay must be positive")
+		}
+		o.Delay = d
+		return nil
+	}
+}
+
+type scheduler struct {
+	c *conn
+}
+
+func (c *conn) Scheduler() (Scheduler, error) {
+	if err := c.declareScheduler(); err != nil {
+		return nil, err
+	}
+	return scheduler{c}, nil
+}
+
+// declareScheduler adds the stream unless it is there, one set up by hand
+// with more replicas is left alone.
+func (c *conn) declareScheduler() error {
+	c.schedMu.Lock()
+	defer c.schedMu.Unlock()
+	if c.schedDeclared {
+		return nil
+	}
+	_, err := c.JetStream().AddStream(nats.StreamConfig{
+		Name:      SchedulerStream,
+		Subjects:  []string{schedulerSubjects},
+		Retention: nats.WorkQueuePolicy,
+		Storage:   nats.FileStorage,
+	})
+	if err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+		return fmt.Errorf("natsv2: scheduler stream: %w", err)
+	}
+	c.schedDeclared = true
+	return nil
+}
+
+// publishDelayed stores m in the scheduler stream to go out at.
+func (c *conn) publishDelayed(ctx context.Context, m *nats.Msg, at time.Time) error {
+	if err := c.declareScheduler(); err != nil {
+		return err
+	}
+	_, err := c.publishJetStream(ctx, SchedulerStream, scheduled(delaySubject+nuid.Next(), m, at), nil, nil)
+	return err
+}
+
+func scheduled(subject string, m *nats.Msg, at time.Time) *nats.Msg {
+	sm := nats.NewMsg(subject)
+	for k, v := range m.Header {
+		sm.Header[k] = v
+	}
+	sm.Header.Set(ScheduleTargetHeader, m.Subject)
+	sm.Header.Set(ScheduleAtHeader, at.UTC().Format(time.RFC3339Nano))
+	sm.Data = m.Data
+	return sm
+}
+
+func (s scheduler) Schedule(name, spec, subject string, v interface{}, opts ...PubOption) error {
+	if err := checkToken(name); err != nil {
+		return fmt.Errorf("natsv2: schedule name: %w", err)
+	}
+	if err := checkSubject(subject, false); err != nil {
+		return err
+	}
+	cs, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+	popts := &PubOptions{}
+	for _, opt := range opts {
+		if err := opt(popts); err != nil {
+			return err
+		}
+	}
+	m, err := s.c.codecs.encode(subject, v, s.c.codecs.out)
+	if err != nil {
+		return err
+	}
+	setHeaders(m, popts.Headers)
+	popts.setExpires(m, true)
+	m.Subject = s.c.outSubject(subject)
+	at := cs.next(time.Now())
+	if at.IsZero() {
+		return fmt.Errorf("%w: %q never matches", ErrBadCron, spec)
+	}
+	if err := s.Unschedule(name); err != nil {
+		return err
+	}
+	sm := scheduled(cronSubject+name, m, at)
+	sm.Header.Set(ScheduleCronHeader, spec)
+	_, err = s.c.publishJetStream(context.Background(), SchedulerStream, sm, nil, nil)
+	return err
+}
+
+func (s scheduler) Unschedule(name string) error {
+	return s.c.JetStream().PurgeStream(SchedulerStream, PurgeSubject(cronSubject+name))
+}
+
+func (s scheduler) Run(opts ...SubOption) (Subscription, error) {
+	_, err := s.c.JetStream().DeclareConsumer(SchedulerStream, nats.ConsumerConfig{
+		Durable:    SchedulerConsumer,
+		AckPolicy:  nats.AckExplicitPolicy,
+		MaxDeliver: -1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cons, err := s.c.Consumer(SchedulerStream, SchedulerConsumer)
+	if err != nil {
+		return nil, err
+	}
+	return cons.Consume(s.fire, opts...)
+}
+
+// fire publishes m's message if it is due and puts it off if not. A cron
+// message queues the next one first, expecting itself to be the last on
+// its subject so a schedule replaced or removed meanwhile stops here.
+func (s scheduler) fire(msg *Msg) {
+	m := msg.m
+	at, err := time.Parse(time.RFC3339Nano, m.Header.Get(ScheduleAtHeader))
+	target := m.Header.Get(ScheduleTargetHeader)
+	if err != nil || target == "" {
+		s.c.log.Warn("dropping bad scheduled message", "subject", m.Subject)
+		m.Term()
+		return
+	}
+	if wait := time.Until(at); wait > 0 {
+		m.NakWithDelay(wait)
+		return
+	}
+	meta, err := m.Metadata()
+	if err != nil {
+		m.Nak()
+		return
+	}
+	if spec := m.Header.Get(ScheduleCronHeader); spec != "" {
+		cs, err := parseCron(spec)
+		if err != nil {
+			s.c.log.Warn("dropping bad schedule", "subject", m.Subject, "error", err)
+			m.Term()
+			return
+		}
+		// From when it was due, so a late run doesn't shift the schedule.
+		next := cs.next(at)
+		for !next.IsZero() && next.Before(time.Now()) {
+			next = cs.next(next)
+		}
+		if !next.IsZero() {
+			nm := scheduled(m.Subject, &nats.Msg{Subject: target, Header: m.Header, Data: m.Data}, next)
+			_, err := s.c.js.PublishMsg(nm, nats.ExpectStream(SchedulerStream), nats.ExpectLastSequencePerSubject(meta.Sequence.Stream))
+			var apiErr *nats.APIError
+			if errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence {
+				m.Ack()
+				return
+			}
+			if err != nil {
+				m.Nak()
+				return
+			}
+		}
+	}
+	out := nats.NewMsg(target)
+	for k, v := range m.Header {
+		out.Header[k] = v
+	}
+	for _, h := range []string{ScheduleTargetHeader, ScheduleAtHeader, ScheduleCronHeader, ScheduleTTLHeader} {
+		out.Header.Del(h)
+	}
+	if ttl, err := time.ParseDuration(m.Header.Get(ScheduleTTLHeader)); err == nil && ttl > 0 {
+		stampExpires(out, ttl)
+	}
+	if out.Header.Get(MsgIDHeader) == "" {
+		out.Header.Set(MsgIDHeader, "natsv2-scheduled-"+strconv.FormatUint(meta.Sequence.Stream, 10))
+	}
+	out.Data = m.Data
+	if err := s.c.send(context.Background(), out, s.c.publish); err != nil {
+		m.Nak()
+		return
+	}
+	if err := s.c.nc.Flush(); err != nil {
+		m.Nak()
+		return
+	}
+	m.Ack()
+}
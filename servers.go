@@ -0,0 +1,53 @@
+package natsv2
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Connect already takes a comma separated list of servers, this is the same
+// for when you have them in a slice. The client fails over between them,
+// picking randomly unless WithInOrderFailover is set.
+func ConnectMulti(urls []string, opts ...ConnectOption) (Connection, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("natsv2: no server urls")
+	}
+	return Connect(strings.Join(urls, ","), opts...)
+}
+
+// WithInOrderFailover tries servers in the order given instead of randomly,
+// e.g. to prefer the nodes in the local cluster.
+func WithInOrderFailover() ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.NATS = append(o.NATS, nats.DontRandomize())
+		return nil
+	}
+}
+
+type Status struct {
+	State nats.Status
+	// The server we are connected to, credentials redacted.
+	Server   string
+	ServerID string
+	// All known servers, including ones discovered from the cluster.
+	Servers    []string
+	Reconnects uint64
+	// Bytes waiting in the offline buffer, see offline.go.
+	OfflineBytes int64
+}
+
+func (c *conn) Status() Status {
+	st := Status{
+		State:      c.nc.Status(),
+		Server:     c.nc.ConnectedUrlRedacted(),
+		ServerID:   c.nc.ConnectedServerId(),
+		Servers:    c.nc.Servers(),
+		Reconnects: c.nc.Stats().Reconnects,
+	}
+	if c.offline != nil {
+		st.OfflineBytes = c.offline.bytes()
+	}
+	return st
+}
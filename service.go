@@ -0,0 +1,411 @@
+package natsv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// A Service is a named and versioned request handler. Instances join the
+// DefaultServiceQueue for their name and version unless told otherwise, so
+// starting more of them spreads the load. The handler is optional, a
+// service can also be made of endpoints added later, see AddEndpoint.
+//
+// Every service answers the micro protocol on $SRV.PING, $SRV.INFO and
+// $SRV.STATS (also per name and per id, e.g. $SRV.INFO.my-service), so the
+// nats CLI and micro clients can find it.
+//
+//	svc, err := nc.Service("my-service", "1.0.0", ServiceHandler(func(msg *Msg) {}))
+//	svc.Discover("services.my-service", "does things")
+//	svc.Health("my-service.healthz")
+//	svc.Shutdown()
+type Service interface {
+	Name() string
+	Version() string
+	ID() string
+	Discover(subject, description string) error
+	Health(subject string) error
+	Stats() ServiceStats
+	Shutdown() error
+	// See endpoint.go.
+	ServiceGroup
+}
+
+var ErrBadServiceName = errors.New("natsv2: invalid service name")
+
+// Same rule as micro, names end up as a single subject token.
+var serviceNameRE = regexp.MustCompile(`^[A-Za-z0-9\-_]+$`)
+
+type ServiceOption func(*ServiceOptions) error
+
+type ServiceOptions struct {
+	// Subject the handler serves, the service name if empty.
+	Subject     string
+	Queue       string
+	Description string
+	Metadata    map[string]string
+	Handler     func(*Msg)
+	HandlerFunc HandlerFunc
+	HTTPHandler HTTPHandlerFunc
+	// See servicestats.go.
+	LatencyBuckets []time.Duration
+	// See limits.go.
+	MaxConcurrent int
+	RateLimit     int
+	Backpressure  bool
+	// See openapi.go.
+	Operations []APIOperation
+	// See panics.go.
+	Panics PanicPolicy
+
+	discover []string
+}
+
+func ServiceHandler(handler func(*Msg)) ServiceOption {
+	return func(o *ServiceOptions) error {
+		o.Handler = handler
+		return nil
+	}
+}
+
+// HTTPHandler serves requests to the service with an HTTP handler, see Handle.
+func HTTPHandler(handler HTTPHandlerFunc) ServiceOption {
+	return func(o *ServiceOptions) error {
+		o.HTTPHandler = handler
+		return nil
+	}
+}
+
+func ServiceSubject(subject string) ServiceOption {
+	return func(o *ServiceOptions) error {
+		if err := checkSubject(subject, true); err != nil {
+			return err
+		}
+		o.Subject = subject
+		return nil
+	}
+}
+
+func ServiceQueue(queue string) ServiceOption {
+	return func(o *ServiceOptions) error {
+		if err := checkQueue(queue); err != nil {
+			return err
+		}
+		o.Queue = queue
+		return nil
+	}
+}
+
+func Description(description string) ServiceOption {
+	return func(o *ServiceOptions) error {
+		o.Description = description
+		return nil
+	}
+}
+
+func Metadata(md map[string]string) ServiceOption {
+	return func(o *ServiceOptions) error {
+		o.Metadata = md
+		return nil
+	}
+}
+
+// Discover as an option, same as calling it on the service.
+func Discover(subject, description string) ServiceOption {
+	return func(o *ServiceOptions) error {
+		if err := checkSubject(subject, false); err != nil {
+			return err
+		}
+		o.Description = description
+		o.discover = append(o.discover, subject)
+		return nil
+	}
+}
+
+// Micro protocol response types.
+const (
+	servicePingType  = "io.nats.micro.v1.ping_response"
+	serviceInfoType  = "io.nats.micro.v1.info_response"
+	serviceStatsType = "io.nats.micro.v1.stats_response"
+)
+
+type ServiceIdentity struct {
+	Name     string            `json:"name"`
+	ID       string            `json:"id"`
+	Version  string            `json:"version"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+type ServicePing struct {
+	ServiceIdentity
+	Type string `json:"type"`
+}
+
+type ServiceInfo struct {
+	ServiceIdentity
+	Type        string            `json:"type"`
+	Description string            `json:"description"`
+	Endpoints   []ServiceEndpoint `json:"endpoints"`
+}
+
+type ServiceEndpoint struct {
+	Name       string            `json:"name"`
+	Subject    string            `json:"subject"`
+	QueueGroup string            `json:"queue_group"`
+	Metadata   map[string]string `json:"metadata"`
+}
+
+type ServiceStats struct {
+	ServiceIdentity
+	Type      string                 `json:"type"`
+	Started   time.Time              `json:"started"`
+	Endpoints []ServiceEndpointStats `json:"endpoints"`
+}
+
+type ServiceEndpointStats struct {
+	Name                  string        `json:"name"`
+	Subject               string        `json:"subject"`
+	QueueGroup            string        `json:"queue_group"`
+	NumRequests           int           `json:"num_requests"`
+	NumErrors             int           `json:"num_errors"`
+	LastError             string        `json:"last_error"`
+	ProcessingTime        time.Duration `json:"processing_time"`
+	AverageProcessingTime time.Duration `json:"average_processing_time"`
+	// Not part of micro, which ignores it.
+	Latency LatencyHistogram `json:"latency"`
+}
+
+type service struct {
+	c       *conn
+	opts    ServiceOptions
+	name    string
+	version string
+	id      string
+	started time.Time
+
+	mu        sync.Mutex
+	subs      []*nats.Subscription
+	endpoints []*endpoint
+	done      bool
+	// Handlers' context, see context.go.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (c *conn) Service(name, version string, opts ...ServiceOption) (Service, error) {
+	if !serviceNameRE.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrBadServiceName, name)
+	}
+	svc := &service{c: c, name: name, version: version, id: nuid.Next(), started: time.Now().UTC()}
+	var err error
+	if svc.opts, err = c.serviceOptions(name, version, opts); err != nil {
+		return nil, err
+	}
+
+	svc.ctx, svc.cancel = context.WithCancel(c.hctx)
+	if err := svc.start(); err != nil {
+		svc.Shutdown()
+		return nil, err
+	}
+	c.services.Store(svc, nil)
+	c.log.Info("service started", "name", name, "version", version, "id", svc.id)
+	return svc, nil
+}
+
+func (s *service) start() error {
+	// The handler given to Service is the endpoint named after it, the
+	// metadata is the service's.
+	if s.opts.Handler != nil || s.opts.HandlerFunc != nil || s.opts.HTTPHandler != nil {
+		e := &endpoint{s: s, name: s.name, opts: s.opts}
+		e.opts.Metadata = nil
+		if err := s.add(e); err != nil {
+			return err
+		}
+	}
+
+	for verb, handler := range map[string]nats.MsgHandler{
+		"PING":  s.reply(func() interface{} { return s.ping() }),
+		"INFO":  s.reply(func() interface{} { return s.info() }),
+		"STATS": s.reply(func() interface{} { return s.Stats() }),
+	} {
+		for _, subject := range []string{
+			"$SRV." + verb,
+			"$SRV." + verb + "." + s.name,
+			"$SRV." + verb + "." + s.name + "." + s.id,
+		} {
+			if err := s.subscribe(subject, handler); err != nil {
+				return err
+			}
+		}
+	}
+	for _, subject := range s.opts.discover {
+		if err := s.Discover(subject, s.opts.Description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribe adds a plain, non queue, subscription every instance answers on.
+func (s *service) subscribe(subject string, handler nats.MsgHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return errors.New("natsv2: service is shut down")
+	}
+	sub, err := s.c.nc.Subscribe(subject, s.c.recoverHandler(panicSite{policy: s.opts.Panics, service: s.name}, handler))
+	if err != nil {
+		return err
+	}
+	s.subs = append(s.subs, sub)
+	return nil
+}
+
+func (s *service) reply(v func() interface{}) nats.MsgHandler {
+	return func(m *nats.Msg) {
+		if m.Reply == "" {
+			return
+		}
+		data, err := json.Marshal(v())
+		if err != nil {
+			s.c.handleError(err)
+			return
+		}
+		m.Respond(data)
+	}
+}
+
+func (s *service) Name() string    { return s.name }
+func (s *service) Version() string { return s.version }
+func (s *service) ID() string      { return s.id }
+
+func (s *service) identity() ServiceIdentity {
+	md := s.opts.Metadata
+	if md == nil {
+		md = map[string]string{}
+	}
+	return ServiceIdentity{Name: s.name, ID: s.id, Version: s.version, Metadata: md}
+}
+
+func (s *service) ping() ServicePing {
+	return ServicePing{ServiceIdentity: s.identity(), Type: servicePingType}
+}
+
+func (s *service) info() ServiceInfo {
+	s.mu.Lock()
+	description := s.opts.Description
+	endpoints := make([]ServiceEndpoint, len(s.endpoints))
+	for i, e := range s.endpoints {
+		endpoints[i] = ServiceEndpoint{Name: e.name, Subject: e.opts.Subject, QueueGroup: e.opts.Queue, Metadata: e.opts.Metadata}
+	}
+	s.mu.Unlock()
+	return ServiceInfo{
+		ServiceIdentity: s.identity(),
+		Type:            serviceInfoType,
+		Description:     description,
+		Endpoints:       endpoints,
+	}
+}
+
+// Stats is what $SRV.STATS answers with, a copy safe to keep.
+func (s *service) Stats() ServiceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]ServiceEndpointStats, len(s.endpoints))
+	for i, e := range s.endpoints {
+		stats[i] = e.stats
+		stats[i].Latency = e.stats.Latency.clone()
+	}
+	return ServiceStats{
+		ServiceIdentity: s.identity(),
+		Type:            serviceStatsType,
+		Started:         s.started,
+		Endpoints:       stats,
+	}
+}
+
+// Discover answers on subject with the service info, same as $SRV.INFO.
+func (s *service) Discover(subject, description string) error {
+	if err := checkSubject(subject, false); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.opts.Description = description
+	s.mu.Unlock()
+	return s.subscribe(subject, s.reply(func() interface{} { return s.info() }))
+}
+
+type ServiceHealth struct {
+	ServiceIdentity
+	Status string `json:"status"`
+}
+
+// Health answers on subject as long as the service is up.
+func (s *service) Health(subject string) error {
+	if err := checkSubject(subject, false); err != nil {
+		return err
+	}
+	return s.subscribe(subject, s.reply(func() interface{} {
+		return ServiceHealth{ServiceIdentity: s.identity(), Status: "ok"}
+	}))
+}
+
+// Shutdown drains all of the service's subscriptions, so requests already
+// received are still answered.
+func (s *service) Shutdown() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil
+	}
+	s.done = true
+	s.cancel()
+	s.c.services.Delete(s)
+	var errs []error
+	for _, sub := range s.subs {
+		if err := sub.Drain(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+			errs = append(errs, err)
+		}
+	}
+	s.c.log.Info("service stopped", "name", s.name, "id", s.id)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// drain is Shutdown waiting up to ctx for the requests in hand, for the
+// connection's Shutdown.
+func (s *service) drain(ctx context.Context) error {
+	if err := s.Shutdown(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	subs, endpoints := s.subs, s.endpoints
+	s.mu.Unlock()
+	// Polled like subscription.Drain.
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for _, sub := range subs {
+		for sub.IsValid() {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	for _, e := range endpoints {
+		if err := e.limits.wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package natsv2
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clients for a service, so the options every request to it wants, timeout,
+// retries, breaker, are given once instead of on every Request. A client
+// calls methods by name under the service's subject and keeps stats for
+// each:
+//
+//	calc := Client(nc, "calc.v1", Timeout(time.Second), Retry(3, 50*time.Millisecond), Breaker(5, 30*time.Second))
+//	var sum AddResponse
+//	err := calc.Call(ctx, "add", AddRequest{A: 2, B: 2}, &sum)
+//
+// Options given to Call come after the client's, so they win, and the
+// client's after the connection's, see defaults.go. Method makes
+// a typed helper for one method:
+//
+//	add := Method[AddRequest, AddResponse](calc, "add")
+//	sum, err := add(ctx, AddRequest{A: 2, B: 2})
+
+type ServiceClient struct {
+	nc       Connection
+	subject  string
+	defaults []ReqOption
+
+	mu    sync.Mutex
+	stats map[string]*ClientMethodStats
+}
+
+// ClientMethodStats are a client's calls of one method, Errors counts
+// service errors as well as ones getting there.
+type ClientMethodStats struct {
+	Method         string           `json:"method"`
+	Calls          int              `json:"calls"`
+	Errors         int              `json:"errors"`
+	LastError      string           `json:"last_error,omitempty"`
+	AverageLatency time.Duration    `json:"average_latency"`
+	Latency        LatencyHistogram `json:"latency"`
+	total          time.Duration
+}
+
+// Client calls the methods at subject, a service's group like "calc.v1",
+// with defaults on every call.
+func Client(nc Connection, subject string, defaults ...ReqOption) *ServiceClient {
+	return &ServiceClient{nc: nc, subject: subject, defaults: defaults, stats: map[string]*ClientMethodStats{}}
+}
+
+// Call sends req to the method and decodes the reply into resp, unless resp
+// is nil.
+func (c *ServiceClient) Call(ctx context.Context, method string, req, resp interface{}, opts ...ReqOption) error {
+	_, err := c.CallMsg(ctx, method, req, resp, opts...)
+	return err
+}
+
+// CallMsg is Call that returns the reply as well, for its headers.
+func (c *ServiceClient) CallMsg(ctx context.Context, method string, req, resp interface{}, opts ...ReqOption) (*Msg, error) {
+	all := make([]ReqOption, 0, len(c.defaults)+len(opts)+1)
+	all = append(append(append(all, c.defaults...), Ctx(ctx)), opts...)
+	start := time.Now()
+	reply, err := c.nc.Request(c.subject+"."+method, req, all...)
+	if err == nil && resp != nil {
+		err = c.nc.Decode(reply, resp)
+	}
+	c.record(method, time.Since(start), err)
+	return reply, err
+}
+
+func (c *ServiceClient) record(method string, took time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats[method]
+	if st == nil {
+		st = &ClientMethodStats{Method: method, Latency: newLatencyHistogram(DefaultLatencyBuckets)}
+		c.stats[method] = st
+	}
+	st.Calls++
+	st.total += took
+	st.AverageLatency = st.total / time.Duration(st.Calls)
+	st.Latency.observe(took)
+	if err != nil {
+		st.Errors++
+		st.LastError = err.Error()
+	}
+}
+
+// Stats are by method, in order of name.
+func (c *ServiceClient) Stats() []ClientMethodStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]ClientMethodStats, 0, len(c.stats))
+	for _, st := range c.stats {
+		cp := *st
+		cp.Latency = st.Latency.clone()
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Method < out[j].Method })
+	return out
+}
+
+// ResetStats starts the counts over.
+func (c *ServiceClient) ResetStats() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats = map[string]*ClientMethodStats{}
+}
+
+// Method is a typed helper calling one of c's methods.
+func Method[Req, Resp any](c *ServiceClient, method string, opts ...ReqOption) func(context.Context, Req) (*Resp, error) {
+	return func(ctx context.Context, req Req) (*Resp, error) {
+		resp := new(Resp)
+		if err := c.Call(ctx, method, req, resp, opts...); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
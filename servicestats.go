@@ -0,0 +1,55 @@
+package natsv2
+
+import (
+	"errors"
+	"time"
+)
+
+// Upper bounds of the processing time histogram in the service stats unless
+// set with LatencyBuckets.
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// LatencyHistogram counts requests by processing time. Counts[i] is how many
+// took at most Bounds[i] but more than the bound before it, the extra last
+// count is for the ones slower than all of them.
+type LatencyHistogram struct {
+	Bounds []time.Duration `json:"bounds"`
+	Counts []int           `json:"counts"`
+}
+
+func newLatencyHistogram(bounds []time.Duration) LatencyHistogram {
+	return LatencyHistogram{Bounds: bounds, Counts: make([]int, len(bounds)+1)}
+}
+
+func (h *LatencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.Bounds) && d > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+}
+
+func (h LatencyHistogram) clone() LatencyHistogram {
+	h.Counts = append([]int(nil), h.Counts...)
+	return h
+}
+
+// LatencyBuckets sets the histogram bounds, in increasing order.
+func LatencyBuckets(bounds ...time.Duration) ServiceOption {
+	return func(o *ServiceOptions) error {
+		if len(bounds) == 0 {
+			return errors.New("natsv2: need at least one latency bucket")
+		}
+		for i := 1; i < len(bounds); i++ {
+			if bounds[i] <= bounds[i-1] {
+				return errors.New("natsv2: latency buckets must be increasing")
+			}
+		}
+		o.LatencyBuckets = append([]time.Duration(nil), bounds...)
+		return nil
+	}
+}
@@ -0,0 +1,192 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Shutting everything down in the right order. Shutdown drains in phases,
+// each waiting for the one before:
+//
+//	ShutdownServices       services, requests in hand are still answered
+//	ShutdownSubscriptions  core NATS subscriptions
+//	ShutdownConsumers      JetStream consumers, pull ones stop fetching first
+//	ShutdownPublishes      async JetStream publishes still waiting for acks
+//	ShutdownConnection     the connection, flushing whatever is buffered
+//
+// Services go first so no new work comes in while the rest finish, the
+// connection last so handlers can still publish and ack on their way out.
+// The subscriptions and services of a phase drain together. Each phase gets
+// up to DefaultShutdownTimeout, or what ShutdownTimeout gives it, and the
+// whole of it up to ctx:
+//
+//	err := nc.Shutdown(ctx, ShutdownTimeout(ShutdownConsumers, time.Minute))
+//	var serr *ShutdownError
+//	if errors.As(err, &serr) {
+//		for _, f := range serr.Failures {
+//			log.Printf("%s %s: %v", f.Phase, f.Name, f.Err)
+//		}
+//	}
+//
+// Whatever didn't drain in time is in the *ShutdownError, the connection is
+// closed either way. Handlers see their context done once their phase starts,
+// see context.go.
+
+type ShutdownPhase string
+
+const (
+	ShutdownServices      ShutdownPhase = "services"
+	ShutdownSubscriptions ShutdownPhase = "subscriptions"
+	ShutdownConsumers     ShutdownPhase = "consumers"
+	ShutdownPublishes     ShutdownPhase = "publishes"
+	ShutdownConnection    ShutdownPhase = "connection"
+)
+
+// DefaultShutdownTimeout is per phase.
+const DefaultShutdownTimeout = 10 * time.Second
+
+type ShutdownOption func(*ShutdownOptions) error
+
+type ShutdownOptions struct {
+	Timeouts map[ShutdownPhase]time.Duration
+}
+
+func ShutdownTimeout(phase ShutdownPhase, d time.Duration) ShutdownOption {
+	return func(o *ShutdownOptions) error {
+		if d <= 0 {
+			return errors.New("natsv2: shutdown timeout must be positive")
+		}
+		if o.Timeouts == nil {
+			o.Timeouts = map[ShutdownPhase]time.Duration{}
+		}
+		o.Timeouts[phase] = d
+		return nil
+	}
+}
+
+func (o *ShutdownOptions) timeout(phase ShutdownPhase) time.Duration {
+	if d, ok := o.Timeouts[phase]; ok {
+		return d
+	}
+	return DefaultShutdownTimeout
+}
+
+// A ShutdownFailure is something that didn't drain, Name is the service,
+// subject or consumer.
+type ShutdownFailure struct {
+	Phase ShutdownPhase
+	Name  string
+	Err   error
+}
+
+type ShutdownError struct {
+	Failures []ShutdownFailure
+}
+
+func (e *ShutdownError) Error() string {
+	s := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		s[i] = fmt.Sprintf("%s %s: %v", f.Phase, f.Name, f.Err)
+	}
+	return fmt.Sprintf("natsv2: %d failed to drain: %s", len(e.Failures), strings.Join(s, "; "))
+}
+
+func (e *ShutdownError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+func (c *conn) Shutdown(ctx context.Context, opts ...ShutdownOption) error {
+	o := &ShutdownOptions{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return err
+		}
+	}
+	if c.closed.Load() {
+		return nil
+	}
+	c.log.Info("shutting down")
+	start := time.Now()
+
+	var services, subs, consumers []drainer
+	c.services.Range(func(k, _ interface{}) bool {
+		s := k.(*service)
+		services = append(services, drainer{s.name, s.drain})
+		return true
+	})
+	c.subs.Range(func(k, v interface{}) bool {
+		d := drainer{k.(*SubOptions).name(), v.(Subscription).Drain}
+		if k.(*SubOptions).Consumer != nil {
+			consumers = append(consumers, d)
+		} else {
+			subs = append(subs, d)
+		}
+		return true
+	})
+
+	var failures []ShutdownFailure
+	phase := func(p ShutdownPhase, ds []drainer) {
+		if len(ds) == 0 {
+			return
+		}
+		c.log.Info("draining", "phase", string(p), "count", len(ds))
+		pctx, cancel := context.WithTimeout(ctx, o.timeout(p))
+		defer cancel()
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, d := range ds {
+			wg.Add(1)
+			go func(d drainer) {
+				defer wg.Done()
+				if err := d.drain(pctx); err != nil {
+					c.log.Warn("drain failed", "phase", string(p), "name", d.name, "error", err)
+					mu.Lock()
+					failures = append(failures, ShutdownFailure{Phase: p, Name: d.name, Err: err})
+					mu.Unlock()
+				}
+			}(d)
+		}
+		wg.Wait()
+	}
+	phase(ShutdownServices, services)
+	phase(ShutdownSubscriptions, subs)
+	phase(ShutdownConsumers, consumers)
+	phase(ShutdownPublishes, []drainer{{"async", c.publishesComplete}})
+	phase(ShutdownConnection, []drainer{{c.nc.ConnectedUrlRedacted(), c.Drain}})
+
+	c.log.Info("shut down", "took", time.Since(start), "failures", len(failures))
+	if len(failures) > 0 {
+		return &ShutdownError{Failures: failures}
+	}
+	return nil
+}
+
+type drainer struct {
+	name  string
+	drain func(context.Context) error
+}
+
+func (c *conn) publishesComplete(ctx context.Context) error {
+	select {
+	case <-c.js.PublishAsyncComplete():
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%w with %d acks pending", ctx.Err(), c.js.PublishAsyncPending())
+	}
+}
+
+// name is what a subscription is called in a ShutdownFailure.
+func (o *SubOptions) name() string {
+	if co := o.Consumer; co != nil && co.Durable != "" {
+		return co.Durable
+	}
+	return o.subject
+}
@@ -0,0 +1,135 @@
+package natsv2
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Payload signing with nkeys, for when who sent a message matters and not
+// just that they could connect. WithSigning signs everything going out, the
+// signature covers the payload as sent, after any pipeline, and goes in
+// Nats-Signature along with the public key in Nats-Signer.
+//
+// WithVerify checks messages coming in, and replies to Request. A message
+// without a valid signature from one of the trusted keys, any key if none
+// are given, goes to onUnverified, which can flag it and return true to let
+// it through. Without a callback, or if it returns false, the message is
+// dropped, the ErrorHandler gets the ErrUnverified and requests get a "403"
+// service error back.
+//
+//	nc, _ := Connect(url, WithSigning(seed), WithVerify([]string{"UD6H..."}, nil))
+
+const (
+	SignatureHeader = "Nats-Signature"
+	SignerHeader    = "Nats-Signer"
+)
+
+var ErrUnverified = errors.New("natsv2: message signature not verified")
+
+type VerifyOptions struct {
+	Trusted      map[string]bool
+	OnUnverified func(m *nats.Msg, err error) bool
+}
+
+func WithSigning(seed []byte) ConnectOption {
+	return func(o *ConnectOptions) error {
+		kp, err := nkeys.FromSeed(seed)
+		if err != nil {
+			return fmt.Errorf("natsv2: signing seed: %w", err)
+		}
+		o.Signer = kp
+		return nil
+	}
+}
+
+func WithVerify(trusted []string, onUnverified func(m *nats.Msg, err error) bool) ConnectOption {
+	return func(o *ConnectOptions) error {
+		v := &VerifyOptions{Trusted: map[string]bool{}, OnUnverified: onUnverified}
+		for _, pub := range trusted {
+			if !nkeys.IsValidPublicKey(pub) {
+				return fmt.Errorf("natsv2: trusted key %q is not a public nkey", pub)
+			}
+			v.Trusted[pub] = true
+		}
+		o.Verify = v
+		return nil
+	}
+}
+
+// sign is the last thing before m goes out.
+func (c *conn) sign(send PublishFunc) PublishFunc {
+	kp := c.opts.Signer
+	if kp == nil {
+		return send
+	}
+	return func(ctx context.Context, m *nats.Msg) error {
+		sig, err := kp.Sign(m.Data)
+		if err != nil {
+			return err
+		}
+		pub, err := kp.PublicKey()
+		if err != nil {
+			return err
+		}
+		m.Header.Set(SignatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+		m.Header.Set(SignerHeader, pub)
+		return send(ctx, m)
+	}
+}
+
+func (v *VerifyOptions) check(m *nats.Msg) error {
+	pub := m.Header.Get(SignerHeader)
+	if pub == "" {
+		return fmt.Errorf("%w: unsigned message on %q", ErrUnverified, m.Subject)
+	}
+	if len(v.Trusted) > 0 && !v.Trusted[pub] {
+		return fmt.Errorf("%w: %s is not trusted", ErrUnverified, pub)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(m.Header.Get(SignatureHeader))
+	if err != nil {
+		return fmt.Errorf("%w: bad signature: %v", ErrUnverified, err)
+	}
+	kp, err := nkeys.FromPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("%w: bad signer: %v", ErrUnverified, err)
+	}
+	if err := kp.Verify(m.Data, sig); err != nil {
+		return fmt.Errorf("%w: bad signature on %q", ErrUnverified, m.Subject)
+	}
+	return nil
+}
+
+// verified reports whether m can be handled, err is why not.
+func (c *conn) verified(m *nats.Msg) (bool, error) {
+	v := c.opts.Verify
+	if v == nil {
+		return true, nil
+	}
+	if m.Header == nil {
+		// For the callback to flag it.
+		m.Header = nats.Header{}
+	}
+	err := v.check(m)
+	if err == nil || (v.OnUnverified != nil && v.OnUnverified(m, err)) {
+		return true, nil
+	}
+	return false, err
+}
+
+func (c *conn) verify(handler nats.MsgHandler) nats.MsgHandler {
+	if c.opts.Verify == nil {
+		return handler
+	}
+	return func(m *nats.Msg) {
+		if ok, err := c.verified(m); !ok {
+			failed(c, m, "403", err)
+			return
+		}
+		handler(m)
+	}
+}
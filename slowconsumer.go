@@ -0,0 +1,244 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Messages wait in the client until the handler gets to them, up to the
+// pending limits, 512k messages or 64MB unless set with PendingLimits. Past
+// that the subscription is a slow consumer and what happens is up to its
+// SlowConsumer policy. OnDrop is called each time the subscription starts
+// dropping, with the totals so far.
+//
+//	nc.Subscribe("ticks", Handler(h), PendingLimits(1000, -1), SlowConsumer(SlowConsumerDropOld),
+//		OnDrop(func(s DropStats) { log.Printf("%s dropped %d", s.Subject, s.Dropped) }))
+
+type SlowConsumerPolicy int
+
+const (
+	// Drop the new messages, the client's own behaviour.
+	SlowConsumerDropNew SlowConsumerPolicy = iota
+	// Drop the oldest pending message to make room, for when only the
+	// latest matters.
+	SlowConsumerDropOld
+	// Unsubscribe, for when a gap is worse than stopping. The ErrorHandler
+	// gets ErrSlowConsumer.
+	SlowConsumerError
+)
+
+var ErrSlowConsumer = nats.ErrSlowConsumer
+
+type DropStats struct {
+	Subject      string
+	Dropped      int
+	Pending      int
+	PendingBytes int
+}
+
+// PendingLimits bounds what waits for the handler, in messages and bytes, -1
+// for no limit.
+func PendingLimits(msgs, bytes int) SubOption {
+	return func(o *SubOptions) error {
+		if msgs == 0 || bytes == 0 {
+			return errors.New("natsv2: pending limits can't be 0, use -1 for no limit")
+		}
+		o.PendingMsgs, o.PendingBytes = msgs, bytes
+		return nil
+	}
+}
+
+func SlowConsumer(policy SlowConsumerPolicy) SubOption {
+	return func(o *SubOptions) error {
+		if policy < SlowConsumerDropNew || policy > SlowConsumerError {
+			return fmt.Errorf("natsv2: unknown slow consumer policy %d", policy)
+		}
+		o.SlowConsumer = policy
+		return nil
+	}
+}
+
+func OnDrop(cb func(DropStats)) SubOption {
+	return func(o *SubOptions) error {
+		o.OnDrop = cb
+		return nil
+	}
+}
+
+func (o *SubOptions) hasPendingOpts() bool {
+	return o.PendingMsgs != 0 || o.SlowConsumer != SlowConsumerDropNew || o.OnDrop != nil
+}
+
+// newPendingQueue puts our own queue in front of handler for the drop old
+// policy, the client can only drop new messages.
+func (o *SubOptions) newPendingQueue(handler nats.MsgHandler, log Logger) (nats.MsgHandler, error) {
+	if o.SlowConsumer != SlowConsumerDropOld {
+		return handler, nil
+	}
+	if handler == nil {
+		return nil, errors.New("natsv2: dropping old messages needs a Handler or Channel")
+	}
+	msgs, bytes := o.PendingMsgs, o.PendingBytes
+	if msgs == 0 {
+		msgs, bytes = nats.DefaultSubPendingMsgsLimit, nats.DefaultSubPendingBytesLimit
+	}
+	q := &pendingQueue{maxMsgs: msgs, maxBytes: bytes, onDrop: o.OnDrop, log: log}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run(handler)
+	o.queue = q
+	return q.push, nil
+}
+
+type pendingQueue struct {
+	maxMsgs, maxBytes int
+	onDrop            func(DropStats)
+	log               Logger
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	msgs    []*nats.Msg
+	bytes   int
+	busy    bool
+	dropped int
+	slow    bool
+	stopped bool
+}
+
+func (q *pendingQueue) push(m *nats.Msg) {
+	q.mu.Lock()
+	dropped := false
+	for len(q.msgs) > 0 && q.full(len(m.Data)) {
+		q.bytes -= len(q.msgs[0].Data)
+		q.msgs[0] = nil
+		q.msgs = q.msgs[1:]
+		q.dropped++
+		dropped = true
+	}
+	q.msgs = append(q.msgs, m)
+	q.bytes += len(m.Data)
+	var stats *DropStats
+	if dropped && !q.slow {
+		stats = &DropStats{Subject: m.Subject, Dropped: q.dropped, Pending: len(q.msgs), PendingBytes: q.bytes}
+	}
+	q.slow = dropped
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	if stats != nil {
+		q.log.Warn("slow consumer, dropping the oldest", "subject", stats.Subject, "dropped", stats.Dropped, "pending", stats.Pending)
+		if q.onDrop != nil {
+			q.onDrop(*stats)
+		}
+	}
+}
+
+func (q *pendingQueue) full(adding int) bool {
+	return (q.maxMsgs > 0 && len(q.msgs)+1 > q.maxMsgs) || (q.maxBytes > 0 && q.bytes+adding > q.maxBytes)
+}
+
+func (q *pendingQueue) run(handler nats.MsgHandler) {
+	q.mu.Lock()
+	for {
+		for len(q.msgs) == 0 && !q.stopped {
+			q.busy = false
+			q.cond.Broadcast()
+			q.cond.Wait()
+		}
+		if q.stopped {
+			q.mu.Unlock()
+			return
+		}
+		m := q.msgs[0]
+		q.msgs[0] = nil
+		q.msgs = q.msgs[1:]
+		q.bytes -= len(m.Data)
+		q.busy = true
+		q.mu.Unlock()
+		handler(m)
+		q.mu.Lock()
+	}
+}
+
+// wait is for the queue to be handled, for Drain.
+func (q *pendingQueue) wait(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+	idle := make(chan struct{})
+	go func() {
+		q.mu.Lock()
+		for (len(q.msgs) > 0 || q.busy) && !q.stopped {
+			q.cond.Wait()
+		}
+		q.mu.Unlock()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *pendingQueue) stop() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// setPending applies the limits and policy to the client's side of s. Pull
+// consumers only hold what they fetched.
+func (c *conn) setPending(sub Subscription) error {
+	s, ok := sub.(*subscription)
+	if !ok || !s.sopts.hasPendingOpts() {
+		return nil
+	}
+	o, ns := s.sopts, s.current()
+	switch {
+	case o.queue != nil:
+		// Ours does the limiting.
+		if err := ns.SetPendingLimits(-1, -1); err != nil {
+			return err
+		}
+	case o.PendingMsgs != 0:
+		if err := ns.SetPendingLimits(o.PendingMsgs, o.PendingBytes); err != nil {
+			return err
+		}
+	}
+	if o.queue == nil {
+		c.slow.Store(ns, s)
+	}
+	return nil
+}
+
+// slowConsumer is called from the client's error handler when it starts
+// dropping for sub.
+func (c *conn) slowConsumer(sub *nats.Subscription) {
+	stats := DropStats{Subject: sub.Subject}
+	stats.Dropped, _ = sub.Dropped()
+	stats.Pending, stats.PendingBytes, _ = sub.Pending()
+	c.log.Warn("slow consumer", "subject", stats.Subject, "dropped", stats.Dropped, "pending", stats.Pending)
+	v, ok := c.slow.Load(sub)
+	if !ok {
+		return
+	}
+	s := v.(*subscription)
+	if cb := s.sopts.OnDrop; cb != nil {
+		cb(stats)
+	}
+	if s.sopts.SlowConsumer == SlowConsumerError {
+		if s.set != nil {
+			s.set.Unsubscribe()
+		} else {
+			s.Unsubscribe()
+		}
+	}
+}
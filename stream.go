@@ -0,0 +1,228 @@
+package natsv2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A Stream is a subject plus how to publish to and subscribe on it, set up
+// once. Values are encoded with the stream's codec, the connection default
+// (JSON) unless told otherwise, and get a Content-Type header so subscribers
+// can Decode them. []byte and string skip the codec, as do payloads built
+// with the helpers like Base64(Gzip(JSON(v))), or declare that once with
+// Pipeline, see pipeline.go.
+//
+//	stream := nc.Stream("foo.bar")
+//	stream.Publish(curTemp)
+//	stream.WithEncoder(MsgPackContentType).Publish(curTemp)
+//	nc.Stream("foo.bar", Pipeline("base64", "gzip", JSONContentType)).Publish(curTemp)
+//
+// Codecs can also be registered on just one stream with StreamCodec, they
+// are used on top of the connection's for publishing and for Decode.
+//
+// Option errors are held on to and returned from Publish and Subscribe so
+// calls can be chained.
+type Stream interface {
+	Subject() string
+	WithEncoder(contentType string) Stream
+	Publish(msg interface{}, opts ...PubOption) error
+	PublishCtx(ctx context.Context, msg interface{}, opts ...PubOption) error
+	PublishAsync(msg interface{}, opts ...PubOption) (nats.PubAckFuture, error)
+	// See asyncpub.go.
+	PublishAsyncComplete() <-chan struct{}
+	Subscribe(opts ...SubOption) (Subscription, error)
+	Decode(m *Msg, v interface{}) error
+	// See replay.go.
+	Replay(from interface{}, handler func(*Msg), opts ...ReplayOption) (*Replay, error)
+	// See pullchan.go.
+	Channel(batch int, opts ...SubOption) (<-chan *Msg, func(), error)
+}
+
+type StreamOption func(*StreamOptions) error
+
+type StreamOptions struct {
+	// Codec for published values, the connection default if empty.
+	ContentType string
+	Codecs      []Codec
+	Pipeline    []string
+	// See compress.go.
+	CompressMinSize int
+	// JetStream stream to publish to, see jetstream.go.
+	JetStream string
+	// Applied before the publish options, see Idempotent.
+	MsgID PubOption
+	// Created or updated first, see jsm.go.
+	Declare *nats.StreamConfig
+	// See asyncpub.go.
+	MaxAsyncPending int
+	OnAsyncError    func(*nats.Msg, error)
+}
+
+func Encoder(contentType string) StreamOption {
+	return func(o *StreamOptions) error {
+		o.ContentType = contentType
+		return nil
+	}
+}
+
+// StreamCodec registers codec on this stream only and publishes with it.
+func StreamCodec(codec Codec) StreamOption {
+	return func(o *StreamOptions) error {
+		o.Codecs = append(o.Codecs, codec)
+		o.ContentType = codec.ContentType()
+		return nil
+	}
+}
+
+type stream struct {
+	c       *conn
+	subject string
+	opts    StreamOptions
+	codecs  *codecs
+	pipe    pipeline
+	err     error
+	// Shared with copies, see asyncpub.go.
+	async *asyncTracker
+}
+
+func (c *conn) Stream(subject string, opts ...StreamOption) Stream {
+	s := &stream{c: c, subject: subject, codecs: c.codecs}
+	if s.err = checkSubject(subject, true); s.err != nil {
+		return s
+	}
+	if s.err = applyOptions(&s.opts, c.opts.StreamDefaults, opts); s.err != nil {
+		return s
+	}
+	if s.opts.Declare != nil {
+		if _, s.err = c.JetStream().DeclareStream(*s.opts.Declare); s.err != nil {
+			return s
+		}
+	}
+	if s.opts.MsgID != nil && s.opts.JetStream == "" {
+		s.err = ErrJetStreamRequired
+		return s
+	}
+	if s.opts.JetStream != "" {
+		if s.err = c.checkJetStream(s.opts.JetStream, c.outSubject(subject)); s.err != nil {
+			return s
+		}
+		s.async = newAsyncTracker(&s.opts)
+	}
+	if len(s.opts.Codecs) > 0 {
+		s.codecs = c.codecs.with(s.opts.Codecs)
+	}
+	s.pipe = s.codecs.out
+	if len(s.opts.Pipeline) > 0 {
+		if s.pipe, s.err = s.codecs.pipeline(s.opts.Pipeline); s.err != nil {
+			return s
+		}
+	}
+	if s.opts.CompressMinSize > 0 {
+		s.pipe.minSize = s.opts.CompressMinSize
+	}
+	if s.opts.ContentType != "" {
+		var codec Codec
+		codec, s.err = s.lookupCodec(s.opts.ContentType)
+		s.pipe = s.pipe.withCodec(codec)
+	}
+	return s
+}
+
+func (s *stream) lookupCodec(contentType string) (Codec, error) {
+	if contentType == "" {
+		return s.codecs.def, nil
+	}
+	if codec := s.codecs.byType[contentType]; codec != nil {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, contentType)
+}
+
+func (s *stream) Subject() string {
+	return s.subject
+}
+
+// WithEncoder returns a copy of the stream using the codec for contentType,
+// which needs to be registered on the connection or the stream.
+func (s *stream) WithEncoder(contentType string) Stream {
+	ns := *s
+	if ns.err == nil {
+		ns.opts.ContentType = contentType
+		var codec Codec
+		codec, ns.err = ns.lookupCodec(contentType)
+		ns.pipe = ns.pipe.withCodec(codec)
+	}
+	return &ns
+}
+
+func (s *stream) Publish(msg interface{}, opts ...PubOption) error {
+	return s.PublishCtx(context.Background(), msg, opts...)
+}
+
+func (s *stream) PublishCtx(ctx context.Context, msg interface{}, opts ...PubOption) error {
+	if s.err != nil {
+		return s.err
+	}
+	if err := checkSubject(s.subject, false); err != nil {
+		return err
+	}
+	m, err := s.codecs.encode(s.subject, msg, s.pipe)
+	if err != nil {
+		return err
+	}
+	if s.opts.JetStream != "" {
+		m.Subject = s.c.outSubject(s.subject)
+		_, err := s.c.publishJetStream(ctx, s.opts.JetStream, m, msg, s.pubOpts(opts))
+		return err
+	}
+	return s.c.publishMsg(ctx, m, opts...)
+}
+
+// PublishAsync needs a JetStream stream, the future resolves with its ack.
+func (s *stream) PublishAsync(msg interface{}, opts ...PubOption) (nats.PubAckFuture, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.opts.JetStream == "" {
+		return nil, ErrJetStreamRequired
+	}
+	if err := checkSubject(s.subject, false); err != nil {
+		return nil, err
+	}
+	m, err := s.codecs.encode(s.subject, msg, s.pipe)
+	if err != nil {
+		return nil, err
+	}
+	m.Subject = s.c.outSubject(s.subject)
+	s.async.acquire()
+	f, err := s.c.publishJetStreamAsync(s.opts.JetStream, m, msg, s.pubOpts(opts))
+	if err != nil {
+		s.async.release()
+		return nil, err
+	}
+	s.async.watch(f)
+	return f, nil
+}
+
+func (s *stream) pubOpts(opts []PubOption) []PubOption {
+	if s.opts.MsgID == nil {
+		return opts
+	}
+	return append([]PubOption{s.opts.MsgID}, opts...)
+}
+
+func (s *stream) Subscribe(opts ...SubOption) (Subscription, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.opts.JetStream != "" {
+		opts = append(opts, bindStream(s.opts.JetStream))
+	}
+	return s.c.Subscribe(s.subject, opts...)
+}
+
+func (s *stream) Decode(m *Msg, v interface{}) error {
+	return s.codecs.decode(m.m, v)
+}
@@ -0,0 +1,172 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Streamed replies let a responder send any number of replies to one request,
+// e.g. incremental results from something long running. The requester marks
+// the request with Nats-Streamed and the responder ends the stream with an
+// empty message carrying Nats-Stream-End, plus the service error headers if
+// it failed. A responder that doesn't stream sends one plain reply, which is
+// taken as the whole stream.
+//
+//	nc.Request("service", "video-22", Streamed(func(msg *Msg) {}))
+//
+//	rs := nc.ReplyStream(req)
+//	for _, r := range results {
+//		rs.Send(r)
+//	}
+//	rs.Close(nil)
+
+const (
+	StreamedHeader  = "Nats-Streamed"
+	StreamEndHeader = "Nats-Stream-End"
+)
+
+// Streamed makes Request hand every reply to cb and return once the stream
+// ends, with the end of stream message or the *RequestError it carried. The
+// request Timeout, DefaultRequestTimeout if not set, is how long to wait for
+// each reply, a Ctx deadline is for the whole stream.
+func Streamed(cb func(*Msg)) ReqOption {
+	return func(o *ReqOptions) error {
+		if cb == nil {
+			return errors.New("natsv2: streamed needs a callback")
+		}
+		o.Streamed = cb
+		return nil
+	}
+}
+
+func (c *conn) requestStreamed(ropts *ReqOptions, m *nats.Msg) (*nats.Msg, error) {
+	ctx := ropts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	idle := ropts.Timeout
+	if idle <= 0 {
+		idle = DefaultRequestTimeout
+	}
+	if c.limiter != nil {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	in, err := c.replyInbox()
+	if err != nil {
+		return nil, err
+	}
+	defer in.close()
+	m.Reply = in.Reply
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	m.Header.Set(StreamedHeader, "true")
+	if err := c.send(ctx, m, c.publish); err != nil {
+		return nil, err
+	}
+
+	for first := true; ; first = false {
+		r, err := c.nextReply(ctx, in, idle)
+		if err != nil {
+			return nil, wrapRequestError(m.Subject, err)
+		}
+		if first && noResponders(r) {
+			return nil, wrapRequestError(m.Subject, nats.ErrNoResponders)
+		}
+		if r.Header.Get(StreamEndHeader) != "" {
+			return r, requestError(m.Subject, r)
+		}
+		if first && r.Header.Get(StreamedHeader) == "" {
+			if err := requestError(m.Subject, r); err != nil {
+				return r, err
+			}
+			ropts.Streamed(c.wrap(r))
+			return r, nil
+		}
+		ropts.Streamed(c.wrap(r))
+	}
+}
+
+func (c *conn) nextReply(ctx context.Context, in *replyInbox, idle time.Duration) (*nats.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, idle)
+	defer cancel()
+	return in.next(ctx)
+}
+
+// A ReplyStream sends streamed replies to one request. Close ends the
+// stream, a non nil err is sent as a service error, use a *RequestError to
+// pick the code.
+type ReplyStream interface {
+	Send(v interface{}) error
+	Close(err error) error
+}
+
+// ReplyStream streams replies to req. If req did not ask for a streamed
+// reply only the first Send goes out, as the single reply.
+func (c *conn) ReplyStream(req *Msg) ReplyStream {
+	return &replyStream{c: c, req: req.m, streamed: req.m.Header.Get(StreamedHeader) != ""}
+}
+
+var ErrReplyStreamClosed = errors.New("natsv2: reply stream closed")
+
+type replyStream struct {
+	c        *conn
+	req      *nats.Msg
+	streamed bool
+	done     bool
+}
+
+func (rs *replyStream) Send(v interface{}) error {
+	if rs.done {
+		return ErrReplyStreamClosed
+	}
+	reply, err := rs.c.encodeReply(rs.req, v, rs.c.codecs.def)
+	if errors.Is(err, ErrNotAcceptable) {
+		rs.done = true
+		reply.Header.Set(StreamEndHeader, "true")
+		if rerr := rs.c.respondMsg(rs.req, reply); rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if !rs.streamed {
+		rs.done = true
+		return rs.c.respondMsg(rs.req, reply)
+	}
+	reply.Header.Set(StreamedHeader, "true")
+	return rs.c.respondMsg(rs.req, reply)
+}
+
+func (rs *replyStream) Close(err error) error {
+	if rs.done {
+		return nil
+	}
+	rs.done = true
+	// Not streamed and nothing sent yet, so an error is the one reply.
+	if !rs.streamed && err == nil {
+		return nil
+	}
+	end := nats.NewMsg(rs.req.Reply)
+	if rs.streamed {
+		end.Header.Set(StreamEndHeader, "true")
+	}
+	if err != nil {
+		var rerr *RequestError
+		if errors.As(err, &rerr) {
+			end.Header.Set(ServiceErrorHeader, rerr.Description)
+			end.Header.Set(ServiceErrorCodeHeader, rerr.Code)
+		} else {
+			end.Header.Set(ServiceErrorHeader, err.Error())
+			end.Header.Set(ServiceErrorCodeHeader, "500")
+		}
+	}
+	return rs.c.respondMsg(rs.req, end)
+}
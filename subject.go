@@ -0,0 +1,219 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Catch bad subjects and queue names up front with a clear error, instead of
+// the server quietly never matching anything.
+//
+// Subjects built from data should go through Subject or a SubjectTemplate
+// rather than Sprintf, an id with a dot or a space in it is an error there
+// instead of a message that goes somewhere else.
+//
+//	subj, err := Subject("orders", region).Token(id).Build()
+//	sub, err := nc.Subscribe(Subject("orders").Wildcard().All().String())
+//
+//	tmpl, err := ParseSubjectTemplate("orders.{region}.{id}")
+//	subj, err := tmpl.Expand(map[string]string{"region": "eu", "id": id})
+//	params, ok := tmpl.Match("orders.eu.42") // {"region": "eu", "id": "42"}
+//
+//	Matches("orders.*.>", "orders.eu.42.lines") // true
+
+var (
+	ErrBadSubject = errors.New("natsv2: invalid subject")
+	ErrBadQueue   = errors.New("natsv2: invalid queue name")
+)
+
+func checkSubject(subject string, wildcards bool) error {
+	if subject == "" {
+		return fmt.Errorf("%w: empty", ErrBadSubject)
+	}
+	tokens := strings.Split(subject, ".")
+	for i, t := range tokens {
+		switch {
+		case t == "":
+			return fmt.Errorf("%w: empty token in %q", ErrBadSubject, subject)
+		case strings.ContainsAny(t, " \t\r\n"):
+			return fmt.Errorf("%w: whitespace in %q", ErrBadSubject, subject)
+		case t == "*" || t == ">":
+			if !wildcards {
+				return fmt.Errorf("%w: wildcards not allowed in %q", ErrBadSubject, subject)
+			}
+			if t == ">" && i != len(tokens)-1 {
+				return fmt.Errorf("%w: '>' must be the last token in %q", ErrBadSubject, subject)
+			}
+		}
+	}
+	return nil
+}
+
+func checkQueue(queue string) error {
+	if strings.TrimSpace(queue) == "" {
+		return fmt.Errorf("%w: empty", ErrBadQueue)
+	}
+	if strings.ContainsAny(queue, " \t\r\n") {
+		return fmt.Errorf("%w: whitespace in %q", ErrBadQueue, queue)
+	}
+	return nil
+}
+
+// DefaultServiceQueue is the queue group a service joins when none is given,
+// so all instances of the same service version share the load.
+func DefaultServiceQueue(name, version string) string {
+	return name + "-" + version
+}
+
+// checkToken is for a single literal token, no dots and no wildcards.
+func checkToken(token string) error {
+	switch {
+	case token == "":
+		return fmt.Errorf("%w: empty token", ErrBadSubject)
+	case strings.ContainsAny(token, " \t\r\n"):
+		return fmt.Errorf("%w: whitespace in token %q", ErrBadSubject, token)
+	case strings.Contains(token, "."):
+		return fmt.Errorf("%w: '.' in token %q", ErrBadSubject, token)
+	case token == "*" || token == ">":
+		return fmt.Errorf("%w: wildcard %q as a literal token", ErrBadSubject, token)
+	}
+	return nil
+}
+
+// A SubjectBuilder puts a subject together a token at a time. The first
+// bad token sticks and Build returns it.
+type SubjectBuilder struct {
+	tokens []string
+	err    error
+}
+
+// Subject starts a subject from literal tokens.
+func Subject(tokens ...string) SubjectBuilder {
+	return SubjectBuilder{}.Token(tokens...)
+}
+
+func (b SubjectBuilder) Token(tokens ...string) SubjectBuilder {
+	for _, t := range tokens {
+		if b.err == nil {
+			b.err = checkToken(t)
+		}
+		b = b.add(t)
+	}
+	return b
+}
+
+// Wildcard adds a '*'.
+func (b SubjectBuilder) Wildcard() SubjectBuilder {
+	return b.add("*")
+}
+
+// All adds a '>', nothing can follow it.
+func (b SubjectBuilder) All() SubjectBuilder {
+	return b.add(">")
+}
+
+// add copies, so builders sharing a prefix don't step on each other.
+func (b SubjectBuilder) add(t string) SubjectBuilder {
+	if b.err == nil && len(b.tokens) > 0 && b.tokens[len(b.tokens)-1] == ">" {
+		b.err = fmt.Errorf("%w: '>' must be the last token", ErrBadSubject)
+	}
+	b.tokens = append(b.tokens[:len(b.tokens):len(b.tokens)], t)
+	return b
+}
+
+func (b SubjectBuilder) Err() error {
+	if b.err == nil && len(b.tokens) == 0 {
+		return fmt.Errorf("%w: empty", ErrBadSubject)
+	}
+	return b.err
+}
+
+func (b SubjectBuilder) Build() (string, error) {
+	if err := b.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(b.tokens, "."), nil
+}
+
+// String is the subject, or "" if it isn't valid so that using it fails
+// rather than going somewhere else. Build has the reason.
+func (b SubjectBuilder) String() string {
+	subject, _ := b.Build()
+	return subject
+}
+
+// A SubjectTemplate is a pattern with named tokens, as for Handle, that
+// can be filled in or matched against.
+type SubjectTemplate struct {
+	rt  *route
+	raw []string
+}
+
+func ParseSubjectTemplate(pattern string) (*SubjectTemplate, error) {
+	rt, err := newRoute(pattern, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SubjectTemplate{rt: rt, raw: strings.Split(pattern, ".")}, nil
+}
+
+func (t *SubjectTemplate) String() string {
+	return t.rt.pattern
+}
+
+// Pattern is the subject to subscribe on, named tokens as '*'.
+func (t *SubjectTemplate) Pattern() string {
+	return t.rt.subject()
+}
+
+// Expand fills in the named tokens, each value has to be a single literal
+// token. Plain wildcards stay as they are unless params has them by number.
+func (t *SubjectTemplate) Expand(params map[string]string) (string, error) {
+	tokens := make([]string, len(t.raw))
+	for i, tok := range t.raw {
+		tokens[i] = tok
+		if t.rt.kinds[i] != pwcToken {
+			continue
+		}
+		name := t.rt.names[i]
+		v, ok := params[name]
+		if !ok {
+			if tok == "*" {
+				continue
+			}
+			return "", fmt.Errorf("%w: no value for {%s} in %q", ErrBadSubject, name, t.rt.pattern)
+		}
+		if err := checkToken(v); err != nil {
+			return "", fmt.Errorf("%w, for {%s} in %q", err, name, t.rt.pattern)
+		}
+		tokens[i] = v
+	}
+	return strings.Join(tokens, "."), nil
+}
+
+// Match returns the named tokens of subject if it matches, the same as
+// Params gives a Handle handler.
+func (t *SubjectTemplate) Match(subject string) (map[string]string, bool) {
+	return t.rt.match(subject)
+}
+
+// Matches is whether subject is in pattern, as the server would have it.
+func Matches(pattern, subject string) bool {
+	i, j := 0, 0
+	for i <= len(pattern) {
+		var pt, st string
+		pt, i = token(pattern, i)
+		switch {
+		case pt == ">":
+			return j <= len(subject)
+		case j > len(subject):
+			return false
+		}
+		st, j = token(subject, j)
+		if pt != "*" && pt != st {
+			return false
+		}
+	}
+	return j > len(subject)
+}
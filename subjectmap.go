@@ -0,0 +1,78 @@
+package natsv2
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// A subject mapper rewrites subjects between the application and the
+// server, so the same code runs for every tenant or behind an account's
+// import and export mappings:
+//
+//	nc, err := Connect(url, WithSubjectPrefix("acme"), WithInboxPrefix("acme._INBOX"))
+//	nc.Publish("orders.placed", order)   // goes out on acme.orders.placed
+//	nc.Subscribe("orders.*", Handler(h)) // subscribes to acme.orders.*, msg.Subject() is orders.placed
+//
+// Publishes, requests, subscriptions, JetStream publishes and consumers,
+// service endpoints, Handle and dead letters are mapped. Replies go where
+// the request said and are not, pick an inbox prefix the tenant may use for
+// those. JetStream management, KV and object stores are left alone, their
+// subjects are the server's. Interceptors and metrics see the subjects on
+// the wire, encryption keys are looked up by the application's.
+
+type SubjectMapper interface {
+	// Out is the subject on the wire for one the application uses.
+	Out(subject string) string
+	// In undoes Out. Subjects Out didn't make should come back as they are.
+	In(subject string) string
+}
+
+// WithSubjectPrefix puts every subject under prefix.
+func WithSubjectPrefix(prefix string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if err := checkSubject(prefix, false); err != nil || strings.HasSuffix(prefix, ".") {
+			return fmt.Errorf("natsv2: invalid subject prefix %q", prefix)
+		}
+		o.SubjectMapper = subjectPrefix(prefix + ".")
+		return nil
+	}
+}
+
+func WithSubjectMapper(m SubjectMapper) ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.SubjectMapper = m
+		return nil
+	}
+}
+
+type subjectPrefix string
+
+func (p subjectPrefix) Out(subject string) string { return string(p) + subject }
+func (p subjectPrefix) In(subject string) string  { return strings.TrimPrefix(subject, string(p)) }
+
+func (c *conn) outSubject(subject string) string {
+	if c.opts.SubjectMapper == nil {
+		return subject
+	}
+	return c.opts.SubjectMapper.Out(subject)
+}
+
+// unmap gives m the application's subject, as the last thing before it is
+// handed over.
+func (c *conn) unmap(m *nats.Msg) *nats.Msg {
+	if c.opts.SubjectMapper != nil {
+		m.Subject = c.opts.SubjectMapper.In(m.Subject)
+	}
+	return m
+}
+
+func (c *conn) unmapping(handler nats.MsgHandler) nats.MsgHandler {
+	if c.opts.SubjectMapper == nil {
+		return handler
+	}
+	return func(m *nats.Msg) {
+		handler(c.unmap(m))
+	}
+}
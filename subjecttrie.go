@@ -0,0 +1,217 @@
+package natsv2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A SubjectTrie finds the patterns a subject matches the way the server's
+// sublist does, one level per token, so a lookup costs the subject's length
+// and not the number of patterns. It walks the subject in place, finding a
+// match doesn't allocate. Handle and MsgRouter dispatch through one.
+//
+//	var t SubjectTrie[func(*Msg)]
+//	t.Insert("orders.*.created", created)
+//	t.Insert("orders.>", audit)
+//	h, pattern, ok := t.Lookup("orders.eu.created") // created, "orders.*.created"
+//	t.Match("orders.eu.created", func(pattern string, h func(*Msg)) { ... }) // both
+//
+// Lookup is the most specific match, literal tokens beat '*' beat '>' from
+// the left. It isn't safe for concurrent use, lock around it.
+// FuzzSubjectTrie checks it against the server's matching, and
+// examples/subjectbench times it against matching each pattern in turn.
+
+type SubjectTrie[T any] struct {
+	root trieNode[T]
+	n    int
+}
+
+type trieNode[T any] struct {
+	literal map[string]*trieNode[T]
+	pwc     *trieNode[T]
+	// The pattern ending here, and the one with a '>' after here.
+	leaf, fwc *trieEntry[T]
+}
+
+type trieEntry[T any] struct {
+	pattern string
+	v       T
+}
+
+// Insert adds pattern, an error if it is already there.
+func (t *SubjectTrie[T]) Insert(pattern string, v T) error {
+	if err := checkSubject(pattern, true); err != nil {
+		return err
+	}
+	slot := t.slot(pattern, true)
+	if *slot != nil {
+		return fmt.Errorf("%w: %q is already in the trie", ErrBadSubject, pattern)
+	}
+	*slot = &trieEntry[T]{pattern: pattern, v: v}
+	t.n++
+	return nil
+}
+
+// Get is what pattern itself was inserted with.
+func (t *SubjectTrie[T]) Get(pattern string) (T, bool) {
+	var zero T
+	if slot := t.slot(pattern, false); slot != nil && *slot != nil {
+		return (*slot).v, true
+	}
+	return zero, false
+}
+
+// Delete takes pattern out, false if it wasn't in.
+func (t *SubjectTrie[T]) Delete(pattern string) bool {
+	if !t.root.delete(pattern, 0) {
+		return false
+	}
+	t.n--
+	return true
+}
+
+func (t *SubjectTrie[T]) Len() int {
+	return t.n
+}
+
+// Lookup is the most specific pattern subject matches and its value.
+func (t *SubjectTrie[T]) Lookup(subject string) (v T, pattern string, ok bool) {
+	if e := t.root.lookup(subject, 0); e != nil {
+		return e.v, e.pattern, true
+	}
+	return v, "", false
+}
+
+// Match calls fn with each pattern subject matches.
+func (t *SubjectTrie[T]) Match(subject string, fn func(pattern string, v T)) {
+	t.root.match(subject, 0, fn)
+}
+
+// slot is where pattern's entry goes, nil if it isn't there and create is
+// false.
+func (t *SubjectTrie[T]) slot(pattern string, create bool) **trieEntry[T] {
+	n := &t.root
+	for i := 0; i <= len(pattern); {
+		var tok string
+		tok, i = token(pattern, i)
+		var next *trieNode[T]
+		switch {
+		case tok == ">" && i > len(pattern):
+			return &n.fwc
+		case tok == "*":
+			if n.pwc == nil && create {
+				n.pwc = &trieNode[T]{}
+			}
+			next = n.pwc
+		default:
+			next = n.literal[tok]
+			if next == nil && create {
+				if n.literal == nil {
+					n.literal = map[string]*trieNode[T]{}
+				}
+				next = &trieNode[T]{}
+				n.literal[tok] = next
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return &n.leaf
+}
+
+// lookup tries literal, then '*', then '>', backing up to the next best
+// branch on a dead end. i is where the rest of subject starts, past its end
+// once all of it has been matched.
+func (n *trieNode[T]) lookup(subject string, i int) *trieEntry[T] {
+	if i > len(subject) {
+		return n.leaf
+	}
+	tok, next := token(subject, i)
+	if c := n.literal[tok]; c != nil {
+		if e := c.lookup(subject, next); e != nil {
+			return e
+		}
+	}
+	if n.pwc != nil {
+		if e := n.pwc.lookup(subject, next); e != nil {
+			return e
+		}
+	}
+	return n.fwc
+}
+
+func (n *trieNode[T]) match(subject string, i int, fn func(string, T)) {
+	if i > len(subject) {
+		if n.leaf != nil {
+			fn(n.leaf.pattern, n.leaf.v)
+		}
+		return
+	}
+	if n.fwc != nil {
+		fn(n.fwc.pattern, n.fwc.v)
+	}
+	tok, next := token(subject, i)
+	if c := n.literal[tok]; c != nil {
+		c.match(subject, next, fn)
+	}
+	if n.pwc != nil {
+		n.pwc.match(subject, next, fn)
+	}
+}
+
+// delete takes the rest of pattern from i out of n, dropping the nodes it
+// leaves empty.
+func (n *trieNode[T]) delete(pattern string, i int) bool {
+	if i > len(pattern) {
+		if n.leaf == nil {
+			return false
+		}
+		n.leaf = nil
+		return true
+	}
+	tok, next := token(pattern, i)
+	switch {
+	case tok == ">" && next > len(pattern):
+		if n.fwc == nil {
+			return false
+		}
+		n.fwc = nil
+		return true
+	case tok == "*":
+		if n.pwc == nil || !n.pwc.delete(pattern, next) {
+			return false
+		}
+		if n.pwc.empty() {
+			n.pwc = nil
+		}
+	default:
+		c := n.literal[tok]
+		if c == nil || !c.delete(pattern, next) {
+			return false
+		}
+		if c.empty() {
+			delete(n.literal, tok)
+		}
+	}
+	return true
+}
+
+func (n *trieNode[T]) empty() bool {
+	return n.leaf == nil && n.fwc == nil && n.pwc == nil && len(n.literal) == 0
+}
+
+// token is subject's token starting at i and where the one after starts,
+// past the end of subject after the last one. Walking a subject with it is
+// strings.Split without the slice:
+//
+//	for i := 0; i <= len(subject); {
+//		tok, i = token(subject, i)
+//	}
+func token(subject string, i int) (string, int) {
+	if j := strings.IndexByte(subject[i:], '.'); j >= 0 {
+		return subject[i : i+j], i + j + 1
+	}
+	return subject[i:], len(subject) + 1
+}
@@ -0,0 +1,130 @@
+package natsv2
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// The server has a filter and a literal subject collide when the subject is
+// in the filter, which is what Lookup, Match and Matches have to agree with.
+func FuzzSubjectTrie(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"orders.*.created", "orders.eu.created"},
+		{"orders.>", "orders"},
+		{"orders.>", "orders.eu.created"},
+		{"*", "orders"},
+		{">", "a.b.c"},
+		{"a.*.>", "a.b"},
+		{"a.*.>", "a.b.c"},
+		{"a.b", "a.b.c"},
+		{"a.*.c", "a.b.d"},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, filter, subject string) {
+		if checkSubject(filter, true) != nil || checkSubject(subject, false) != nil {
+			t.Skip()
+		}
+		// The server takes any token starting with '*' or '>' for the
+		// wildcard, where a subject like ours has it as a literal.
+		for _, tok := range strings.Split(filter+"."+subject, ".") {
+			if len(tok) > 1 && (tok[0] == '*' || tok[0] == '>') {
+				t.Skip()
+			}
+		}
+		want := server.SubjectsCollide(filter, subject)
+		if got := Matches(filter, subject); got != want {
+			t.Fatalf("Matches(%q, %q) = %v, server %v", filter, subject, got, want)
+		}
+
+		var trie SubjectTrie[int]
+		if err := trie.Insert(filter, 1); err != nil {
+			t.Fatal(err)
+		}
+		// Others around it mustn't change the answer for filter.
+		for _, other := range []string{">", "*", subject, "x.>"} {
+			if other != filter {
+				trie.Insert(other, 2)
+			}
+		}
+		matched := false
+		trie.Match(subject, func(pattern string, v int) {
+			if pattern == filter {
+				if v != 1 || matched {
+					t.Fatalf("Match(%q) gave %q twice or with %d", subject, filter, v)
+				}
+				matched = true
+			}
+		})
+		if matched != want {
+			t.Fatalf("Match(%q) with %q = %v, server %v", subject, filter, matched, want)
+		}
+
+		var alone SubjectTrie[int]
+		alone.Insert(filter, 1)
+		if _, pattern, ok := alone.Lookup(subject); ok != want || ok && pattern != filter {
+			t.Fatalf("Lookup(%q) in {%q} = %q, %v, server %v", subject, filter, pattern, ok, want)
+		}
+	})
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		trie, subjects := benchTrie(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				trie.Lookup(subjects[i%len(subjects)])
+			}
+		})
+	}
+}
+
+func TestSubjectTrieAllocs(t *testing.T) {
+	trie, subjects := benchTrie(1000)
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		trie.Lookup(subjects[i%len(subjects)])
+		trie.Match(subjects[i%len(subjects)], func(string, int) {})
+		i++
+	})
+	if allocs != 0 {
+		t.Fatalf("%v allocations a lookup", allocs)
+	}
+}
+
+// benchTrie has n random patterns over few tokens, so they overlap, and
+// subjects to look up in it.
+func benchTrie(n int) (*SubjectTrie[int], []string) {
+	rnd := rand.New(rand.NewSource(1))
+	tokens := []string{"orders", "eu", "us", "created", "42", "a", "b"}
+	subject := func() []string {
+		s := make([]string, 1+rnd.Intn(6))
+		for i := range s {
+			s[i] = tokens[rnd.Intn(len(tokens))]
+		}
+		return s
+	}
+	trie := &SubjectTrie[int]{}
+	for trie.Len() < n {
+		p := subject()
+		for i := range p {
+			if rnd.Intn(4) == 0 {
+				p[i] = "*"
+			}
+		}
+		if rnd.Intn(3) == 0 {
+			p[len(p)-1] = ">"
+		}
+		trie.Insert(strings.Join(p, "."), trie.Len())
+	}
+	subjects := make([]string, 1024)
+	for i := range subjects {
+		subjects[i] = strings.Join(subject(), ".")
+	}
+	return trie, subjects
+}
@@ -0,0 +1,251 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// One subscription on several subject trees. Unlike SubscribeMulti, where
+// each subject gets a subscription of its own with the same options,
+// SubscribeMany sets up the handler and everything around it once, so the
+// subjects share the workers, limits, Max, dedupe and the rest, and stop
+// together:
+//
+//	set, err := nc.SubscribeMany([]string{"orders.>", "refunds.*"}, Workers(8), Handler(handle))
+//	...
+//	set.Add("chargebacks.>")
+//	log.Println(set.Stats().Delivered)
+//	set.Drain(ctx)
+//
+// It is for core NATS, a Handler or Channel, and not partitioned; JetStream
+// takes several subjects as a stream's. A slow consumer that ends one of
+// the subjects with SlowConsumerError ends them all.
+
+type SubscriptionSet struct {
+	c       *conn
+	sopts   *SubOptions
+	handler nats.MsgHandler
+
+	mu      sync.Mutex
+	members []setMember
+}
+
+type setMember struct {
+	subject string
+	s       *subscription
+}
+
+// SubscriptionStats are the client's counts for a subject, or all of them.
+type SubscriptionStats struct {
+	Subject      string `json:"subject,omitempty"`
+	Delivered    int64  `json:"delivered"`
+	Pending      int    `json:"pending"`
+	PendingBytes int    `json:"pending_bytes"`
+	Dropped      int    `json:"dropped"`
+}
+
+type SubscriptionSetStats struct {
+	SubscriptionStats
+	Subjects []SubscriptionStats `json:"subjects"`
+}
+
+var errSetClosed = errors.New("natsv2: subscription set is closed")
+
+func (c *conn) SubscribeMany(subjects []string, opts ...SubOption) (*SubscriptionSet, error) {
+	if len(subjects) == 0 {
+		return nil, fmt.Errorf("%w: no subjects", ErrBadSubject)
+	}
+	seen := map[string]bool{}
+	for _, subject := range subjects {
+		if err := checkSubject(subject, true); err != nil {
+			return nil, err
+		}
+		if seen[subject] {
+			return nil, fmt.Errorf("%w: %q given twice", ErrBadSubject, subject)
+		}
+		seen[subject] = true
+	}
+	sopts := &SubOptions{}
+	for _, opt := range opts {
+		if err := opt(sopts); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case sopts.Consumer != nil || sopts.Partitions > 0:
+		return nil, errors.New("natsv2: SubscribeMany is for core subscriptions, not JetStream or partitions")
+	case sopts.Handler == nil && sopts.Channel == nil:
+		return nil, errors.New("natsv2: SubscribeMany needs a Handler or Channel")
+	}
+	c.log.Debug("subscribe", "subjects", subjects, "queue", sopts.Queue)
+
+	sopts.ctx, sopts.cancel = context.WithCancel(c.hctx)
+	sopts.subject = strings.Join(subjects, ",")
+	sopts.pause = &pauseGate{}
+	handler, err := c.buildHandler(sopts)
+	if err != nil {
+		sopts.cancel()
+		return nil, err
+	}
+	ss := &SubscriptionSet{c: c, sopts: sopts, handler: handler}
+	for _, subject := range subjects {
+		if err := ss.add(subject); err != nil {
+			ss.Unsubscribe()
+			return nil, err
+		}
+	}
+	c.subs.Store(sopts, ss)
+	if sopts.auto != nil {
+		if err := sopts.auto.start(ss); err != nil {
+			ss.Unsubscribe()
+			return nil, err
+		}
+	}
+	return ss, nil
+}
+
+// add subscribes subject, called with mu held or before anyone has ss.
+func (ss *SubscriptionSet) add(subject string) error {
+	sub, err := ss.c.subscribe(ss.c.outSubject(subject), ss.sopts, ss.handler)
+	if err != nil {
+		return err
+	}
+	s := sub.(*subscription)
+	s.set = ss
+	if err := ss.c.setPending(s); err != nil {
+		s.sub.Unsubscribe()
+		ss.c.slow.Delete(s.sub)
+		return err
+	}
+	ss.members = append(ss.members, setMember{subject, s})
+	return nil
+}
+
+// Add subscribes another subject, with the same handler and options.
+func (ss *SubscriptionSet) Add(subject string) error {
+	if err := checkSubject(subject, true); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.sopts.ctx.Err() != nil {
+		return errSetClosed
+	}
+	for _, m := range ss.members {
+		if m.subject == subject {
+			return fmt.Errorf("%w: already subscribed to %q", ErrBadSubject, subject)
+		}
+	}
+	return ss.add(subject)
+}
+
+// Remove unsubscribes subject, leaving the others. Its messages already
+// received are still handled.
+func (ss *SubscriptionSet) Remove(subject string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for i, m := range ss.members {
+		if m.subject != subject {
+			continue
+		}
+		ss.members = append(ss.members[:i], ss.members[i+1:]...)
+		sub := m.s.current()
+		ss.c.slow.Delete(sub)
+		return sub.Unsubscribe()
+	}
+	return fmt.Errorf("%w: not subscribed to %q", ErrBadSubject, subject)
+}
+
+// Subjects are in the order they were added.
+func (ss *SubscriptionSet) Subjects() []string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	subjects := make([]string, len(ss.members))
+	for i, m := range ss.members {
+		subjects[i] = m.subject
+	}
+	return subjects
+}
+
+func (ss *SubscriptionSet) Stats() SubscriptionSetStats {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	var stats SubscriptionSetStats
+	for _, m := range ss.members {
+		st, sub := SubscriptionStats{Subject: m.subject}, m.s.current()
+		st.Delivered, _ = sub.Delivered()
+		st.Pending, st.PendingBytes, _ = sub.Pending()
+		st.Dropped, _ = sub.Dropped()
+		stats.Delivered += st.Delivered
+		stats.Pending += st.Pending
+		stats.PendingBytes += st.PendingBytes
+		stats.Dropped += st.Dropped
+		stats.Subjects = append(stats.Subjects, st)
+	}
+	return stats
+}
+
+// take empties the set, for stopping all of it.
+func (ss *SubscriptionSet) take() []*nats.Subscription {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	subs := make([]*nats.Subscription, len(ss.members))
+	for i, m := range ss.members {
+		subs[i] = m.s.current()
+		ss.c.slow.Delete(subs[i])
+	}
+	ss.members = nil
+	return subs
+}
+
+// invalid is a subscription that ended by itself, nil if none did.
+func (ss *SubscriptionSet) invalid() *nats.Subscription {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, m := range ss.members {
+		if sub := m.s.current(); !sub.IsValid() {
+			return sub
+		}
+	}
+	return nil
+}
+
+func (ss *SubscriptionSet) Close() {
+	ss.Unsubscribe()
+}
+
+func (ss *SubscriptionSet) Unsubscribe() error {
+	defer ss.c.unsubscribed(ss.sopts)
+	var first error
+	for _, sub := range ss.take() {
+		if err := sub.Unsubscribe(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ss *SubscriptionSet) Drain(ctx context.Context) error {
+	ss.sopts.cancel()
+	defer ss.c.unsubscribed(ss.sopts)
+	return ss.c.drainSubs(ctx, ss.sopts, ss.take()...)
+}
+
+func (ss *SubscriptionSet) Next(ctx context.Context) (*Msg, error) {
+	return nil, errors.New("natsv2: no Next on SubscribeMany, use a Channel")
+}
+
+func (ss *SubscriptionSet) Pause() {
+	ss.sopts.pause.pause()
+	ss.c.log.Info("subscription paused", "subject", ss.sopts.subject)
+}
+
+func (ss *SubscriptionSet) Resume() {
+	ss.sopts.pause.resume()
+	ss.c.log.Info("subscription resumed", "subject", ss.sopts.subject)
+}
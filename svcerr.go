@@ -0,0 +1,62 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Services report failure with a pair of headers on the reply, same as the
+// nats.go micro package, so either side can be micro. Nats-Service-Error has
+// the description and Nats-Service-Error-Code the code, HTTP style status
+// codes by convention, the body is whatever the service put there.
+//
+// Request returns such a reply along with a *RequestError, look for it with
+// errors.As. On the service side RespondError and HandlerFunc errors send
+// them, and a Service counts them in its stats.
+
+const (
+	ServiceErrorHeader     = "Nats-Service-Error"
+	ServiceErrorCodeHeader = "Nats-Service-Error-Code"
+)
+
+// RequestError is the error a requester gets back for a service error reply.
+type RequestError struct {
+	Subject     string
+	Code        string
+	Description string
+}
+
+func (e *RequestError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("natsv2: service error on %q: %s", e.Subject, e.Description)
+	}
+	return fmt.Sprintf("natsv2: service error %s on %q: %s", e.Code, e.Subject, e.Description)
+}
+
+// requestError returns a *RequestError if the reply carries service error headers.
+func requestError(subject string, reply *nats.Msg) error {
+	desc, code := reply.Header.Get(ServiceErrorHeader), reply.Header.Get(ServiceErrorCodeHeader)
+	if desc == "" && code == "" {
+		return nil
+	}
+	return &RequestError{Subject: subject, Code: code, Description: desc}
+}
+
+func errorReply(code string, err error) *nats.Msg {
+	reply := nats.NewMsg("")
+	desc := err.Error()
+	var rerr *RequestError
+	if errors.As(err, &rerr) {
+		desc = rerr.Description
+		if code == "" {
+			code = rerr.Code
+		}
+	}
+	reply.Header.Set(ServiceErrorHeader, desc)
+	if code != "" {
+		reply.Header.Set(ServiceErrorCodeHeader, code)
+	}
+	return reply
+}
@@ -0,0 +1,226 @@
+package natsv2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Taps, for seeing what goes by in production without taking part. A tap
+// is a subscription of its own, never in a queue group, so queue members
+// still get every message they would have and the tap a copy besides. What
+// it sees is decoded if it can be, kept in a ring buffer and handed to sink
+// if there is one:
+//
+//	tap, err := nc.Tap("orders.>", nil, TapBuffer(500))
+//	...
+//	tap.Dump(os.Stderr)
+//	tap.Close()
+//
+// None of the subscription middleware runs, a tap sees invalid and expired
+// messages too, and subjects are the application's. Payloads over MaxData
+// are kept cut short. A sink is called on the tap's own goroutine, a slow one
+// only slows the tap.
+
+const (
+	DefaultTapBuffer  = 1000
+	DefaultTapMaxData = 64 << 10
+)
+
+type TapSink func(*TapRecord)
+
+type TapRecord struct {
+	Received time.Time `json:"received"`
+	// Since the message before it, 0 for the first.
+	Gap     time.Duration `json:"gap"`
+	Subject string        `json:"subject"`
+	Reply   string        `json:"reply,omitempty"`
+	Header  Header        `json:"header,omitempty"`
+	Size    int           `json:"size"`
+	// Up to MaxData of the payload as sent.
+	Data []byte `json:"data"`
+	// The payload decoded by its Content-Type, or why that didn't work.
+	Decoded     interface{} `json:"decoded,omitempty"`
+	DecodeError string      `json:"decode_error,omitempty"`
+}
+
+type TapOption func(*TapOptions) error
+
+type TapOptions struct {
+	Buffer  int
+	MaxData int
+	// No decode attempts, for when they cost too much.
+	NoDecode bool
+}
+
+func TapBuffer(n int) TapOption {
+	return func(o *TapOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: tap buffer must hold at least one message")
+		}
+		o.Buffer = n
+		return nil
+	}
+}
+
+func TapMaxData(n int) TapOption {
+	return func(o *TapOptions) error {
+		if n < 0 {
+			return errors.New("natsv2: negative tap max data")
+		}
+		o.MaxData = n
+		return nil
+	}
+}
+
+func TapNoDecode() TapOption {
+	return func(o *TapOptions) error {
+		o.NoDecode = true
+		return nil
+	}
+}
+
+type Tap struct {
+	c    *conn
+	sub  *nats.Subscription
+	sink TapSink
+	opts TapOptions
+
+	mu    sync.Mutex
+	ring  []*TapRecord
+	next  int
+	full  bool
+	last  time.Time
+	count int
+}
+
+func (c *conn) Tap(pattern string, sink TapSink, opts ...TapOption) (*Tap, error) {
+	if err := checkSubject(pattern, true); err != nil {
+		return nil, err
+	}
+	t := &Tap{c: c, sink: sink, opts: TapOptions{Buffer: DefaultTapBuffer, MaxData: DefaultTapMaxData}}
+	for _, opt := range opts {
+		if err := opt(&t.opts); err != nil {
+			return nil, err
+		}
+	}
+	t.ring = make([]*TapRecord, t.opts.Buffer)
+	sub, err := c.nc.Subscribe(c.outSubject(pattern), t.record)
+	if err != nil {
+		return nil, err
+	}
+	// A tap is for when things are busy, better to drop than to be the slow
+	// consumer that gets the connection in trouble.
+	sub.SetPendingLimits(64*1024, 64<<20)
+	t.sub = sub
+	return t, nil
+}
+
+func (t *Tap) record(m *nats.Msg) {
+	now := time.Now()
+	m = t.c.unmap(m)
+	r := &TapRecord{Received: now, Subject: m.Subject, Reply: m.Reply, Header: Header(m.Header), Size: len(m.Data), Data: m.Data}
+	if len(r.Data) > t.opts.MaxData {
+		r.Data = r.Data[:t.opts.MaxData]
+	}
+	if !t.opts.NoDecode && len(m.Data) > 0 {
+		var v interface{}
+		if err := t.c.codecs.decode(m, &v); err != nil {
+			r.DecodeError = err.Error()
+		} else {
+			r.Decoded = v
+		}
+	}
+	t.mu.Lock()
+	if !t.last.IsZero() {
+		r.Gap = now.Sub(t.last)
+	}
+	t.last = now
+	t.ring[t.next] = r
+	t.next = (t.next + 1) % len(t.ring)
+	t.full = t.full || t.next == 0
+	t.count++
+	t.mu.Unlock()
+	if t.sink != nil {
+		t.sink(r)
+	}
+}
+
+// Records is what the ring buffer holds, oldest first.
+func (t *Tap) Records() []*TapRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.full {
+		return append([]*TapRecord(nil), t.ring[:t.next]...)
+	}
+	return append(append([]*TapRecord(nil), t.ring[t.next:]...), t.ring[:t.next]...)
+}
+
+// Count is how many messages the tap saw, Dropped how many the server sent
+// that it didn't keep up with.
+func (t *Tap) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+func (t *Tap) Dropped() int {
+	n, _ := t.sub.Dropped()
+	return n
+}
+
+// Dump writes the records, oldest first, a line for each with its headers
+// and payload after it.
+func (t *Tap) Dump(w io.Writer) error {
+	for _, r := range t.Records() {
+		if _, err := fmt.Fprintf(w, "%s +%v %s", r.Received.Format("15:04:05.000000"), r.Gap.Round(time.Microsecond), r.Subject); err != nil {
+			return err
+		}
+		if r.Reply != "" {
+			fmt.Fprintf(w, " reply %s", r.Reply)
+		}
+		fmt.Fprintf(w, " %d bytes\n", r.Size)
+		keys := make([]string, 0, len(r.Header))
+		for k := range r.Header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range r.Header[k] {
+				fmt.Fprintf(w, "  %s: %s\n", k, v)
+			}
+		}
+		if _, err := fmt.Fprintf(w, "  %s\n", r.payload()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *TapRecord) payload() string {
+	if r.Decoded != nil {
+		if b, err := json.Marshal(r.Decoded); err == nil {
+			return string(b)
+		}
+	}
+	s := fmt.Sprintf("%q", r.Data)
+	if utf8.Valid(r.Data) {
+		s = string(r.Data)
+	}
+	if len(r.Data) < r.Size {
+		s += "..."
+	}
+	return s
+}
+
+// Close stops the tap, the records are kept.
+func (t *Tap) Close() error {
+	return t.sub.Unsubscribe()
+}
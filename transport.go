@@ -0,0 +1,221 @@
+package natsv2
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// How we get to the server, without building nats URLs and options by
+// hand. WithWebsocket connects over websocket instead of plain TCP, for
+// edge and browser adjacent setups where only HTTP ports are open. The URLs
+// stay as they are, nats:// becomes ws:// and tls:// or TLS becomes wss://:
+//
+//	nc, err := Connect("nats.example.com:443", WithWebsocketOptions(WebsocketOptions{
+//		Path: "/nats",
+//		TLS:  lbTLS,
+//	}), WithProxyFromEnvironment())
+//
+// WithDialer replaces how TCP connections are made. WithProxy and
+// WithProxyFromEnvironment go through an HTTP CONNECT proxy, plain or over
+// TLS, for websockets and TCP alike, with the proxy URL's user as basic auth.
+// ConnectGroups dials through all of these too.
+
+type WebsocketOptions struct {
+	// Where the server is behind a reverse proxy, e.g. "/nats".
+	Path string
+	// For wss, instead of WithTLS's, as websocket ports are often behind a
+	// load balancer with certs of its own.
+	TLS         *tls.Config
+	Compression bool
+}
+
+// A ProxyFunc is the proxy to reach addr through, nil to go direct.
+type ProxyFunc func(addr string) (*url.URL, error)
+
+func WithWebsocket() ConnectOption {
+	return WithWebsocketOptions(WebsocketOptions{})
+}
+
+func WithWebsocketOptions(ws WebsocketOptions) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if ws.Path != "" && !strings.HasPrefix(ws.Path, "/") {
+			return fmt.Errorf("natsv2: websocket path %q must start with /", ws.Path)
+		}
+		o.Websocket = &ws
+		return nil
+	}
+}
+
+// WithDialer makes the TCP connections, *net.Dialer is one.
+func WithDialer(d nats.CustomDialer) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if d == nil {
+			return errors.New("natsv2: nil dialer")
+		}
+		o.Dialer = d
+		return nil
+	}
+}
+
+// WithProxy goes through the proxy at proxyURL, http:// or https://.
+func WithProxy(proxyURL string) ConnectOption {
+	return func(o *ConnectOptions) error {
+		pu, err := parseProxyURL(proxyURL)
+		if err != nil {
+			return err
+		}
+		o.Proxy = func(string) (*url.URL, error) { return pu, nil }
+		return nil
+	}
+}
+
+// WithProxyFromEnvironment takes the proxy from HTTPS_PROXY and NO_PROXY,
+// as net/http does for https URLs.
+func WithProxyFromEnvironment() ConnectOption {
+	return func(o *ConnectOptions) error {
+		o.Proxy = func(addr string) (*url.URL, error) {
+			return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+		}
+		return nil
+	}
+}
+
+func parseProxyURL(proxyURL string) (*url.URL, error) {
+	pu, err := url.Parse(proxyURL)
+	if err != nil || pu.Host == "" || pu.Scheme != "http" && pu.Scheme != "https" {
+		return nil, fmt.Errorf("natsv2: invalid proxy url %q", proxyURL)
+	}
+	return pu, nil
+}
+
+// dialer is WithDialer's with the proxy in front, nil if neither is set.
+func (o *ConnectOptions) dialer() nats.CustomDialer {
+	if o.Proxy == nil {
+		return o.Dialer
+	}
+	d := o.Dialer
+	if d == nil {
+		d = &net.Dialer{Timeout: nats.DefaultTimeout}
+	}
+	return &proxyDialer{proxy: o.Proxy, dialer: d}
+}
+
+// transport is the low level options for the above, after the rest so
+// they see whether TLS was asked for.
+func (o *ConnectOptions) transport() []nats.Option {
+	var opts []nats.Option
+	if d := o.dialer(); d != nil {
+		opts = append(opts, nats.SetCustomDialer(d))
+	}
+	ws := o.Websocket
+	if ws == nil {
+		return opts
+	}
+	if ws.Path != "" {
+		opts = append(opts, nats.ProxyPath(ws.Path))
+	}
+	if ws.Compression {
+		opts = append(opts, nats.Compression(true))
+	}
+	if ws.TLS != nil {
+		opts = append(opts, nats.Secure(ws.TLS))
+	}
+	return append(opts, func(no *nats.Options) error {
+		for i, s := range no.Servers {
+			no.Servers[i] = websocketURL(s, no.Secure)
+		}
+		return nil
+	})
+}
+
+func websocketURL(s string, secure bool) string {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		scheme, rest = "nats", s
+	}
+	switch {
+	case scheme == "ws" || scheme == "wss":
+		return s
+	case scheme == "tls" || secure:
+		return "wss://" + rest
+	}
+	return "ws://" + rest
+}
+
+type proxyDialer struct {
+	proxy  ProxyFunc
+	dialer nats.CustomDialer
+}
+
+func (p *proxyDialer) Dial(network, addr string) (net.Conn, error) {
+	pu, err := p.proxy(addr)
+	if err != nil {
+		return nil, err
+	}
+	if pu == nil {
+		return p.dialer.Dial(network, addr)
+	}
+	host := pu.Host
+	if pu.Port() == "" {
+		port := "80"
+		if pu.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(pu.Hostname(), port)
+	}
+	conn, err := p.dialer.Dial("tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("natsv2: proxy %s: %w", pu.Host, err)
+	}
+	conn.SetDeadline(time.Now().Add(nats.DefaultTimeout))
+	if pu.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: pu.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("natsv2: proxy %s: %w", pu.Host, err)
+		}
+		conn = tc
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if u := pu.User; u != nil {
+		pass, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("natsv2: proxy %s: %w", pu.Host, err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("natsv2: proxy %s: %w", pu.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("natsv2: proxy %s: %s", pu.Host, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	// The server talks first, its INFO can already be in r.
+	return &bufferedConn{Conn: conn, r: r}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
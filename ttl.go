@@ -0,0 +1,106 @@
+package natsv2
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Messages that are worse late than never, cache invalidations, presence,
+// quotes. TTL stamps the time a message is good until in ExpiresHeader, and
+// subscriptions drop it once that has passed, before Dedupe, DeadLetter and
+// the handler see it:
+//
+//	nc.Publish("presence.alice", online, TTL(5*time.Second))
+//	nc.Subscribe("presence.*", Handler(update), OnExpired(func(msg *Msg) { stale.Inc() }))
+//
+// The time is absolute, so the clocks of publisher and subscriber need to
+// agree to well within the TTL. It counts from when the message goes out,
+// for Delay and schedules when the scheduler publishes it, and the offline
+// buffer doesn't replay what has expired meanwhile. JetStream messages that
+// expired are acked. This isn't the server's per message TTL, a stream
+// keeps them until its own limits say otherwise.
+
+const ExpiresHeader = "Nats-Expires"
+
+func TTL(d time.Duration) PubOption {
+	return func(o *PubOptions) error {
+		if d <= 0 {
+			return errors.New("natsv2: ttl must be positive")
+		}
+		o.TTL = d
+		return nil
+	}
+}
+
+// OnExpired gets the expired messages instead of them being dropped
+// quietly.
+func OnExpired(cb func(*Msg)) SubOption {
+	return func(o *SubOptions) error {
+		o.OnExpired = cb
+		return nil
+	}
+}
+
+// Expires is when the message stops being good, zero if it doesn't.
+func (m *Msg) Expires() time.Time {
+	return expires(m.m)
+}
+
+func expires(m *nats.Msg) time.Time {
+	if m.Header == nil {
+		return time.Time{}
+	}
+	v := m.Header.Get(ExpiresHeader)
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func expired(m *nats.Msg, now time.Time) bool {
+	t := expires(m)
+	return !t.IsZero() && now.After(t)
+}
+
+// setExpires stamps m if there is a TTL. The scheduler's are stamped when
+// due, until then the TTL waits in ScheduleTTLHeader.
+func (o *PubOptions) setExpires(m *nats.Msg, scheduled bool) {
+	if o.TTL <= 0 {
+		return
+	}
+	if m.Header == nil {
+		m.Header = nats.Header{}
+	}
+	if scheduled {
+		m.Header.Set(ScheduleTTLHeader, o.TTL.String())
+		return
+	}
+	stampExpires(m, o.TTL)
+}
+
+func stampExpires(m *nats.Msg, ttl time.Duration) {
+	m.Header.Set(ExpiresHeader, time.Now().Add(ttl).UTC().Format(time.RFC3339Nano))
+}
+
+func (c *conn) dropExpired(sopts *SubOptions, handler nats.MsgHandler) nats.MsgHandler {
+	jetstream := sopts.Consumer != nil && sopts.Consumer.AckPolicy != AckNone
+	return func(m *nats.Msg) {
+		if !expired(m, time.Now()) {
+			handler(m)
+			return
+		}
+		c.log.Debug("dropped expired", "subject", m.Subject)
+		if sopts.OnExpired != nil {
+			sopts.OnExpired(c.wrap(m))
+		}
+		if jetstream {
+			m.Ack()
+		}
+	}
+}
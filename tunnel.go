@@ -0,0 +1,341 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Connections tunnelled over NATS, for what wants a net.Conn: a gRPC
+// server, an SSH port forward, a database's wire protocol to a host only
+// the mesh can reach. Listen answers dials on a subject:
+//
+//	l, err := Listen(nc, "tunnel.db1")
+//	go grpcServer.Serve(l)
+//
+//	conn, err := Dial(nc, "tunnel.db1")
+//
+// The dialer asks with its inbox in Nats-Tunnel-Inbox, the listener replies
+// with its own, and from then on each side writes a stream as NewWriter does
+// to the other's inbox and reads one from its own, so a slow reader holds up
+// the other side's writes instead of losing bytes. Listeners on a subject
+// are a queue group, each dial goes to one of them.
+//
+// Write sends at once, it doesn't wait for a chunk to fill. Close ends our
+// stream, the other side reads io.EOF, and stops reading theirs, their next
+// Write fails with io.ErrClosedPipe. There are no keepalives, a peer that
+// vanishes is noticed by the next Write not being acked within the
+// IOTimeout; Read waits for as long as the read deadline lets it.
+
+const (
+	TunnelInboxHeader = "Nats-Tunnel-Inbox"
+	// Where the stop goes when we close, the inbox of the writer.
+	TunnelAckHeader = "Nats-Tunnel-Ack"
+
+	tunnelQueue   = "natsv2-tunnel"
+	tunnelBacklog = 64
+)
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "nats" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+type tunnelListener struct {
+	c      *conn
+	addr   tunnelAddr
+	opts   *IOOptions
+	sub    *nats.Subscription
+	accept chan *tunnelConn
+	done   chan struct{}
+	once   sync.Once
+}
+
+func Listen(nc Connection, subject string, opts ...IOOption) (net.Listener, error) {
+	if err := checkSubject(subject, false); err != nil {
+		return nil, err
+	}
+	c := connFor(nc, subject)
+	if c == nil {
+		return nil, fmt.Errorf("natsv2: no tunnels on a %T", nc)
+	}
+	o, err := ioOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	l := &tunnelListener{c: c, addr: tunnelAddr(subject), opts: o, accept: make(chan *tunnelConn, tunnelBacklog), done: make(chan struct{})}
+	if l.sub, err = c.nc.QueueSubscribe(c.outSubject(subject), tunnelQueue, l.dialed); err != nil {
+		return nil, err
+	}
+	// So a dial from another connection right after finds us.
+	if err := c.nc.Flush(); err != nil {
+		l.sub.Unsubscribe()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *tunnelListener) dialed(m *nats.Msg) {
+	peer, acks := m.Header.Get(TunnelInboxHeader), m.Header.Get(TunnelAckHeader)
+	if m.Reply == "" || peer == "" || acks == "" {
+		return
+	}
+	reply := nats.NewMsg(m.Reply)
+	t, err := l.c.newTunnel(l.addr, tunnelAddr(peer), l.opts)
+	if err == nil {
+		t.w.subject, t.acks = peer, acks
+		select {
+		case l.accept <- t:
+		case <-l.done:
+			err = net.ErrClosed
+		default:
+			err = errors.New("natsv2: listener backlog full")
+		}
+		if err != nil {
+			t.abort()
+		}
+	}
+	if err != nil {
+		reply.Header.Set(StreamErrorHeader, err.Error())
+	} else {
+		reply.Header.Set(TunnelInboxHeader, t.inbox)
+		reply.Header.Set(TunnelAckHeader, t.w.inbox)
+	}
+	if err := m.RespondMsg(reply); err != nil {
+		l.c.log.Warn("tunnel not answered", "subject", m.Subject, "error", err)
+	}
+}
+
+func (l *tunnelListener) Accept() (net.Conn, error) {
+	select {
+	case t := <-l.accept:
+		return t, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-l.c.hctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops answering dials, the connections accepted stay up.
+func (l *tunnelListener) Close() error {
+	err := net.ErrClosed
+	l.once.Do(func() {
+		close(l.done)
+		err = l.sub.Unsubscribe()
+		// Dialed meanwhile and never accepted.
+		for {
+			select {
+			case t := <-l.accept:
+				go t.Close()
+			default:
+				return
+			}
+		}
+	})
+	return err
+}
+
+func (l *tunnelListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Dial connects to the listener on subject.
+func Dial(nc Connection, subject string, opts ...IOOption) (net.Conn, error) {
+	if err := checkSubject(subject, false); err != nil {
+		return nil, err
+	}
+	c := connFor(nc, subject)
+	if c == nil {
+		return nil, fmt.Errorf("natsv2: no tunnels on a %T", nc)
+	}
+	o, err := ioOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	subject = c.outSubject(subject)
+	t, err := c.newTunnel(nil, tunnelAddr(subject), o)
+	if err != nil {
+		return nil, err
+	}
+	t.local = tunnelAddr(t.inbox)
+	m := nats.NewMsg(subject)
+	m.Header.Set(TunnelInboxHeader, t.inbox)
+	m.Header.Set(TunnelAckHeader, t.w.inbox)
+	reply, err := c.nc.RequestMsg(m, o.Timeout)
+	if errors.Is(err, nats.ErrNoResponders) {
+		err = fmt.Errorf("natsv2: no listener on %q: %w", subject, err)
+	}
+	if err == nil {
+		if e := reply.Header.Get(StreamErrorHeader); e != "" {
+			err = fmt.Errorf("natsv2: dial %q: %s", subject, e)
+		}
+	}
+	if err != nil {
+		t.abort()
+		return nil, err
+	}
+	t.w.subject, t.acks = reply.Header.Get(TunnelInboxHeader), reply.Header.Get(TunnelAckHeader)
+	return t, nil
+}
+
+type tunnelConn struct {
+	c             *conn
+	local, remote net.Addr
+	// We read from inbox, the other side's writer gets our stop at acks.
+	inbox string
+	acks  string
+
+	rmu sync.Mutex
+	r   *Reader
+	rd  readDeadline
+	wmu sync.Mutex
+	w   *Writer
+
+	closed atomic.Bool
+}
+
+func (c *conn) newTunnel(local, remote net.Addr, o *IOOptions) (*tunnelConn, error) {
+	t := &tunnelConn{c: c, local: local, remote: remote, inbox: c.nc.NewInbox()}
+	sub, err := c.subscribeWith(t.inbox, &SubOptions{})
+	if err != nil {
+		return nil, err
+	}
+	t.r = &Reader{sub: sub, opts: o}
+	wo := *o
+	if t.w, err = c.newWriter("", &wo); err != nil {
+		sub.Unsubscribe()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *tunnelConn) Read(p []byte) (int, error) {
+	t.rmu.Lock()
+	defer t.rmu.Unlock()
+	for {
+		ctx, cancel := t.rd.context()
+		n, err := t.r.read(ctx, p)
+		cancel()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return n, os.ErrDeadlineExceeded
+		case errors.Is(err, context.Canceled):
+			// The deadline moved.
+			continue
+		case err != nil && err != io.EOF && t.closed.Load():
+			return n, net.ErrClosed
+		}
+		return n, err
+	}
+}
+
+func (t *tunnelConn) Write(p []byte) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	if t.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	if d := t.w.deadline.Load(); d != 0 && time.Now().UnixNano() >= d {
+		return 0, os.ErrDeadlineExceeded
+	}
+	n, err := t.w.Write(p)
+	if err == nil {
+		err = t.w.Flush()
+	}
+	return n, err
+}
+
+func (t *tunnelConn) Close() error {
+	if t.closed.Swap(true) {
+		return net.ErrClosed
+	}
+	// Stop reading first, so the other side isn't left blocked on acks.
+	stop := nats.NewMsg(t.acks)
+	stop.Header.Set(StreamEndHeader, "true")
+	t.c.nc.PublishMsg(stop)
+	t.r.sub.Unsubscribe()
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	if err := t.w.Close(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return err
+	}
+	return nil
+}
+
+// abort is Close for a tunnel that never got to the other side.
+func (t *tunnelConn) abort() {
+	t.closed.Store(true)
+	t.r.sub.Unsubscribe()
+	t.w.sub.Unsubscribe()
+}
+
+func (t *tunnelConn) LocalAddr() net.Addr  { return t.local }
+func (t *tunnelConn) RemoteAddr() net.Addr { return t.remote }
+
+func (t *tunnelConn) SetDeadline(at time.Time) error {
+	t.SetReadDeadline(at)
+	return t.SetWriteDeadline(at)
+}
+
+func (t *tunnelConn) SetReadDeadline(at time.Time) error {
+	t.rd.set(at)
+	return nil
+}
+
+// SetWriteDeadline is seen by the next wait for acks, not one under way.
+func (t *tunnelConn) SetWriteDeadline(at time.Time) error {
+	var d int64
+	if !at.IsZero() {
+		d = at.UnixNano()
+	}
+	t.w.deadline.Store(d)
+	return nil
+}
+
+// readDeadline lets a Read waiting for the next chunk see its deadline
+// move.
+type readDeadline struct {
+	mu    sync.Mutex
+	at    time.Time
+	moved chan struct{}
+}
+
+func (d *readDeadline) set(at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.at = at
+	if d.moved != nil {
+		close(d.moved)
+	}
+	d.moved = make(chan struct{})
+}
+
+func (d *readDeadline) context() (context.Context, context.CancelFunc) {
+	d.mu.Lock()
+	if d.moved == nil {
+		d.moved = make(chan struct{})
+	}
+	at, moved := d.at, d.moved
+	d.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	if !at.IsZero() {
+		ctx, cancel = context.WithDeadline(context.Background(), at)
+	}
+	go func() {
+		select {
+		case <-moved:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,93 @@
+package natsv2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Typed helpers on top of a Connection. Values go through the connection's
+// codecs both ways, so the Content-Type on the wire decides how to decode.
+//
+//	natsv2.Subscribe(nc, "orders", func(ctx context.Context, o Order) error { ... })
+//	resp, err := natsv2.Request[AddReq, AddResp](nc, "calc.add", req)
+
+// DecodeError is what the ErrorHandler gets when a subscription could not
+// decode a message.
+type DecodeError struct {
+	Subject string
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("natsv2: decode on %q: %v", e.Subject, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+func Publish[T any](c Connection, subject string, v T, opts ...PubOption) error {
+	return c.Publish(subject, v, opts...)
+}
+
+// Subscribe decodes each message into a T for handler. Handler errors go to
+// the ErrorHandler, and if the message was a request the requester gets a
+// service error back so it does not sit there timing out. Decode errors go
+// where DecodeErrors says, see decodeerror.go.
+func Subscribe[T any](c Connection, subject string, handler func(ctx context.Context, v T) error, opts ...SubOption) (Subscription, error) {
+	return c.Subscribe(subject, append(opts, Handler(func(m *Msg) {
+		var v T
+		if err := c.Decode(m, &v); err != nil {
+			decodeFailedMsg(c, m, err)
+			return
+		}
+		if err := handler(m.Context(), v); err != nil {
+			failedMsg(c, m, "500", err)
+		}
+	}))...)
+}
+
+// failedMsg is failed for messages that may not be from a connection, see
+// FromNATSWith.
+func failedMsg(c Connection, m *Msg, code string, err error) {
+	if _, ok := c.(*conn); ok || m.respond == nil {
+		failed(c, m.m, code, err)
+		return
+	}
+	m.fail(code, err)
+}
+
+func failed(c Connection, m *nats.Msg, code string, err error) {
+	cc, ok := c.(*conn)
+	if ok {
+		cc.handleError(err)
+	}
+	if m == nil || replyTo(m) == "" {
+		return
+	}
+	reply := errorReply(code, err)
+	if ok {
+		cc.respondMsg(m, reply)
+		return
+	}
+	m.RespondMsg(reply)
+}
+
+// Request sends req and decodes the reply into an R. A service error reply
+// comes back as a *RequestError.
+func Request[Req, R any](c Connection, subject string, req Req, opts ...ReqOption) (R, error) {
+	var r R
+	_, err := RequestMsgInto(c, subject, req, &r, opts...)
+	return r, err
+}
+
+// RequestMsgInto decodes the reply into out with the connection's codecs and
+// also hands back the raw reply for headers and such. If the service replied
+// with an error the raw message is still returned, along with a *RequestError.
+func RequestMsgInto[T any](c Connection, subject string, msg interface{}, out *T, opts ...ReqOption) (*Msg, error) {
+	reply, err := c.Request(subject, msg, opts...)
+	if err != nil {
+		return reply, err
+	}
+	return reply, c.Decode(reply, out)
+}
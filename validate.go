@@ -0,0 +1,109 @@
+package natsv2
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Payload validation at the edges, a contract per subject that publishers
+// can't break and subscribers can count on. Validators are registered on the
+// connection for subject patterns, wildcards work and the most specific
+// match wins, and see the payload with any content encodings undone:
+//
+//	orderSchema, err := JSONSchema(schemaJSON)
+//	nc, err := Connect(url, WithValidator("orders.>", orderSchema),
+//		WithValidator("audit.*", func(subject string, data []byte) error { ... }))
+//
+// Whatever is sent on a matching subject is checked first, a publish or
+// request that doesn't pass fails with a *ValidationError and nothing goes
+// out. What comes in on one goes to the ErrorHandler instead of the handler,
+// and to the subscription's DeadLetter subject if it has one; a JetStream
+// message is then acked, or terminated without a dead letter, since it won't
+// get any better, and a request gets a 400 back, unless DecodeErrors says
+// otherwise. Subjects are the application's, see subjectmap.go.
+
+var ErrInvalidPayload = errors.New("natsv2: invalid payload")
+
+// A Validator says what is wrong with data, nil if nothing is.
+type Validator func(subject string, data []byte) error
+
+type ValidationError struct {
+	Subject string
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("natsv2: invalid payload on %q: %v", e.Subject, e.Err)
+}
+
+func (e *ValidationError) Unwrap() []error { return []error{ErrInvalidPayload, e.Err} }
+
+type subjectValidator struct {
+	route *route
+	fn    Validator
+}
+
+func WithValidator(pattern string, v Validator) ConnectOption {
+	return func(o *ConnectOptions) error {
+		if v == nil {
+			return errors.New("natsv2: nil validator")
+		}
+		rt, err := newRoute(pattern, nil)
+		if err != nil {
+			return err
+		}
+		o.validators = append(o.validators, subjectValidator{rt, v})
+		return nil
+	}
+}
+
+// validate checks m, whose subject is the application's.
+func (c *conn) validate(m *nats.Msg) error {
+	if len(c.opts.validators) == 0 {
+		return nil
+	}
+	var best *subjectValidator
+	for i, sv := range c.opts.validators {
+		if sv.route.matches(m.Subject) && (best == nil || sv.route.moreSpecific(best.route)) {
+			best = &c.opts.validators[i]
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	data, err := c.codecs.plain(m)
+	if err == nil {
+		err = best.fn(m.Subject, data)
+	}
+	if err != nil {
+		return &ValidationError{Subject: m.Subject, Err: err}
+	}
+	return nil
+}
+
+// validateOut is validate for m on its way out, with the wire subject.
+func (c *conn) validateOut(m *nats.Msg) error {
+	if len(c.opts.validators) == 0 {
+		return nil
+	}
+	subject := m.Subject
+	if c.opts.SubjectMapper != nil {
+		subject = c.opts.SubjectMapper.In(subject)
+	}
+	return c.validate(&nats.Msg{Subject: subject, Header: m.Header, Data: m.Data})
+}
+
+// validating keeps invalid messages from handler, see above.
+func (c *conn) validating(o *SubOptions, handler nats.MsgHandler) nats.MsgHandler {
+	if len(c.opts.validators) == 0 {
+		return handler
+	}
+	return func(m *nats.Msg) {
+		err := c.validate(m)
+		if err == nil || c.decodeFailed(o, m, err, true) {
+			handler(m)
+		}
+	}
+}
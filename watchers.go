@@ -0,0 +1,106 @@
+package natsv2
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Watches and listings as channels, for those who would rather range than
+// give Watch a Handler and remember to Unsubscribe. The channel is closed,
+// and the watcher stopped, when ctx is done or the connection closes:
+//
+//	ctx, cancel := context.WithCancel(ctx)
+//	defer cancel()
+//	updates, err := kv.Updates(ctx, "settings.>")
+//	for e := range updates {
+//		...
+//	}
+//
+// Updates has the current values first and then changes as they happen,
+// deletes and purges as entries with their Op and no Value, deleted objects
+// with Deleted set. ListKeys is closed once it has had every key. With Go
+// 1.23 there are iterators over the same, see iter.go.
+
+func (b *kvBucket) Updates(ctx context.Context, keys string) (<-chan *KVEntry, error) {
+	w, err := b.kv.Watch(keys)
+	if err != nil {
+		return nil, err
+	}
+	return watchChan(b.c, ctx, w.Updates(), func(e nats.KeyValueEntry) (*KVEntry, bool) {
+		// nil marks the end of the initial values.
+		if e == nil {
+			return nil, false
+		}
+		return b.entry(e), true
+	}, w.Stop), nil
+}
+
+func (b *kvBucket) ListKeys(ctx context.Context) (<-chan string, error) {
+	kl, err := b.kv.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	return watchChan(b.c, ctx, kl.Keys(), func(key string) (string, bool) {
+		return key, true
+	}, kl.Stop), nil
+}
+
+func (s *objectStore) Updates(ctx context.Context) (<-chan *ObjectInfo, error) {
+	w, err := s.obs.Watch()
+	if err != nil {
+		return nil, err
+	}
+	return watchChan(s.c, ctx, w.Updates(), func(info *ObjectInfo) (*ObjectInfo, bool) {
+		return info, info != nil
+	}, w.Stop), nil
+}
+
+// watchChan passes on what in has, as conv has it and less what it skips,
+// until in is closed or ctx or the connection is done, then stops the
+// watcher.
+func watchChan[T, U any](c *conn, ctx context.Context, in <-chan T, conv func(T) (U, bool), stop func() error) <-chan U {
+	out := make(chan U)
+	ctx, cancel := context.WithCancel(ctx)
+	unhook := context.AfterFunc(c.hctx, cancel)
+	go func() {
+		defer close(out)
+		defer func() {
+			unhook()
+			cancel()
+			stop()
+			// nats.go blocks sending to in, so whatever is in it goes, to let
+			// the watcher finish.
+			for {
+				select {
+				case _, ok := <-in:
+					if ok {
+						continue
+					}
+				default:
+				}
+				return
+			}
+		}()
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				u, ok := conv(v)
+				if !ok {
+					continue
+				}
+				select {
+				case out <- u:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
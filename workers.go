@@ -0,0 +1,141 @@
+package natsv2
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Handlers normally run one at a time on the subscription's dispatch
+// goroutine, so a slow one holds up everything behind it. Workers runs them
+// on a pool instead, MaxInFlight bounds how many messages are taken off the
+// subscription and not yet handled, once reached we wait, and the client's
+// pending limits take it from there. OrderBySubject keeps messages on the
+// same subject in order by always giving them to the same worker.
+//
+//	nc.Subscribe("orders.*", Handler(process), Workers(8), OrderBySubject())
+
+func Workers(n int) SubOption {
+	return func(o *SubOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: workers must be at least 1")
+		}
+		o.Workers = n
+		return nil
+	}
+}
+
+// MaxInFlight defaults to the number of workers, given on its own it is the
+// number of workers too.
+func MaxInFlight(n int) SubOption {
+	return func(o *SubOptions) error {
+		if n < 1 {
+			return errors.New("natsv2: max in flight must be at least 1")
+		}
+		o.MaxInFlight = n
+		return nil
+	}
+}
+
+func OrderBySubject() SubOption {
+	return func(o *SubOptions) error {
+		o.OrderBySubject = true
+		return nil
+	}
+}
+
+type workerPool struct {
+	queues  []chan *nats.Msg
+	ordered bool
+	slots   chan struct{}
+	pending sync.WaitGroup
+	done    chan struct{}
+	once    sync.Once
+}
+
+func (o *SubOptions) newWorkerPool(handler nats.MsgHandler) (nats.MsgHandler, error) {
+	if o.Workers == 0 && o.MaxInFlight == 0 {
+		return handler, nil
+	}
+	if handler == nil {
+		return nil, errors.New("natsv2: workers need a Handler or Channel")
+	}
+	workers, max := o.Workers, o.MaxInFlight
+	if workers == 0 {
+		workers = max
+	}
+	if max == 0 {
+		max = workers
+	}
+	p := &workerPool{ordered: o.OrderBySubject, slots: make(chan struct{}, max), done: make(chan struct{})}
+	queues := 1
+	if p.ordered {
+		queues = workers
+	}
+	for i := 0; i < queues; i++ {
+		p.queues = append(p.queues, make(chan *nats.Msg, max))
+	}
+	for i := 0; i < workers; i++ {
+		go p.work(p.queues[i%queues], handler)
+	}
+	o.pool = p
+	return p.dispatch, nil
+}
+
+func (p *workerPool) dispatch(m *nats.Msg) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.done:
+		return
+	}
+	q := p.queues[0]
+	if p.ordered {
+		h := fnv.New32a()
+		h.Write([]byte(m.Subject))
+		q = p.queues[h.Sum32()%uint32(len(p.queues))]
+	}
+	p.pending.Add(1)
+	q <- m
+}
+
+func (p *workerPool) work(q chan *nats.Msg, handler nats.MsgHandler) {
+	for {
+		select {
+		case m := <-q:
+			handler(m)
+			<-p.slots
+			p.pending.Done()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// wait is for the messages already taken to be handled, for Drain.
+func (p *workerPool) wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	idle := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop drops whatever is still queued.
+func (p *workerPool) stop() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() { close(p.done) })
+}